@@ -12,6 +12,9 @@ import (
 
 	"github.com/ducesoft/overlord/pkg/log"
 	"github.com/ducesoft/overlord/proxy"
+	"github.com/ducesoft/overlord/proxy/capture"
+	"github.com/ducesoft/overlord/proxy/hotkey"
+	"github.com/ducesoft/overlord/proxy/prom"
 	"github.com/ducesoft/overlord/proxy/slowlog"
 	"github.com/ducesoft/overlord/version"
 )
@@ -27,6 +30,9 @@ var (
 	slowlogSlowerThan  int
 	slowlogMaxBytes    int
 	slowlogBackupCount int
+	captureFile        string
+	captureMaxBytes    int
+	captureBackupCount int
 )
 
 type clustersFlag []string
@@ -57,6 +63,9 @@ func init() {
 	flag.IntVar(&slowlogSlowerThan, "slower-than", 0, "slower-than is the microseconds which slowlog must slower than.")
 	flag.IntVar(&slowlogMaxBytes, "slower-max-bytes", 500000000, "slower-max-bytes is maximum size of slow log file.")
 	flag.IntVar(&slowlogBackupCount, "slower-backup-count", 7, "slower-backup-count is maximum backup count of slow log file.")
+	flag.StringVar(&captureFile, "capture", "", "capture is the file where captured traffic output, capture is started by admin.")
+	flag.IntVar(&captureMaxBytes, "capture-max-bytes", 500000000, "capture-max-bytes is maximum size of capture file.")
+	flag.IntVar(&captureBackupCount, "capture-backup-count", 7, "capture-backup-count is maximum backup count of capture file.")
 }
 
 func main() {
@@ -78,6 +87,12 @@ func main() {
 	if err != nil {
 		log.Errorf("fail to init slowlog due %s", err)
 	}
+	// init capture if need
+	if err = capture.Init(captureFile, captureMaxBytes, captureBackupCount); err != nil {
+		log.Errorf("fail to init capture due %s", err)
+	}
+	prom.Init()
+	hotkey.Init()
 
 	// new proxy
 	p, err := proxy.New(c)
@@ -85,6 +100,7 @@ func main() {
 		panic(err)
 	}
 	defer p.Close()
+	p.SetClusterConfFile(clusterConfFile)
 	p.Serve(ccs)
 	if reload {
 		go p.MonitorConfChange(clusterConfFile)
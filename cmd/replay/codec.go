@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+var (
+	errShort   = errors.New("request is incomplete")
+	errBadResp = errors.New("bad resp")
+
+	crlfBytes = []byte("\r\n")
+)
+
+// codec counts the requests captured and reads the replies of them.
+type codec interface {
+	// count returns the count of replies of requests.
+	count(req []byte) (int, error)
+	// read reads a reply and appends into dst.
+	read(br *bufio.Reader, dst []byte) ([]byte, error)
+	// isError reports whether any of replies is error.
+	isError(reply []byte) bool
+	// name returns the command name of the first request.
+	name(req []byte) string
+}
+
+func newCodec(typ string) (codec, error) {
+	switch typ {
+	case "redis", "redis_cluster":
+		return respCodec{}, nil
+	case "memcache":
+		return mcCodec{}, nil
+	}
+	return nil, fmt.Errorf("unsupported cache type %q", typ)
+}
+
+type respCodec struct{}
+
+// respLen returns the length of the first resp in b.
+func respLen(b []byte) (int, error) {
+	idx := bytes.Index(b, crlfBytes)
+	if idx < 1 {
+		return 0, errShort
+	}
+	switch b[0] {
+	case '+', '-', ':':
+		return idx + 2, nil
+	case '$':
+		n, err := strconv.Atoi(string(b[1:idx]))
+		if err != nil {
+			return 0, errBadResp
+		}
+		if n < 0 {
+			return idx + 2, nil
+		}
+		if l := idx + 2 + n + 2; l <= len(b) {
+			return l, nil
+		}
+		return 0, errShort
+	case '*':
+		n, err := strconv.Atoi(string(b[1:idx]))
+		if err != nil {
+			return 0, errBadResp
+		}
+		off := idx + 2
+		for i := 0; i < n; i++ {
+			l, err := respLen(b[off:])
+			if err != nil {
+				return 0, err
+			}
+			off += l
+		}
+		return off, nil
+	}
+	return 0, errBadResp
+}
+
+func (respCodec) count(req []byte) (n int, err error) {
+	for len(req) > 0 {
+		var l int
+		if l, err = respLen(req); err != nil {
+			return
+		}
+		req = req[l:]
+		n++
+	}
+	return
+}
+
+func (c respCodec) read(br *bufio.Reader, dst []byte) ([]byte, error) {
+	line, err := br.ReadBytes('\n')
+	if err != nil {
+		return dst, err
+	}
+	dst = append(dst, line...)
+	if len(line) < 3 {
+		return dst, errBadResp
+	}
+	switch line[0] {
+	case '+', '-', ':':
+		return dst, nil
+	case '$', '*':
+	default:
+		return dst, errBadResp
+	}
+	n, err := strconv.Atoi(string(line[1 : len(line)-2]))
+	if err != nil {
+		return dst, errBadResp
+	}
+	if line[0] == '$' {
+		if n < 0 {
+			return dst, nil
+		}
+		return readFull(br, dst, n+2)
+	}
+	for i := 0; i < n; i++ {
+		if dst, err = c.read(br, dst); err != nil {
+			return dst, err
+		}
+	}
+	return dst, nil
+}
+
+func (respCodec) isError(reply []byte) bool {
+	for len(reply) > 0 {
+		if reply[0] == '-' {
+			return true
+		}
+		l, err := respLen(reply)
+		if err != nil {
+			return false
+		}
+		reply = reply[l:]
+	}
+	return false
+}
+
+func (respCodec) name(req []byte) string {
+	// NOTE: *N\r\n$L\r\nCMD\r\n
+	lines := bytes.SplitN(req, crlfBytes, 4)
+	if len(lines) < 3 || len(req) == 0 || req[0] != '*' {
+		return "unknown"
+	}
+	return string(bytes.ToUpper(lines[2]))
+}
+
+func readFull(br *bufio.Reader, dst []byte, n int) ([]byte, error) {
+	l := len(dst)
+	dst = append(dst, make([]byte, n)...)
+	_, err := io.ReadFull(br, dst[l:])
+	return dst, err
+}
+
+type mcCodec struct{}
+
+var (
+	mcStorageCmds = map[string]bool{"set": true, "add": true, "replace": true, "append": true, "prepend": true, "cas": true}
+	mcValueBytes  = []byte("VALUE ")
+	mcErrorBytes  = [][]byte{[]byte("ERROR"), []byte("CLIENT_ERROR"), []byte("SERVER_ERROR")}
+	noreplyBytes  = []byte("noreply")
+)
+
+func (mcCodec) count(req []byte) (n int, err error) {
+	for len(req) > 0 {
+		idx := bytes.Index(req, crlfBytes)
+		if idx < 0 {
+			return 0, errShort
+		}
+		fields := bytes.Fields(req[:idx])
+		req = req[idx+2:]
+		if len(fields) == 0 {
+			return 0, errShort
+		}
+		if mcStorageCmds[string(fields[0])] {
+			if len(fields) < 5 {
+				return 0, errShort
+			}
+			l, err := strconv.Atoi(string(fields[4]))
+			if err != nil || l+2 > len(req) {
+				return 0, errShort
+			}
+			req = req[l+2:]
+		}
+		if !bytes.Equal(fields[len(fields)-1], noreplyBytes) {
+			n++
+		}
+	}
+	return
+}
+
+func (mcCodec) read(br *bufio.Reader, dst []byte) ([]byte, error) {
+	for {
+		line, err := br.ReadBytes('\n')
+		if err != nil {
+			return dst, err
+		}
+		dst = append(dst, line...)
+		if !bytes.HasPrefix(line, mcValueBytes) {
+			// NOTE: END of values or the reply of single line
+			return dst, nil
+		}
+		// NOTE: VALUE <key> <flags> <bytes> [<cas unique>]\r\n
+		fields := bytes.Fields(line)
+		if len(fields) < 4 {
+			return dst, errBadResp
+		}
+		n, err := strconv.Atoi(string(fields[3]))
+		if err != nil {
+			return dst, errBadResp
+		}
+		if dst, err = readFull(br, dst, n+2); err != nil {
+			return dst, err
+		}
+	}
+}
+
+func (mcCodec) isError(reply []byte) bool {
+	for _, line := range bytes.Split(reply, crlfBytes) {
+		for _, eb := range mcErrorBytes {
+			if bytes.HasPrefix(line, eb) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (mcCodec) name(req []byte) string {
+	if idx := bytes.IndexAny(req, " \r"); idx > 0 {
+		return string(req[:idx])
+	}
+	return "unknown"
+}
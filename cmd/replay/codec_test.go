@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRespCodec(t *testing.T) {
+	cd, err := newCodec("redis_cluster")
+	assert.NoError(t, err)
+	n, err := cd.count([]byte("*2\r\n$3\r\nGET\r\n$1\r\na\r\n*3\r\n$3\r\nSET\r\n$1\r\na\r\n$-1\r\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	_, err = cd.count([]byte("*2\r\n$3\r\nGET\r\n$1\r\n"))
+	assert.Equal(t, errShort, err)
+	assert.Equal(t, "GET", cd.name([]byte("*2\r\n$3\r\nget\r\n$1\r\na\r\n")))
+
+	br := bufio.NewReader(strings.NewReader("*3\r\n$5\r\nhello\r\n$-1\r\n:1\r\n-ERR x\r\n+OK\r\n"))
+	reply, err := cd.read(br, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "*3\r\n$5\r\nhello\r\n$-1\r\n:1\r\n", string(reply))
+	assert.False(t, cd.isError(reply))
+	reply, err = cd.read(br, reply)
+	assert.NoError(t, err)
+	assert.True(t, cd.isError(reply))
+	_, err = cd.read(br, nil)
+	assert.NoError(t, err)
+}
+
+func TestMCCodec(t *testing.T) {
+	cd, err := newCodec("memcache")
+	assert.NoError(t, err)
+	n, err := cd.count([]byte("get a\r\nset a 0 0 5\r\nhel\r\n\r\nset b 0 0 1 noreply\r\nx\r\ndelete a\r\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	_, err = cd.count([]byte("set a 0 0 5\r\nhe"))
+	assert.Equal(t, errShort, err)
+	assert.Equal(t, "set", cd.name([]byte("set a 0 0 5\r\nhello\r\n")))
+
+	br := bufio.NewReader(strings.NewReader("VALUE a 0 5\r\nhello\r\nVALUE b 0 1\r\nx\r\nEND\r\nSTORED\r\nSERVER_ERROR out of memory\r\n"))
+	reply, err := cd.read(br, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "VALUE a 0 5\r\nhello\r\nVALUE b 0 1\r\nx\r\nEND\r\n", string(reply))
+	reply, err = cd.read(br, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "STORED\r\n", string(reply))
+	assert.False(t, cd.isError(reply))
+	reply, err = cd.read(br, nil)
+	assert.NoError(t, err)
+	assert.True(t, cd.isError(reply))
+
+	_, err = newCodec("memcache_binary")
+	assert.Error(t, err)
+}
+
+func TestReplay(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				br := bufio.NewReader(c)
+				for {
+					// NOTE: replies OK to each GET of one key
+					for i := 0; i < 5; i++ {
+						if _, err := br.ReadString('\n'); err != nil {
+							return
+						}
+					}
+					_, _ = c.Write([]byte("+OK\r\n"))
+				}
+			}(c)
+		}
+	}()
+	addr, timeout, speed = l.Addr().String(), time.Second, 0
+	get := []byte("*2\r\n$3\r\nGET\r\n$1\r\na\r\n")
+	recs := []*record{
+		{Time: 1, Client: "c1", Type: "redis", Req: get, Reply: []byte("+OK\r\n"), Dur: 100},
+		{Time: 2, Client: "c2", Type: "redis", Req: get, Reply: []byte("$-1\r\n"), Dur: 200},
+		{Time: 3, Client: "c1", Type: "redis", Req: append(append([]byte{}, get...), get...), Err: "timeout", Dur: 300},
+	}
+	rets := replay(recs)
+	assert.Len(t, rets, 3)
+	for _, ret := range rets {
+		assert.NoError(t, ret.err)
+	}
+	assert.Equal(t, "+OK\r\n", string(rets[1].reply))
+	assert.Equal(t, "+OK\r\n+OK\r\n", string(rets[2].reply))
+
+	s := summarize(rets)
+	assert.Equal(t, 3, s.total)
+	assert.Equal(t, 1, s.recErrors)
+	assert.Equal(t, 1, s.goneErrors)
+	assert.Equal(t, 2, s.compared)
+	assert.Equal(t, 1, s.mismatches)
+	assert.Equal(t, 1, s.cmds["GET"])
+}
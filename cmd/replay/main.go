@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	files   string
+	addr    string
+	cluster string
+	speed   float64
+	timeout time.Duration
+)
+
+var usage = func() {
+	fmt.Fprintf(os.Stderr, "Usage of Overlord replay:\n")
+	fmt.Fprintf(os.Stderr, "  replay the traffic captured by proxy against a proxy or backend node and report the differences.\n")
+	flag.PrintDefaults()
+}
+
+func init() {
+	flag.Usage = usage
+	flag.StringVar(&files, "file", "", "capture files split by comma, eg: capture.log.1,capture.log")
+	flag.StringVar(&addr, "addr", "", "addr of backend node to be replayed against, the requests are captured after rewritten by proxy, eg: key_prefix.")
+	flag.StringVar(&cluster, "cluster", "", "only replay the records of cluster, all if empty.")
+	flag.Float64Var(&speed, "speed", 1, "speed of replay scaled by the original, 0 means as fast as possible.")
+	flag.DurationVar(&timeout, "timeout", time.Second, "timeout of dial, write and read.")
+}
+
+// record is the message captured by proxy, see proxy/capture.Record.
+type record struct {
+	Time    int64  `json:"ts"`
+	Cluster string `json:"cluster"`
+	Client  string `json:"client"`
+	Type    string `json:"type"`
+	Req     []byte `json:"req"`
+	Reply   []byte `json:"reply"`
+	Err     string `json:"err"`
+	Dur     int64  `json:"dur_us"`
+}
+
+// result is the result of record replayed.
+type result struct {
+	rec   *record
+	reply []byte
+	err   error
+	dur   time.Duration
+}
+
+func main() {
+	flag.Parse()
+	if files == "" || addr == "" || speed < 0 {
+		usage()
+		os.Exit(1)
+	}
+	recs, err := load(strings.Split(files, ","), cluster)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fail to load capture due %s\n", err)
+		os.Exit(1)
+	}
+	if len(recs) == 0 {
+		fmt.Fprintf(os.Stderr, "no record to replay\n")
+		os.Exit(1)
+	}
+	rets := replay(recs)
+	report(os.Stdout, rets)
+}
+
+// load reads the records of files in the order of time.
+func load(names []string, cluster string) (recs []*record, err error) {
+	for _, name := range names {
+		var f *os.File
+		if f, err = os.Open(name); err != nil {
+			return
+		}
+		sc := bufio.NewScanner(f)
+		sc.Buffer(make([]byte, 64*1024), 1024*1024*1024)
+		for sc.Scan() {
+			rec := &record{}
+			if err = json.Unmarshal(sc.Bytes(), rec); err != nil {
+				_ = f.Close()
+				return nil, fmt.Errorf("%s: %s", name, err)
+			}
+			if cluster != "" && rec.Cluster != cluster {
+				continue
+			}
+			recs = append(recs, rec)
+		}
+		err = sc.Err()
+		_ = f.Close()
+		if err != nil {
+			return
+		}
+	}
+	sort.SliceStable(recs, func(i, j int) bool { return recs[i].Time < recs[j].Time })
+	return
+}
+
+// replay replays the records of each client by a connection in the original
+// order and interval scaled by speed.
+func replay(recs []*record) []*result {
+	var (
+		clients = map[string][]int{}
+		rets    = make([]*result, len(recs))
+		wg      sync.WaitGroup
+		begin   = recs[0].Time
+		start   = time.Now()
+	)
+	for i, rec := range recs {
+		clients[rec.Client] = append(clients[rec.Client], i)
+	}
+	for _, idxs := range clients {
+		wg.Add(1)
+		go func(idxs []int) {
+			defer wg.Done()
+			var c *conn
+			for _, i := range idxs {
+				rec := recs[i]
+				if speed > 0 {
+					at := start.Add(time.Duration(float64(rec.Time-begin) / speed))
+					time.Sleep(time.Until(at))
+				}
+				if c == nil {
+					var err error
+					if c, err = dial(rec.Type); err != nil {
+						rets[i] = &result{rec: rec, err: err}
+						continue
+					}
+				}
+				rets[i] = c.do(rec)
+				if rets[i].err != nil {
+					c.close()
+					c = nil
+				}
+			}
+			if c != nil {
+				c.close()
+			}
+		}(idxs)
+	}
+	wg.Wait()
+	return rets
+}
+
+type conn struct {
+	nc    net.Conn
+	br    *bufio.Reader
+	codec codec
+}
+
+func dial(typ string) (*conn, error) {
+	cd, err := newCodec(typ)
+	if err != nil {
+		return nil, err
+	}
+	nc, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{nc: nc, br: bufio.NewReader(nc), codec: cd}, nil
+}
+
+func (c *conn) do(rec *record) *result {
+	ret := &result{rec: rec}
+	n, err := c.codec.count(rec.Req)
+	if err != nil {
+		ret.err = err
+		return ret
+	}
+	now := time.Now()
+	_ = c.nc.SetDeadline(now.Add(timeout))
+	if _, ret.err = c.nc.Write(rec.Req); ret.err != nil {
+		return ret
+	}
+	for i := 0; i < n; i++ {
+		if ret.reply, ret.err = c.codec.read(c.br, ret.reply); ret.err != nil {
+			break
+		}
+	}
+	ret.dur = time.Since(now)
+	return ret
+}
+
+func (c *conn) close() {
+	_ = c.nc.Close()
+}
+
+// stats is the stats of replay.
+type stats struct {
+	total      int
+	errors     int // replayed with error
+	recErrors  int // recorded with error
+	newErrors  int // error in replay but not in record
+	goneErrors int // error in record but not in replay
+	compared   int
+	mismatches int
+	cmds       map[string]int // mismatches by command
+	recDurs    []time.Duration
+	durs       []time.Duration
+}
+
+func summarize(rets []*result) *stats {
+	s := &stats{cmds: map[string]int{}}
+	for _, ret := range rets {
+		rec := ret.rec
+		s.total++
+		cd, _ := newCodec(rec.Type)
+		recErr := rec.Err != "" || (cd != nil && cd.isError(rec.Reply))
+		replayErr := ret.err != nil || (cd != nil && cd.isError(ret.reply))
+		if recErr {
+			s.recErrors++
+		}
+		if replayErr {
+			s.errors++
+		}
+		if replayErr && !recErr {
+			s.newErrors++
+		} else if recErr && !replayErr {
+			s.goneErrors++
+		}
+		if len(rec.Reply) > 0 && ret.err == nil {
+			s.compared++
+			if !bytes.Equal(rec.Reply, ret.reply) {
+				s.mismatches++
+				if cd != nil {
+					s.cmds[cd.name(rec.Req)]++
+				}
+			}
+		}
+		s.recDurs = append(s.recDurs, time.Duration(rec.Dur)*time.Microsecond)
+		if ret.err == nil {
+			s.durs = append(s.durs, ret.dur)
+		}
+	}
+	return s
+}
+
+func report(w io.Writer, rets []*result) {
+	s := summarize(rets)
+	fmt.Fprintf(w, "records: %d\n", s.total)
+	fmt.Fprintf(w, "errors: recorded %d, replayed %d, new %d, gone %d\n", s.recErrors, s.errors, s.newErrors, s.goneErrors)
+	fmt.Fprintf(w, "replies: compared %d, mismatched %d\n", s.compared, s.mismatches)
+	names := make([]string, 0, len(s.cmds))
+	for name := range s.cmds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %s: %d\n", name, s.cmds[name])
+	}
+	fmt.Fprintf(w, "latency recorded: %s\n", latency(s.recDurs))
+	fmt.Fprintf(w, "latency replayed: %s\n", latency(s.durs))
+}
+
+func latency(durs []time.Duration) string {
+	if len(durs) == 0 {
+		return "-"
+	}
+	sort.Slice(durs, func(i, j int) bool { return durs[i] < durs[j] })
+	p := func(q float64) time.Duration {
+		return durs[int(q*float64(len(durs)-1))]
+	}
+	return fmt.Sprintf("p50 %s, p90 %s, p99 %s, max %s", p(0.5), p(0.9), p(0.99), durs[len(durs)-1])
+}
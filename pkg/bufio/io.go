@@ -79,6 +79,23 @@ func (r *Reader) Read() error {
 	return nil
 }
 
+// Discard drop the next n bytes, the bytes not buffered are read and dropped
+// without growing the buffer.
+func (r *Reader) Discard(n int) error {
+	for {
+		m := r.b.buffered()
+		if m >= n {
+			r.b.r += n
+			return nil
+		}
+		r.b.r += m
+		n -= m
+		if err := r.Read(); err != nil {
+			return err
+		}
+	}
+}
+
 // ReadLine will read until meet the first crlf bytes.
 func (r *Reader) ReadLine() (line []byte, err error) {
 	if r.err != nil {
@@ -91,6 +91,19 @@ func TestReaderReadExact(t *testing.T) {
 	assert.Equal(t, ErrBufferFull, err)
 }
 
+func TestReaderDiscard(t *testing.T) {
+	bts := _genData()
+
+	b := NewReader(bytes.NewBuffer(bts), Get(defaultBufferSize))
+	_ = b.Read()
+	assert.NoError(t, b.Discard(len(bts)-1))
+	assert.Equal(t, defaultBufferSize, b.Buffer().len())
+	data, err := b.ReadExact(1)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{fbyte}, data)
+	assert.Error(t, b.Discard(1))
+}
+
 func TestWriterWriteOk(t *testing.T) {
 	data := "Bilibili 干杯 - ( ゜- ゜)つロ"
 	conn := libnet.NewConn(mockconn.CreateConn(nil, 1), time.Second, time.Second)
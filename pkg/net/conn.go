@@ -42,6 +42,16 @@ func (c *Conn) Dup() *Conn {
 	return DialWithTimeout(c.addr, c.dialTimeout, c.readTimeout, c.writeTimeout)
 }
 
+// ReadTimeout returns the auto read timeout.
+func (c *Conn) ReadTimeout() time.Duration {
+	return c.readTimeout
+}
+
+// SetReadTimeout change the auto read timeout, 0 means no timeout.
+func (c *Conn) SetReadTimeout(timeout time.Duration) {
+	c.readTimeout = timeout
+}
+
 func (c *Conn) Read(b []byte) (n int, err error) {
 	if c.closed || c.Conn == nil {
 		return 0, ErrConnClosed
@@ -0,0 +1,246 @@
+package capture
+
+import (
+	errs "errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ducesoft/overlord/pkg/log"
+	"github.com/ducesoft/overlord/pkg/types"
+	"github.com/ducesoft/overlord/proxy/proto"
+)
+
+// errors
+var (
+	ErrCaptureNoFile   = errs.New("capture file is not set")
+	ErrCaptureRunning  = errs.New("capture is running")
+	ErrCaptureStopped  = errs.New("capture is not running")
+	ErrCaptureNegative = errs.New("capture seconds and count must not be negative")
+	// ErrCaptureNotSupported is the error of cluster whose requests can't be
+	// captured, eg: memcache_binary.
+	ErrCaptureNotSupported = errs.New("capture not supported by memcache_binary")
+)
+
+// Record is the message captured, it's written as a JSON line into the capture
+// file and the bytes are encoded in base64 by JSON.
+type Record struct {
+	// Time is the unix time in nanoseconds when the message is forwarded.
+	Time    int64  `json:"ts"`
+	Cluster string `json:"cluster"`
+	Client  string `json:"client"`
+	Type    string `json:"type"`
+	// Req is the requests in the protocol sent to backend nodes, which are
+	// rewritten by proxy, eg: key_prefix, so it's replayed against backend.
+	Req []byte `json:"req"`
+	// Reply is the replies in the protocol read from backend nodes, empty if
+	// the replies are not captured or the message is batch.
+	Reply []byte `json:"reply,omitempty"`
+	Err   string `json:"err,omitempty"`
+	// Dur is the duration in microseconds from forwarded to replied.
+	Dur int64 `json:"dur_us"`
+
+	msg     *proto.Message
+	replies bool
+}
+
+// session is the capture running, remain is the count of messages left to be
+// captured when count is limited.
+type session struct {
+	deadline time.Time
+	limited  bool
+	remain   int64
+	replies  bool
+}
+
+// Stats is the stats of capture.
+type Stats struct {
+	Active   bool
+	Captured int64
+	Dropped  int64
+}
+
+// Capture captures the messages of cluster into the capture file when it's
+// started by admin, for a time window, a count of messages or until stopped.
+type Capture struct {
+	name      string
+	cacheType string
+
+	lock sync.Mutex
+	sess atomic.Value // *session, nil if not running
+
+	captured int64
+	dropped  int64
+}
+
+// New new a capture of cluster which is stopped.
+func New(name, cacheType string) *Capture {
+	c := &Capture{name: name, cacheType: cacheType}
+	c.sess.Store((*session)(nil))
+	return c
+}
+
+// Name returns the name of cluster.
+func (c *Capture) Name() string {
+	return c.name
+}
+
+func (c *Capture) session() *session {
+	return c.sess.Load().(*session)
+}
+
+// Start starts capturing for d and at most count messages, zero means no limit
+// and the capture runs until stopped. The replies are captured if replies.
+func (c *Capture) Start(d time.Duration, count int, replies bool) error {
+	if c.cacheType == string(types.CacheTypeMemcacheBinary) {
+		return ErrCaptureNotSupported
+	}
+	if d < 0 || count < 0 {
+		return ErrCaptureNegative
+	}
+	if fh == nil {
+		return ErrCaptureNoFile
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.session() != nil {
+		return ErrCaptureRunning
+	}
+	s := &session{limited: count > 0, remain: int64(count), replies: replies}
+	if d > 0 {
+		s.deadline = time.Now().Add(d)
+	}
+	c.sess.Store(s)
+	log.Infof("cluster(%s) start capture duration:%s count:%d replies:%t", c.name, d, count, replies)
+	return nil
+}
+
+// Stop stops capturing, the messages captured before are still written.
+func (c *Capture) Stop() error {
+	if !c.finish(nil) {
+		return ErrCaptureStopped
+	}
+	return nil
+}
+
+// finish stops the session s, or the running one if s is nil.
+func (c *Capture) finish(s *session) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	cur := c.session()
+	if cur == nil || (s != nil && s != cur) {
+		return false
+	}
+	c.sess.Store((*session)(nil))
+	log.Infof("cluster(%s) stop capture", c.name)
+	return true
+}
+
+// Sample appends the records of messages into recs if capture is running, it
+// must be called before msgs are forwarded because the requests may be merged
+// or replaced by replies.
+func (c *Capture) Sample(client string, msgs []*proto.Message, recs []*Record) []*Record {
+	s := c.session()
+	if s == nil {
+		return recs
+	}
+	now := time.Now()
+	if !s.deadline.IsZero() && now.After(s.deadline) {
+		c.finish(s)
+		return recs
+	}
+	for _, msg := range msgs {
+		if proto.IsSession(msg) {
+			continue
+		}
+		var req []byte
+		for _, r := range msg.Requests() {
+			if cp, ok := r.(proto.Capturer); ok {
+				req = cp.AppendRequest(req)
+			}
+		}
+		if len(req) == 0 {
+			continue
+		}
+		if s.limited {
+			n := atomic.AddInt64(&s.remain, -1)
+			if n < 0 {
+				return recs
+			}
+			if n == 0 {
+				c.finish(s)
+			}
+		}
+		recs = append(recs, &Record{
+			Time:    now.UnixNano(),
+			Cluster: c.name,
+			Client:  client,
+			Type:    c.cacheType,
+			Req:     req,
+			msg:     msg,
+			replies: s.replies,
+		})
+	}
+	return recs
+}
+
+// Push writes the records sampled after the messages are replied, the records
+// are dropped if the file writer is busy.
+func (c *Capture) Push(recs []*Record) {
+	for _, rec := range recs {
+		msg := rec.msg
+		rec.msg = nil
+		rec.Dur = int64(time.Duration(time.Now().UnixNano()-rec.Time) / time.Microsecond)
+		if err := msg.Err(); err != nil {
+			rec.Err = err.Error()
+		} else if rec.replies && !msg.IsBatch() {
+			for _, r := range msg.Requests() {
+				rec.Reply = r.(proto.Capturer).AppendReply(rec.Reply)
+			}
+		}
+		if fh != nil && fh.save(rec) {
+			atomic.AddInt64(&c.captured, 1)
+		} else {
+			atomic.AddInt64(&c.dropped, 1)
+		}
+	}
+}
+
+// Stats returns the stats of capture.
+func (c *Capture) Stats() Stats {
+	return Stats{
+		Active:   c.session() != nil,
+		Captured: atomic.LoadInt64(&c.captured),
+		Dropped:  atomic.LoadInt64(&c.dropped),
+	}
+}
+
+var (
+	captureMap  = map[string]*Capture{}
+	captureLock sync.RWMutex
+)
+
+// Register the capture of cluster which can be started by http, the old one of
+// the same name is replaced.
+func Register(c *Capture) {
+	captureLock.Lock()
+	captureMap[c.name] = c
+	captureLock.Unlock()
+}
+
+// Get returns the capture of cluster, nil if not registered.
+func Get(name string) *Capture {
+	captureLock.RLock()
+	defer captureLock.RUnlock()
+	return captureMap[name]
+}
+
+// Init capture with file and http, capture can not be started if fileName is empty.
+func Init(fileName string, maxBytes int, backupCount int) error {
+	registerCaptureHTTP()
+	if fileName == "" {
+		return nil
+	}
+	log.Infof("setup capture for file [%s]", fileName)
+	return initFileHandler(fileName, maxBytes, backupCount)
+}
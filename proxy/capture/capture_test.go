@@ -0,0 +1,171 @@
+package capture
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ducesoft/overlord/proxy/proto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type _request struct {
+	req, reply string
+}
+
+func (r *_request) CmdString() string               { return "get" }
+func (r *_request) Cmd() []byte                     { return []byte("get") }
+func (r *_request) Key() []byte                     { return nil }
+func (r *_request) Put()                            {}
+func (r *_request) Merge([]proto.Request) error     { return nil }
+func (r *_request) Slowlog() *proto.SlowlogEntry    { return nil }
+func (r *_request) AppendRequest(dst []byte) []byte { return append(dst, r.req...) }
+func (r *_request) AppendReply(dst []byte) []byte   { return append(dst, r.reply...) }
+func (r *_request) replied(reply string) *_request  { r.reply = reply; return r }
+
+func _msg(reqs ...*_request) *proto.Message {
+	msg := proto.NewMessage()
+	for _, req := range reqs {
+		msg.WithRequest(req)
+	}
+	if len(reqs) > 1 {
+		msg.Batch()
+	}
+	return msg
+}
+
+// _withExchange replaces the file handler by an exchange which is never written.
+func _withExchange(t *testing.T, size int) chan *Record {
+	old := fh
+	fh = &fileHandler{exchange: make(chan *Record, size)}
+	t.Cleanup(func() { fh = old })
+	return fh.exchange
+}
+
+func TestCaptureStartStop(t *testing.T) {
+	c := New("test", "redis")
+	old := fh
+	fh = nil
+	assert.Equal(t, ErrCaptureNoFile, c.Start(0, 0, false))
+	fh = old
+
+	_withExchange(t, 1)
+	assert.Equal(t, ErrCaptureNegative, c.Start(-time.Second, 0, false))
+	assert.Equal(t, ErrCaptureStopped, c.Stop())
+	assert.NoError(t, c.Start(0, 0, false))
+	assert.True(t, c.Stats().Active)
+	assert.Equal(t, ErrCaptureRunning, c.Start(0, 0, false))
+	assert.NoError(t, c.Stop())
+	assert.False(t, c.Stats().Active)
+
+	assert.NoError(t, c.Start(time.Millisecond, 0, false))
+	time.Sleep(2 * time.Millisecond)
+	recs := c.Sample("client", []*proto.Message{_msg(&_request{req: "a"})}, nil)
+	assert.Len(t, recs, 0)
+	assert.False(t, c.Stats().Active)
+}
+
+func TestCaptureSamplePush(t *testing.T) {
+	exchange := _withExchange(t, 3)
+	c := New("test", "redis")
+	assert.NoError(t, c.Start(0, 3, true))
+
+	r1, r2, r3, r4 := &_request{req: "a"}, &_request{req: "b"}, &_request{req: "c"}, &_request{req: "d"}
+	msgs := []*proto.Message{_msg(r1), _msg(&_request{}), _msg(r2, r3), _msg(r4), _msg(&_request{req: "e"})}
+	recs := c.Sample("127.0.0.1:1234", msgs, nil)
+	assert.Len(t, recs, 3)
+	assert.False(t, c.Stats().Active, "stopped by count")
+
+	r1.replied("1")
+	r2.replied("2")
+	r3.replied("3")
+	msgs[3].WithError(errors.New("timeout"))
+	c.Push(recs)
+
+	rec := <-exchange
+	assert.Equal(t, "test", rec.Cluster)
+	assert.Equal(t, "127.0.0.1:1234", rec.Client)
+	assert.Equal(t, "redis", rec.Type)
+	assert.Equal(t, "a", string(rec.Req))
+	assert.Equal(t, "1", string(rec.Reply))
+	assert.Nil(t, rec.msg)
+	rec = <-exchange
+	assert.Equal(t, "bc", string(rec.Req))
+	assert.Len(t, rec.Reply, 0, "batch is not replied")
+	rec = <-exchange
+	assert.Equal(t, "d", string(rec.Req))
+	assert.Equal(t, "timeout", rec.Err)
+
+	assert.NoError(t, c.Start(0, 0, false))
+	recs = c.Sample("127.0.0.1:1234", []*proto.Message{_msg(r1.replied("1"))}, nil)
+	c.Push(recs)
+	assert.Len(t, (<-exchange).Reply, 0, "replies are not captured")
+	recs = c.Sample("127.0.0.1:1234", []*proto.Message{_msg(r1), _msg(r1), _msg(r1), _msg(r1)}, nil)
+	c.Push(recs)
+	s := c.Stats()
+	assert.Equal(t, int64(7), s.Captured)
+	assert.Equal(t, int64(1), s.Dropped)
+}
+
+func TestFileRotate(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "capture.log")
+	f := &fileHandler{fileName: name, maxBytes: 200, backupCount: 2}
+	assert.NoError(t, f.openFile())
+	defer f.close()
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, f.write(&Record{Time: int64(i), Cluster: "test", Req: []byte("*1\r\n$4\r\nPING\r\n")}))
+	}
+	assert.NoError(t, f.wr.Flush())
+	for _, fn := range []string{name, name + ".1", name + ".2"} {
+		_, err := os.Stat(fn)
+		assert.NoError(t, err)
+	}
+	_, err := os.Stat(name + ".3")
+	assert.True(t, os.IsNotExist(err))
+
+	fd, err := os.Open(name + ".1")
+	assert.NoError(t, err)
+	defer fd.Close()
+	sc := bufio.NewScanner(fd)
+	assert.True(t, sc.Scan())
+	rec := &Record{}
+	assert.NoError(t, json.Unmarshal(sc.Bytes(), rec))
+	assert.Equal(t, "*1\r\n$4\r\nPING\r\n", string(rec.Req))
+}
+
+func TestCaptureHTTP(t *testing.T) {
+	_withExchange(t, 1)
+	Register(New("http", "memcache"))
+
+	w := httptest.NewRecorder()
+	startCapture(w, httptest.NewRequest("GET", "/capture/start?cluster=http&seconds=10&count=5&replies=true", nil))
+	assert.Equal(t, 200, w.Code)
+	assert.True(t, Get("http").Stats().Active)
+	w = httptest.NewRecorder()
+	startCapture(w, httptest.NewRequest("GET", "/capture/start?cluster=http", nil))
+	assert.Equal(t, 400, w.Code)
+	w = httptest.NewRecorder()
+	startCapture(w, httptest.NewRequest("GET", "/capture/start?cluster=none", nil))
+	assert.Equal(t, 404, w.Code)
+	Register(New("binary", "memcache_binary"))
+	assert.Equal(t, ErrCaptureNotSupported, Get("binary").Start(0, 0, false))
+	w = httptest.NewRecorder()
+	startCapture(w, httptest.NewRequest("GET", "/capture/start?cluster=binary", nil))
+	assert.Equal(t, 400, w.Code)
+	assert.False(t, Get("binary").Stats().Active)
+
+	w = httptest.NewRecorder()
+	showCapture(w, httptest.NewRequest("GET", "/capture", nil))
+	assert.Contains(t, w.Body.String(), `{"cluster":"http","active":true,"captured":0,"dropped":0}`)
+
+	w = httptest.NewRecorder()
+	stopCapture(w, httptest.NewRequest("GET", "/capture/stop?cluster=http", nil))
+	assert.Equal(t, 200, w.Code)
+	assert.False(t, Get("http").Stats().Active)
+}
@@ -0,0 +1,131 @@
+package capture
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/ducesoft/overlord/pkg/log"
+)
+
+type fileHandler struct {
+	fd            *os.File
+	wr            *bufio.Writer
+	exchange      chan *Record
+	flushInterval time.Duration
+
+	fileName    string
+	curBytes    int
+	maxBytes    int
+	backupCount int
+}
+
+func (f *fileHandler) save(rec *Record) bool {
+	select {
+	case f.exchange <- rec:
+		return true
+	default:
+		return false
+	}
+}
+
+func (f *fileHandler) openFile() (err error) {
+	f.fd, err = os.OpenFile(f.fileName, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return
+	}
+	fdStat, err := f.fd.Stat()
+	if err != nil {
+		return
+	}
+	f.curBytes = int(fdStat.Size())
+	f.wr = bufio.NewWriterSize(f.fd, 40960)
+	return
+}
+
+// rotate renames the file to file.1 and file.N to file.N+1, the oldest one is
+// overwritten. The file is truncated if no backup.
+func (f *fileHandler) rotate() error {
+	if err := f.wr.Flush(); err != nil {
+		return err
+	}
+	_ = f.fd.Close()
+	if f.backupCount > 0 {
+		for i := f.backupCount - 1; i > 0; i-- {
+			sfn := fmt.Sprintf("%s.%d", f.fileName, i)
+			dfn := fmt.Sprintf("%s.%d", f.fileName, i+1)
+			_ = os.Rename(sfn, dfn)
+		}
+		_ = os.Rename(f.fileName, fmt.Sprintf("%s.1", f.fileName))
+	} else {
+		_ = os.Remove(f.fileName)
+	}
+	return f.openFile()
+}
+
+func (f *fileHandler) write(rec *Record) error {
+	bs, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	bs = append(bs, '\n')
+	if _, err = f.wr.Write(bs); err != nil {
+		return err
+	}
+	f.curBytes += len(bs)
+	if f.maxBytes > 0 && f.curBytes >= f.maxBytes {
+		return f.rotate()
+	}
+	return nil
+}
+
+func (f *fileHandler) close() error {
+	if f.fd != nil {
+		return f.fd.Close()
+	}
+	return nil
+}
+
+func (f *fileHandler) run() {
+	defer f.close()
+	ticker := time.NewTicker(f.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case rec := <-f.exchange:
+			if err := f.write(rec); err != nil {
+				log.Errorf("fail to write capture into file due %s", err)
+				return
+			}
+		case <-ticker.C:
+			if f.wr.Buffered() > 0 {
+				if err := f.wr.Flush(); err != nil {
+					log.Errorf("fail to flush capture due %s", err)
+					return
+				}
+			}
+		}
+	}
+}
+
+var fh *fileHandler
+
+// initFileHandler will init the file handler to the given file
+func initFileHandler(fileName string, maxBytes int, backupCount int) error {
+	h := &fileHandler{
+		exchange:      make(chan *Record, 4096),
+		flushInterval: time.Second,
+		maxBytes:      maxBytes,
+		backupCount:   backupCount,
+		fileName:      fileName,
+	}
+	if err := h.openFile(); err != nil {
+		return err
+	}
+	fh = h
+	go fh.run()
+	return nil
+}
@@ -0,0 +1,94 @@
+package capture
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// captureState is the state of capture reported by http.
+type captureState struct {
+	Cluster  string `json:"cluster"`
+	Active   bool   `json:"active"`
+	Captured int64  `json:"captured"`
+	Dropped  int64  `json:"dropped"`
+}
+
+// showCapture will show the state of captures to http.
+func showCapture(w http.ResponseWriter, _req *http.Request) {
+	captureLock.RLock()
+	states := make([]*captureState, 0, len(captureMap))
+	for _, c := range captureMap {
+		s := c.Stats()
+		states = append(states, &captureState{Cluster: c.name, Active: s.Active, Captured: s.Captured, Dropped: s.Dropped})
+	}
+	captureLock.RUnlock()
+	sort.Slice(states, func(i, j int) bool { return states[i].Cluster < states[j].Cluster })
+
+	encoder := json.NewEncoder(w)
+	if err := encoder.Encode(states); err != nil {
+		http.Error(w, fmt.Sprintf("%s", err), http.StatusInternalServerError)
+	}
+}
+
+// startCapture will start the capture of cluster by
+// /capture/start?cluster=name&seconds=n&count=n&replies=true
+func startCapture(w http.ResponseWriter, req *http.Request) {
+	c := Get(req.FormValue("cluster"))
+	if c == nil {
+		http.Error(w, "cluster not found", http.StatusNotFound)
+		return
+	}
+	var (
+		seconds, count int
+		replies        bool
+		err            error
+	)
+	if v := req.FormValue("seconds"); v != "" {
+		if seconds, err = strconv.Atoi(v); err != nil {
+			http.Error(w, fmt.Sprintf("invalid seconds: %s", err), http.StatusBadRequest)
+			return
+		}
+	}
+	if v := req.FormValue("count"); v != "" {
+		if count, err = strconv.Atoi(v); err != nil {
+			http.Error(w, fmt.Sprintf("invalid count: %s", err), http.StatusBadRequest)
+			return
+		}
+	}
+	if v := req.FormValue("replies"); v != "" {
+		if replies, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, fmt.Sprintf("invalid replies: %s", err), http.StatusBadRequest)
+			return
+		}
+	}
+	if err = c.Start(time.Duration(seconds)*time.Second, count, replies); err != nil {
+		http.Error(w, fmt.Sprintf("%s", err), http.StatusBadRequest)
+		return
+	}
+	fmt.Fprintln(w, "OK")
+}
+
+// stopCapture will stop the capture of cluster by /capture/stop?cluster=name
+func stopCapture(w http.ResponseWriter, req *http.Request) {
+	c := Get(req.FormValue("cluster"))
+	if c == nil {
+		http.Error(w, "cluster not found", http.StatusNotFound)
+		return
+	}
+	if err := c.Stop(); err != nil {
+		http.Error(w, fmt.Sprintf("%s", err), http.StatusBadRequest)
+		return
+	}
+	fmt.Fprintln(w, "OK")
+}
+
+// registerCaptureHTTP will register capture by /capture, /capture/start and /capture/stop
+func registerCaptureHTTP() {
+	http.HandleFunc("/capture", showCapture)
+	http.HandleFunc("/capture/start", startCapture)
+	http.HandleFunc("/capture/stop", stopCapture)
+}
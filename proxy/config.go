@@ -10,6 +10,8 @@ import (
 
 	"github.com/ducesoft/overlord/pkg/log"
 	"github.com/ducesoft/overlord/pkg/types"
+	"github.com/ducesoft/overlord/proxy/proto"
+	"github.com/ducesoft/overlord/proxy/proto/redis"
 
 	"github.com/BurntSushi/toml"
 	"github.com/Pallinder/go-randomdata"
@@ -79,7 +81,85 @@ type ClusterConfig struct {
 	PingFailLimit     int             `toml:"ping_fail_limit"`
 	PingAutoEject     bool            `toml:"ping_auto_eject"`
 	SlowlogSlowerThan int             `toml:"slowlog_slower_than"`
+	Databases         int             `toml:"databases"`
 	Servers           []string        `toml:"servers"`
+	// CrossNodeAlgebra enable the redis set algebra across nodes computed by proxy,
+	// eg: SUNION, and AlgebraMaxMembers is the max members of each key.
+	CrossNodeAlgebra  bool `toml:"cross_node_algebra"`
+	AlgebraMaxMembers int  `toml:"algebra_max_members"`
+	// ReadPolicy routes the read only requests to replicas, which fall back to
+	// master when the replica is unhealthy, and the replication lag in seconds
+	// of redis_cluster exceeds ReplicaMaxLag.
+	ReadPolicy    proto.ReadPolicy `toml:"read_policy"`
+	ReplicaMaxLag int              `toml:"replica_max_lag"`
+	// HotKeyTopK enable the hot key detection and reports the top K keys, 1 of
+	// every HotKeySample keys is recorded and the key which count reaches
+	// HotKeyThreshold is warned, the counts decay by half every HotKeyWindow seconds.
+	HotKeyTopK      int `toml:"hotkey_topk"`
+	HotKeySample    int `toml:"hotkey_sample"`
+	HotKeyThreshold int `toml:"hotkey_threshold"`
+	HotKeyWindow    int `toml:"hotkey_window"`
+	// KeyPrefix is prepended to all the keys sent to cache servers, eg: tenant:,
+	// and removed from the keys in reply.
+	KeyPrefix string `toml:"key_prefix"`
+	// MaxValueBytes, MaxRequestBytes and MaxReplyBytes limit the bytes of each
+	// argument or value, the whole request and the reply of node, and MaxKeys
+	// limits the keys of multi-key request, eg: MGET, 0 means unlimited. The
+	// values larger than BigValueBytes are only logged and counted.
+	MaxValueBytes   int `toml:"max_value_bytes"`
+	MaxRequestBytes int `toml:"max_request_bytes"`
+	MaxReplyBytes   int `toml:"max_reply_bytes"`
+	MaxKeys         int `toml:"max_keys"`
+	BigValueBytes   int `toml:"big_value_bytes"`
+	// NearCacheMaxBytes enable the proxy-local cache of the reads of keys which
+	// match the NearCacheKeys patterns, eg: redis GET, every entry expires after
+	// NearCacheTTL milliseconds. NearCacheTracking invalidates the entries by the
+	// CLIENT TRACKING broadcasts of redis 6 masters.
+	NearCacheMaxBytes int      `toml:"near_cache_max_bytes"`
+	NearCacheTTL      int      `toml:"near_cache_ttl"`
+	NearCacheKeys     []string `toml:"near_cache_keys"`
+	NearCacheTracking bool     `toml:"near_cache_tracking"`
+	// CoalesceReads coalesces the identical reads in flight to the same node,
+	// eg: redis GET and memcache get, which share the reply of the first one.
+	// The reads following the write to the same key in the same batch of
+	// client are never coalesced to read the write.
+	CoalesceReads bool `toml:"coalesce_reads"`
+	// NodePipeQueue is the capacity of the queue of each node connection, zero
+	// means node_pipe_count*node_pipe_count*16. NodePipeWait is the max milliseconds
+	// to wait for the full queue before the request fails, zero means fail fast.
+	NodePipeQueue int `toml:"node_pipe_queue"`
+	NodePipeWait  int `toml:"node_pipe_wait"`
+	// Mirror duplicates the sampled requests to the shadow cluster asynchronously,
+	// which is another cluster of the same cache type named by Mirror or the
+	// MirrorServers, and MirrorRate of requests are sampled. At most MirrorQueue
+	// requests wait for the shadow, the others are dropped. MirrorCompare counts
+	// the replies of shadow different from cluster.
+	Mirror        string   `toml:"mirror"`
+	MirrorServers []string `toml:"mirror_servers"`
+	MirrorRate    float64  `toml:"mirror_rate"`
+	MirrorQueue   int      `toml:"mirror_queue"`
+	MirrorCompare bool     `toml:"mirror_compare"`
+	// MigrateFrom is the old servers which the cluster is migrating from to
+	// Servers, the reads of single key missed in Servers fall back to
+	// MigrateFrom, the batch reads, eg: MGET, never fall back, and the
+	// writes go to both or only Servers by MigrateWrite. MigrateReadRepair
+	// stores the values read from MigrateFrom into Servers if absent, which
+	// expire after MigrateRepairTTL seconds, 0 means never.
+	MigrateFrom       []string `toml:"migrate_from"`
+	MigrateWrite      string   `toml:"migrate_write"`
+	MigrateReadRepair bool     `toml:"migrate_read_repair"`
+	MigrateRepairTTL  int      `toml:"migrate_repair_ttl"`
+	// AdminCommands enable the redis admin commands which change the state of
+	// proxy, eg: PROXY RELOAD, PROXY MIGRATE FINISH, PROXY CAPTURE and SLOWLOG
+	// RESET. The read only ones, eg: PROXY NODES and SLOWLOG GET, are always enabled.
+	AdminCommands bool `toml:"admin_commands"`
+	// Commands extends or overrides the redis command table of cluster.
+	Commands []*redis.CommandConfig `toml:"commands"`
+
+	cmds   *redis.Commands
+	limits *proto.Limits
+	// batch counts the batch requests which are partially failed.
+	batch *proto.BatchStats
 }
 
 // ValidateStandalone validate redis/memcache address is valid or not
@@ -90,13 +170,19 @@ func ValidateStandalone(servers []string) (err error) {
 	var hasAlias bool
 	for i, server := range servers {
 		ipAlias := strings.Split(server, " ")
-		if i == 0 && len(ipAlias) == 2 {
+		if i == 0 && len(ipAlias) >= 2 {
 			hasAlias = true
 		}
-		if (hasAlias && len(ipAlias) != 2) || (!hasAlias && len(ipAlias) != 1) {
+		if (hasAlias && len(ipAlias) != 2 && len(ipAlias) != 3) || (!hasAlias && len(ipAlias) != 1) {
 			err = errors.Wrapf(ErrClusterConfInvalid, "server:%s", server)
 			return
 		}
+		if len(ipAlias) == 3 {
+			if _, e := parseServerOpts(ipAlias[2]); e != nil {
+				err = errors.Wrapf(ErrClusterConfInvalid, "server:%s", server)
+				return
+			}
+		}
 		ipPort := strings.Split(ipAlias[0], ":")
 		if len(ipPort) != 3 {
 			err = errors.Wrapf(ErrClusterConfInvalid, "server:%s", server)
@@ -117,8 +203,133 @@ func ValidateStandalone(servers []string) (err error) {
 // Validate validate config field value.
 func (cc *ClusterConfig) Validate() error {
 	// TODO(felix): complete validates
-	if cc.CacheType != types.CacheTypeRedisCluster {
-		return ValidateStandalone(cc.Servers)
+	if cc.Databases < 0 || (cc.Databases > 1 && cc.CacheType != types.CacheTypeRedis) {
+		return errors.Wrapf(ErrClusterConfInvalid, "databases:%d only supported by redis", cc.Databases)
+	}
+	if cc.CrossNodeAlgebra && cc.CacheType != types.CacheTypeRedis && cc.CacheType != types.CacheTypeRedisCluster {
+		return errors.Wrapf(ErrClusterConfInvalid, "cross_node_algebra only supported by redis and redis_cluster")
+	}
+	if cc.AdminCommands && cc.CacheType != types.CacheTypeRedis && cc.CacheType != types.CacheTypeRedisCluster {
+		return errors.Wrapf(ErrClusterConfInvalid, "admin_commands only supported by redis and redis_cluster")
+	}
+	if cc.AlgebraMaxMembers < 0 {
+		return errors.Wrapf(ErrClusterConfInvalid, "algebra_max_members:%d", cc.AlgebraMaxMembers)
+	}
+	switch cc.ReadPolicy {
+	case "", proto.ReadPolicyMaster:
+	case proto.ReadPolicyPreferReplica, proto.ReadPolicyRoundRobin:
+		if cc.CacheType != types.CacheTypeRedis && cc.CacheType != types.CacheTypeRedisCluster {
+			return errors.Wrapf(ErrClusterConfInvalid, "read_policy:%s only supported by redis and redis_cluster", cc.ReadPolicy)
+		}
+	default:
+		return errors.Wrapf(ErrClusterConfInvalid, "read_policy:%s", cc.ReadPolicy)
+	}
+	if cc.ReplicaMaxLag < 0 {
+		return errors.Wrapf(ErrClusterConfInvalid, "replica_max_lag:%d", cc.ReplicaMaxLag)
+	}
+	if cc.HotKeyTopK < 0 || cc.HotKeyTopK > 10000 {
+		return errors.Wrapf(ErrClusterConfInvalid, "hotkey_topk:%d", cc.HotKeyTopK)
+	}
+	if cc.HotKeySample < 0 || cc.HotKeyThreshold < 0 || cc.HotKeyWindow < 0 {
+		return errors.Wrapf(ErrClusterConfInvalid, "hotkey_sample:%d hotkey_threshold:%d hotkey_window:%d", cc.HotKeySample, cc.HotKeyThreshold, cc.HotKeyWindow)
+	}
+	if strings.ContainsAny(cc.KeyPrefix, " \t\r\n\x7f") || len(cc.KeyPrefix) > 200 {
+		return errors.Wrapf(ErrClusterConfInvalid, "key_prefix:%q", cc.KeyPrefix)
+	}
+	if cc.CacheType == types.CacheTypeRedisCluster && strings.ContainsAny(cc.KeyPrefix, "{}") {
+		return errors.Wrapf(ErrClusterConfInvalid, "key_prefix:%q with hash tag", cc.KeyPrefix)
+	}
+	if cc.MaxValueBytes < 0 || cc.MaxRequestBytes < 0 || cc.MaxReplyBytes < 0 || cc.MaxKeys < 0 || cc.BigValueBytes < 0 {
+		return errors.Wrapf(ErrClusterConfInvalid, "max_value_bytes:%d max_request_bytes:%d max_reply_bytes:%d max_keys:%d big_value_bytes:%d",
+			cc.MaxValueBytes, cc.MaxRequestBytes, cc.MaxReplyBytes, cc.MaxKeys, cc.BigValueBytes)
+	}
+	if cc.MaxValueBytes > 0 || cc.MaxRequestBytes > 0 || cc.MaxReplyBytes > 0 || cc.MaxKeys > 0 || cc.BigValueBytes > 0 {
+		cc.limits = &proto.Limits{
+			Cluster:    cc.Name,
+			MaxValue:   cc.MaxValueBytes,
+			MaxRequest: cc.MaxRequestBytes,
+			MaxReply:   cc.MaxReplyBytes,
+			MaxKeys:    cc.MaxKeys,
+			BigValue:   cc.BigValueBytes,
+		}
+	}
+	if cc.NearCacheMaxBytes < 0 || cc.NearCacheTTL < 0 {
+		return errors.Wrapf(ErrClusterConfInvalid, "near_cache_max_bytes:%d near_cache_ttl:%d", cc.NearCacheMaxBytes, cc.NearCacheTTL)
+	}
+	if cc.NearCacheMaxBytes > 0 {
+		if cc.CacheType == types.CacheTypeMemcacheBinary {
+			return errors.Wrapf(ErrClusterConfInvalid, "near cache not supported by memcache_binary")
+		}
+		if len(cc.NearCacheKeys) == 0 {
+			return errors.Wrapf(ErrClusterConfInvalid, "near_cache_keys is required by near cache")
+		}
+	}
+	if cc.NearCacheTracking && cc.CacheType != types.CacheTypeRedis && cc.CacheType != types.CacheTypeRedisCluster {
+		return errors.Wrapf(ErrClusterConfInvalid, "near_cache_tracking only supported by redis and redis_cluster")
+	}
+	if cc.CoalesceReads && cc.CacheType == types.CacheTypeMemcacheBinary {
+		return errors.Wrapf(ErrClusterConfInvalid, "coalesce_reads not supported by memcache_binary")
+	}
+	if cc.NodePipeQueue < 0 || cc.NodePipeWait < 0 {
+		return errors.Wrapf(ErrClusterConfInvalid, "node_pipe_queue:%d node_pipe_wait:%d", cc.NodePipeQueue, cc.NodePipeWait)
+	}
+	if cc.Mirror != "" || len(cc.MirrorServers) > 0 {
+		if cc.CacheType == types.CacheTypeMemcacheBinary {
+			return errors.Wrapf(ErrClusterConfInvalid, "mirror not supported by memcache_binary")
+		}
+		if cc.Mirror != "" && len(cc.MirrorServers) > 0 {
+			return errors.Wrapf(ErrClusterConfInvalid, "mirror:%s conflicts with mirror_servers", cc.Mirror)
+		}
+		if cc.Mirror == cc.Name {
+			return errors.Wrapf(ErrClusterConfInvalid, "mirror:%s is the cluster itself", cc.Mirror)
+		}
+		if len(cc.MirrorServers) > 0 && cc.CacheType != types.CacheTypeRedisCluster {
+			if err := ValidateStandalone(cc.MirrorServers); err != nil {
+				return errors.Wrapf(err, "mirror_servers")
+			}
+		}
+	}
+	if len(cc.MigrateFrom) > 0 {
+		if cc.CacheType != types.CacheTypeMemcache && cc.CacheType != types.CacheTypeRedis {
+			return errors.Wrapf(ErrClusterConfInvalid, "migrate_from only supported by memcache and redis")
+		}
+		if err := ValidateStandalone(cc.MigrateFrom); err != nil {
+			return errors.Wrapf(err, "migrate_from")
+		}
+	}
+	if cc.MigrateWrite != "" && cc.MigrateWrite != migrateWriteBoth && cc.MigrateWrite != migrateWriteNew {
+		return errors.Wrapf(ErrClusterConfInvalid, "migrate_write:%s", cc.MigrateWrite)
+	}
+	if cc.MigrateRepairTTL < 0 {
+		return errors.Wrapf(ErrClusterConfInvalid, "migrate_repair_ttl:%d", cc.MigrateRepairTTL)
+	}
+	if cc.MirrorRate < 0 || cc.MirrorRate > 1 || cc.MirrorQueue < 0 {
+		return errors.Wrapf(ErrClusterConfInvalid, "mirror_rate:%v mirror_queue:%d", cc.MirrorRate, cc.MirrorQueue)
+	}
+	if len(cc.Commands) > 0 {
+		if cc.CacheType != types.CacheTypeRedis && cc.CacheType != types.CacheTypeRedisCluster {
+			return errors.Wrapf(ErrClusterConfInvalid, "commands only supported by redis and redis_cluster")
+		}
+		cmds, err := redis.NewCommands(cc.Commands)
+		if err != nil {
+			return errors.Wrapf(err, "cluster:%s", cc.Name)
+		}
+		cc.cmds = cmds
+	}
+	if cc.CacheType == types.CacheTypeRedisCluster {
+		return nil
+	}
+	if err := ValidateStandalone(cc.Servers); err != nil {
+		return err
+	}
+	_, _, _, _, opts, err := parseServers(cc.Servers)
+	if err != nil {
+		return errors.Wrapf(ErrClusterConfInvalid, "servers:%v", err)
+	}
+	for _, opt := range opts {
+		if opt != nil && cc.CacheType != types.CacheTypeRedis {
+			return errors.Wrapf(ErrClusterConfInvalid, "replicas and sentinel only supported by redis")
+		}
 	}
 	return nil
 }
@@ -156,6 +367,38 @@ func (cc *ClusterConfig) SetDefault() {
 		cc.NodePipeCount = 32
 	}
 
+	if cc.Databases == 0 {
+		cc.Databases = 1
+	}
+
+	if cc.CrossNodeAlgebra && cc.AlgebraMaxMembers == 0 {
+		cc.AlgebraMaxMembers = 10000
+	}
+	if cc.ReadPolicy == "" {
+		cc.ReadPolicy = proto.ReadPolicyMaster
+	}
+	if cc.ReplicaMaxLag == 0 {
+		cc.ReplicaMaxLag = 15
+	}
+	if cc.HotKeySample == 0 {
+		cc.HotKeySample = 1
+	}
+	if cc.HotKeyWindow == 0 {
+		cc.HotKeyWindow = 60
+	}
+	if cc.NearCacheMaxBytes > 0 && cc.NearCacheTTL == 0 {
+		cc.NearCacheTTL = 1000
+	}
+	if cc.MirrorRate == 0 {
+		cc.MirrorRate = 1
+	}
+	if cc.MirrorQueue == 0 {
+		cc.MirrorQueue = 4096
+	}
+	if cc.MigrateWrite == "" {
+		cc.MigrateWrite = migrateWriteBoth
+	}
+
 	if len(cc.ListenAddr) == 0 {
 		fmt.Fprint(os.Stderr, "checking out ListenAddr may only using for [anzi] from\n")
 	} else if !strings.Contains(cc.ListenAddr, ":") {
@@ -182,21 +425,30 @@ func (ccs *ClusterConfigs) LoadFromFile(reader io.Reader) error {
 			return err
 		}
 		if cc.CacheType == types.CacheTypeRedisCluster {
-			servers := make([]string, len(cc.Servers))
-			for i, server := range cc.Servers {
-				ssp := strings.Split(server, ":")
-				if len(ssp) == 3 {
-					servers[i] = fmt.Sprintf("%s:%s", ssp[0], ssp[1])
-				} else {
-					servers[i] = server
-				}
-			}
-			cc.Servers = servers
+			cc.Servers = trimWeights(cc.Servers)
+			cc.MirrorServers = trimWeights(cc.MirrorServers)
 		}
 	}
 	return nil
 }
 
+// trimWeights trims the weights of redis_cluster seed servers.
+func trimWeights(servers []string) []string {
+	if len(servers) == 0 {
+		return servers
+	}
+	trimed := make([]string, len(servers))
+	for i, server := range servers {
+		ssp := strings.Split(server, ":")
+		if len(ssp) == 3 {
+			trimed[i] = fmt.Sprintf("%s:%s", ssp[0], ssp[1])
+		} else {
+			trimed[i] = server
+		}
+	}
+	return trimed
+}
+
 // LoadClusterConfWithPath load cluster config.
 func LoadClusterConfWithPath(path string) (ccs []*ClusterConfig, err error) {
 	fd, err := os.Open(path)
@@ -232,6 +484,21 @@ func LoadClusterConf(reader io.Reader) (ccs []*ClusterConfig, err error) {
 		}
 		checks[port] = struct{}{}
 	}
+	for _, cc := range cs.Clusters {
+		if cc.Mirror == "" {
+			continue
+		}
+		var shadow *ClusterConfig
+		for _, sc := range cs.Clusters {
+			if sc.Name == cc.Mirror {
+				shadow = sc
+			}
+		}
+		if shadow == nil || shadow.CacheType != cc.CacheType {
+			err = errors.Wrapf(ErrClusterConfInvalid, "mirror:%s is not a cluster of %s", cc.Mirror, cc.CacheType)
+			return
+		}
+	}
 	ccs = append(ccs, cs.Clusters...)
 	return
 }
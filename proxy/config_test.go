@@ -1,9 +1,15 @@
 package proxy
 
 import (
+	"fmt"
 	"os"
+	"strings"
 	"testing"
 
+	"github.com/ducesoft/overlord/pkg/types"
+	"github.com/ducesoft/overlord/proxy/proto"
+	"github.com/ducesoft/overlord/proxy/proto/redis"
+
 	"github.com/stretchr/testify/assert"
 )
 
@@ -125,8 +131,248 @@ func TestClusterConfigLoadFromFile(t *testing.T) {
 	}
 	assert.NoError(t, fd.Close())
 
+	fd, err = os.Open(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer fd.Close()
 	ccs := &ClusterConfigs{}
-	err = ccs.LoadFromFile(path)
+	err = ccs.LoadFromFile(fd)
 	assert.NoError(t, err)
 	assert.Len(t, ccs.Clusters, 3)
 }
+
+func TestClusterConfigDatabases(t *testing.T) {
+	cc := &ClusterConfig{CacheType: types.CacheTypeRedis, Servers: []string{"127.0.0.1:6379:1"}}
+	cc.SetDefault()
+	assert.Equal(t, 1, cc.Databases)
+	cc.Databases = 16
+	assert.NoError(t, cc.Validate())
+
+	cc.CacheType = types.CacheTypeRedisCluster
+	assert.Error(t, cc.Validate())
+	cc.Databases = -1
+	cc.CacheType = types.CacheTypeRedis
+	assert.Error(t, cc.Validate())
+}
+
+func TestClusterConfigCommands(t *testing.T) {
+	cc := &ClusterConfig{CacheType: types.CacheTypeRedis, Servers: []string{"127.0.0.1:6379:1"}}
+	cc.SetDefault()
+	cc.Commands = []*redis.CommandConfig{{Name: "JSON.GET", Flag: redis.CommandFlagRead, First: 1}, {Name: "FLUSHALL", Disable: true}}
+	assert.NoError(t, cc.Validate())
+	assert.NotNil(t, cc.cmds)
+
+	cc.Commands = append(cc.Commands, &redis.CommandConfig{Name: "BF.ADD", First: 1})
+	assert.Error(t, cc.Validate())
+
+	cc.Commands = cc.Commands[:1]
+	cc.CacheType = types.CacheTypeMemcache
+	assert.Error(t, cc.Validate())
+}
+
+func TestClusterConfigCrossNodeAlgebra(t *testing.T) {
+	cc := &ClusterConfig{CacheType: types.CacheTypeRedisCluster, Servers: []string{"127.0.0.1:7000"}, CrossNodeAlgebra: true}
+	cc.SetDefault()
+	assert.Equal(t, 10000, cc.AlgebraMaxMembers)
+	assert.NoError(t, cc.Validate())
+
+	cc.AlgebraMaxMembers = -1
+	assert.Error(t, cc.Validate())
+	cc.AlgebraMaxMembers = 100
+	cc.CacheType = types.CacheTypeMemcache
+	cc.Servers = []string{"127.0.0.1:11211:1"}
+	assert.Error(t, cc.Validate())
+}
+
+func TestClusterConfigAdminCommands(t *testing.T) {
+	cc := &ClusterConfig{CacheType: types.CacheTypeRedis, Servers: []string{"127.0.0.1:6379:1"}, AdminCommands: true}
+	assert.NoError(t, cc.Validate())
+	cc.CacheType = types.CacheTypeMemcache
+	cc.Servers = []string{"127.0.0.1:11211:1"}
+	assert.Error(t, cc.Validate())
+}
+
+func TestClusterConfigKeyPrefix(t *testing.T) {
+	cc := &ClusterConfig{CacheType: types.CacheTypeMemcache, Servers: []string{"127.0.0.1:11211:1"}, KeyPrefix: "tenant:"}
+	assert.NoError(t, cc.Validate())
+	cc.KeyPrefix = "ten ant"
+	assert.Error(t, cc.Validate())
+
+	cc = &ClusterConfig{CacheType: types.CacheTypeRedisCluster, Servers: []string{"127.0.0.1:7000"}, KeyPrefix: "{tenant}"}
+	assert.Error(t, cc.Validate())
+	cc.KeyPrefix = "tenant:"
+	assert.NoError(t, cc.Validate())
+}
+
+func TestClusterConfigReadPolicy(t *testing.T) {
+	cc := &ClusterConfig{CacheType: types.CacheTypeRedisCluster, Servers: []string{"127.0.0.1:7000"}}
+	cc.SetDefault()
+	assert.Equal(t, proto.ReadPolicyMaster, cc.ReadPolicy)
+	assert.Equal(t, 15, cc.ReplicaMaxLag)
+	assert.NoError(t, cc.Validate())
+
+	cc.ReadPolicy = proto.ReadPolicyRoundRobin
+	assert.NoError(t, cc.Validate())
+	cc.ReadPolicy = "replica"
+	assert.Error(t, cc.Validate())
+
+	cc = &ClusterConfig{CacheType: types.CacheTypeMemcache, Servers: []string{"127.0.0.1:11211:1"}, ReadPolicy: proto.ReadPolicyPreferReplica}
+	assert.Error(t, cc.Validate())
+}
+
+func TestClusterConfigReplicas(t *testing.T) {
+	cc := &ClusterConfig{CacheType: types.CacheTypeRedis, ReadPolicy: proto.ReadPolicyPreferReplica, Servers: []string{
+		"127.0.0.1:6379:1 shard1 replicas=127.0.0.1:6380,127.0.0.1:6381",
+		"127.0.0.1:6382:1 shard2",
+	}}
+	assert.NoError(t, cc.Validate())
+
+	cc.Servers = []string{"127.0.0.1:6379:1 shard1 slaves=127.0.0.1:6380"}
+	assert.Error(t, cc.Validate())
+	cc.Servers = []string{"127.0.0.1:6379:1 shard1 replicas=127.0.0.1"}
+	assert.Error(t, cc.Validate())
+	cc.Servers = []string{"127.0.0.1:6379:1 shard1 replicas=127.0.0.1:6380", "127.0.0.1:6380:1 shard2"}
+	assert.Error(t, cc.Validate())
+
+	cc.Servers = []string{"127.0.0.1:6379:1 shard1 sentinel=mymaster@127.0.0.1:26379,127.0.0.1:26380"}
+	assert.NoError(t, cc.Validate())
+	cc.Servers = []string{"127.0.0.1:6379:1 shard1 sentinel=@127.0.0.1:26379"}
+	assert.Error(t, cc.Validate())
+
+	cc = &ClusterConfig{CacheType: types.CacheTypeMemcache, Servers: []string{"127.0.0.1:11211:1 mc1 replicas=127.0.0.1:11212"}}
+	assert.Error(t, cc.Validate())
+	cc.Servers = []string{"127.0.0.1:11211:1 mc1 sentinel=mymaster@127.0.0.1:26379"}
+	assert.Error(t, cc.Validate())
+}
+
+func TestClusterConfigHotKey(t *testing.T) {
+	cc := &ClusterConfig{CacheType: types.CacheTypeMemcache, Servers: []string{"127.0.0.1:11211:1"}, HotKeyTopK: 10}
+	cc.SetDefault()
+	assert.Equal(t, 1, cc.HotKeySample)
+	assert.Equal(t, 60, cc.HotKeyWindow)
+	assert.NoError(t, cc.Validate())
+	cc.HotKeyThreshold = -1
+	assert.Error(t, cc.Validate())
+	cc.HotKeyThreshold = 0
+	cc.HotKeyTopK = 100000
+	assert.Error(t, cc.Validate())
+}
+
+func TestClusterConfigLimits(t *testing.T) {
+	cc := &ClusterConfig{Name: "test", CacheType: types.CacheTypeMemcache, Servers: []string{"127.0.0.1:11211:1"}}
+	assert.NoError(t, cc.Validate())
+	assert.Nil(t, cc.limits)
+	cc.MaxValueBytes = 1024
+	cc.MaxKeys = 100
+	cc.BigValueBytes = 512
+	assert.NoError(t, cc.Validate())
+	assert.Equal(t, &proto.Limits{Cluster: "test", MaxValue: 1024, MaxKeys: 100, BigValue: 512}, cc.limits)
+	cc.MaxReplyBytes = -1
+	assert.Error(t, cc.Validate())
+}
+
+func TestClusterConfigNearCache(t *testing.T) {
+	cc := &ClusterConfig{CacheType: types.CacheTypeMemcache, Servers: []string{"127.0.0.1:11211:1"}, NearCacheMaxBytes: 1 << 20}
+	cc.SetDefault()
+	assert.Equal(t, 1000, cc.NearCacheTTL)
+	assert.Error(t, cc.Validate())
+	cc.NearCacheKeys = []string{"flag:*"}
+	assert.NoError(t, cc.Validate())
+	cc.NearCacheTracking = true
+	assert.Error(t, cc.Validate())
+	cc.CacheType = types.CacheTypeRedis
+	assert.NoError(t, cc.Validate())
+	cc.CacheType = types.CacheTypeMemcacheBinary
+	cc.NearCacheTracking = false
+	assert.Error(t, cc.Validate())
+}
+
+func TestClusterConfigCoalesceReads(t *testing.T) {
+	cc := &ClusterConfig{CacheType: types.CacheTypeMemcache, Servers: []string{"127.0.0.1:11211:1"}, CoalesceReads: true}
+	assert.NoError(t, cc.Validate())
+	cc.CacheType = types.CacheTypeMemcacheBinary
+	assert.Error(t, cc.Validate())
+}
+
+func TestClusterConfigNodePipe(t *testing.T) {
+	cc := &ClusterConfig{CacheType: types.CacheTypeRedis, Servers: []string{"127.0.0.1:6379:1"}, NodePipeQueue: 128, NodePipeWait: 5}
+	assert.NoError(t, cc.Validate())
+	cc.NodePipeQueue = -1
+	assert.Error(t, cc.Validate())
+	cc.NodePipeQueue = 0
+	cc.NodePipeWait = -1
+	assert.Error(t, cc.Validate())
+}
+
+func TestClusterConfigMirror(t *testing.T) {
+	cc := &ClusterConfig{Name: "a", CacheType: types.CacheTypeRedis, Servers: []string{"127.0.0.1:6379:1"}, Mirror: "b"}
+	cc.SetDefault()
+	assert.NoError(t, cc.Validate())
+	assert.Equal(t, 1.0, cc.MirrorRate)
+	assert.Equal(t, 4096, cc.MirrorQueue)
+	cc.Mirror = "a"
+	assert.Error(t, cc.Validate())
+	cc.Mirror = "b"
+	cc.MirrorServers = []string{"127.0.0.1:6380:1"}
+	assert.Error(t, cc.Validate())
+	cc.Mirror = ""
+	assert.NoError(t, cc.Validate())
+	cc.MirrorServers = []string{"127.0.0.1:6380"}
+	assert.Error(t, cc.Validate())
+	cc.MirrorServers = nil
+	cc.MirrorRate = 1.5
+	assert.Error(t, cc.Validate())
+
+	const clusters = `
+[[clusters]]
+name = "a"
+cache_type = "redis"
+listen_addr = "0.0.0.0:21221"
+servers = ["127.0.0.1:6379:1"]
+mirror = "%s"
+[[clusters]]
+name = "b"
+cache_type = "memcache"
+listen_addr = "0.0.0.0:21222"
+servers = ["127.0.0.1:11211:1"]
+[[clusters]]
+name = "c"
+cache_type = "redis"
+listen_addr = "0.0.0.0:21223"
+servers = ["127.0.0.1:6380:1"]
+`
+	_, err := LoadClusterConf(strings.NewReader(fmt.Sprintf(clusters, "c")))
+	assert.NoError(t, err)
+	_, err = LoadClusterConf(strings.NewReader(fmt.Sprintf(clusters, "b")))
+	assert.Error(t, err)
+	_, err = LoadClusterConf(strings.NewReader(fmt.Sprintf(clusters, "d")))
+	assert.Error(t, err)
+}
+
+func TestClusterConfigMigrate(t *testing.T) {
+	cc := &ClusterConfig{CacheType: types.CacheTypeMemcache, Servers: []string{"127.0.0.1:11211:1"}, MigrateFrom: []string{"127.0.0.1:11212:1"}}
+	cc.SetDefault()
+	assert.NoError(t, cc.Validate())
+	assert.Equal(t, migrateWriteBoth, cc.MigrateWrite)
+	cc.MigrateWrite = "old"
+	assert.Error(t, cc.Validate())
+	cc.MigrateWrite = migrateWriteNew
+	cc.MigrateRepairTTL = -1
+	assert.Error(t, cc.Validate())
+	cc.MigrateRepairTTL = 0
+	cc.MigrateFrom = []string{"127.0.0.1:11212"}
+	assert.Error(t, cc.Validate())
+	cc.MigrateFrom = []string{"127.0.0.1:11212:1"}
+	cc.CacheType = types.CacheTypeMemcacheBinary
+	assert.Error(t, cc.Validate())
+}
+
+func TestParseChangedMigrate(t *testing.T) {
+	oldConfs := []*ClusterConfig{{Name: "a", Servers: []string{"127.0.0.1:11211:1"}}, {Name: "b", Servers: []string{"127.0.0.1:11212:1"}}}
+	newConfs := []*ClusterConfig{{Name: "a", Servers: []string{"127.0.0.1:11211:1"}, MigrateFrom: []string{"127.0.0.1:11213:1"}},
+		{Name: "b", Servers: []string{"127.0.0.1:11212:1"}}}
+	changed := ParseChanged(newConfs, oldConfs)
+	assert.Len(t, changed, 1)
+	assert.Equal(t, "a", changed[0].Name)
+}
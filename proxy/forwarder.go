@@ -6,6 +6,7 @@ import (
 	errs "errors"
 	"net"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -36,6 +37,14 @@ var (
 	ErrConnectionNotExist  = errs.New("connection of forwarder is not initialized")
 )
 
+const (
+	replicasPrefix = "replicas="
+	sentinelPrefix = "sentinel="
+
+	roleMaster = "master"
+	roleSlave  = "slave"
+)
+
 var (
 	defaultForwardCacheTypes = map[types.CacheType]struct{}{
 		types.CacheTypeMemcache:       struct{}{},
@@ -54,7 +63,9 @@ func NewForwarder(cc *ClusterConfig) proto.Forwarder {
 		dto := time.Duration(cc.DialTimeout) * time.Millisecond
 		rto := time.Duration(cc.ReadTimeout) * time.Millisecond
 		wto := time.Duration(cc.WriteTimeout) * time.Millisecond
-		return rclstr.NewForwarder(cc.Name, cc.ListenAddr, cc.Servers, cc.NodeConnections, cc.NodePipeCount, dto, rto, wto, []byte(cc.HashTag))
+		lag := time.Duration(cc.ReplicaMaxLag) * time.Second
+		return rclstr.NewForwarder(cc.Name, cc.ListenAddr, cc.Servers, cc.NodeConnections, cc.NodePipeCount, dto, rto, wto, []byte(cc.HashTag), cc.ReadPolicy, lag, cc.limits, cc.CoalesceReads,
+			cc.NodePipeQueue, time.Duration(cc.NodePipeWait)*time.Millisecond)
 	}
 	panic("unsupported protocol")
 }
@@ -65,24 +76,53 @@ type defaultForwarder struct {
 	hashTag []byte
 	conns   atomic.Value
 	state   int32
+
+	// lock serializes the updates by reload and sentinels.
+	lock      sync.Mutex
+	servers   []string
+	sentinels map[string]*sentinel
+	// migration is the old servers which the cluster is migrating from, nil if not migrating.
+	migration atomic.Value
+	// stats of migration reported by INFO
+	fallbacks int64
+	repairs   int64
+	// scripts is the script cache of redis shared by all the node conns.
+	scripts *redis.ScriptCache
 }
 
 // newDefaultForwarder must combinf.
 func newDefaultForwarder(cc *ClusterConfig) proto.Forwarder {
 	f := &defaultForwarder{cc: cc}
 	f.hashTag = []byte(cc.HashTag)
+	if cc.CacheType == types.CacheTypeRedis {
+		f.scripts = redis.NewScriptCache()
+	}
 	// parse servers config
-	addrs, ws, ans, alias, err := parseServers(cc.Servers)
+	addrs, ws, ans, alias, opts, err := parseServers(cc.Servers)
 	if err != nil {
 		panic(err)
 	}
-	conns := newConnections(cc)
-	conns.init(addrs, ans, ws, alias, nil)
+	masters := f.resolveMasters(opts)
+	// NOTE: the watchers of sentinels switch master by update after constructed.
+	f.lock.Lock()
+	f.servers = cc.Servers
+	f.watchMasters(addrs, opts, masters)
+	conns := f.newConnections()
+	conns.init(addrs, ans, ws, alias, opts, nil)
 	conns.startPinger()
 	f.conns.Store(conns)
+	f.lock.Unlock()
+	if err = f.Migrate(cc); err != nil {
+		panic(err)
+	}
 	return f
 }
 
+// Scripts returns the script cache of redis, nil for the other protocols.
+func (f *defaultForwarder) Scripts() *redis.ScriptCache {
+	return f.scripts
+}
+
 // Forward impl proto.Forwarder
 func (f *defaultForwarder) Forward(msgs []*proto.Message) error {
 	if closed := atomic.LoadInt32(&f.state); closed == forwarderStateClosed {
@@ -92,8 +132,23 @@ func (f *defaultForwarder) Forward(msgs []*proto.Message) error {
 	if !ok {
 		return ErrConnectionNotExist
 	}
+	return f.forward(conns, msgs)
+}
+
+// forward the msgs by conns, which are the old servers of migration or the current servers.
+func (f *defaultForwarder) forward(conns *connections, msgs []*proto.Message) error {
+	var sessMsgs []*proto.Message
+	defer func() {
+		proto.ForwardSession(sessMsgs, func(m *proto.Message) (string, error) {
+			return f.route(conns, m)
+		}, func(addr string) proto.NodeConn {
+			return newNodeConn(f.cc, addr, f.scripts)
+		})
+	}()
 	for _, m := range msgs {
-		if m.IsBatch() {
+		if proto.IsBroadcast(m) {
+			proto.Broadcast(m, conns.allPipes())
+		} else if m.IsBatch() {
 			ctxMap := make(map[string]*nodeConnPipeContext)
 			for _, subm := range m.Batch() {
 				key := subm.Request().Key()
@@ -108,10 +163,18 @@ func (f *defaultForwarder) Forward(msgs []*proto.Message) error {
 				ctxMap[ctx.identifier].msgs = append(ctxMap[ctx.identifier].msgs, subm)
 				subm.MarkStartPipe()
 			}
-			f.batchPush(ctxMap)
+			f.batchPush(conns, ctxMap)
+		} else if proto.IsSession(m) {
+			sessMsgs = append(sessMsgs, m)
 		} else {
 			key := m.Request().Key()
-			ncp, ok := conns.getPipes(f.trimHashTag(key))
+			if mk, ok := m.Request().(proto.MultiKeyer); ok && len(mk.Keys()) > 1 {
+				if _, err := f.route(conns, m); err != nil {
+					m.WithError(err)
+					continue
+				}
+			}
+			ncp, ok := conns.getPipes(f.trimHashTag(key), m.Request())
 			if !ok {
 				m.WithError(ErrForwarderHashNoNode)
 				return errors.WithStack(ErrForwarderHashNoNode)
@@ -124,7 +187,20 @@ func (f *defaultForwarder) Forward(msgs []*proto.Message) error {
 }
 
 func (f *defaultForwarder) Update(servers []string) error {
-	addrs, ws, ans, alias, err := parseServers(servers)
+	_, _, _, _, opts, err := parseServers(servers)
+	if err != nil {
+		return err
+	}
+	masters := f.resolveMasters(opts)
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.update(servers, masters)
+}
+
+// update swap the connections to servers, masters is the masters resolved
+// by the sentinels which are not watched yet.
+func (f *defaultForwarder) update(servers []string, masters map[string]string) error {
+	addrs, ws, ans, alias, opts, err := parseServers(servers)
 	if err != nil {
 		return err
 	}
@@ -132,11 +208,13 @@ func (f *defaultForwarder) Update(servers []string) error {
 	if !ok {
 		return errors.WithStack(ErrConnectionNotExist)
 	}
-	newConns := newConnections(f.cc)
-	copyed := newConns.init(addrs, ans, ws, alias, oldConns.nodePipe)
+	f.servers = servers
+	f.watchMasters(addrs, opts, masters)
+	newConns := f.newConnections()
+	copyed := newConns.init(addrs, ans, ws, alias, opts, oldConns.nodePipe)
+	newConns.startPinger()
 	f.conns.Store(newConns)
 	oldConns.cancel()
-	newConns.startPinger()
 	// close unused
 	for addr, conn := range oldConns.nodePipe {
 		if copyed[addr] {
@@ -148,6 +226,28 @@ func (f *defaultForwarder) Update(servers []string) error {
 	return nil
 }
 
+// NodeStates impl the proto.NodeStater.
+func (f *defaultForwarder) NodeStates() []*proto.NodeState {
+	conns, ok := f.conns.Load().(*connections)
+	if !ok {
+		return nil
+	}
+	return conns.nodeStates()
+}
+
+// KeyNode impl the proto.KeyRouter, empty if no node.
+func (f *defaultForwarder) KeyNode(key []byte) string {
+	conns, ok := f.conns.Load().(*connections)
+	if !ok {
+		return ""
+	}
+	ctx, ok := conns.getPipesContext(f.trimHashTag(key))
+	if !ok {
+		return ""
+	}
+	return ctx.identifier
+}
+
 // Close close forwarder.
 func (f *defaultForwarder) Close() error {
 	if atomic.CompareAndSwapInt32(&f.state, forwarderStateOpening, forwarderStateClosed) {
@@ -160,26 +260,76 @@ func (f *defaultForwarder) Close() error {
 			go np.Close()
 		}
 		curConns.cancel()
+		f.lock.Lock()
+		for _, s := range f.sentinels {
+			s.close()
+		}
+		if mg := f.migrating(); mg != nil {
+			mg.close()
+		}
+		f.lock.Unlock()
 		return nil
 	}
 	return nil
 }
 
-func (f *defaultForwarder) batchPush(ctxMap map[string]*nodeConnPipeContext) {
+func (f *defaultForwarder) batchPush(conns *connections, ctxMap map[string]*nodeConnPipeContext) {
 	for _, ctx := range ctxMap {
 		mainMsg := ctx.msgs[0]
 		var reqs []proto.Request
 		for i := 1; i < len(ctx.msgs); i++ {
 			reqs = append(reqs, ctx.msgs[i].Request())
 		}
-		if err := mainMsg.Request().Merge(reqs); err != nil {
-			// todo report error
+		err := mainMsg.Request().Merge(reqs)
+		if err == proto.ErrMergeNotSupported {
+			for _, m := range ctx.msgs {
+				ncp := ctx.ncp
+				if rp, ok := conns.readPipe(ctx.identifier, m.Request()); ok {
+					ncp = rp
+				}
+				ncp.Push(m)
+			}
+			continue
+		}
+		mainMsg.WithMerged(ctx.msgs[1:])
+		if err != nil {
+			// NOTE: the sub messages of node fail together, the others are still forwarded.
+			mainMsg.WithError(errors.WithStack(err))
+			continue
+		}
+		if ncp, ok := conns.readPipe(ctx.identifier, mainMsg.Request()); ok {
+			ctx.ncp = ncp
 		}
-
 		ctx.ncp.Push(mainMsg)
 	}
 }
 
+// route returns the node addr of message, all the keys of message must
+// be hashed into the same node.
+func (f *defaultForwarder) route(conns *connections, m *proto.Message) (addr string, err error) {
+	req := m.Request()
+	keys := [][]byte{req.Key()}
+	if mk, ok := req.(proto.MultiKeyer); ok {
+		if mkeys := mk.Keys(); len(mkeys) > 0 {
+			keys = mkeys
+		}
+	}
+	for _, key := range keys {
+		ctx, ok := conns.getPipesContext(f.trimHashTag(key))
+		if !ok {
+			err = errors.WithStack(ErrForwarderHashNoNode)
+			return
+		}
+		if addr == "" {
+			addr = ctx.identifier
+		} else if addr != ctx.identifier {
+			err = proto.ErrCrossSlot
+			return
+		}
+	}
+	return
+}
+
 func (f *defaultForwarder) trimHashTag(key []byte) []byte {
 	if len(f.hashTag) != 2 {
 		return key
@@ -204,21 +354,36 @@ type connections struct {
 	addrs, ans []string
 	ws         []int
 	aliasMap   map[string]string
-	nodePipe   map[string]*proto.NodeConnPipe
-	ring       *hashkit.HashRing
+	// reps is the replicas of master addr, nodePipe contains both masters and replicas.
+	reps     map[string][]string
+	nodePipe map[string]*proto.NodeConnPipe
+	ring     *hashkit.HashRing
+	pingers  map[string]*pinger
+	rr       uint32
+	// scripts is the script cache of forwarder.
+	scripts *redis.ScriptCache
+}
+
+// newConnections new the connections which share the script cache of forwarder.
+func (f *defaultForwarder) newConnections() *connections {
+	c := newConnections(f.cc)
+	c.scripts = f.scripts
+	return c
 }
 
 func newConnections(cc *ClusterConfig) *connections {
 	c := &connections{}
 	c.cc = cc
 	c.aliasMap = make(map[string]string)
+	c.reps = make(map[string][]string)
 	c.nodePipe = make(map[string]*proto.NodeConnPipe)
+	c.pingers = make(map[string]*pinger)
 	c.ring = hashkit.NewRing(cc.HashDistribution, cc.HashMethod)
 	c.ctx, c.cancel = context.WithCancel(context.Background())
 	return c
 }
 
-func (c *connections) init(addrs, ans []string, ws []int, alias bool, oldNcps map[string]*proto.NodeConnPipe) map[string]bool {
+func (c *connections) init(addrs, ans []string, ws []int, alias bool, opts []*serverOpts, oldNcps map[string]*proto.NodeConnPipe) map[string]bool {
 	c.alias = alias
 	c.addrs = addrs
 	c.ans = ans
@@ -232,17 +397,32 @@ func (c *connections) init(addrs, ans []string, ws []int, alias bool, oldNcps ma
 		c.ring.Init(addrs, ws)
 	}
 	copyed := make(map[string]bool)
+	// NOTE: the pipes are reused by addr, so the promotion of replica by reload keeps the conns.
+	all := addrs
+	for idx, opt := range opts {
+		if opt != nil && len(opt.replicas) > 0 {
+			c.reps[addrs[idx]] = opt.replicas
+			all = append(all[:len(all):len(all)], opt.replicas...)
+		}
+	}
 	// start nbc
-	for _, addr := range addrs {
+	for _, addr := range all {
 		toAddr := addr // NOTE: avoid closure
 		var cnn, ok = oldNcps[toAddr]
 		if ok {
 			c.nodePipe[toAddr] = cnn
 			copyed[toAddr] = true
 		} else {
-			c.nodePipe[toAddr] = proto.NewNodeConnPipe(c.cc.NodeConnections, c.cc.NodePipeCount, func() proto.NodeConn {
-				return newNodeConn(c.cc, toAddr)
+			ncp := proto.NewNodeConnPipe(c.cc.NodeConnections, c.cc.NodePipeCount, c.cc.NodePipeQueue, func() proto.NodeConn {
+				return newNodeConn(c.cc, toAddr, c.scripts)
 			})
+			if c.cc.CoalesceReads {
+				ncp.WithCoalesce()
+			}
+			if c.cc.NodePipeWait > 0 {
+				ncp.WithWait(time.Duration(c.cc.NodePipeWait) * time.Millisecond)
+			}
+			c.nodePipe[toAddr] = ncp
 		}
 	}
 	return copyed
@@ -254,7 +434,7 @@ type nodeConnPipeContext struct {
 	msgs       []*proto.Message
 }
 
-func (c *connections) getPipes(key []byte) (ncp *proto.NodeConnPipe, ok bool) {
+func (c *connections) getPipes(key []byte, req proto.Request) (ncp *proto.NodeConnPipe, ok bool) {
 	var addr string
 	if addr, ok = c.ring.GetNode(key); !ok {
 		return
@@ -264,10 +444,58 @@ func (c *connections) getPipes(key []byte) (ncp *proto.NodeConnPipe, ok bool) {
 			return
 		}
 	}
+	if ncp, ok = c.readPipe(addr, req); ok {
+		return
+	}
 	ncp, ok = c.nodePipe[addr]
 	return
 }
 
+// readPipe returns the pipe of healthy replica of master which serves the read
+// only request by read policy, false means the request is sent to master.
+func (c *connections) readPipe(master string, req proto.Request) (ncp *proto.NodeConnPipe, ok bool) {
+	reps := c.reps[master]
+	if len(reps) == 0 || !c.cc.ReadPolicy.ReadReplicas() {
+		return
+	}
+	if ro, isRO := req.(proto.ReadOnlyer); !isRO || !ro.IsReadOnly() {
+		return
+	}
+	n := 0
+	for _, addr := range reps {
+		if c.isAlive(addr) {
+			n++
+		}
+	}
+	i := c.cc.ReadPolicy.Pick(atomic.AddUint32(&c.rr, 1), n)
+	if i < 0 {
+		return
+	}
+	for _, addr := range reps {
+		if !c.isAlive(addr) {
+			continue
+		}
+		if i == 0 {
+			ncp, ok = c.nodePipe[addr]
+			return
+		}
+		i--
+	}
+	return
+}
+
+func (c *connections) isAlive(addr string) bool {
+	p, ok := c.pingers[addr]
+	return ok && atomic.LoadInt32(&p.alive) == 1
+}
+
+func (c *connections) allPipes() (ncps []*proto.NodeConnPipe) {
+	for _, addr := range c.addrs {
+		ncps = append(ncps, c.nodePipe[addr])
+	}
+	return
+}
+
 func (c *connections) getPipesContext(key []byte) (ctx *nodeConnPipeContext, ok bool) {
 	var addr string
 	if addr, ok = c.ring.GetNode(key); !ok {
@@ -290,14 +518,22 @@ func (c *connections) getPipesContext(key []byte) (ctx *nodeConnPipeContext, ok
 }
 
 func (c *connections) startPinger() {
-	if !c.cc.PingAutoEject {
-		return
-	}
 	for idx, addr := range c.addrs {
-		p := &pinger{cc: c.cc, addr: addr, alias: addr, weight: c.ws[idx]}
+		alias := addr
 		if c.alias {
-			p.alias = c.ans[idx]
+			alias = c.ans[idx]
+		}
+		// NOTE: the replicas are always pinged to check the health for reads.
+		for _, raddr := range c.reps[addr] {
+			p := &pinger{cc: c.cc, addr: raddr, alias: alias, replica: true, alive: 1}
+			c.pingers[raddr] = p
+			go c.processPing(p)
 		}
+		if !c.cc.PingAutoEject {
+			continue
+		}
+		p := &pinger{cc: c.cc, addr: addr, alias: alias, weight: c.ws[idx], alive: 1}
+		c.pingers[addr] = p
 		go c.processPing(p)
 	}
 }
@@ -326,6 +562,7 @@ func (c *connections) processPing(p *pinger) {
 			err = p.ping.Ping()
 			if err == nil {
 				p.failure = 0
+				atomic.StoreInt32(&p.alive, 1)
 				if del {
 					del = false
 					c.ring.AddNode(p.alias, p.weight)
@@ -340,10 +577,12 @@ func (c *connections) processPing(p *pinger) {
 			}
 
 			p.failure++
+			atomic.StoreInt32(&p.alive, 0)
 			if log.V(3) {
 				log.Warnf("ping node:%s addr:%s fail:%d times with err:%v", p.alias, p.addr, p.failure, err)
 			}
-			if p.failure < c.cc.PingFailLimit {
+			// NOTE: the replica is never in ring, the reads fall back to master until it is alive.
+			if p.replica || p.failure < c.cc.PingFailLimit {
 				time.Sleep(pingSleepTime(false))
 				p.ping = newPingConn(p.cc, p.addr)
 				continue
@@ -369,24 +608,72 @@ type pinger struct {
 	addr   string
 	alias  string // NOTE: default is addr
 	weight int
+	// replica pinger only tracks the health of the replica of alias.
+	replica bool
 
 	failure int
+	alive   int32
+}
+
+// nodeStates returns the state of nodes, the status is unknown if ping is disabled.
+// the role is set only if the shard has replicas.
+func (c *connections) nodeStates() (states []*proto.NodeState) {
+	for idx, addr := range c.addrs {
+		state := &proto.NodeState{Addr: addr, Status: c.status(addr), Pipe: c.pipeStats(addr)}
+		if c.alias {
+			state.Alias = c.ans[idx]
+		}
+		reps := c.reps[addr]
+		if len(reps) > 0 {
+			state.Role = roleMaster
+		}
+		states = append(states, state)
+		for _, raddr := range reps {
+			states = append(states, &proto.NodeState{Addr: raddr, Alias: state.Alias, Role: roleSlave, Status: c.status(raddr), Pipe: c.pipeStats(raddr)})
+		}
+	}
+	return
+}
+
+func (c *connections) pipeStats(addr string) (s proto.PipeStats) {
+	if ncp, ok := c.nodePipe[addr]; ok {
+		s = ncp.Stats()
+	}
+	return
 }
 
-func newNodeConn(cc *ClusterConfig, addr string) proto.NodeConn {
+func (c *connections) status(addr string) string {
+	p, ok := c.pingers[addr]
+	if !ok {
+		return proto.NodeStatusUnknown
+	}
+	if atomic.LoadInt32(&p.alive) == 1 {
+		return proto.NodeStatusOK
+	}
+	return proto.NodeStatusFail
+}
+
+func newNodeConn(cc *ClusterConfig, addr string, scripts *redis.ScriptCache) proto.NodeConn {
 	dto := time.Duration(cc.DialTimeout) * time.Millisecond
 	rto := time.Duration(cc.ReadTimeout) * time.Millisecond
 	wto := time.Duration(cc.WriteTimeout) * time.Millisecond
+	var nc proto.NodeConn
 	switch cc.CacheType {
 	case types.CacheTypeMemcache:
-		return memcache.NewNodeConn(cc.Name, addr, dto, rto, wto)
+		nc = memcache.NewNodeConn(cc.Name, addr, dto, rto, wto)
 	case types.CacheTypeMemcacheBinary:
-		return mcbin.NewNodeConn(cc.Name, addr, dto, rto, wto)
+		nc = mcbin.NewNodeConn(cc.Name, addr, dto, rto, wto)
 	case types.CacheTypeRedis:
-		return redis.NewNodeConn(cc.Name, addr, dto, rto, wto)
+		nc = redis.NewNodeConn(cc.Name, addr, dto, rto, wto)
+		nc.(*redis.NodeConn).WithScripts(scripts)
+		nc.(*redis.NodeConn).WithDatabases(cc.Databases)
 	default:
 		panic(types.ErrNoSupportCacheType)
 	}
+	if lnc, ok := nc.(limitsConn); ok && cc.limits != nil {
+		lnc.WithLimits(cc.limits)
+	}
+	return nc
 }
 
 func newPingConn(cc *ClusterConfig, addr string) proto.Pinger {
@@ -404,7 +691,19 @@ func newPingConn(cc *ClusterConfig, addr string) proto.Pinger {
 	}
 }
 
-func parseServers(svrs []string) (addrs []string, ws []int, ans []string, alias bool, err error) {
+// serverOpts is the options of shard in the optional third field of servers config.
+type serverOpts struct {
+	// replicas serve the reads of shard, eg: "replicas=10.0.0.2:6379,10.0.0.3:6379".
+	replicas []string
+	// master is resolved by sentinels, eg: "sentinel=mymaster@10.0.0.5:26379,10.0.0.6:26379".
+	master    string
+	sentinels []string
+}
+
+// parseServers parse the servers config, the options of shard are nil if absent,
+// eg: "10.0.0.1:6379:1 shard1 replicas=10.0.0.2:6379,10.0.0.3:6379".
+func parseServers(svrs []string) (addrs []string, ws []int, ans []string, alias bool, opts []*serverOpts, err error) {
+	var hasReps bool
 	for _, svr := range svrs {
 		if strings.Contains(svr, " ") {
 			alias = true
@@ -415,10 +714,16 @@ func parseServers(svrs []string) (addrs []string, ws []int, ans []string, alias
 		var (
 			ss    []string
 			addrW string
+			opt   *serverOpts
 		)
 		if alias {
 			ss = strings.Split(svr, " ")
-			if len(ss) != 2 {
+			if len(ss) == 3 {
+				if opt, err = parseServerOpts(ss[2]); err != nil {
+					err = errors.Wrapf(err, "server:%s", svr)
+					return
+				}
+			} else if len(ss) != 2 {
 				err = errors.Wrapf(ErrConfigServerFormat, "server:%s", svr)
 				return
 			}
@@ -432,16 +737,75 @@ func parseServers(svrs []string) (addrs []string, ws []int, ans []string, alias
 			err = errors.Wrapf(ErrConfigServerFormat, "server:%s", svr)
 			return
 		}
-		addrs = append(addrs, net.JoinHostPort(ss[0], ss[1]))
+		addr := net.JoinHostPort(ss[0], ss[1])
+		addrs = append(addrs, addr)
 		w, we := conv.Btoi([]byte(ss[2]))
 		if we != nil || w <= 0 {
 			err = errors.Wrapf(ErrConfigServerFormat, "server:%s", svr)
 			return
 		}
 		ws = append(ws, int(w))
+		opts = append(opts, opt)
+		hasReps = hasReps || (opt != nil && len(opt.replicas) > 0)
 	}
 	if len(addrs) != len(ans) && len(ans) > 0 {
 		err = ErrConfigServerFormat
+		return
+	}
+	if !hasReps {
+		return
+	}
+	// NOTE: the pipes are keyed by addr, so the member of shards must be unique.
+	seen := make(map[string]bool)
+	for idx, addr := range addrs {
+		members := []string{addr}
+		if opts[idx] != nil {
+			members = append(members, opts[idx].replicas...)
+		}
+		for _, a := range members {
+			if seen[a] {
+				err = errors.Wrapf(ErrConfigServerFormat, "duplicate addr:%s", a)
+				return
+			}
+			seen[a] = true
+		}
+	}
+	return
+}
+
+// parseServerOpts parse the third field of servers config, which is the
+// replicas or the sentinels of shard.
+func parseServerOpts(field string) (opt *serverOpts, err error) {
+	var addrs string
+	opt = &serverOpts{}
+	switch {
+	case strings.HasPrefix(field, replicasPrefix):
+		addrs = field[len(replicasPrefix):]
+	case strings.HasPrefix(field, sentinelPrefix):
+		at := strings.IndexByte(field, '@')
+		if at <= len(sentinelPrefix) {
+			err = errors.Wrapf(ErrConfigServerFormat, "sentinel:%s", field)
+			return
+		}
+		opt.master = field[len(sentinelPrefix):at]
+		addrs = field[at+1:]
+	default:
+		err = errors.WithStack(ErrConfigServerFormat)
+		return
+	}
+	var members []string
+	for _, a := range strings.Split(addrs, ",") {
+		host, port, e := net.SplitHostPort(a)
+		if e != nil || host == "" || port == "" {
+			err = errors.Wrapf(ErrConfigServerFormat, "addr:%s", a)
+			return
+		}
+		members = append(members, net.JoinHostPort(host, port))
+	}
+	if opt.master != "" {
+		opt.sentinels = members
+	} else {
+		opt.replicas = members
 	}
 	return
 }
@@ -0,0 +1,172 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ducesoft/overlord/pkg/mockconn"
+	libnet "github.com/ducesoft/overlord/pkg/net"
+	"github.com/ducesoft/overlord/pkg/types"
+	"github.com/ducesoft/overlord/proxy/proto"
+	"github.com/ducesoft/overlord/proxy/proto/memcache"
+	"github.com/ducesoft/overlord/proxy/proto/redis"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseServersReplicas(t *testing.T) {
+	addrs, ws, ans, alias, opts, err := parseServers([]string{
+		"127.0.0.1:6379:2 shard1 replicas=127.0.0.1:6380,127.0.0.1:6381",
+		"127.0.0.1:6382:1 shard2",
+	})
+	assert.NoError(t, err)
+	assert.True(t, alias)
+	assert.Equal(t, []string{"127.0.0.1:6379", "127.0.0.1:6382"}, addrs)
+	assert.Equal(t, []int{2, 1}, ws)
+	assert.Equal(t, []string{"shard1", "shard2"}, ans)
+	assert.Equal(t, []*serverOpts{{replicas: []string{"127.0.0.1:6380", "127.0.0.1:6381"}}, nil}, opts)
+
+	_, _, _, _, opts, err = parseServers([]string{"127.0.0.1:6379:1 shard1 sentinel=mymaster@127.0.0.1:26379,127.0.0.1:26380"})
+	assert.NoError(t, err)
+	assert.Equal(t, []*serverOpts{{master: "mymaster", sentinels: []string{"127.0.0.1:26379", "127.0.0.1:26380"}}}, opts)
+
+	_, _, _, _, _, err = parseServers([]string{"127.0.0.1:6379:1 shard1 127.0.0.1:6380"})
+	assert.Error(t, err)
+	_, _, _, _, _, err = parseServers([]string{"127.0.0.1:6379:1 shard1 replicas=127.0.0.1:6379"})
+	assert.Error(t, err)
+	_, _, _, _, _, err = parseServers([]string{"127.0.0.1:6379:1 shard1 sentinel=127.0.0.1:26379"})
+	assert.Error(t, err)
+}
+
+type _readOnlyReq struct {
+	proto.Request
+	ro bool
+}
+
+func (r *_readOnlyReq) IsReadOnly() bool { return r.ro }
+
+func TestConnectionsReadPipe(t *testing.T) {
+	cc := &ClusterConfig{CacheType: types.CacheTypeRedis, ReadPolicy: proto.ReadPolicyPreferReplica}
+	c := newConnections(cc)
+	master, r1, r2 := "127.0.0.1:6379", "127.0.0.1:6380", "127.0.0.1:6381"
+	for _, addr := range []string{master, r1, r2} {
+		c.nodePipe[addr] = &proto.NodeConnPipe{}
+		c.pingers[addr] = &pinger{addr: addr, alive: 1}
+	}
+	c.reps[master] = []string{r1, r2}
+
+	get, set := &_readOnlyReq{ro: true}, &_readOnlyReq{}
+	_, ok := c.readPipe(master, set)
+	assert.False(t, ok)
+	picked := map[*proto.NodeConnPipe]int{}
+	for i := 0; i < 4; i++ {
+		ncp, ok := c.readPipe(master, get)
+		assert.True(t, ok)
+		picked[ncp]++
+	}
+	assert.Equal(t, 2, picked[c.nodePipe[r1]])
+	assert.Equal(t, 2, picked[c.nodePipe[r2]])
+
+	// NOTE: the failed replica is skipped and reads fall back to master.
+	c.pingers[r1].alive = 0
+	ncp, ok := c.readPipe(master, get)
+	assert.True(t, ok)
+	assert.Equal(t, c.nodePipe[r2], ncp)
+	c.pingers[r2].alive = 0
+	_, ok = c.readPipe(master, get)
+	assert.False(t, ok)
+
+	cc.ReadPolicy = proto.ReadPolicyMaster
+	c.pingers[r1].alive = 1
+	_, ok = c.readPipe(master, get)
+	assert.False(t, ok)
+}
+
+func TestForwarderMemcacheBatch(t *testing.T) {
+	// NOTE: the fake memcache replies the value "v-<key>" to each single key get.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		br := bufio.NewReader(conn)
+		for {
+			line, err := br.ReadString('\n')
+			if err != nil {
+				_ = conn.Close()
+				return
+			}
+			key := strings.TrimPrefix(strings.TrimSpace(line), "get ")
+			fmt.Fprintf(conn, "VALUE %s 0 %d\r\nv-%s\r\nEND\r\n", key, len(key)+2, key)
+		}
+	}()
+
+	cc := &ClusterConfig{Name: "mc", CacheType: types.CacheTypeMemcache, HashMethod: "fnv1a_64", HashDistribution: "ketama",
+		DialTimeout: 1000, ReadTimeout: 1000, WriteTimeout: 1000, NodeConnections: 1,
+		Servers: []string{ln.Addr().String() + ":1"}}
+	cc.SetDefault()
+	assert.NoError(t, cc.Validate())
+	f := newDefaultForwarder(cc)
+	defer f.Close()
+
+	conn := libnet.NewConn(mockconn.CreateConn([]byte("get a b c\r\n"), 1), time.Second, time.Second)
+	pc := memcache.NewProxyConn(conn)
+	msgs, err := pc.Decode(proto.GetMsgs(1))
+	assert.NoError(t, err)
+	wg := &sync.WaitGroup{}
+	msgs[0].WithWaitGroup(wg)
+	assert.NoError(t, f.Forward(msgs))
+	wg.Wait()
+	assert.NoError(t, pc.Encode(msgs[0]))
+	assert.NoError(t, pc.Flush())
+	assert.Equal(t, "VALUE a 0 3\r\nv-a\r\nVALUE b 0 3\r\nv-b\r\nVALUE c 0 3\r\nv-c\r\nEND\r\n",
+		conn.Conn.(*mockconn.MockConn).Wbuf.String(), "every key of the same node is sent")
+}
+
+func TestForwarderRouteNumkeys(t *testing.T) {
+	s1 := _newFakeRedis(t, map[string]string{})
+	defer s1.ln.Close()
+	s2 := _newFakeRedis(t, map[string]string{})
+	defer s2.ln.Close()
+	cc := &ClusterConfig{Name: "numkeys", CacheType: types.CacheTypeRedis, HashMethod: "fnv1a_64", HashDistribution: "ketama",
+		DialTimeout: 1000, ReadTimeout: 1000, WriteTimeout: 1000, NodeConnections: 1,
+		Servers: []string{s1.ln.Addr().String() + ":1", s2.ln.Addr().String() + ":1"}}
+	cc.SetDefault()
+	assert.NoError(t, cc.Validate())
+	f := newDefaultForwarder(cc).(*defaultForwarder)
+	defer f.Close()
+	conns := f.conns.Load().(*connections)
+	node := func(key string) string {
+		addr, _ := conns.ring.GetNode([]byte(key))
+		return addr
+	}
+	// NOTE: the keys are of the node other than the one of numkeys.
+	tag := "a"
+	for node(tag) == node("2") {
+		tag += "a"
+	}
+	cmd := fmt.Sprintf("ZUNION 2 {%s}x {%s}y", tag, tag)
+	conn := libnet.NewConn(mockconn.CreateConn([]byte(cmd+"\r\n"), 1), time.Second, time.Second)
+	pc := redis.NewProxyConn(conn, true)
+	msgs, err := pc.Decode(proto.GetMsgs(1))
+	assert.NoError(t, err)
+	assert.Equal(t, "{"+tag+"}x", string(msgs[0].Request().Key()))
+	wg := &sync.WaitGroup{}
+	msgs[0].WithWaitGroup(wg)
+	assert.NoError(t, f.Forward(msgs))
+	wg.Wait()
+	owner, other := s1, s2
+	if node(tag) == s2.ln.Addr().String() {
+		owner, other = s2, s1
+	}
+	assert.Equal(t, []string{cmd}, owner.commands(), "forwarded to the node of keys")
+	assert.Empty(t, other.commands())
+}
@@ -10,6 +10,10 @@ import (
 	"github.com/ducesoft/overlord/pkg/log"
 	libnet "github.com/ducesoft/overlord/pkg/net"
 	"github.com/ducesoft/overlord/pkg/types"
+	"github.com/ducesoft/overlord/proxy/capture"
+	"github.com/ducesoft/overlord/proxy/hotkey"
+	"github.com/ducesoft/overlord/proxy/mirror"
+	"github.com/ducesoft/overlord/proxy/nearcache"
 	"github.com/ducesoft/overlord/proxy/proto"
 	"github.com/ducesoft/overlord/proxy/proto/memcache"
 	mcbin "github.com/ducesoft/overlord/proxy/proto/memcache/binary"
@@ -40,15 +44,65 @@ type Handler struct {
 	slog       slowlog.Handler
 	slowerThan time.Duration
 
+	hotkey    *hotkey.Store
+	nearcache *nearcache.Cache
+	mirror    *mirror.Mirror
+	migrator  migrator
+	capture   *capture.Capture
+
 	forwarder proto.Forwarder
 
-	conn *libnet.Conn
-	pc   proto.ProxyConn
+	conn   *libnet.Conn
+	pc     proto.ProxyConn
+	client string
 
 	closed int32
 	err    error
 }
 
+// databasesProxyConn is the ProxyConn which supports SELECT db, eg: standalone redis.
+type databasesProxyConn interface {
+	WithDatabases(n int)
+}
+
+// adminProxyConn is the ProxyConn which runs the admin commands changing the
+// state of proxy, eg: redis PROXY RELOAD.
+type adminProxyConn interface {
+	WithAdminCommands()
+}
+
+// algebraProxyConn is the ProxyConn which computes the set algebra across nodes, eg: redis SUNION.
+type algebraProxyConn interface {
+	WithAlgebra(limit int, router proto.KeyRouter)
+}
+
+// keyPrefixProxyConn is the ProxyConn which namespaces the keys by prefix.
+type keyPrefixProxyConn interface {
+	WithKeyPrefix(prefix []byte)
+}
+
+// limitsConn is the ProxyConn or NodeConn which rejects the requests or replies
+// exceed the size limits.
+type limitsConn interface {
+	WithLimits(l *proto.Limits)
+}
+
+// batchStatsProxyConn is the ProxyConn which replies the batch requests
+// partially and counts them.
+type batchStatsProxyConn interface {
+	WithBatchStats(s *proto.BatchStats)
+}
+
+// scriptsProxyConn is the ProxyConn which records the scripts of redis.
+type scriptsProxyConn interface {
+	WithScripts(s *redis.ScriptCache)
+}
+
+// scriptsForwarder is the Forwarder which owns the script cache of redis.
+type scriptsForwarder interface {
+	Scripts() *redis.ScriptCache
+}
+
 // NewHandler new a conn handler.
 func NewHandler(p *Proxy, cc *ClusterConfig, conn net.Conn, forwarder proto.Forwarder) (h *Handler) {
 	h = &Handler{
@@ -61,8 +115,24 @@ func NewHandler(p *Proxy, cc *ClusterConfig, conn net.Conn, forwarder proto.Forw
 		h.slowerThan = time.Duration(cc.SlowlogSlowerThan) * time.Microsecond
 		h.slog = slowlog.Get(cc.Name)
 	}
+	if cc.HotKeyTopK > 0 {
+		h.hotkey = hotkey.Get(cc.Name)
+	}
+	if cc.NearCacheMaxBytes > 0 {
+		h.nearcache = nearcache.Get(cc.Name)
+	}
+	if cc.Mirror != "" || len(cc.MirrorServers) > 0 {
+		h.mirror = mirror.Get(cc.Name)
+	}
+	if mg, ok := forwarder.(migrator); ok {
+		h.migrator = mg
+	}
+	h.capture = capture.Get(cc.Name)
 
 	h.conn = libnet.NewConn(conn, time.Second*time.Duration(h.p.c.Proxy.ReadTimeout), time.Second*time.Duration(h.p.c.Proxy.WriteTimeout))
+	if addr := conn.RemoteAddr(); addr != nil {
+		h.client = addr.String()
+	}
 	// cache type
 	switch cc.CacheType {
 	case types.CacheTypeMemcache:
@@ -76,6 +146,36 @@ func NewHandler(p *Proxy, cc *ClusterConfig, conn net.Conn, forwarder proto.Forw
 	default:
 		panic(types.ErrNoSupportCacheType)
 	}
+	if dpc, ok := h.pc.(databasesProxyConn); ok {
+		dpc.WithDatabases(cc.Databases)
+	}
+	if cpc, ok := h.pc.(commandsProxyConn); ok {
+		cpc.WithCommands(cc.cmds)
+	}
+	if apc, ok := h.pc.(adminProxyConn); ok && cc.AdminCommands {
+		apc.WithAdminCommands()
+	}
+	if apc, ok := h.pc.(algebraProxyConn); ok && cc.CrossNodeAlgebra {
+		router, _ := forwarder.(proto.KeyRouter)
+		apc.WithAlgebra(cc.AlgebraMaxMembers, router)
+	}
+	if kpc, ok := h.pc.(keyPrefixProxyConn); ok && cc.KeyPrefix != "" {
+		kpc.WithKeyPrefix([]byte(cc.KeyPrefix))
+	}
+	if lpc, ok := h.pc.(limitsConn); ok && cc.limits != nil {
+		lpc.WithLimits(cc.limits)
+	}
+	if spc, ok := h.pc.(scriptsProxyConn); ok {
+		if sf, ok := forwarder.(scriptsForwarder); ok {
+			spc.WithScripts(sf.Scripts())
+		}
+	}
+	if bpc, ok := h.pc.(batchStatsProxyConn); ok {
+		bpc.WithBatchStats(cc.batch)
+	}
+	if ipc, ok := h.pc.(infoProxyConn); ok {
+		ipc.WithInfo(h)
+	}
 	return
 }
 
@@ -89,7 +189,13 @@ func (h *Handler) handle() {
 	var (
 		messages []*proto.Message
 		msgs     []*proto.Message
+		fwdMsgs  []*proto.Message
+		fmsgs    []*proto.Message
+		mjobs    []interface{}
+		crecs    []*capture.Record
+		omsgs    []*proto.Message
 		wg       = &sync.WaitGroup{}
+		seq      uint64
 		err      error
 	)
 	messages = h.allocMaxConcurrent(wg, messages, len(msgs))
@@ -99,9 +205,50 @@ func (h *Handler) handle() {
 			h.deferHandle(messages, err)
 			return
 		}
+		atomic.AddInt64(&h.p.commands, int64(len(msgs)))
+		if h.nearcache != nil {
+			seq = h.nearcache.Seq()
+		}
+		fwdMsgs = h.forwardMsgs(msgs, fwdMsgs[:0])
+		if h.hotkey != nil {
+			h.recordHotKeys(fwdMsgs)
+		}
+		if h.mirror != nil {
+			mjobs = h.mirror.Sample(fwdMsgs, mjobs[:0])
+		}
+		if h.capture != nil {
+			crecs = h.capture.Sample(h.client, fwdMsgs, crecs[:0])
+		}
 		// 2. send to cluster
-		h.forwarder.Forward(msgs)
-		wg.Wait()
+		if h.migrator != nil {
+			omsgs = h.migrator.writeOld(fwdMsgs, omsgs[:0], wg)
+		}
+		h.forward(fwdMsgs, wg)
+		if h.migrator != nil {
+			h.migrator.readOld(fwdMsgs, wg)
+			if len(omsgs) > 0 {
+				proto.PutMsgs(omsgs)
+			}
+		}
+		if h.nearcache != nil {
+			h.updateNearCache(fwdMsgs, seq)
+		}
+		if len(mjobs) > 0 {
+			h.mirror.Push(mjobs)
+		}
+		if len(crecs) > 0 {
+			h.capture.Push(crecs)
+		}
+		// NOTE: followup after replies, eg: STORE of set algebra computed by proxy
+		if fu, ok := h.pc.(proto.Followuper); ok {
+			if fmsgs = fu.Followup(msgs); len(fmsgs) > 0 {
+				for _, fm := range fmsgs {
+					fm.WithWaitGroup(wg)
+				}
+				h.forwarder.Forward(fmsgs)
+				wg.Wait()
+			}
+		}
 		// 3. encode
 		for _, msg := range msgs {
 			msg.MarkEndPipe()
@@ -120,7 +267,7 @@ func (h *Handler) handle() {
 		// 4. check slowlog before release resource
 		if h.slowerThan != 0 {
 			for _, msg := range msgs {
-				if msg.TotalDur() > h.slowerThan {
+				if msg.TotalDur() > h.slowerThan && !msg.Rejected() {
 					h.slog.Record(msg.Slowlog())
 				}
 			}
@@ -130,11 +277,112 @@ func (h *Handler) handle() {
 			msg.ResetSubs()
 			msg.Reset()
 		}
+		if len(fmsgs) > 0 {
+			proto.PutMsgs(fmsgs)
+			fmsgs = nil
+		}
 		// 5. alloc MaxConcurrent
 		messages = h.allocMaxConcurrent(wg, messages, len(msgs))
 	}
 }
 
+// forward forwards the messages and waits for the replies. The session messages,
+// eg: MULTI/EXEC, run on their own conns, so the messages are forwarded by the
+// segments split at session boundaries to keep the order of pipelined commands.
+func (h *Handler) forward(msgs []*proto.Message, wg *sync.WaitGroup) {
+	for len(msgs) > 0 {
+		sess := proto.IsSession(msgs[0])
+		i := 1
+		for i < len(msgs) && proto.IsSession(msgs[i]) == sess {
+			i++
+		}
+		h.forwarder.Forward(msgs[:i])
+		wg.Wait()
+		msgs = msgs[i:]
+	}
+}
+
+// forwardMsgs appends the messages which must be forwarded into fwd, the
+// rejected messages and the reads served by near cache are replied by proxy itself.
+func (h *Handler) forwardMsgs(msgs, fwd []*proto.Message) []*proto.Message {
+	if h.nearcache != nil || h.cc.CoalesceReads {
+		proto.MarkFresh(msgs)
+	}
+	for _, msg := range msgs {
+		if !msg.Rejected() && !h.nearCacheHit(msg) {
+			fwd = append(fwd, msg)
+		}
+	}
+	return fwd
+}
+
+// nearCacheHit replies the read of single key by the near cache, false if
+// missed, the request is not cacheable or follows the write to the same key.
+func (h *Handler) nearCacheHit(msg *proto.Message) bool {
+	if h.nearcache == nil || msg.IsBatch() || msg.Fresh() {
+		return false
+	}
+	req := msg.Request()
+	nc, ok := req.(proto.NearCacher)
+	if !ok || nc.NearCacheOp() != proto.NearCacheRead || !h.nearcache.Match(req.Key()) {
+		return false
+	}
+	data, ok := h.nearcache.Get(req.Key())
+	if !ok {
+		return false
+	}
+	nc.ReplyNearCache(data)
+	return true
+}
+
+// updateNearCache stores the replies of reads missed near cache and invalidates
+// the keys written. The keys are invalidated after the replies of writes, so the
+// reads concurrent with writes are never stored because of the seq taken before.
+func (h *Handler) updateNearCache(msgs []*proto.Message, seq uint64) {
+	for _, msg := range msgs {
+		for _, req := range msg.Requests() {
+			nc, ok := req.(proto.NearCacher)
+			if !ok {
+				continue
+			}
+			switch nc.NearCacheOp() {
+			case proto.NearCacheRead:
+				if msg.IsBatch() || msg.Err() != nil {
+					continue
+				}
+				if data := nc.NearCacheReply(); data != nil && h.nearcache.Match(req.Key()) {
+					h.nearcache.Set(req.Key(), data, seq)
+				}
+			case proto.NearCacheInvalidate:
+				if mk, ok := req.(proto.MultiKeyer); ok {
+					for _, key := range mk.Keys() {
+						h.nearcache.Invalidate(key)
+					}
+				} else {
+					h.nearcache.Invalidate(req.Key())
+				}
+			}
+		}
+	}
+}
+
+// ctlRequest is the request which is control command or replied by proxy itself, eg: redis PING.
+type ctlRequest interface {
+	IsCtl() bool
+}
+
+// recordHotKeys record the keys of requests which are sent to backend nodes.
+func (h *Handler) recordHotKeys(msgs []*proto.Message) {
+	for _, msg := range msgs {
+		for _, req := range msg.Requests() {
+			if ctl, ok := req.(ctlRequest); ok && ctl.IsCtl() {
+				continue
+			}
+			h.hotkey.Record(req.Key())
+		}
+	}
+}
+
 func (h *Handler) allocMaxConcurrent(wg *sync.WaitGroup, msgs []*proto.Message, lastCount int) []*proto.Message {
 	var alloc int
 	if msgsLength := len(msgs); msgsLength == 0 {
@@ -162,6 +410,9 @@ func (h *Handler) closeWithError(err error) {
 	if atomic.CompareAndSwapInt32(&h.closed, handlerOpening, handlerClosed) {
 		h.err = err
 		_ = h.conn.Close()
+		if closer, ok := h.pc.(io.Closer); ok {
+			_ = closer.Close()
+		}
 		atomic.AddInt32(&h.p.conns, -1) // NOTE: decr!!!
 		if err == proto.ErrQuit {
 			return
@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ducesoft/overlord/pkg/mockconn"
+	libnet "github.com/ducesoft/overlord/pkg/net"
+	"github.com/ducesoft/overlord/proxy/nearcache"
+	"github.com/ducesoft/overlord/proxy/proto"
+	"github.com/ducesoft/overlord/proxy/proto/memcache"
+	"github.com/ducesoft/overlord/proxy/proto/redis"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// _sessReq is the request which runs on session if sess is set.
+type _sessReq struct {
+	proto.Request
+	cmd  string
+	sess *proto.Session
+}
+
+func (r *_sessReq) Session() (*proto.Session, proto.SessionOp) {
+	return r.sess, proto.SessionKeep
+}
+
+// _orderForwarder records the commands of each forwarded segment.
+type _orderForwarder struct {
+	proto.Forwarder
+	segs [][]string
+}
+
+func (f *_orderForwarder) Forward(msgs []*proto.Message) error {
+	var cmds []string
+	for _, m := range msgs {
+		cmds = append(cmds, m.Request().(*_sessReq).cmd)
+	}
+	f.segs = append(f.segs, cmds)
+	return nil
+}
+
+func TestHandlerForwardSessionBoundary(t *testing.T) {
+	sess := &proto.Session{}
+	reqs := []*_sessReq{{cmd: "SET"}, {cmd: "MULTI", sess: sess}, {cmd: "GET", sess: sess}, {cmd: "EXEC", sess: sess}, {cmd: "GET"}, {cmd: "DEL"}}
+	wg := &sync.WaitGroup{}
+	msgs := proto.GetMsgs(len(reqs))
+	for i, m := range msgs {
+		m.WithRequest(reqs[i])
+		m.WithWaitGroup(wg)
+	}
+	f := &_orderForwarder{}
+	h := &Handler{forwarder: f}
+	h.forward(msgs, wg)
+	assert.Equal(t, [][]string{{"SET"}, {"MULTI", "GET", "EXEC"}, {"GET", "DEL"}}, f.segs)
+}
+
+func TestHandlerNearCacheAfterWrite(t *testing.T) {
+	ts := []struct {
+		name string
+		pc   func(*libnet.Conn) proto.ProxyConn
+		data string
+		val  string
+	}{
+		{"redis", func(c *libnet.Conn) proto.ProxyConn { return redis.NewProxyConn(c, true) }, "GET k\r\nSET k v\r\nGET k\r\nGET j\r\n", "$1\r\nx\r\n"},
+		{"memcache", memcache.NewProxyConn, "get k\r\nset k 0 0 1\r\nv\r\nget k\r\nget j\r\n", "VALUE k 0 1\r\nx\r\nEND\r\n"},
+	}
+	for _, tt := range ts {
+		t.Run(tt.name, func(t *testing.T) {
+			nc := nearcache.New(tt.name, 1024, time.Minute, []string{"*"})
+			nc.Set([]byte("k"), []byte(tt.val), nc.Seq())
+			nc.Set([]byte("j"), []byte(tt.val), nc.Seq())
+			h := &Handler{cc: &ClusterConfig{}, nearcache: nc}
+			pc := tt.pc(libnet.NewConn(mockconn.CreateConn([]byte(tt.data), 1), time.Second, time.Second))
+			msgs, err := pc.Decode(proto.GetMsgs(8))
+			assert.NoError(t, err)
+			assert.Len(t, msgs, 4)
+			fwd := h.forwardMsgs(msgs, nil)
+			// NOTE: only the read following the write to the same key is forwarded.
+			assert.Equal(t, []*proto.Message{msgs[1], msgs[2]}, fwd)
+		})
+	}
+}
+
+// _updateForwarder accepts the servers updated by reload.
+type _updateForwarder struct {
+	proto.Forwarder
+}
+
+func (*_updateForwarder) Update(servers []string) error {
+	return nil
+}
+
+func TestHandlerConfigReload(t *testing.T) {
+	cc := &ClusterConfig{Name: "test", Servers: []string{"127.0.0.1:6379:1"}}
+	p := &Proxy{ccs: []*ClusterConfig{cc}, forwarders: map[string]proto.Forwarder{"test": &_updateForwarder{}}}
+	h := &Handler{p: p, cc: cc}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			conf := &ClusterConfig{Name: "test", Servers: []string{"127.0.0.1:6380:1"}, MigrateFrom: []string{"127.0.0.1:6379:1"}}
+			assert.NoError(t, p.UpdateConfig(conf))
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		h.Config()
+	}
+	<-done
+	fields := map[string]string{}
+	for _, f := range h.Config() {
+		fields[f.Key] = f.Value
+	}
+	assert.Equal(t, "127.0.0.1:6380:1", fields["servers"])
+	assert.Equal(t, "127.0.0.1:6379:1", fields["migrate_from"])
+}
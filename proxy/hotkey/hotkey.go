@@ -0,0 +1,211 @@
+package hotkey
+
+import (
+	"container/heap"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ducesoft/overlord/pkg/log"
+	"github.com/ducesoft/overlord/proxy/proto"
+)
+
+// capacityFactor is the times of top-K counters tracked by space-saving,
+// the more counters the less overestimation of the top keys.
+const capacityFactor = 8
+
+// counter is the counter of key, the count of new key inherits the count of
+// the evicted key, so it may be overestimated.
+type counter struct {
+	key    string
+	count  int64
+	idx    int
+	warned bool
+}
+
+// counters is the min heap of counters by count.
+type counters []*counter
+
+func (cs counters) Len() int           { return len(cs) }
+func (cs counters) Less(i, j int) bool { return cs[i].count < cs[j].count }
+func (cs counters) Swap(i, j int) {
+	cs[i], cs[j] = cs[j], cs[i]
+	cs[i].idx = i
+	cs[j].idx = j
+}
+func (cs *counters) Push(x interface{}) {
+	c := x.(*counter)
+	c.idx = len(*cs)
+	*cs = append(*cs, c)
+}
+func (cs *counters) Pop() interface{} {
+	old := *cs
+	c := old[len(old)-1]
+	*cs = old[:len(old)-1]
+	return c
+}
+
+// Store tracks the hot keys of cluster by the space-saving sketch, the memory
+// is bounded by the topk counters. The counts decay by half in every window so
+// the keys which are not hot anymore are evicted.
+type Store struct {
+	name      string
+	topk      int
+	sample    uint32
+	threshold int64
+	window    time.Duration
+
+	seq      uint32
+	lock     sync.Mutex
+	index    map[string]*counter
+	counters counters
+	decayAt  time.Time
+	router   atomic.Value
+}
+
+// New new a hot key store, 1 of every sample keys is recorded and the key is
+// warned when the estimated count reaches the threshold, 0 means never.
+func New(name string, topk, sample, threshold int, window time.Duration) *Store {
+	if sample < 1 {
+		sample = 1
+	}
+	capacity := topk * capacityFactor
+	return &Store{
+		name:      name,
+		topk:      topk,
+		sample:    uint32(sample),
+		threshold: int64(threshold),
+		window:    window,
+		index:     make(map[string]*counter, capacity),
+		counters:  make(counters, 0, capacity),
+		decayAt:   time.Now(),
+	}
+}
+
+// WithRouter set the router which reports the node of hot keys.
+func (s *Store) WithRouter(router proto.KeyRouter) {
+	s.router.Store(router)
+}
+
+// Record the key accessed, the key is copied when tracked.
+func (s *Store) Record(key []byte) {
+	if len(key) == 0 || (s.sample > 1 && atomic.AddUint32(&s.seq, 1)%s.sample != 0) {
+		return
+	}
+	s.lock.Lock()
+	if now := time.Now(); s.window > 0 && now.Sub(s.decayAt) >= s.window {
+		s.decay()
+		s.decayAt = now
+	}
+	c, ok := s.index[string(key)]
+	if ok {
+		c.count++
+		heap.Fix(&s.counters, c.idx)
+	} else if len(s.counters) < cap(s.counters) {
+		c = &counter{key: string(key), count: 1}
+		s.index[c.key] = c
+		heap.Push(&s.counters, c)
+	} else {
+		// NOTE: replace the min counter and inherit its count.
+		c = s.counters[0]
+		delete(s.index, c.key)
+		c.key = string(key)
+		c.count++
+		c.warned = false
+		s.index[c.key] = c
+		heap.Fix(&s.counters, 0)
+	}
+	var warn bool
+	count := c.count * int64(s.sample)
+	if s.threshold > 0 && !c.warned && count >= s.threshold {
+		c.warned = true
+		warn = true
+	}
+	s.lock.Unlock()
+	if warn && bool(log.V(2)) {
+		log.Warnf("cluster:%s hot key:%q count:%d reaches threshold:%d node:%s", s.name, key, count, s.threshold, s.node(key))
+	}
+}
+
+// decay halve the counts and drop the zero counters.
+func (s *Store) decay() {
+	cs := s.counters[:0]
+	for _, c := range s.counters {
+		c.count /= 2
+		c.warned = false
+		if c.count == 0 {
+			delete(s.index, c.key)
+			continue
+		}
+		cs = append(cs, c)
+	}
+	for i := len(cs); i < len(s.counters); i++ {
+		s.counters[i] = nil
+	}
+	s.counters = cs
+	heap.Init(&s.counters)
+}
+
+// Top returns the top n hot keys by estimated count, n < 0 or n > topk means topk.
+func (s *Store) Top(n int) []*proto.HotKey {
+	if n < 0 || n > s.topk {
+		n = s.topk
+	}
+	s.lock.Lock()
+	cs := make([]counter, len(s.counters))
+	for i, c := range s.counters {
+		cs[i] = *c
+	}
+	s.lock.Unlock()
+	sort.Slice(cs, func(i, j int) bool {
+		if cs[i].count != cs[j].count {
+			return cs[i].count > cs[j].count
+		}
+		return cs[i].key < cs[j].key
+	})
+	if n > len(cs) {
+		n = len(cs)
+	}
+	hks := make([]*proto.HotKey, n)
+	for i := range hks {
+		hks[i] = &proto.HotKey{
+			Key:   cs[i].key,
+			Count: cs[i].count * int64(s.sample),
+			Node:  s.node([]byte(cs[i].key)),
+		}
+	}
+	return hks
+}
+
+// Reply returns the top hot keys of cluster.
+func (s *Store) Reply() *proto.HotKeys {
+	return &proto.HotKeys{Cluster: s.name, Keys: s.Top(-1)}
+}
+
+func (s *Store) node(key []byte) string {
+	if router, ok := s.router.Load().(proto.KeyRouter); ok {
+		return router.KeyNode(key)
+	}
+	return ""
+}
+
+var (
+	storeMap  = map[string]*Store{}
+	storeLock sync.RWMutex
+)
+
+// Register the store of cluster which is reported by http, the old one of
+// the same name is replaced.
+func Register(s *Store) {
+	storeLock.Lock()
+	storeMap[s.name] = s
+	storeLock.Unlock()
+}
+
+// Get returns the store of cluster, nil if not registered.
+func Get(name string) *Store {
+	storeLock.RLock()
+	defer storeLock.RUnlock()
+	return storeMap[name]
+}
@@ -0,0 +1,84 @@
+package hotkey
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ducesoft/overlord/proxy/prom"
+	"github.com/ducesoft/overlord/proxy/proto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type mockRouter struct{}
+
+func (mockRouter) KeyNode(key []byte) string { return "node-" + string(key) }
+
+func TestStoreTop(t *testing.T) {
+	s := New("test", 2, 1, 0, time.Minute)
+	s.WithRouter(mockRouter{})
+	for i := 0; i < 100; i++ {
+		s.Record([]byte("hot"))
+		if i%2 == 0 {
+			s.Record([]byte("warm"))
+		}
+		// NOTE: the cold keys are more than capacity and evict each other.
+		s.Record([]byte("cold" + strconv.Itoa(i)))
+	}
+	s.Record(nil)
+	assert.Len(t, s.counters, 2*capacityFactor)
+	assert.Len(t, s.index, 2*capacityFactor)
+
+	hks := s.Top(-1)
+	assert.Len(t, hks, 2)
+	assert.Equal(t, &proto.HotKey{Key: "hot", Count: 100, Node: "node-hot"}, hks[0])
+	assert.Equal(t, "warm", hks[1].Key)
+	assert.Len(t, s.Top(1), 1)
+	assert.Equal(t, "test", s.Reply().Cluster)
+}
+
+func TestStoreSampleAndDecay(t *testing.T) {
+	s := New("test", 1, 4, 40, time.Hour)
+	for i := 0; i < 40; i++ {
+		s.Record([]byte("a"))
+	}
+	hks := s.Top(1)
+	assert.Equal(t, int64(40), hks[0].Count)
+	assert.True(t, s.index["a"].warned)
+
+	s.Record([]byte("b"))
+	s.Record([]byte("b"))
+	s.Record([]byte("b"))
+	s.Record([]byte("b"))
+	s.lock.Lock()
+	s.decay()
+	s.lock.Unlock()
+	assert.Equal(t, int64(20), s.Top(1)[0].Count)
+	assert.False(t, s.index["a"].warned)
+	_, ok := s.index["b"]
+	assert.False(t, ok)
+}
+
+func TestShowMetrics(t *testing.T) {
+	s := New("metrics", 1, 1, 0, time.Minute)
+	s.WithRouter(mockRouter{})
+	s.Record([]byte("a\"b"))
+	Register(s)
+	defer func() {
+		storeLock.Lock()
+		delete(storeMap, "metrics")
+		storeLock.Unlock()
+	}()
+	assert.Equal(t, s, Get("metrics"))
+
+	buf := &bytes.Buffer{}
+	assert.NoError(t, prom.Write(buf))
+	assert.Contains(t, buf.String(), `overlord_proxy_hotkey_count{cluster="metrics",key="a\"b",node="node-a\"b"} 1`)
+
+	w := httptest.NewRecorder()
+	showHotKeys(w, httptest.NewRequest("GET", "/hotkey", nil))
+	assert.Contains(t, w.Body.String(), `{"cluster":"metrics","keys":[{"key":"a\"b","count":1,"node":"node-a\"b"}]}`)
+}
@@ -0,0 +1,51 @@
+package hotkey
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/ducesoft/overlord/proxy/prom"
+	"github.com/ducesoft/overlord/proxy/proto"
+)
+
+const metricHotKeyCount = "overlord_proxy_hotkey_count"
+
+func replies() []*proto.HotKeys {
+	storeLock.RLock()
+	hks := make([]*proto.HotKeys, 0, len(storeMap))
+	for _, s := range storeMap {
+		hks = append(hks, s.Reply())
+	}
+	storeLock.RUnlock()
+	sort.Slice(hks, func(i, j int) bool { return hks[i].Cluster < hks[j].Cluster })
+	return hks
+}
+
+// showHotKeys will show the hot keys of clusters to http
+func showHotKeys(w http.ResponseWriter, _req *http.Request) {
+	encoder := json.NewEncoder(w)
+	if err := encoder.Encode(replies()); err != nil {
+		http.Error(w, fmt.Sprintf("%s", err), http.StatusInternalServerError)
+	}
+}
+
+// collect writes the hot keys of clusters as the metrics of proxy.
+func collect(w *prom.Writer) {
+	w.Family(metricHotKeyCount, prom.Gauge, "The estimated count of hot key.")
+	for _, hks := range replies() {
+		for _, hk := range hks.Keys {
+			w.Sample(metricHotKeyCount, hk.Count, "cluster", hks.Cluster, "key", hk.Key, "node", hk.Node)
+		}
+	}
+}
+
+func init() {
+	prom.Register("hotkey", collect)
+}
+
+// Init register the hot keys http by /hotkey
+func Init() {
+	http.HandleFunc("/hotkey", showHotKeys)
+}
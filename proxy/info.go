@@ -0,0 +1,226 @@
+package proxy
+
+import (
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/ducesoft/overlord/proxy/capture"
+	"github.com/ducesoft/overlord/proxy/mirror"
+	"github.com/ducesoft/overlord/proxy/nearcache"
+	"github.com/ducesoft/overlord/proxy/proto"
+	"github.com/ducesoft/overlord/proxy/proto/redis"
+	"github.com/ducesoft/overlord/proxy/slowlog"
+	"github.com/ducesoft/overlord/version"
+)
+
+// infoProxyConn is the ProxyConn which replies proxy state, eg: redis INFO.
+type infoProxyConn interface {
+	WithInfo(info proto.Infoer)
+}
+
+// commandsProxyConn is the ProxyConn which decodes by the command table of cluster.
+type commandsProxyConn interface {
+	WithCommands(cmds *redis.Commands)
+}
+
+// Info impl the proto.Infoer and reports proxy state of handler's cluster.
+func (h *Handler) Info() []*proto.InfoSection {
+	uptime := int64(time.Since(h.p.start) / time.Second)
+	server := &proto.InfoSection{
+		Name: "Server",
+		Fields: []proto.InfoField{
+			{Key: "overlord_version", Value: version.Str()},
+			{Key: "go_version", Value: runtime.Version()},
+			{Key: "os", Value: runtime.GOOS + " " + runtime.GOARCH},
+			{Key: "process_id", Value: strconv.Itoa(os.Getpid())},
+			{Key: "cluster", Value: h.cc.Name},
+			{Key: "cache_type", Value: string(h.cc.CacheType)},
+			{Key: "listen_proto", Value: h.cc.ListenProto},
+			{Key: "listen_addr", Value: h.cc.ListenAddr},
+			{Key: "uptime_in_seconds", Value: strconv.FormatInt(uptime, 10)},
+			{Key: "uptime_in_days", Value: strconv.FormatInt(uptime/(3600*24), 10)},
+		},
+	}
+	clients := &proto.InfoSection{
+		Name: "Clients",
+		Fields: []proto.InfoField{
+			{Key: "connected_clients", Value: strconv.Itoa(int(atomic.LoadInt32(&h.p.conns)))},
+			{Key: "maxclients", Value: strconv.Itoa(int(h.p.c.Proxy.MaxConnections))},
+		},
+	}
+	var ncs nearcache.Stats
+	if h.nearcache != nil {
+		ncs = h.nearcache.Stats()
+	}
+	var fallbacks, repairs int64
+	if h.migrator != nil {
+		fallbacks, repairs = h.migrator.migrateStats()
+	}
+	var ms mirror.Stats
+	if h.mirror != nil {
+		ms = h.mirror.Stats()
+	}
+	var cs capture.Stats
+	if h.capture != nil {
+		cs = h.capture.Stats()
+	}
+	stats := &proto.InfoSection{
+		Name: "Stats",
+		Fields: []proto.InfoField{
+			{Key: "total_connections_received", Value: strconv.FormatInt(atomic.LoadInt64(&h.p.totalConns), 10)},
+			{Key: "total_commands_processed", Value: strconv.FormatInt(atomic.LoadInt64(&h.p.commands), 10)},
+			{Key: "rejected_connections", Value: strconv.FormatInt(atomic.LoadInt64(&h.p.rejectedConns), 10)},
+			{Key: "rejected_requests", Value: strconv.FormatInt(h.cc.limits.Rejected(), 10)},
+			{Key: "big_values", Value: strconv.FormatInt(h.cc.limits.BigValues(), 10)},
+			{Key: "batch_partial_failures", Value: strconv.FormatInt(h.cc.batch.Partial(), 10)},
+			{Key: "batch_failed_keys", Value: strconv.FormatInt(h.cc.batch.Failed(), 10)},
+			{Key: "near_cache_hits", Value: strconv.FormatInt(ncs.Hits, 10)},
+			{Key: "near_cache_misses", Value: strconv.FormatInt(ncs.Misses, 10)},
+			{Key: "near_cache_keys", Value: strconv.Itoa(ncs.Keys)},
+			{Key: "mirror_requests", Value: strconv.FormatInt(ms.Mirrored, 10)},
+			{Key: "mirror_dropped", Value: strconv.FormatInt(ms.Dropped, 10)},
+			{Key: "mirror_errors", Value: strconv.FormatInt(ms.Errors, 10)},
+			{Key: "mirror_mismatches", Value: strconv.FormatInt(ms.TotalMismatches(), 10)},
+			{Key: "migrate_fallbacks", Value: strconv.FormatInt(fallbacks, 10)},
+			{Key: "migrate_repairs", Value: strconv.FormatInt(repairs, 10)},
+			{Key: "capture_active", Value: strconv.FormatBool(cs.Active)},
+			{Key: "capture_records", Value: strconv.FormatInt(cs.Captured, 10)},
+			{Key: "capture_dropped", Value: strconv.FormatInt(cs.Dropped, 10)},
+		},
+	}
+	nodes := &proto.InfoSection{Name: "Nodes"}
+	for i, state := range h.NodeStates() {
+		nodes.Fields = append(nodes.Fields, proto.InfoField{
+			Key:   "node" + strconv.Itoa(i),
+			Value: nodeStateString(state),
+		})
+	}
+	return []*proto.InfoSection{server, clients, stats, nodes}
+}
+
+func nodeStateString(state *proto.NodeState) string {
+	fields := []string{"addr=" + state.Addr}
+	if state.Alias != "" {
+		fields = append(fields, "alias="+state.Alias)
+	}
+	if state.Role != "" {
+		fields = append(fields, "role="+state.Role)
+	}
+	fields = append(fields, "status="+state.Status)
+	if state.Pipe.Capacity > 0 {
+		fields = append(fields, "queue="+strconv.Itoa(state.Pipe.Queued), "queue_cap="+strconv.Itoa(state.Pipe.Capacity),
+			"queue_full="+strconv.FormatInt(state.Pipe.Full, 10), "queue_waited="+strconv.FormatInt(state.Pipe.Waited, 10))
+	}
+	return strings.Join(fields, ",")
+}
+
+// NodeStates impl the proto.Admin and reports the state of backend nodes.
+func (h *Handler) NodeStates() []*proto.NodeState {
+	if ns, ok := h.forwarder.(proto.NodeStater); ok {
+		return ns.NodeStates()
+	}
+	return nil
+}
+
+// Config impl the proto.Admin and reports the config of handler's cluster,
+// the redis_auth is hidden.
+func (h *Handler) Config() []proto.InfoField {
+	// NOTE: the servers and migration of cc are changed by reload under the lock of proxy.
+	h.p.lock.Lock()
+	cc := *h.cc
+	h.p.lock.Unlock()
+	return []proto.InfoField{
+		{Key: "name", Value: cc.Name},
+		{Key: "hash_method", Value: cc.HashMethod},
+		{Key: "hash_distribution", Value: cc.HashDistribution},
+		{Key: "hash_tag", Value: cc.HashTag},
+		{Key: "cache_type", Value: string(cc.CacheType)},
+		{Key: "listen_proto", Value: cc.ListenProto},
+		{Key: "listen_addr", Value: cc.ListenAddr},
+		{Key: "dial_timeout", Value: strconv.Itoa(cc.DialTimeout)},
+		{Key: "read_timeout", Value: strconv.Itoa(cc.ReadTimeout)},
+		{Key: "write_timeout", Value: strconv.Itoa(cc.WriteTimeout)},
+		{Key: "node_connections", Value: strconv.Itoa(int(cc.NodeConnections))},
+		{Key: "node_pipe_count", Value: strconv.Itoa(cc.NodePipeCount)},
+		{Key: "node_pipe_queue", Value: strconv.Itoa(cc.NodePipeQueue)},
+		{Key: "node_pipe_wait", Value: strconv.Itoa(cc.NodePipeWait)},
+		{Key: "ping_fail_limit", Value: strconv.Itoa(cc.PingFailLimit)},
+		{Key: "ping_auto_eject", Value: strconv.FormatBool(cc.PingAutoEject)},
+		{Key: "slowlog_slower_than", Value: strconv.Itoa(cc.SlowlogSlowerThan)},
+		{Key: "databases", Value: strconv.Itoa(cc.Databases)},
+		{Key: "cross_node_algebra", Value: strconv.FormatBool(cc.CrossNodeAlgebra)},
+		{Key: "algebra_max_members", Value: strconv.Itoa(cc.AlgebraMaxMembers)},
+		{Key: "read_policy", Value: string(cc.ReadPolicy)},
+		{Key: "replica_max_lag", Value: strconv.Itoa(cc.ReplicaMaxLag)},
+		{Key: "hotkey_topk", Value: strconv.Itoa(cc.HotKeyTopK)},
+		{Key: "hotkey_sample", Value: strconv.Itoa(cc.HotKeySample)},
+		{Key: "hotkey_threshold", Value: strconv.Itoa(cc.HotKeyThreshold)},
+		{Key: "hotkey_window", Value: strconv.Itoa(cc.HotKeyWindow)},
+		{Key: "key_prefix", Value: cc.KeyPrefix},
+		{Key: "max_value_bytes", Value: strconv.Itoa(cc.MaxValueBytes)},
+		{Key: "max_request_bytes", Value: strconv.Itoa(cc.MaxRequestBytes)},
+		{Key: "max_reply_bytes", Value: strconv.Itoa(cc.MaxReplyBytes)},
+		{Key: "max_keys", Value: strconv.Itoa(cc.MaxKeys)},
+		{Key: "big_value_bytes", Value: strconv.Itoa(cc.BigValueBytes)},
+		{Key: "near_cache_max_bytes", Value: strconv.Itoa(cc.NearCacheMaxBytes)},
+		{Key: "near_cache_ttl", Value: strconv.Itoa(cc.NearCacheTTL)},
+		{Key: "near_cache_keys", Value: strings.Join(cc.NearCacheKeys, ",")},
+		{Key: "near_cache_tracking", Value: strconv.FormatBool(cc.NearCacheTracking)},
+		{Key: "coalesce_reads", Value: strconv.FormatBool(cc.CoalesceReads)},
+		{Key: "mirror", Value: cc.Mirror},
+		{Key: "mirror_servers", Value: strings.Join(cc.MirrorServers, ",")},
+		{Key: "mirror_rate", Value: strconv.FormatFloat(cc.MirrorRate, 'f', -1, 64)},
+		{Key: "mirror_queue", Value: strconv.Itoa(cc.MirrorQueue)},
+		{Key: "mirror_compare", Value: strconv.FormatBool(cc.MirrorCompare)},
+		{Key: "migrate_from", Value: strings.Join(cc.MigrateFrom, ",")},
+		{Key: "migrate_write", Value: cc.MigrateWrite},
+		{Key: "migrate_read_repair", Value: strconv.FormatBool(cc.MigrateReadRepair)},
+		{Key: "migrate_repair_ttl", Value: strconv.Itoa(cc.MigrateRepairTTL)},
+		{Key: "admin_commands", Value: strconv.FormatBool(cc.AdminCommands)},
+		{Key: "servers", Value: strings.Join(cc.Servers, ",")},
+	}
+}
+
+// Reload impl the proto.Admin and reloads the cluster config file of proxy.
+func (h *Handler) Reload() error {
+	return h.p.Reload()
+}
+
+// FinishMigration impl the proto.Admin and finishes the migration of handler's cluster.
+func (h *Handler) FinishMigration() error {
+	return h.p.FinishMigration(h.cc.Name)
+}
+
+// StartCapture impl the proto.Admin and starts capturing the messages of handler's cluster.
+func (h *Handler) StartCapture(d time.Duration, count int, replies bool) error {
+	if h.capture == nil {
+		return ErrProxyNoCapture
+	}
+	return h.capture.Start(d, count, replies)
+}
+
+// StopCapture impl the proto.Admin and stops capturing the messages of handler's cluster.
+func (h *Handler) StopCapture() error {
+	if h.capture == nil {
+		return ErrProxyNoCapture
+	}
+	return h.capture.Stop()
+}
+
+// HotKeys impl the proto.Admin and returns the hot keys of handler's cluster,
+// empty if the hot key detection is disabled.
+func (h *Handler) HotKeys(n int) []*proto.HotKey {
+	if h.hotkey == nil {
+		return nil
+	}
+	return h.hotkey.Top(n)
+}
+
+// Slowlog impl the proto.Admin and returns the slowlog of handler's cluster.
+func (h *Handler) Slowlog() proto.SlowlogStore {
+	return slowlog.Get(h.cc.Name)
+}
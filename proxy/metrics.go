@@ -0,0 +1,111 @@
+package proxy
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/ducesoft/overlord/proxy/prom"
+	"github.com/ducesoft/overlord/proxy/proto"
+)
+
+const (
+	pipeMetricPrefix  = "overlord_proxy_pipe_"
+	batchMetricPrefix = "overlord_proxy_batch_"
+)
+
+var (
+	staterMap  = map[string]proto.NodeStater{}
+	staterLock sync.RWMutex
+
+	batchMap  = map[string]*proto.BatchStats{}
+	batchLock sync.RWMutex
+)
+
+// registerNodeStater register the forwarder of cluster whose node pipes are
+// reported by http, the old one of the same name is replaced.
+func registerNodeStater(name string, ns proto.NodeStater) {
+	staterLock.Lock()
+	staterMap[name] = ns
+	staterLock.Unlock()
+}
+
+// collectPipe writes the stats of node pipes as the metrics of proxy.
+func collectPipe(w *prom.Writer) {
+	staterLock.RLock()
+	names := make([]string, 0, len(staterMap))
+	for name := range staterMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	states := make([][]*proto.NodeState, len(names))
+	for i, name := range names {
+		states[i] = staterMap[name].NodeStates()
+	}
+	staterLock.RUnlock()
+	metrics := []struct {
+		name, typ, help string
+		value           func(s proto.PipeStats) int64
+	}{
+		{"queue", prom.Gauge, "The count of requests waiting in the queue of node.", func(s proto.PipeStats) int64 { return int64(s.Queued) }},
+		{"queue_capacity", prom.Gauge, "The capacity of the queue of node.", func(s proto.PipeStats) int64 { return int64(s.Capacity) }},
+		{"full_total", prom.Counter, "The count of requests failed by the full queue of node.", func(s proto.PipeStats) int64 { return s.Full }},
+		{"waited_total", prom.Counter, "The count of requests waited for the full queue of node.", func(s proto.PipeStats) int64 { return s.Waited }},
+	}
+	for _, m := range metrics {
+		name := pipeMetricPrefix + m.name
+		w.Family(name, m.typ, m.help)
+		for i, cluster := range names {
+			for _, state := range states[i] {
+				w.Sample(name, m.value(state.Pipe), "cluster", cluster, "node", state.Addr)
+			}
+		}
+	}
+}
+
+// registerBatchStats returns the batch stats of cluster, which is kept by
+// the cluster of the same name.
+func registerBatchStats(name string) *proto.BatchStats {
+	batchLock.Lock()
+	defer batchLock.Unlock()
+	s, ok := batchMap[name]
+	if !ok {
+		s = &proto.BatchStats{}
+		batchMap[name] = s
+	}
+	return s
+}
+
+// collectBatch writes the stats of partially failed batch requests as the
+// metrics of proxy.
+func collectBatch(w *prom.Writer) {
+	batchLock.RLock()
+	names := make([]string, 0, len(batchMap))
+	for name := range batchMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	stats := make([]*proto.BatchStats, len(names))
+	for i, name := range names {
+		stats[i] = batchMap[name]
+	}
+	batchLock.RUnlock()
+	metrics := []struct {
+		name, help string
+		value      func(s *proto.BatchStats) int64
+	}{
+		{"partial_failures_total", "The count of batch requests of which part of keys are failed.", (*proto.BatchStats).Partial},
+		{"failed_keys_total", "The count of failed keys of partially failed batch requests.", (*proto.BatchStats).Failed},
+	}
+	for _, m := range metrics {
+		name := batchMetricPrefix + m.name
+		w.Family(name, prom.Counter, m.help)
+		for i, cluster := range names {
+			w.Sample(name, m.value(stats[i]), "cluster", cluster)
+		}
+	}
+}
+
+func init() {
+	prom.Register("pipe", collectPipe)
+	prom.Register("batch", collectBatch)
+}
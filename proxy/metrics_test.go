@@ -0,0 +1,45 @@
+package proxy
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ducesoft/overlord/proxy/prom"
+	"github.com/ducesoft/overlord/proxy/proto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type _nodeStater []*proto.NodeState
+
+func (s _nodeStater) NodeStates() []*proto.NodeState { return s }
+
+func TestCollectPipe(t *testing.T) {
+	registerNodeStater("test", _nodeStater{
+		{Addr: "127.0.0.1:6379", Pipe: proto.PipeStats{Queued: 3, Capacity: 1024, Full: 2, Waited: 5}},
+	})
+	buf := &bytes.Buffer{}
+	assert.NoError(t, prom.Write(buf))
+	body := buf.String()
+	assert.Contains(t, body, "# TYPE overlord_proxy_pipe_queue gauge\n")
+	assert.Contains(t, body, `overlord_proxy_pipe_queue{cluster="test",node="127.0.0.1:6379"} 3`)
+	assert.Contains(t, body, `overlord_proxy_pipe_queue_capacity{cluster="test",node="127.0.0.1:6379"} 1024`)
+	assert.Contains(t, body, `overlord_proxy_pipe_full_total{cluster="test",node="127.0.0.1:6379"} 2`)
+	assert.Contains(t, body, `overlord_proxy_pipe_waited_total{cluster="test",node="127.0.0.1:6379"} 5`)
+
+	assert.Equal(t, "addr=127.0.0.1:6379,status=ok,queue=3,queue_cap=1024,queue_full=2,queue_waited=5",
+		nodeStateString(&proto.NodeState{Addr: "127.0.0.1:6379", Status: proto.NodeStatusOK, Pipe: proto.PipeStats{Queued: 3, Capacity: 1024, Full: 2, Waited: 5}}))
+}
+
+func TestCollectBatch(t *testing.T) {
+	s := registerBatchStats("batch")
+	assert.Equal(t, s, registerBatchStats("batch"))
+	s.Observe(2)
+	s.Observe(0)
+	buf := &bytes.Buffer{}
+	assert.NoError(t, prom.Write(buf))
+	body := buf.String()
+	assert.Contains(t, body, "# TYPE overlord_proxy_batch_partial_failures_total counter\n")
+	assert.Contains(t, body, `overlord_proxy_batch_partial_failures_total{cluster="batch"} 1`)
+	assert.Contains(t, body, `overlord_proxy_batch_failed_keys_total{cluster="batch"} 2`)
+}
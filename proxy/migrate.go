@@ -0,0 +1,254 @@
+package proxy
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/ducesoft/overlord/proxy/proto"
+)
+
+const (
+	migrateWriteBoth = "both"
+	migrateWriteNew  = "new"
+)
+
+// migrator is the forwarder which may be migrating from the old servers to
+// the current servers.
+type migrator interface {
+	// writeOld forwards the copies of writes in msgs to the old servers if
+	// written both, it must be called before msgs are forwarded because the
+	// requests may be merged, and the copies appended into omsgs must be
+	// released after wg is done.
+	writeOld(msgs, omsgs []*proto.Message, wg *sync.WaitGroup) []*proto.Message
+	// readOld forwards the copies of reads of msgs missed in the current servers
+	// to the old servers and waits for the replies, the reply is copied back
+	// only if hit in the old servers.
+	readOld(msgs []*proto.Message, wg *sync.WaitGroup)
+	// Migrate starts the migration from cc.MigrateFrom, or finishes it if empty.
+	Migrate(cc *ClusterConfig) error
+	// Migrating reports whether the migration is in progress.
+	Migrating() bool
+	// migrateStats returns the count of reads fallen back and values repaired.
+	migrateStats() (fallbacks, repairs int64)
+}
+
+// migration is the old servers which the cluster is migrating from.
+type migration struct {
+	conns     *connections
+	writeBoth bool
+	repair    bool
+	ttl       int
+}
+
+func (mg *migration) close() {
+	mg.conns.cancel()
+	for _, ncp := range mg.conns.nodePipe {
+		go ncp.Close()
+	}
+}
+
+func (f *defaultForwarder) migrating() *migration {
+	mg, _ := f.migration.Load().(*migration)
+	return mg
+}
+
+// Migrate impl the migrator, the reads missed in the current servers fall
+// back to the old servers and the writes are forwarded to both if written both.
+func (f *defaultForwarder) Migrate(cc *ClusterConfig) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	var mg *migration
+	if len(cc.MigrateFrom) > 0 {
+		addrs, ws, ans, alias, opts, err := parseServers(cc.MigrateFrom)
+		if err != nil {
+			return err
+		}
+		// NOTE: the old servers are never pinged and ejected.
+		conns := f.newConnections()
+		conns.init(addrs, ans, ws, alias, opts, nil)
+		mg = &migration{
+			conns:     conns,
+			writeBoth: cc.MigrateWrite != migrateWriteNew,
+			repair:    cc.MigrateReadRepair,
+			ttl:       cc.MigrateRepairTTL,
+		}
+	}
+	old := f.migrating()
+	f.migration.Store(mg)
+	if old != nil {
+		old.close()
+	}
+	return nil
+}
+
+// Migrating impl the migrator.
+func (f *defaultForwarder) Migrating() bool {
+	return f.migrating() != nil
+}
+
+func (f *defaultForwarder) migrateStats() (fallbacks, repairs int64) {
+	return atomic.LoadInt64(&f.fallbacks), atomic.LoadInt64(&f.repairs)
+}
+
+// sameNode check whether all the keys of m are routed to the same node by
+// the current servers and the old servers.
+func (f *defaultForwarder) sameNode(conns, old *connections, m *proto.Message) bool {
+	if !m.IsBatch() {
+		addr, err := f.route(conns, m)
+		oaddr, oerr := f.route(old, m)
+		return err == nil && oerr == nil && addr == oaddr
+	}
+	for _, req := range m.Requests() {
+		key := f.trimHashTag(req.Key())
+		ctx, ok := conns.getPipesContext(key)
+		octx, ook := old.getPipesContext(key)
+		if !ok || !ook || ctx.identifier != octx.identifier {
+			return false
+		}
+	}
+	return true
+}
+
+func isReadOnly(m *proto.Message) bool {
+	ro, ok := m.Request().(proto.ReadOnlyer)
+	return ok && ro.IsReadOnly()
+}
+
+// cloneMsg returns the copy of m, nil if any request can't be copied.
+func cloneMsg(m *proto.Message) *proto.Message {
+	reqs := m.Requests()
+	clones := make([]proto.Request, 0, len(reqs))
+	for _, req := range reqs {
+		var clone proto.Request
+		if mr, ok := req.(proto.Mirrorer); ok {
+			clone = mr.Clone()
+		}
+		if clone == nil {
+			for _, c := range clones {
+				c.Put()
+			}
+			return nil
+		}
+		clones = append(clones, clone)
+	}
+	nm := proto.NewMessage()
+	nm.Type = m.Type
+	for _, clone := range clones {
+		nm.WithRequest(clone)
+	}
+	return nm
+}
+
+func (f *defaultForwarder) writeOld(msgs, omsgs []*proto.Message, wg *sync.WaitGroup) []*proto.Message {
+	mg := f.migrating()
+	if mg == nil || !mg.writeBoth {
+		return omsgs
+	}
+	conns, ok := f.conns.Load().(*connections)
+	if !ok {
+		return omsgs
+	}
+	n := len(omsgs)
+	for _, m := range msgs {
+		if proto.IsSession(m) || proto.IsBroadcast(m) || isReadOnly(m) || f.sameNode(conns, mg.conns, m) {
+			continue
+		}
+		if om := cloneMsg(m); om != nil {
+			om.WithWaitGroup(wg)
+			omsgs = append(omsgs, om)
+		}
+	}
+	if len(omsgs) > n {
+		// NOTE: the replies of old servers are dropped.
+		_ = f.forward(mg.conns, omsgs[n:])
+	}
+	return omsgs
+}
+
+func (f *defaultForwarder) readOld(msgs []*proto.Message, wg *sync.WaitGroup) {
+	mg := f.migrating()
+	if mg == nil {
+		return
+	}
+	conns, ok := f.conns.Load().(*connections)
+	if !ok {
+		return
+	}
+	var reads, omsgs []*proto.Message
+	for _, m := range msgs {
+		// NOTE: the batch reads, eg: MGET, never fall back.
+		if m.IsBatch() || m.Err() != nil || proto.IsSession(m) || !isReadOnly(m) {
+			continue
+		}
+		mr, ok := m.Request().(proto.Migrater)
+		if !ok || f.sameNode(conns, mg.conns, m) {
+			continue
+		}
+		req := mr.Fallback()
+		if req == nil {
+			continue
+		}
+		ncp, ok := mg.conns.getPipes(f.trimHashTag(req.Key()), req)
+		if !ok {
+			req.Put()
+			continue
+		}
+		om := proto.NewMessage()
+		om.Type = m.Type
+		om.WithRequest(req)
+		om.WithWaitGroup(wg)
+		om.MarkStartPipe()
+		ncp.Push(om)
+		reads = append(reads, m)
+		omsgs = append(omsgs, om)
+	}
+	if len(reads) == 0 {
+		return
+	}
+	atomic.AddInt64(&f.fallbacks, int64(len(reads)))
+	wg.Wait()
+	// NOTE: the error or miss of old servers keeps the miss of current servers.
+	var hits []*proto.Message
+	for i, om := range omsgs {
+		if mr, ok := om.Request().(proto.Migrater); !ok || om.Err() != nil || !mr.Hit() {
+			continue
+		}
+		if rc, ok := reads[i].Request().(proto.ReplyCopier); ok {
+			rc.CopyReply(om.Request())
+			hits = append(hits, reads[i])
+		}
+	}
+	proto.PutMsgs(omsgs)
+	if mg.repair {
+		f.repair(conns, hits, mg.ttl)
+	}
+}
+
+// repair stores the values read from the old servers into the current
+// servers asynchronously.
+func (f *defaultForwarder) repair(conns *connections, reads []*proto.Message, ttl int) {
+	var (
+		rmsgs []*proto.Message
+		wg    = &sync.WaitGroup{}
+	)
+	for _, m := range reads {
+		req := m.Request().(proto.Migrater).Repair(ttl)
+		if req == nil {
+			continue
+		}
+		rm := proto.NewMessage()
+		rm.Type = m.Type
+		rm.WithRequest(req)
+		rm.WithWaitGroup(wg)
+		rmsgs = append(rmsgs, rm)
+	}
+	if len(rmsgs) == 0 {
+		return
+	}
+	atomic.AddInt64(&f.repairs, int64(len(rmsgs)))
+	_ = f.forward(conns, rmsgs)
+	go func() {
+		wg.Wait()
+		proto.PutMsgs(rmsgs)
+	}()
+}
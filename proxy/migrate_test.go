@@ -0,0 +1,170 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ducesoft/overlord/pkg/mockconn"
+	libnet "github.com/ducesoft/overlord/pkg/net"
+	"github.com/ducesoft/overlord/pkg/types"
+	"github.com/ducesoft/overlord/proxy/proto"
+	"github.com/ducesoft/overlord/proxy/proto/redis"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// _fakeRedis speaks RESP on a local port and stores the strings by GET, SET [NX] [EX ttl] and DEL.
+type _fakeRedis struct {
+	ln   net.Listener
+	lock sync.Mutex
+	kv   map[string]string
+	cmds []string
+}
+
+func _newFakeRedis(t *testing.T, kv map[string]string) *_fakeRedis {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	s := &_fakeRedis{ln: ln, kv: kv}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *_fakeRedis) serve(conn net.Conn) {
+	br := bufio.NewReader(conn)
+	for {
+		args, err := _readArgs(br)
+		if err != nil {
+			_ = conn.Close()
+			return
+		}
+		s.lock.Lock()
+		s.cmds = append(s.cmds, strings.Join(args, " "))
+		switch strings.ToUpper(args[0]) {
+		case "GET":
+			if v, ok := s.kv[args[1]]; ok {
+				fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(v), v)
+			} else {
+				fmt.Fprintf(conn, "$-1\r\n")
+			}
+		case "SET":
+			if _, ok := s.kv[args[1]]; ok && len(args) > 3 && strings.ToUpper(args[3]) == "NX" {
+				fmt.Fprintf(conn, "$-1\r\n")
+			} else {
+				s.kv[args[1]] = args[2]
+				fmt.Fprintf(conn, "+OK\r\n")
+			}
+		case "DEL":
+			_, ok := s.kv[args[1]]
+			delete(s.kv, args[1])
+			if ok {
+				fmt.Fprintf(conn, ":1\r\n")
+			} else {
+				fmt.Fprintf(conn, ":0\r\n")
+			}
+		default:
+			fmt.Fprintf(conn, "-ERR unknown command\r\n")
+		}
+		s.lock.Unlock()
+	}
+}
+
+func (s *_fakeRedis) commands() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string(nil), s.cmds...)
+}
+
+func (s *_fakeRedis) get(key string) (string, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	v, ok := s.kv[key]
+	return v, ok
+}
+
+// _migrate forwards the commands like handler and returns the replies.
+func _migrate(t *testing.T, f *defaultForwarder, data string) string {
+	conn := libnet.NewConn(mockconn.CreateConn([]byte(data), 1), time.Second, time.Second)
+	pc := redis.NewProxyConn(conn, true)
+	msgs, err := pc.Decode(proto.GetMsgs(8))
+	assert.NoError(t, err)
+	wg := &sync.WaitGroup{}
+	for _, m := range msgs {
+		m.WithWaitGroup(wg)
+	}
+	omsgs := f.writeOld(msgs, nil, wg)
+	assert.NoError(t, f.Forward(msgs))
+	wg.Wait()
+	f.readOld(msgs, wg)
+	proto.PutMsgs(omsgs)
+	for _, m := range msgs {
+		assert.NoError(t, pc.Encode(m))
+	}
+	assert.NoError(t, pc.Flush())
+	return conn.Conn.(*mockconn.MockConn).Wbuf.String()
+}
+
+func TestForwarderMigrate(t *testing.T) {
+	old := _newFakeRedis(t, map[string]string{"a": "old-a", "b": "old-b"})
+	defer old.ln.Close()
+	cur := _newFakeRedis(t, map[string]string{"b": "new-b"})
+	defer cur.ln.Close()
+
+	cc := &ClusterConfig{Name: "migrate", CacheType: types.CacheTypeRedis, HashMethod: "fnv1a_64", HashDistribution: "ketama",
+		DialTimeout: 1000, ReadTimeout: 1000, WriteTimeout: 1000, NodeConnections: 1,
+		Servers: []string{cur.ln.Addr().String() + ":1"}, MigrateFrom: []string{old.ln.Addr().String() + ":1"},
+		MigrateReadRepair: true, MigrateRepairTTL: 60}
+	cc.SetDefault()
+	assert.NoError(t, cc.Validate())
+	f := newDefaultForwarder(cc).(*defaultForwarder)
+	defer f.Close()
+	assert.True(t, f.Migrating())
+
+	// the missed read falls back to old servers and is repaired into new servers
+	assert.Equal(t, "$5\r\nold-a\r\n$5\r\nnew-b\r\n$-1\r\n", _migrate(t, f, "GET a\r\nGET b\r\nGET c\r\n"))
+	assert.Eventually(t, func() bool { v, _ := cur.get("a"); return v == "old-a" }, time.Second, 10*time.Millisecond)
+	assert.Contains(t, cur.commands(), "SET a old-a NX EX 60")
+	fallbacks, repairs := f.migrateStats()
+	assert.Equal(t, int64(2), fallbacks)
+	assert.Equal(t, int64(1), repairs)
+
+	// the writes go to both
+	assert.Equal(t, "+OK\r\n:1\r\n", _migrate(t, f, "SET d 1\r\nDEL b\r\n"))
+	v, _ := old.get("d")
+	assert.Equal(t, "1", v)
+	_, ok := old.get("b")
+	assert.False(t, ok)
+
+	// the writes only go to new servers
+	cc.MigrateWrite = migrateWriteNew
+	assert.NoError(t, f.Migrate(cc))
+	assert.Equal(t, "+OK\r\n", _migrate(t, f, "SET e 1\r\n"))
+	_, ok = old.get("e")
+	assert.False(t, ok)
+
+	// the error of old servers keeps the miss of new servers
+	dead := _newFakeRedis(t, nil)
+	dead.ln.Close()
+	cc.MigrateFrom = []string{dead.ln.Addr().String() + ":1"}
+	assert.NoError(t, f.Migrate(cc))
+	assert.Equal(t, "$-1\r\n", _migrate(t, f, "GET g\r\n"))
+
+	assert.NoError(t, f.Migrate(&ClusterConfig{}))
+	assert.False(t, f.Migrating())
+	old.lock.Lock()
+	old.kv["f"] = "old-f"
+	old.lock.Unlock()
+	assert.Equal(t, "$-1\r\n", _migrate(t, f, "GET f\r\n"))
+}
@@ -0,0 +1,55 @@
+package mirror
+
+import (
+	"sort"
+
+	"github.com/ducesoft/overlord/proxy/prom"
+)
+
+const metricPrefix = "overlord_proxy_mirror_"
+
+func mirrors() []*Mirror {
+	mirrorLock.RLock()
+	ms := make([]*Mirror, 0, len(mirrorMap))
+	for _, m := range mirrorMap {
+		ms = append(ms, m)
+	}
+	mirrorLock.RUnlock()
+	sort.Slice(ms, func(i, j int) bool { return ms[i].name < ms[j].name })
+	return ms
+}
+
+// collect writes the stats of mirrors as the metrics of proxy.
+func collect(w *prom.Writer) {
+	ms := mirrors()
+	stats := make([]Stats, len(ms))
+	for i, m := range ms {
+		stats[i] = m.Stats()
+	}
+	metrics := []struct {
+		name, help string
+		value      func(s Stats) int64
+	}{
+		{"requests_total", "The count of messages queued to shadow.", func(s Stats) int64 { return s.Mirrored }},
+		{"dropped_total", "The count of messages dropped by full queue.", func(s Stats) int64 { return s.Dropped }},
+		{"errors_total", "The count of messages failed in shadow.", func(s Stats) int64 { return s.Errors }},
+	}
+	for _, m := range metrics {
+		name := metricPrefix + m.name
+		w.Family(name, prom.Counter, m.help)
+		for i, mr := range ms {
+			w.Sample(name, m.value(stats[i]), "cluster", mr.name)
+		}
+	}
+	name := metricPrefix + "mismatches_total"
+	w.Family(name, prom.Counter, "The count of replies of shadow different from cluster.")
+	for i, mr := range ms {
+		for _, cmd := range stats[i].commands() {
+			w.Sample(name, stats[i].Mismatches[cmd], "cluster", mr.name, "cmd", cmd)
+		}
+	}
+}
+
+func init() {
+	prom.Register("mirror", collect)
+}
@@ -0,0 +1,303 @@
+package mirror
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ducesoft/overlord/proxy/proto"
+)
+
+// batchSize is the max messages forwarded to shadow at once.
+const batchSize = 64
+
+// job is the message mirrored to shadow, expect holds the copies of replies
+// of cluster to be compared, nil if not compared.
+type job struct {
+	msg    *proto.Message
+	expect []proto.Request
+	src    *proto.Message
+}
+
+func (j *job) put() {
+	for _, req := range j.expect {
+		req.Put()
+	}
+	proto.PutMsgs([]*proto.Message{j.msg})
+}
+
+// Stats is the stats of mirror.
+type Stats struct {
+	Mirrored int64
+	Dropped  int64
+	Errors   int64
+	// Mismatches is the count of replies of shadow different from cluster by command.
+	Mismatches map[string]int64
+}
+
+// Mirror duplicates the sampled requests of cluster to the shadow forwarder
+// asynchronously and drops the replies of shadow, or compares them with the
+// replies of cluster. The messages are queued and dropped when the queue is
+// full, so the clients are never blocked by the shadow.
+type Mirror struct {
+	name    string
+	shadow  proto.Forwarder
+	rate    float64
+	compare bool
+
+	seq    uint64
+	jobs   chan *job
+	closed chan struct{}
+	once   sync.Once
+
+	mirrored int64
+	dropped  int64
+	errors   int64
+
+	lock       sync.Mutex
+	mismatches map[string]int64
+}
+
+// New new a mirror of cluster, rate is the sample rate in [0, 1] and queue is
+// the max messages waiting to be forwarded to shadow.
+func New(name string, shadow proto.Forwarder, rate float64, queue int, compare bool) *Mirror {
+	m := &Mirror{
+		name:       name,
+		shadow:     shadow,
+		rate:       rate,
+		compare:    compare,
+		jobs:       make(chan *job, queue),
+		closed:     make(chan struct{}),
+		mismatches: make(map[string]int64),
+	}
+	go m.run()
+	return m
+}
+
+// Name returns the name of cluster.
+func (m *Mirror) Name() string {
+	return m.name
+}
+
+// sample reports whether the next message is mirrored, every message is
+// mirrored when the count of messages multiplied by rate reaches the next integer.
+func (m *Mirror) sample() bool {
+	n := atomic.AddUint64(&m.seq, 1)
+	return math.Floor(float64(n)*m.rate) != math.Floor(float64(n-1)*m.rate)
+}
+
+// Sample copies the sampled messages to be mirrored and appends into jobs, it
+// must be called before msgs are forwarded because the requests may be merged.
+func (m *Mirror) Sample(msgs []*proto.Message, jobs []interface{}) []interface{} {
+	for _, msg := range msgs {
+		if proto.IsSession(msg) || !m.sample() {
+			continue
+		}
+		if j := m.clone(msg); j != nil {
+			jobs = append(jobs, j)
+		}
+	}
+	return jobs
+}
+
+// replyCopiers check whether all the replies of reqs can be copied to compare
+// with the shadow cluster.
+func replyCopiers(reqs []proto.Request) bool {
+	for _, req := range reqs {
+		if _, ok := req.(proto.ReplyCopier); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *Mirror) clone(msg *proto.Message) *job {
+	reqs := msg.Requests()
+	clones := make([]proto.Request, 0, len(reqs))
+	var expect []proto.Request
+	compare := m.compare && !msg.IsBatch() && replyCopiers(reqs)
+	for _, req := range reqs {
+		var clone, exp proto.Request
+		if mr, ok := req.(proto.Mirrorer); ok {
+			clone = mr.Clone()
+			if clone != nil && compare {
+				exp = mr.Clone()
+			}
+		}
+		if clone == nil {
+			for _, c := range clones {
+				c.Put()
+			}
+			for _, e := range expect {
+				e.Put()
+			}
+			return nil
+		}
+		clones = append(clones, clone)
+		if exp != nil {
+			expect = append(expect, exp)
+		}
+	}
+	nm := proto.NewMessage()
+	nm.Type = msg.Type
+	for _, clone := range clones {
+		nm.WithRequest(clone)
+	}
+	return &job{msg: nm, expect: expect, src: msg}
+}
+
+// Push queues the jobs sampled after the messages of cluster are replied, the
+// jobs are dropped if the queue is full.
+func (m *Mirror) Push(jobs []interface{}) {
+	for _, ji := range jobs {
+		j := ji.(*job)
+		if len(j.expect) > 0 {
+			if j.src.Err() == nil {
+				for i, req := range j.src.Requests() {
+					if rc, ok := j.expect[i].(proto.ReplyCopier); ok {
+						rc.CopyReply(req)
+					}
+				}
+			} else {
+				for _, req := range j.expect {
+					req.Put()
+				}
+				j.expect = nil
+			}
+		}
+		j.src = nil
+		select {
+		case <-m.closed:
+			j.put()
+			continue
+		default:
+		}
+		select {
+		case m.jobs <- j:
+			atomic.AddInt64(&m.mirrored, 1)
+		default:
+			atomic.AddInt64(&m.dropped, 1)
+			j.put()
+		}
+	}
+}
+
+func (m *Mirror) run() {
+	var (
+		wg    = &sync.WaitGroup{}
+		batch = make([]*job, 0, batchSize)
+		msgs  = make([]*proto.Message, 0, batchSize)
+	)
+	for {
+		select {
+		case j := <-m.jobs:
+			batch = append(batch[:0], j)
+		case <-m.closed:
+			return
+		}
+	DRAIN:
+		for len(batch) < batchSize {
+			select {
+			case j := <-m.jobs:
+				batch = append(batch, j)
+			default:
+				break DRAIN
+			}
+		}
+		msgs = msgs[:0]
+		for _, j := range batch {
+			j.msg.WithWaitGroup(wg)
+			msgs = append(msgs, j.msg)
+		}
+		err := m.shadow.Forward(msgs)
+		wg.Wait()
+		for _, j := range batch {
+			if err != nil {
+				// NOTE: the messages after the failed one are never forwarded.
+				atomic.AddInt64(&m.errors, 1)
+			} else {
+				m.check(j)
+			}
+			j.put()
+		}
+	}
+}
+
+// check compares the replies of shadow with the replies of cluster.
+func (m *Mirror) check(j *job) {
+	if j.msg.Err() != nil {
+		atomic.AddInt64(&m.errors, 1)
+		return
+	}
+	for i, req := range j.expect {
+		sreq := j.msg.Requests()[i]
+		if !sreq.(proto.Mirrorer).ReplyEqual(req) {
+			m.lock.Lock()
+			m.mismatches[sreq.CmdString()]++
+			m.lock.Unlock()
+			return
+		}
+	}
+}
+
+// Stats returns the stats of mirror.
+func (m *Mirror) Stats() Stats {
+	s := Stats{
+		Mirrored:   atomic.LoadInt64(&m.mirrored),
+		Dropped:    atomic.LoadInt64(&m.dropped),
+		Errors:     atomic.LoadInt64(&m.errors),
+		Mismatches: make(map[string]int64),
+	}
+	m.lock.Lock()
+	for cmd, n := range m.mismatches {
+		s.Mismatches[cmd] = n
+	}
+	m.lock.Unlock()
+	return s
+}
+
+// TotalMismatches returns the total count of mismatched replies.
+func (s Stats) TotalMismatches() (n int64) {
+	for _, c := range s.Mismatches {
+		n += c
+	}
+	return
+}
+
+// commands returns the commands of mismatches in order.
+func (s Stats) commands() []string {
+	cmds := make([]string, 0, len(s.Mismatches))
+	for cmd := range s.Mismatches {
+		cmds = append(cmds, cmd)
+	}
+	sort.Strings(cmds)
+	return cmds
+}
+
+// Close stop mirroring, the queued messages are dropped.
+func (m *Mirror) Close() {
+	m.once.Do(func() {
+		close(m.closed)
+	})
+}
+
+var (
+	mirrorMap  = map[string]*Mirror{}
+	mirrorLock sync.RWMutex
+)
+
+// Register the mirror of cluster which is reported by http, the old one of
+// the same name is replaced.
+func Register(m *Mirror) {
+	mirrorLock.Lock()
+	mirrorMap[m.name] = m
+	mirrorLock.Unlock()
+}
+
+// Get returns the mirror of cluster, nil if not registered.
+func Get(name string) *Mirror {
+	mirrorLock.RLock()
+	defer mirrorLock.RUnlock()
+	return mirrorMap[name]
+}
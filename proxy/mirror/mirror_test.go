@@ -0,0 +1,150 @@
+package mirror
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ducesoft/overlord/proxy/prom"
+	"github.com/ducesoft/overlord/proxy/proto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type _request struct {
+	cmd, key, reply string
+	bound           bool
+}
+
+func (r *_request) CmdString() string               { return r.cmd }
+func (r *_request) Cmd() []byte                     { return []byte(r.cmd) }
+func (r *_request) Key() []byte                     { return []byte(r.key) }
+func (r *_request) Put()                            {}
+func (r *_request) Merge([]proto.Request) error     { return nil }
+func (r *_request) Slowlog() *proto.SlowlogEntry    { return nil }
+func (r *_request) CoalesceKey(dst []byte) []byte   { return nil }
+func (r *_request) CopyReply(src proto.Request)     { r.reply = src.(*_request).reply }
+func (r *_request) ReplyEqual(o proto.Request) bool { return r.reply == o.(*_request).reply }
+func (r *_request) Clone() proto.Request {
+	if r.bound {
+		return nil
+	}
+	return &_request{cmd: r.cmd, key: r.key}
+}
+
+// _shadow replies the value of key in values, or the error.
+type _shadow struct {
+	lock   sync.Mutex
+	values map[string]string
+	keys   []string
+	err    error
+	block  chan struct{}
+}
+
+func (s *_shadow) Forward(msgs []*proto.Message) error {
+	if s.block != nil {
+		<-s.block
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, m := range msgs {
+		m.Add()
+		for _, req := range m.Requests() {
+			r := req.(*_request)
+			r.reply = s.values[r.key]
+			s.keys = append(s.keys, r.key)
+		}
+		m.WithError(s.err)
+		m.Done()
+	}
+	return nil
+}
+func (s *_shadow) Close() error                  { return nil }
+func (s *_shadow) Update(servers []string) error { return nil }
+
+func (s *_shadow) forwarded() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string(nil), s.keys...)
+}
+
+// _replied mirrors the requests which are replied by cluster with the value of key.
+func _replied(m *Mirror, reqs ...*_request) {
+	msgs := make([]*proto.Message, len(reqs))
+	for i, req := range reqs {
+		msgs[i] = proto.NewMessage()
+		msgs[i].WithRequest(req)
+	}
+	jobs := m.Sample(msgs, nil)
+	for _, req := range reqs {
+		req.reply = "v" + req.key
+	}
+	m.Push(jobs)
+}
+
+func TestMirrorCompare(t *testing.T) {
+	shadow := &_shadow{values: map[string]string{"a": "va", "b": "stale"}}
+	m := New("compare", shadow, 1, 16, true)
+	defer m.Close()
+
+	_replied(m, &_request{cmd: "GET", key: "a"}, &_request{cmd: "GET", key: "b"}, &_request{cmd: "MULTI", key: "c", bound: true})
+	assert.Eventually(t, func() bool { return len(shadow.forwarded()) == 2 }, time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool { return m.Stats().TotalMismatches() == 1 }, time.Second, 10*time.Millisecond)
+	s := m.Stats()
+	assert.Equal(t, int64(2), s.Mirrored)
+	assert.Equal(t, map[string]int64{"GET": 1}, s.Mismatches)
+
+	shadow.lock.Lock()
+	shadow.err = errors.New("shadow down")
+	shadow.lock.Unlock()
+	_replied(m, &_request{cmd: "GET", key: "a"})
+	assert.Eventually(t, func() bool { return m.Stats().Errors == 1 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, int64(1), m.Stats().TotalMismatches())
+}
+
+func TestMirrorSample(t *testing.T) {
+	shadow := &_shadow{}
+	m := New("sample", shadow, 0.25, 16, false)
+	defer m.Close()
+	for i := 0; i < 8; i++ {
+		_replied(m, &_request{cmd: "SET", key: "k"})
+	}
+	assert.Eventually(t, func() bool { return len(shadow.forwarded()) == 2 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, int64(2), m.Stats().Mirrored)
+}
+
+func TestMirrorDrop(t *testing.T) {
+	shadow := &_shadow{block: make(chan struct{})}
+	m := New("drop", shadow, 1, 2, false)
+	defer m.Close()
+
+	// NOTE: the worker takes the first request and blocks.
+	_replied(m, &_request{cmd: "SET", key: "0"})
+	assert.Eventually(t, func() bool { return len(m.jobs) == 0 }, time.Second, 10*time.Millisecond)
+	for i := 0; i < 4; i++ {
+		_replied(m, &_request{cmd: "SET", key: "k"})
+	}
+	s := m.Stats()
+	assert.Equal(t, int64(3), s.Mirrored)
+	assert.Equal(t, int64(2), s.Dropped)
+	close(shadow.block)
+	assert.Eventually(t, func() bool { return len(shadow.forwarded()) == 3 }, time.Second, 10*time.Millisecond)
+}
+
+func TestCollect(t *testing.T) {
+	m := New("metrics", &_shadow{values: map[string]string{}}, 1, 16, true)
+	defer m.Close()
+	Register(m)
+	assert.Equal(t, m, Get("metrics"))
+	_replied(m, &_request{cmd: "GET", key: "a"})
+	assert.Eventually(t, func() bool { return m.Stats().TotalMismatches() == 1 }, time.Second, 10*time.Millisecond)
+
+	buf := &bytes.Buffer{}
+	assert.NoError(t, prom.Write(buf))
+	body := buf.String()
+	assert.True(t, strings.Contains(body, `overlord_proxy_mirror_requests_total{cluster="metrics"} 1`), body)
+	assert.True(t, strings.Contains(body, `overlord_proxy_mirror_mismatches_total{cluster="metrics",cmd="GET"} 1`), body)
+}
@@ -0,0 +1,51 @@
+package nearcache
+
+import (
+	"sort"
+
+	"github.com/ducesoft/overlord/proxy/prom"
+)
+
+const metricPrefix = "overlord_proxy_nearcache_"
+
+func caches() []*Cache {
+	cacheLock.RLock()
+	cs := make([]*Cache, 0, len(cacheMap))
+	for _, c := range cacheMap {
+		cs = append(cs, c)
+	}
+	cacheLock.RUnlock()
+	sort.Slice(cs, func(i, j int) bool { return cs[i].name < cs[j].name })
+	return cs
+}
+
+// collect writes the stats of near caches as the metrics of proxy.
+func collect(w *prom.Writer) {
+	cs := caches()
+	stats := make([]Stats, len(cs))
+	for i, c := range cs {
+		stats[i] = c.Stats()
+	}
+	metrics := []struct {
+		name, typ, help string
+		value           func(s Stats) int64
+	}{
+		{"hits_total", prom.Counter, "The count of reads served by near cache.", func(s Stats) int64 { return s.Hits }},
+		{"misses_total", prom.Counter, "The count of reads missed near cache.", func(s Stats) int64 { return s.Misses }},
+		{"evictions_total", prom.Counter, "The count of keys evicted by max memory.", func(s Stats) int64 { return s.Evictions }},
+		{"invalidations_total", prom.Counter, "The count of keys invalidated by writes.", func(s Stats) int64 { return s.Invalidations }},
+		{"keys", prom.Gauge, "The count of cached keys.", func(s Stats) int64 { return int64(s.Keys) }},
+		{"bytes", prom.Gauge, "The estimated memory of cached keys.", func(s Stats) int64 { return int64(s.Bytes) }},
+	}
+	for _, m := range metrics {
+		name := metricPrefix + m.name
+		w.Family(name, m.typ, m.help)
+		for i, c := range cs {
+			w.Sample(name, m.value(stats[i]), "cluster", c.name)
+		}
+	}
+}
+
+func init() {
+	prom.Register("nearcache", collect)
+}
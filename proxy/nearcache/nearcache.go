@@ -0,0 +1,253 @@
+package nearcache
+
+import (
+	"container/list"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// entryOverhead is the estimated bytes of each entry besides key and value,
+// which is counted into the max memory.
+const entryOverhead = 64
+
+type entry struct {
+	key    string
+	val    []byte
+	expire time.Time
+}
+
+func (e *entry) size() int {
+	return len(e.key) + len(e.val) + entryOverhead
+}
+
+// Stats is the stats of near cache.
+type Stats struct {
+	Hits          int64
+	Misses        int64
+	Evictions     int64
+	Invalidations int64
+	Keys          int
+	Bytes         int
+}
+
+// Cache is the in-process cache of the replies of hot read keys, the memory
+// is bounded by the LRU of maxBytes and every entry expires after ttl. Only
+// the keys matching the patterns are cached.
+//
+// Every invalidation of matching keys bumps the sequence, the reply read
+// before an invalidation is never stored, so the write passing through proxy
+// always invalidates the reply of the concurrent read.
+type Cache struct {
+	name     string
+	maxBytes int
+	ttl      time.Duration
+	patterns []string
+
+	lock  sync.Mutex
+	ll    *list.List
+	items map[string]*list.Element
+	bytes int
+	seq   uint64
+
+	hits          int64
+	misses        int64
+	evictions     int64
+	invalidations int64
+}
+
+// New new a near cache of cluster, the patterns are glob of '*' and '?'.
+func New(name string, maxBytes int, ttl time.Duration, patterns []string) *Cache {
+	return &Cache{
+		name:     name,
+		maxBytes: maxBytes,
+		ttl:      ttl,
+		patterns: patterns,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+// Name returns the name of cluster.
+func (c *Cache) Name() string {
+	return c.name
+}
+
+// Match check whether the key is allowed to be cached by the patterns.
+func (c *Cache) Match(key []byte) bool {
+	for _, p := range c.patterns {
+		if match(p, string(key)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Prefixes returns the literal prefixes of patterns before the first wildcard,
+// the prefix covered by the other one is removed. Nil means all keys, eg: '*'.
+func (c *Cache) Prefixes() (prefixes []string) {
+	for _, p := range c.patterns {
+		prefix := p
+		if i := strings.IndexAny(p, "*?"); i >= 0 {
+			prefix = p[:i]
+		}
+		if prefix == "" {
+			return nil
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	n := 0
+	for _, prefix := range prefixes {
+		if n > 0 && strings.HasPrefix(prefix, prefixes[n-1]) {
+			continue
+		}
+		prefixes[n] = prefix
+		n++
+	}
+	return prefixes[:n]
+}
+
+// Get returns the cached reply of key, the reply must not be modified.
+func (c *Cache) Get(key []byte) ([]byte, bool) {
+	c.lock.Lock()
+	if el, ok := c.items[string(key)]; ok {
+		e := el.Value.(*entry)
+		if time.Now().Before(e.expire) {
+			c.ll.MoveToFront(el)
+			c.lock.Unlock()
+			atomic.AddInt64(&c.hits, 1)
+			return e.val, true
+		}
+		c.remove(el)
+	}
+	c.lock.Unlock()
+	atomic.AddInt64(&c.misses, 1)
+	return nil, false
+}
+
+// Seq returns the sequence of invalidations, which must be taken before the
+// read is forwarded and passed to Set.
+func (c *Cache) Seq() uint64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.seq
+}
+
+// Set store the copy of reply of key which is read after seq, it is ignored if
+// any key is invalidated since seq or the entry is larger than max memory.
+func (c *Cache) Set(key, val []byte, seq uint64) {
+	e := &entry{key: string(key), val: append([]byte(nil), val...), expire: time.Now().Add(c.ttl)}
+	size := e.size()
+	if size > c.maxBytes {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if seq != c.seq {
+		return
+	}
+	if el, ok := c.items[e.key]; ok {
+		c.remove(el)
+	}
+	for c.bytes+size > c.maxBytes {
+		c.remove(c.ll.Back())
+		atomic.AddInt64(&c.evictions, 1)
+	}
+	c.items[e.key] = c.ll.PushFront(e)
+	c.bytes += size
+}
+
+// Invalidate removes the key if it matches the patterns, eg: written by SET.
+func (c *Cache) Invalidate(key []byte) {
+	if !c.Match(key) {
+		return
+	}
+	c.lock.Lock()
+	c.seq++
+	if el, ok := c.items[string(key)]; ok {
+		c.remove(el)
+	}
+	c.lock.Unlock()
+	atomic.AddInt64(&c.invalidations, 1)
+}
+
+// Flush removes all the keys, eg: the invalidations from backend may be lost.
+func (c *Cache) Flush() {
+	c.lock.Lock()
+	c.seq++
+	c.ll.Init()
+	c.items = make(map[string]*list.Element)
+	c.bytes = 0
+	c.lock.Unlock()
+}
+
+// Stats returns the stats of cache.
+func (c *Cache) Stats() Stats {
+	c.lock.Lock()
+	keys, bytes := len(c.items), c.bytes
+	c.lock.Unlock()
+	return Stats{
+		Hits:          atomic.LoadInt64(&c.hits),
+		Misses:        atomic.LoadInt64(&c.misses),
+		Evictions:     atomic.LoadInt64(&c.evictions),
+		Invalidations: atomic.LoadInt64(&c.invalidations),
+		Keys:          keys,
+		Bytes:         bytes,
+	}
+}
+
+func (c *Cache) remove(el *list.Element) {
+	e := c.ll.Remove(el).(*entry)
+	delete(c.items, e.key)
+	c.bytes -= e.size()
+}
+
+// match reports whether s matches the glob pattern, '*' matches any sequence
+// and '?' matches any single byte, the other bytes match themselves.
+func match(pattern, s string) bool {
+	var p, i, star, next = 0, 0, -1, 0
+	for i < len(s) {
+		switch {
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == s[i]):
+			p++
+			i++
+		case p < len(pattern) && pattern[p] == '*':
+			star, next = p, i
+			p++
+		case star >= 0:
+			// NOTE: backtrack and let the last '*' match one more byte
+			p = star + 1
+			next++
+			i = next
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+var (
+	cacheMap  = map[string]*Cache{}
+	cacheLock sync.RWMutex
+)
+
+// Register the cache of cluster which is reported by http, the old one of
+// the same name is replaced.
+func Register(c *Cache) {
+	cacheLock.Lock()
+	cacheMap[c.name] = c
+	cacheLock.Unlock()
+}
+
+// Get returns the cache of cluster, nil if not registered.
+func Get(name string) *Cache {
+	cacheLock.RLock()
+	defer cacheLock.RUnlock()
+	return cacheMap[name]
+}
@@ -0,0 +1,129 @@
+package nearcache
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ducesoft/overlord/proxy/prom"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatch(t *testing.T) {
+	cases := []struct {
+		pattern, s string
+		ok         bool
+	}{
+		{"*", "", true},
+		{"*", "any/key", true},
+		{"flag:*", "flag:a/b", true},
+		{"flag:*", "flags", false},
+		{"a?c", "abc", true},
+		{"a?c", "ac", false},
+		{"*:on", "a:b:on", true},
+		{"a*b*c", "axxbyybc", true},
+		{"a*b*c", "axxbyyb", false},
+		{"exact", "exact", true},
+		{"exact", "exactly", false},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.ok, match(c.pattern, c.s), "pattern:%s s:%s", c.pattern, c.s)
+	}
+}
+
+func TestPrefixes(t *testing.T) {
+	c := New("test", 1024, time.Second, []string{"flag:*", "flag:a:*", "conf?", "user:1"})
+	assert.Equal(t, []string{"conf", "flag:", "user:1"}, c.Prefixes())
+	c = New("test", 1024, time.Second, []string{"flag:*", "*:on"})
+	assert.Nil(t, c.Prefixes())
+}
+
+func TestCacheGetSet(t *testing.T) {
+	c := New("test", 1024, time.Minute, []string{"flag:*"})
+	assert.True(t, c.Match([]byte("flag:a")))
+	assert.False(t, c.Match([]byte("user:a")))
+
+	_, ok := c.Get([]byte("flag:a"))
+	assert.False(t, ok)
+	val := []byte("$1\r\na")
+	c.Set([]byte("flag:a"), val, c.Seq())
+	val[0] = 'x' // NOTE: the value is copied
+	data, ok := c.Get([]byte("flag:a"))
+	assert.True(t, ok)
+	assert.Equal(t, []byte("$1\r\na"), data)
+
+	// the read before invalidation is never stored
+	seq := c.Seq()
+	c.Invalidate([]byte("flag:a"))
+	c.Set([]byte("flag:a"), val, seq)
+	_, ok = c.Get([]byte("flag:a"))
+	assert.False(t, ok)
+	// the key not matched is not invalidated
+	seq = c.Seq()
+	c.Invalidate([]byte("user:a"))
+	assert.Equal(t, seq, c.Seq())
+
+	stats := c.Stats()
+	assert.Equal(t, int64(1), stats.Hits)
+	assert.Equal(t, int64(2), stats.Misses)
+	assert.Equal(t, int64(1), stats.Invalidations)
+	assert.Equal(t, 0, stats.Keys)
+}
+
+func TestCacheTTLAndFlush(t *testing.T) {
+	c := New("test", 1024, 10*time.Millisecond, []string{"*"})
+	c.Set([]byte("a"), []byte("1"), c.Seq())
+	c.Set([]byte("b"), []byte("2"), c.Seq())
+	time.Sleep(20 * time.Millisecond)
+	_, ok := c.Get([]byte("a"))
+	assert.False(t, ok)
+	assert.Equal(t, 1, c.Stats().Keys)
+
+	c.Flush()
+	assert.Equal(t, Stats{Misses: 1}, c.Stats())
+}
+
+func TestCacheEvict(t *testing.T) {
+	c := New("test", 3*(entryOverhead+2), time.Minute, []string{"*"})
+	for i := 0; i < 3; i++ {
+		c.Set([]byte(strconv.Itoa(i)), []byte("v"), c.Seq())
+	}
+	// NOTE: 0 is the most recently used
+	_, ok := c.Get([]byte("0"))
+	assert.True(t, ok)
+	c.Set([]byte("3"), []byte("v"), c.Seq())
+	_, ok = c.Get([]byte("1"))
+	assert.False(t, ok)
+	_, ok = c.Get([]byte("0"))
+	assert.True(t, ok)
+	stats := c.Stats()
+	assert.Equal(t, int64(1), stats.Evictions)
+	assert.Equal(t, 3, stats.Keys)
+	assert.Equal(t, 3*(entryOverhead+2), stats.Bytes)
+
+	// the entry larger than max memory is never stored
+	c.Set([]byte("big"), make([]byte, 3*entryOverhead), c.Seq())
+	assert.Equal(t, 3, c.Stats().Keys)
+}
+
+func TestCollect(t *testing.T) {
+	c := New("metrics", 1024, time.Minute, []string{"*"})
+	c.Set([]byte("a"), []byte("1"), c.Seq())
+	c.Get([]byte("a"))
+	Register(c)
+	defer func() {
+		cacheLock.Lock()
+		delete(cacheMap, "metrics")
+		cacheLock.Unlock()
+	}()
+	assert.Equal(t, c, Get("metrics"))
+
+	buf := &bytes.Buffer{}
+	assert.NoError(t, prom.Write(buf))
+	body := buf.String()
+	assert.Contains(t, body, "# TYPE overlord_proxy_nearcache_hits_total counter\n")
+	assert.Contains(t, body, `overlord_proxy_nearcache_hits_total{cluster="metrics"} 1`)
+	assert.Contains(t, body, `overlord_proxy_nearcache_keys{cluster="metrics"} 1`)
+}
@@ -0,0 +1,98 @@
+// Package prom writes the metrics of proxy in the prometheus text format, the
+// collectors of features are registered here and exposed together by /metrics.
+package prom
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// metric types
+const (
+	Counter = "counter"
+	Gauge   = "gauge"
+)
+
+// labelEscaper escape the label value of prometheus text format.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Writer writes the metric families in the prometheus text format.
+type Writer struct {
+	bw *bufio.Writer
+}
+
+// Family writes the HELP and TYPE of metric which must be written before its samples.
+func (w *Writer) Family(name, typ, help string) {
+	w.bw.WriteString("# HELP " + name + " " + help + "\n")
+	w.bw.WriteString("# TYPE " + name + " " + typ + "\n")
+}
+
+// Sample writes the value of metric, labels are the pairs of label name and value.
+func (w *Writer) Sample(name string, value int64, labels ...string) {
+	w.bw.WriteString(name)
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i == 0 {
+			w.bw.WriteByte('{')
+		} else {
+			w.bw.WriteByte(',')
+		}
+		w.bw.WriteString(labels[i] + `="` + labelEscaper.Replace(labels[i+1]) + `"`)
+	}
+	if len(labels) > 1 {
+		w.bw.WriteByte('}')
+	}
+	w.bw.WriteByte(' ')
+	w.bw.WriteString(strconv.FormatInt(value, 10))
+	w.bw.WriteByte('\n')
+}
+
+// Collector writes the metrics of a feature by w.
+type Collector func(w *Writer)
+
+var (
+	collectors    = map[string]Collector{}
+	collectorLock sync.RWMutex
+)
+
+// Register register the collector by name, the old one of the same name is replaced.
+func Register(name string, c Collector) {
+	collectorLock.Lock()
+	collectors[name] = c
+	collectorLock.Unlock()
+}
+
+// Write writes the metrics of all collectors in the order of name.
+func Write(w io.Writer) error {
+	collectorLock.RLock()
+	names := make([]string, 0, len(collectors))
+	for name := range collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	cs := make([]Collector, len(names))
+	for i, name := range names {
+		cs[i] = collectors[name]
+	}
+	collectorLock.RUnlock()
+	pw := &Writer{bw: bufio.NewWriter(w)}
+	for _, c := range cs {
+		c(pw)
+	}
+	return pw.bw.Flush()
+}
+
+// showMetrics will show the metrics of proxy in the prometheus text format.
+func showMetrics(w http.ResponseWriter, _req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_ = Write(w)
+}
+
+// Init register the metrics http by /metrics
+func Init() {
+	http.HandleFunc("/metrics", showMetrics)
+}
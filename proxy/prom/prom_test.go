@@ -0,0 +1,36 @@
+package prom
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWrite(t *testing.T) {
+	Register("b", func(w *Writer) {
+		w.Family("test_b_total", Counter, "The count of b.")
+		w.Sample("test_b_total", 2, "cluster", "a\"b\\c\nd", "node", "n1")
+	})
+	Register("a", func(w *Writer) {
+		w.Family("test_a", Gauge, "The value of a.")
+		w.Sample("test_a", -1)
+	})
+	defer func() {
+		collectorLock.Lock()
+		delete(collectors, "a")
+		delete(collectors, "b")
+		collectorLock.Unlock()
+	}()
+	buf := &bytes.Buffer{}
+	assert.NoError(t, Write(buf))
+	assert.Equal(t, "# HELP test_a The value of a.\n# TYPE test_a gauge\ntest_a -1\n"+
+		"# HELP test_b_total The count of b.\n# TYPE test_b_total counter\n"+
+		`test_b_total{cluster="a\"b\\c\nd",node="n1"} 2`+"\n", buf.String())
+
+	w := httptest.NewRecorder()
+	showMetrics(w, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, "text/plain; version=0.0.4", w.Header().Get("Content-Type"))
+	assert.Equal(t, buf.String(), w.Body.String())
+}
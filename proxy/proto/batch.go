@@ -0,0 +1,35 @@
+package proto
+
+import "sync/atomic"
+
+// BatchStats counts the batch requests which are partially failed, eg: MGET
+// of which the keys of one node are failed but the others are replied.
+type BatchStats struct {
+	partial int64
+	failed  int64
+}
+
+// Observe counts the batch request of which failed sub requests are failed.
+func (s *BatchStats) Observe(failed int) {
+	if s == nil || failed <= 0 {
+		return
+	}
+	atomic.AddInt64(&s.partial, 1)
+	atomic.AddInt64(&s.failed, int64(failed))
+}
+
+// Partial returns the count of partially failed batch requests.
+func (s *BatchStats) Partial() int64 {
+	if s == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&s.partial)
+}
+
+// Failed returns the count of failed sub requests of partially failed batch requests.
+func (s *BatchStats) Failed() int64 {
+	if s == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&s.failed)
+}
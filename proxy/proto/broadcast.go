@@ -0,0 +1,41 @@
+package proto
+
+import (
+	errs "errors"
+)
+
+// errors
+var (
+	ErrBroadcastNoNode = errs.New("broadcast no node")
+)
+
+// Broadcaster is the type of request which must be sent to every node.
+type Broadcaster interface {
+	IsBroadcast() bool
+	// Broadcast fills message with a copy of request for each of n nodes.
+	Broadcast(m *Message, n int)
+}
+
+// IsBroadcast check whether the message must be sent to every node.
+func IsBroadcast(m *Message) bool {
+	bc, ok := m.Request().(Broadcaster)
+	return ok && bc.IsBroadcast()
+}
+
+// Broadcast push the message into every node pipe.
+func Broadcast(m *Message, ncps []*NodeConnPipe) {
+	if len(ncps) == 0 {
+		m.WithError(ErrBroadcastNoNode)
+		return
+	}
+	m.Request().(Broadcaster).Broadcast(m, len(ncps))
+	if !m.IsBatch() {
+		m.MarkStartPipe()
+		ncps[0].Push(m)
+		return
+	}
+	for i, subm := range m.Batch() {
+		subm.MarkStartPipe()
+		ncps[i].Push(subm)
+	}
+}
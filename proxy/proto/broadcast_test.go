@@ -0,0 +1,57 @@
+package proto
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type mockBroadcastRequest struct {
+	mockRequest
+}
+
+func (*mockBroadcastRequest) IsBroadcast() bool { return true }
+
+func (*mockBroadcastRequest) Broadcast(m *Message, n int) {
+	for i := 1; i < n; i++ {
+		m.WithRequest(&mockBroadcastRequest{})
+	}
+}
+
+func TestBroadcast(t *testing.T) {
+	var (
+		wg   = &sync.WaitGroup{}
+		ncs  []*mockNodeConn
+		ncps []*NodeConnPipe
+	)
+	for i := 0; i < 3; i++ {
+		nc := &mockNodeConn{num: 10}
+		ncs = append(ncs, nc)
+		ncps = append(ncps, NewNodeConnPipe(1, 32, 0, func() NodeConn {
+			return nc
+		}))
+	}
+	msg := NewMessage()
+	msg.WithRequest(&mockBroadcastRequest{})
+	msg.WithWaitGroup(wg)
+	assert.True(t, IsBroadcast(msg))
+	Broadcast(msg, ncps)
+	wg.Wait()
+	assert.Len(t, msg.Batch(), 3)
+	for _, nc := range ncs {
+		assert.Equal(t, 1, nc.count)
+	}
+
+	msg = NewMessage()
+	msg.WithRequest(&mockBroadcastRequest{})
+	Broadcast(msg, nil)
+	assert.Equal(t, ErrBroadcastNoNode, msg.Err())
+
+	msg = NewMessage()
+	msg.WithRequest(&mockRequest{})
+	assert.False(t, IsBroadcast(msg))
+	for _, ncp := range ncps {
+		ncp.Close()
+	}
+}
@@ -0,0 +1,10 @@
+package proto
+
+// Capturer is the type of request which can be captured in the raw protocol.
+type Capturer interface {
+	// AppendRequest appends the request in the protocol sent to backend to dst.
+	AppendRequest(dst []byte) []byte
+	// AppendReply appends the reply in the protocol read from backend to dst,
+	// nothing is appended if no reply, eg: the reply is filled when encoding.
+	AppendReply(dst []byte) []byte
+}
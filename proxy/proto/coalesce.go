@@ -0,0 +1,92 @@
+package proto
+
+import (
+	"sync"
+)
+
+// ReplyCopier is the type of request which can copy the reply of the identical
+// request, eg: the coalesced reads and the mirrored requests.
+type ReplyCopier interface {
+	// CopyReply copy the reply of the identical request src, the reply is
+	// untouched if src is not the same type.
+	CopyReply(src Request)
+}
+
+// Coalescer is the type of request which can share the reply of the identical
+// request in flight to the same node, eg: redis GET.
+type Coalescer interface {
+	ReplyCopier
+	// CoalesceKey appends the identity of request to dst, nil means the request
+	// is never coalesced, eg: writes.
+	CoalesceKey(dst []byte) []byte
+}
+
+// flight is the read in flight and the identical reads waiting for its reply.
+type flight struct {
+	leader  *Message
+	waiters []*Message
+}
+
+// flights is the reads in flight of node pipe by the identity of request.
+type flights struct {
+	lock  sync.Mutex
+	calls map[string]*flight
+}
+
+func newFlights() *flights {
+	return &flights{calls: make(map[string]*flight)}
+}
+
+func coalesceKey(m *Message) []byte {
+	if m.IsBatch() || m.fresh {
+		return nil
+	}
+	if c, ok := m.Request().(Coalescer); ok {
+		return c.CoalesceKey(nil)
+	}
+	return nil
+}
+
+// join returns true if m waits for the identical read in flight, otherwise m
+// becomes the leader of read if it can be coalesced.
+func (fs *flights) join(m *Message) bool {
+	key := coalesceKey(m)
+	if key == nil {
+		return false
+	}
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	if f, ok := fs.calls[string(key)]; ok {
+		m.MarkWrite()
+		f.waiters = append(f.waiters, m)
+		return true
+	}
+	fs.calls[string(key)] = &flight{leader: m}
+	return false
+}
+
+// land shares the reply or err of leader m with the waiters, it must be called
+// before m is done because m is reused after that.
+func (fs *flights) land(m *Message, err error) {
+	key := coalesceKey(m)
+	if key == nil {
+		return
+	}
+	fs.lock.Lock()
+	f, ok := fs.calls[string(key)]
+	if !ok || f.leader != m {
+		fs.lock.Unlock()
+		return
+	}
+	delete(fs.calls, string(key))
+	fs.lock.Unlock()
+	for _, w := range f.waiters {
+		if rc, ok := w.Request().(ReplyCopier); ok && err == nil {
+			rc.CopyReply(m.Request())
+		}
+		w.WithError(err)
+		w.MarkRead()
+		w.MarkAddr(m.Addr())
+		w.Done()
+	}
+}
@@ -0,0 +1,131 @@
+package proto
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type mockCoalesceRequest struct {
+	mockRequest
+	key   string
+	write bool
+	reply string
+}
+
+func (r *mockCoalesceRequest) Key() []byte { return []byte(r.key) }
+func (r *mockCoalesceRequest) CoalesceKey(dst []byte) []byte {
+	if r.write {
+		return nil
+	}
+	return append(dst, r.key...)
+}
+func (r *mockCoalesceRequest) CopyReply(src Request) { r.reply = src.(*mockCoalesceRequest).reply }
+func (r *mockCoalesceRequest) IsReadOnly() bool      { return !r.write }
+
+// mockSlowNodeConn blocks the reads until released.
+type mockSlowNodeConn struct {
+	mockNodeConn
+	writes  int32
+	release chan struct{}
+}
+
+func (n *mockSlowNodeConn) Write(*Message) error {
+	atomic.AddInt32(&n.writes, 1)
+	return nil
+}
+func (n *mockSlowNodeConn) Read(m *Message) error {
+	<-n.release
+	if n.err != nil {
+		return n.err
+	}
+	req := m.Request().(*mockCoalesceRequest)
+	req.reply = "reply of " + req.key
+	return nil
+}
+
+func _pushCoalesce(ncp *NodeConnPipe, wg *sync.WaitGroup, reqs ...*mockCoalesceRequest) (msgs []*Message) {
+	for _, req := range reqs {
+		m := getMsg()
+		m.WithRequest(req)
+		m.WithWaitGroup(wg)
+		ncp.Push(m)
+		msgs = append(msgs, m)
+	}
+	return
+}
+
+func TestPipeCoalesce(t *testing.T) {
+	nc := &mockSlowNodeConn{release: make(chan struct{})}
+	ncp := NewNodeConnPipe(1, 32, 0, func() NodeConn { return nc })
+	ncp.WithCoalesce()
+	defer ncp.Close()
+
+	wg := &sync.WaitGroup{}
+	reqs := []*mockCoalesceRequest{{key: "a"}, {key: "a"}, {key: "b"}, {key: "a", write: true}, {key: "a"}}
+	msgs := _pushCoalesce(ncp, wg, reqs...)
+	close(nc.release)
+	wg.Wait()
+	assert.Equal(t, int32(3), atomic.LoadInt32(&nc.writes))
+	for i, m := range msgs {
+		assert.NoError(t, m.Err())
+		assert.Equal(t, "reply of "+reqs[i].key, reqs[i].reply)
+	}
+	assert.Len(t, ncp.flights.calls, 0)
+
+	// the read after the reply is sent again
+	_pushCoalesce(ncp, wg, &mockCoalesceRequest{key: "a"})
+	wg.Wait()
+	assert.Equal(t, int32(4), atomic.LoadInt32(&nc.writes))
+}
+
+func TestPipeCoalesceError(t *testing.T) {
+	nc := &mockSlowNodeConn{release: make(chan struct{})}
+	nc.err = errors.New("some error")
+	ncp := NewNodeConnPipe(1, 32, 0, func() NodeConn { return nc })
+	ncp.WithCoalesce()
+	defer ncp.Close()
+
+	wg := &sync.WaitGroup{}
+	reqs := []*mockCoalesceRequest{{key: "a"}, {key: "a"}}
+	msgs := _pushCoalesce(ncp, wg, reqs...)
+	close(nc.release)
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&nc.writes))
+	for i, m := range msgs {
+		assert.EqualError(t, m.Err(), "some error")
+		assert.Empty(t, reqs[i].reply)
+	}
+}
+
+func TestMarkFresh(t *testing.T) {
+	nc := &mockSlowNodeConn{release: make(chan struct{})}
+	ncp := NewNodeConnPipe(1, 32, 0, func() NodeConn { return nc })
+	ncp.WithCoalesce()
+	defer ncp.Close()
+
+	wg := &sync.WaitGroup{}
+	// the read of another client in flight
+	_pushCoalesce(ncp, wg, &mockCoalesceRequest{key: "a"})
+	var msgs []*Message
+	for _, req := range []*mockCoalesceRequest{{key: "b"}, {key: "a", write: true}, {key: "a"}, {key: "b"}} {
+		m := getMsg()
+		m.WithRequest(req)
+		m.WithWaitGroup(wg)
+		msgs = append(msgs, m)
+	}
+	MarkFresh(msgs)
+	assert.False(t, msgs[0].fresh)
+	assert.True(t, msgs[2].fresh)
+	assert.False(t, msgs[3].fresh)
+	for _, m := range msgs {
+		ncp.Push(m)
+	}
+	close(nc.release)
+	wg.Wait()
+	// only the read of b following the read of b is coalesced
+	assert.Equal(t, int32(4), atomic.LoadInt32(&nc.writes))
+}
@@ -0,0 +1,20 @@
+package proto
+
+// HotKey is the frequently accessed key of cluster and the node it is routed to.
+type HotKey struct {
+	Key string `json:"key"`
+	// Count is the estimated count of key, which decays by half in every window.
+	Count int64  `json:"count"`
+	Node  string `json:"node"`
+}
+
+// HotKeys is the hot keys of cluster.
+type HotKeys struct {
+	Cluster string    `json:"cluster"`
+	Keys    []*HotKey `json:"keys"`
+}
+
+// KeyRouter is the forwarder which reports the backend node addr of key.
+type KeyRouter interface {
+	KeyNode(key []byte) string
+}
@@ -0,0 +1,71 @@
+package proto
+
+import "time"
+
+// node status
+const (
+	NodeStatusOK      = "ok"
+	NodeStatusFail    = "fail"
+	NodeStatusUnknown = "unknown"
+)
+
+// InfoField is a key value pair of proxy state.
+type InfoField struct {
+	Key   string
+	Value string
+}
+
+// InfoSection is a named group of proxy state, eg: the "# Server" of redis INFO.
+type InfoSection struct {
+	Name   string
+	Fields []InfoField
+}
+
+// Get returns the value of key in section.
+func (s *InfoSection) Get(key string) (string, bool) {
+	for _, f := range s.Fields {
+		if f.Key == key {
+			return f.Value, true
+		}
+	}
+	return "", false
+}
+
+// Infoer is the type which reports proxy state to client, eg: redis INFO.
+type Infoer interface {
+	Info() []*InfoSection
+}
+
+// NodeState is the state of backend node.
+type NodeState struct {
+	Addr   string
+	Alias  string
+	Role   string
+	Status string
+	// Pipe is the stats of the pipe to node, empty if no pipe.
+	Pipe PipeStats
+}
+
+// NodeStater is the forwarder which reports the state of backend nodes.
+type NodeStater interface {
+	NodeStates() []*NodeState
+}
+
+// Admin is the proxy admin operations of cluster which can be called by client, eg: redis PROXY.
+type Admin interface {
+	NodeStater
+	// Config returns the config of cluster.
+	Config() []InfoField
+	// Reload reloads the cluster config file.
+	Reload() error
+	Slowlog() SlowlogStore
+	// HotKeys returns the top n hot keys of cluster, n < 0 means all tracked.
+	HotKeys(n int) []*HotKey
+	// FinishMigration finishes the migration from the old servers of cluster.
+	FinishMigration() error
+	// StartCapture starts capturing the messages of cluster into file for d and
+	// at most count messages, zero means no limit.
+	StartCapture(d time.Duration, count int, replies bool) error
+	// StopCapture stops capturing the messages of cluster.
+	StopCapture() error
+}
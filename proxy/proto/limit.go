@@ -0,0 +1,92 @@
+package proto
+
+import (
+	"sync/atomic"
+
+	"github.com/ducesoft/overlord/pkg/log"
+)
+
+// logKeyLen is the max length of key in log, the big keys are truncated.
+const logKeyLen = 128
+
+// Limits is the guardrails of the size of requests and replies of cluster,
+// the zero value of each limit means unlimited. The methods are safe for nil.
+type Limits struct {
+	Cluster string
+	// MaxValue is the max bytes of each argument or value of request.
+	MaxValue int
+	// MaxRequest is the max bytes of the whole request.
+	MaxRequest int
+	// MaxReply is the max bytes of the reply read from node, the reply exceeds
+	// it is dropped and only fails the request itself, the conn is kept.
+	MaxReply int
+	// MaxKeys is the max keys of the multi-key request, eg: MGET.
+	MaxKeys int
+	// BigValue is the threshold of values which are only logged and counted.
+	BigValue int
+
+	rejected  int64
+	bigValues int64
+}
+
+// ValueTooLarge reports whether the argument or value of n bytes exceeds the limit.
+func (l *Limits) ValueTooLarge(n int) bool {
+	return l != nil && l.MaxValue > 0 && n > l.MaxValue
+}
+
+// RequestTooLarge reports whether the request of n bytes exceeds the limit.
+func (l *Limits) RequestTooLarge(n int) bool {
+	return l != nil && l.MaxRequest > 0 && n > l.MaxRequest
+}
+
+// TooManyKeys reports whether the request of n keys exceeds the limit.
+func (l *Limits) TooManyKeys(n int) bool {
+	return l != nil && l.MaxKeys > 0 && n > l.MaxKeys
+}
+
+// Reject logs and counts the request which is rejected by err, addr is the
+// client of request or the node of reply.
+func (l *Limits) Reject(addr string, key []byte, err error) {
+	if l == nil {
+		return
+	}
+	atomic.AddInt64(&l.rejected, 1)
+	if log.V(2) {
+		log.Warnf("cluster:%s addr:%s key:%q rejected error:%v", l.Cluster, addr, logKey(key), err)
+	}
+}
+
+// CheckBigValue logs and counts the value of n bytes when it exceeds the big
+// value threshold, addr is the client of request or the node of reply.
+func (l *Limits) CheckBigValue(addr string, key []byte, n int) {
+	if l == nil || l.BigValue <= 0 || n <= l.BigValue {
+		return
+	}
+	atomic.AddInt64(&l.bigValues, 1)
+	if log.V(2) {
+		log.Warnf("cluster:%s addr:%s key:%q big value:%d bytes exceeds:%d", l.Cluster, addr, logKey(key), n, l.BigValue)
+	}
+}
+
+// Rejected returns the count of rejected requests.
+func (l *Limits) Rejected() int64 {
+	if l == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&l.rejected)
+}
+
+// BigValues returns the count of big values.
+func (l *Limits) BigValues() int64 {
+	if l == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&l.bigValues)
+}
+
+func logKey(key []byte) []byte {
+	if len(key) > logKeyLen {
+		return key[:logKeyLen]
+	}
+	return key
+}
@@ -0,0 +1,56 @@
+package binary
+
+import (
+	"encoding/binary"
+	errs "errors"
+
+	"github.com/ducesoft/overlord/proxy/proto"
+)
+
+// errors of size limits, the reply exceeds the limit is dropped by node conn
+// and only fails the request itself.
+var (
+	ErrValueTooLarge = errs.New("object too large for cache")
+	ErrReplyTooLarge = proto.NewReplyError("reply is too large")
+)
+
+// tooLarge reject the request with body of bl bytes exceeds the limits, the
+// reply is status value too large without body, and the body is swallowed
+// without buffered. The quiet requests in the same message are rejected too.
+func (p *proxyConn) tooLarge(m *proto.Message, req *MCRequest, bl int) bool {
+	el := int(uint8(req.extraLen[0]))
+	kl := int(binary.BigEndian.Uint16(req.keyLen))
+	if !p.limits.ValueTooLarge(bl-el-kl) && !p.limits.RequestTooLarge(requestHeaderLen+bl) {
+		return false
+	}
+	var key []byte
+	if buf := p.br.Buffer().Bytes(); len(buf) >= el+kl {
+		key = buf[el : el+kl]
+	}
+	p.limits.Reject(p.addr, key, ErrValueTooLarge)
+	resetBody(req)
+	m.WithError(ErrValueTooLarge)
+	p.swallow = bl
+	return true
+}
+
+// swallowBody drop the buffered body of rejected request, returns false when
+// the body is not read completely.
+func (p *proxyConn) swallowBody() bool {
+	n := len(p.br.Buffer().Bytes())
+	if n > p.swallow {
+		n = p.swallow
+	}
+	p.br.Advance(n)
+	p.swallow -= n
+	return p.swallow == 0
+}
+
+// resetBody clear the key, extras and value of request or reply.
+func resetBody(req *MCRequest) {
+	req.key = req.key[:0]
+	req.data = req.data[:0]
+	copy(req.keyLen, zeroTwoBytes)
+	copy(req.extraLen, zeroBytes)
+	copy(req.bodyLen, zeroFourBytes)
+}
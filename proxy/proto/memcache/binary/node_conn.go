@@ -28,6 +28,9 @@ type nodeConn struct {
 	bw   *bufio.Writer
 	br   *bufio.Reader
 
+	// limits rejects the replies exceed the size limit of cluster.
+	limits *proto.Limits
+
 	state int32
 }
 
@@ -44,6 +47,12 @@ func NewNodeConn(cluster, addr string, dialTimeout, readTimeout, writeTimeout ti
 	return
 }
 
+// WithLimits limit the size of replies, the conn is closed when the reply
+// exceeds because the rest of reply is never read.
+func (n *nodeConn) WithLimits(l *proto.Limits) {
+	n.limits = l
+}
+
 func (n *nodeConn) Addr() string {
 	return n.addr
 }
@@ -130,6 +139,20 @@ REREAD:
 	if bl == 0 {
 		return
 	}
+	if n.limits != nil {
+		if n.limits.MaxReply > 0 && int(bl) > n.limits.MaxReply {
+			// NOTE: drop the body to keep the conn usable for the rest replies.
+			if err = n.br.Discard(int(bl)); err != nil {
+				err = errors.WithStack(err)
+				return
+			}
+			err = errors.Wrapf(ErrReplyTooLarge, "node:%s limit:%d", n.addr, n.limits.MaxReply)
+			n.limits.Reject(n.addr, mcr.key, err)
+			resetBody(mcr)
+			return
+		}
+		n.limits.CheckBigValue(n.addr, mcr.key, int(bl))
+	}
 REREADData:
 	var data []byte
 	if data, err = n.br.ReadExact(int(bl)); err == bufio.ErrBufferFull {
@@ -294,3 +294,16 @@ func TestNewNodeConnWithClosedBinder(t *testing.T) {
 	nc := NewNodeConn("anyName", addr.String(), time.Second, time.Second, time.Second)
 	assert.NotNil(t, nc)
 }
+
+func TestNodeConnMaxReply(t *testing.T) {
+	l := &proto.Limits{MaxReply: 8}
+	nc := _createNodeConn(getRespTestData)
+	nc.WithLimits(l)
+	msg := _createReqMsg(getTestData)
+	err := nc.Read(msg)
+	_causeEqual(t, ErrReplyTooLarge, err)
+	assert.True(t, proto.IsReplyError(err))
+	mcr := msg.Request().(*MCRequest)
+	assert.Equal(t, zeroFourBytes, mcr.bodyLen)
+	assert.Equal(t, int64(1), l.Rejected())
+}
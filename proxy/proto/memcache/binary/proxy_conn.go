@@ -22,6 +22,17 @@ type proxyConn struct {
 	br        *bufio.Reader
 	bw        *bufio.Writer
 	completed bool
+
+	// prefix is prepended to all the keys, eg: tenant:.
+	prefix []byte
+	// limits rejects the requests exceed the size limits of cluster, and
+	// swallow is the bytes of rejected body which are not read yet.
+	limits  *proto.Limits
+	swallow int
+	addr    string
+	// batch counts the multi-key gets of which the keys of failed nodes
+	// are omitted.
+	batch *proto.BatchStats
 }
 
 // NewProxyConn new a memcache decoder and encode.
@@ -32,9 +43,28 @@ func NewProxyConn(rw *libnet.Conn) proto.ProxyConn {
 		bw:        bufio.NewWriter(rw),
 		completed: true,
 	}
+	if rw != nil && rw.Conn != nil {
+		p.addr = rw.RemoteAddr().String()
+	}
 	return p
 }
 
+// WithKeyPrefix namespace all the keys by prefix, the prefix is removed from
+// the keys in reply.
+func (p *proxyConn) WithKeyPrefix(prefix []byte) {
+	p.prefix = prefix
+}
+
+// WithLimits set the size limits of requests.
+func (p *proxyConn) WithLimits(l *proto.Limits) {
+	p.limits = l
+}
+
+// WithBatchStats set the stats of partially failed batch requests.
+func (p *proxyConn) WithBatchStats(s *proto.BatchStats) {
+	p.batch = s
+}
+
 func (p *proxyConn) Decode(msgs []*proto.Message) ([]*proto.Message, error) {
 	var err error
 	// if completed, means that we have parsed all the buffered
@@ -66,6 +96,17 @@ func (p *proxyConn) Decode(msgs []*proto.Message) ([]*proto.Message, error) {
 
 func (p *proxyConn) decode(m *proto.Message) (err error) {
 NEXTGET:
+	if p.swallow > 0 && !p.swallowBody() {
+		if len(m.Requests()) == 0 {
+			err = bufio.ErrBufferFull
+			return
+		}
+		// NOTE: the rejected quiet request, read the rest body of it
+		if err = p.br.Read(); err != nil {
+			return
+		}
+		goto NEXTGET
+	}
 	// bufio reset buffer
 	head, err := p.br.ReadExact(requestHeaderLen)
 	if err == bufio.ErrBufferFull {
@@ -110,6 +151,9 @@ NEXTGET:
 
 func (p *proxyConn) decodeCommon(m *proto.Message, req *MCRequest) (err error) {
 	bl := binary.BigEndian.Uint32(req.bodyLen)
+	if p.limits != nil && p.tooLarge(m, req, int(bl)) {
+		return
+	}
 	body, err := p.br.ReadExact(int(bl))
 	if err == bufio.ErrBufferFull {
 		return
@@ -121,9 +165,23 @@ func (p *proxyConn) decodeCommon(m *proto.Message, req *MCRequest) (err error) {
 	kl := binary.BigEndian.Uint16(req.keyLen)
 	// copy
 	req.key = req.key[:0]
-	req.key = append(req.key, body[int(el):int(el)+int(kl)]...)
 	req.data = req.data[:0]
+	if len(p.prefix) > 0 && kl > 0 && len(body) >= int(el)+int(kl) {
+		// NOTE: body contains "<extras><key><value>", the prefix is inserted before key
+		req.key = append(req.key, p.prefix...)
+		req.key = append(req.key, body[int(el):int(el)+int(kl)]...)
+		req.data = append(req.data, body[:int(el)]...)
+		req.data = append(req.data, req.key...)
+		req.data = append(req.data, body[int(el)+int(kl):]...)
+		binary.BigEndian.PutUint16(req.keyLen, kl+uint16(len(p.prefix)))
+		binary.BigEndian.PutUint32(req.bodyLen, bl+uint32(len(p.prefix)))
+		return
+	}
+	req.key = append(req.key, body[int(el):int(el)+int(kl)]...)
 	req.data = append(req.data, body...)
+	if p.limits != nil {
+		p.limits.CheckBigValue(p.addr, req.key, int(bl)-int(el)-int(kl))
+	}
 	return
 }
 
@@ -151,21 +209,49 @@ func parseHeader(bs []byte, req *MCRequest, isDecode bool) {
 	copy(req.cas, bs[16:24])
 }
 
-// Encode encode response and write into writer.
+// isQuietGet check whether the request is the quiet get, whose miss is not
+// replied, eg: GETKQ.
+func isQuietGet(mcr *MCRequest) bool {
+	return mcr.respType == RequestTypeGetQ || mcr.respType == RequestTypeGetKQ || mcr.respType == RequestTypeGatQ
+}
+
+// Encode encode response and write into writer. The failed quiet gets of batch
+// are omitted as missed, unless all the requests are failed.
 func (p *proxyConn) Encode(m *proto.Message) (err error) {
 	reqs := m.Requests()
-	for _, req := range reqs {
+	var failed int
+	if !m.Rejected() && m.IsBatch() {
+		for i := range reqs {
+			if m.SubErr(i) != nil {
+				failed++
+			}
+		}
+	}
+	partial := failed > 0 && failed < len(reqs)
+	if partial {
+		p.batch.Observe(failed)
+	}
+	for i, req := range reqs {
 		mcr, ok := req.(*MCRequest)
 		if !ok {
 			err = errors.WithStack(ErrAssertReq)
 			return
 		}
+		me := m.Err()
+		if partial {
+			if me = m.SubErr(i); me != nil && isQuietGet(mcr) {
+				continue
+			}
+		}
+		p.trimPrefix(mcr)
 		_ = p.bw.Write(magicRespBytes) // NOTE: magic
 		_ = p.bw.Write(mcr.respType.Bytes())
 		_ = p.bw.Write(mcr.keyLen)
 		_ = p.bw.Write(mcr.extraLen)
 		_ = p.bw.Write(zeroBytes)
-		if me := m.Err(); me != nil {
+		if me != nil && errors.Cause(me) == ErrValueTooLarge {
+			_ = p.bw.Write(resopnseStatusValueTooLargeBytes)
+		} else if me != nil {
 			_ = p.bw.Write(resopnseStatusInternalErrBytes)
 		} else {
 			_ = p.bw.Write(mcr.status)
@@ -181,6 +267,21 @@ func (p *proxyConn) Encode(m *proto.Message) (err error) {
 	return
 }
 
+// trimPrefix remove the key prefix from the key of reply, eg: GETK.
+func (p *proxyConn) trimPrefix(mcr *MCRequest) {
+	kl := int(binary.BigEndian.Uint16(mcr.keyLen))
+	if len(p.prefix) == 0 || kl < len(p.prefix) {
+		return
+	}
+	el := int(uint8(mcr.extraLen[0]))
+	if len(mcr.data) < el+kl || !bytes.HasPrefix(mcr.data[el:], p.prefix) {
+		return
+	}
+	mcr.data = append(mcr.data[:el], mcr.data[el+len(p.prefix):]...)
+	binary.BigEndian.PutUint16(mcr.keyLen, uint16(kl-len(p.prefix)))
+	binary.BigEndian.PutUint32(mcr.bodyLen, binary.BigEndian.Uint32(mcr.bodyLen)-uint32(len(p.prefix)))
+}
+
 func (p *proxyConn) Flush() (err error) {
 	return p.bw.Flush()
 }
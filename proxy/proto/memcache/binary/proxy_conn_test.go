@@ -376,3 +376,88 @@ func TestProxyConnEncodeErr(t *testing.T) {
 	c.Wbuf.Read(buf)
 	assert.Equal(t, resopnseStatusInternalErrBytes, buf[6:8])
 }
+
+func TestProxyConnEncodePartial(t *testing.T) {
+	s := &proto.BatchStats{}
+	encode := func(msg *proto.Message) []byte {
+		conn := libcon.NewConn(mockconn.CreateConn(nil, 1), time.Second, time.Second)
+		p := NewProxyConn(conn)
+		p.(*proxyConn).WithBatchStats(s)
+		assert.NoError(t, p.Encode(msg))
+		assert.NoError(t, p.Flush())
+		return conn.Conn.(*mockconn.MockConn).Wbuf.Bytes()
+	}
+	msg := _createRespMsg(t, getQTestData, getQRespTestData)
+	subs := msg.Batch()
+	subs[1].WithError(errors.New("timeout"))
+	var except []byte
+	except = append(except, getQRespTestData[0]...)
+	except = append(except, getQRespTestData[2]...)
+	assert.Equal(t, except, encode(msg), "failed quiet get omitted")
+	assert.Equal(t, int64(1), s.Partial())
+	assert.Equal(t, int64(1), s.Failed())
+
+	subs[1].WithError(nil)
+	subs[2].WithError(errors.New("timeout"))
+	out := encode(msg)
+	n := len(getQRespTestData[0]) + len(getQRespTestData[1])
+	assert.Equal(t, append(except[:len(getQRespTestData[0]):len(getQRespTestData[0])], getQRespTestData[1]...), out[:n])
+	assert.Equal(t, resopnseStatusInternalErrBytes, out[n+6:n+8], "failed get replied the error")
+	assert.Equal(t, int64(2), s.Partial())
+}
+
+func TestProxyConnKeyPrefix(t *testing.T) {
+	conn := libcon.NewConn(mockconn.CreateConn(getTestData, 1), time.Second, time.Second)
+	p := NewProxyConn(conn)
+	p.(*proxyConn).WithKeyPrefix([]byte("t:"))
+	msgs, err := p.Decode(proto.GetMsgs(1))
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 1)
+	mcr := msgs[0].Request().(*MCRequest)
+	assert.Equal(t, "t:ABC", string(mcr.Key()))
+	assert.Equal(t, []byte{0x00, 0x05}, mcr.keyLen)
+	assert.Equal(t, []byte{0x00, 0x00, 0x00, 0x05}, mcr.bodyLen)
+	assert.Equal(t, "t:ABC", string(mcr.data))
+
+	resp := append([]byte{}, getRespTestData[:requestHeaderLen+4]...)
+	resp[3] = 0x05
+	resp[11] = 0x0e
+	resp = append(resp, "t:ABCABCDE"...)
+	assert.NoError(t, _createNodeConn(resp).Read(msgs[0]))
+	assert.NoError(t, p.Encode(msgs[0]))
+	assert.NoError(t, p.Flush())
+	c := conn.Conn.(*mockconn.MockConn)
+	assert.Equal(t, getRespTestData, c.Wbuf.Bytes())
+}
+
+func TestProxyConnLimits(t *testing.T) {
+	l := &proto.Limits{MaxValue: 2}
+	data := append([]byte{}, setTestData...)
+	data[11] = byte(len(setTestData) - requestHeaderLen) // NOTE: the exact body len
+	data = append(data, getTestData...)
+	conn := libcon.NewConn(mockconn.CreateConn(data, 1), time.Second, time.Second)
+	p := NewProxyConn(conn)
+	p.(*proxyConn).WithLimits(l)
+	msgs, err := p.Decode(proto.GetMsgs(2))
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 2)
+	assert.True(t, msgs[0].Rejected())
+	assert.False(t, msgs[1].Rejected())
+	assert.Equal(t, "ABC", string(msgs[1].Request().Key()))
+	assert.Equal(t, int64(1), l.Rejected())
+
+	assert.NoError(t, p.Encode(msgs[0]))
+	assert.NoError(t, p.Flush())
+	c := conn.Conn.(*mockconn.MockConn)
+	assert.Equal(t, []byte{
+		0x81,       // magic
+		0x01,       // cmd
+		0x00, 0x00, // key len
+		0x00,       // extra len
+		0x00,       // data type
+		0x00, 0x03, // status: value too large
+		0x00, 0x00, 0x00, 0x00, // body len
+		0x00, 0x00, 0x00, 0x00, // opaque
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // cas
+	}, c.Wbuf.Bytes())
+}
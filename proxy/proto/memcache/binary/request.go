@@ -278,7 +278,8 @@ const (
 )
 
 var (
-	resopnseStatusInternalErrBytes = []byte{0x00, 0x84}
+	resopnseStatusInternalErrBytes   = []byte{0x00, 0x84}
+	resopnseStatusValueTooLargeBytes = []byte{0x00, 0x03}
 )
 
 // errors
@@ -369,8 +370,9 @@ func (r *MCRequest) Key() []byte {
 	return r.key
 }
 
-func (r *MCRequest) Merge([]proto.Request) (err error) {
-	return
+// Merge impl the proto.Request, the requests of memcache are never merged.
+func (r *MCRequest) Merge([]proto.Request) error {
+	return proto.ErrMergeNotSupported
 }
 
 func (r *MCRequest) String() string {
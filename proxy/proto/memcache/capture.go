@@ -0,0 +1,27 @@
+package memcache
+
+// AppendRequest impl the proto.Capturer by the protocol sent to node, quit and
+// version are replied by proxy and never captured.
+func (r *MCRequest) AppendRequest(dst []byte) []byte {
+	if r.respType == RequestTypeQuit || r.respType == RequestTypeVersion {
+		return dst
+	}
+	dst = append(dst, r.respType.Bytes()...)
+	dst = append(dst, spaceBytes...)
+	if r.respType == RequestTypeGat || r.respType == RequestTypeGats {
+		dst = append(dst, r.data...) // NOTE: exp time
+		dst = append(dst, spaceBytes...)
+		dst = append(dst, r.key...)
+		return append(dst, crlfBytes...)
+	}
+	dst = append(dst, r.key...)
+	return append(dst, r.data...)
+}
+
+// AppendReply impl the proto.Capturer, the data of set with noreply is never replied.
+func (r *MCRequest) AppendReply(dst []byte) []byte {
+	if r.respType == RequestTypeQuit || r.respType == RequestTypeVersion || r.respType == RequestTypeSetNoreply {
+		return dst
+	}
+	return append(dst, r.data...)
+}
@@ -0,0 +1,22 @@
+package memcache
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProxyConnCapture(t *testing.T) {
+	_, _, msgs := _decodeRead(t, "get a\r\nset a 0 0 1\r\nx\r\ngat 10 a\r\nset b 0 0 1 noreply\r\ny\r\nversion\r\n", 5, "")
+	get := msgs[0].Request().(*MCRequest)
+	assert.Equal(t, "get a\r\n", string(get.AppendRequest(nil)))
+	assert.Equal(t, "set a 0 0 1\r\nx\r\n", string(msgs[1].Request().(*MCRequest).AppendRequest(nil)))
+	assert.Equal(t, "gat 10 a\r\n", string(msgs[2].Request().(*MCRequest).AppendRequest(nil)))
+	noreply := msgs[3].Request().(*MCRequest)
+	assert.Equal(t, "set b 0 0 1 noreply\r\ny\r\n", string(noreply.AppendRequest(nil)))
+	assert.Len(t, noreply.AppendReply(nil), 0)
+	assert.Len(t, msgs[4].Request().(*MCRequest).AppendRequest(nil), 0)
+
+	assert.NoError(t, _createNodeConn([]byte("VALUE a 0 1\r\nx\r\nEND\r\n")).Read(msgs[0]))
+	assert.Equal(t, "VALUE a 0 1\r\nx\r\nEND\r\n", string(get.AppendReply(nil)))
+}
@@ -0,0 +1,21 @@
+package memcache
+
+import (
+	"github.com/ducesoft/overlord/proxy/proto"
+)
+
+// CoalesceKey impl the proto.Coalescer, only get and gets are coalesced.
+func (r *MCRequest) CoalesceKey(dst []byte) []byte {
+	if r.respType != RequestTypeGet && r.respType != RequestTypeGets {
+		return nil
+	}
+	dst = append(dst, byte(r.respType))
+	return append(dst, r.key...)
+}
+
+// CopyReply impl the proto.ReplyCopier.
+func (r *MCRequest) CopyReply(src proto.Request) {
+	if s, ok := src.(*MCRequest); ok {
+		r.data = append(r.data[:0], s.data...)
+	}
+}
@@ -0,0 +1,23 @@
+package memcache
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProxyConnCoalesce(t *testing.T) {
+	p, c, msgs := _decodeRead(t, "get a\r\nget a\r\ngets a\r\ndelete a\r\n", 4, "VALUE a 0 1\r\nx\r\nEND\r\n")
+	leader := msgs[0].Request().(*MCRequest)
+	key := leader.CoalesceKey(nil)
+	assert.Equal(t, key, msgs[1].Request().(*MCRequest).CoalesceKey(nil))
+	assert.NotEqual(t, key, msgs[2].Request().(*MCRequest).CoalesceKey(nil))
+	assert.Nil(t, msgs[3].Request().(*MCRequest).CoalesceKey(nil))
+
+	// the reply is copied and encoded by the waiter
+	msgs[1].Request().(*MCRequest).CopyReply(leader)
+	leader.data = leader.data[:0]
+	assert.NoError(t, p.Encode(msgs[1]))
+	assert.NoError(t, p.Flush())
+	assert.Equal(t, "VALUE a 0 1\r\nx\r\nEND\r\n", c.Wbuf.String())
+}
@@ -0,0 +1,54 @@
+package memcache
+
+import (
+	errs "errors"
+
+	"github.com/ducesoft/overlord/proxy/proto"
+)
+
+// errors of size limits, which are replied as SERVER_ERROR <error>. The reply
+// exceeds the limit is dropped by node conn and only fails the request itself.
+var (
+	ErrValueTooLarge = errs.New("object too large for cache")
+	ErrTooManyKeys   = errs.New("too many keys")
+	ErrReplyTooLarge = proto.NewReplyError("reply is too large")
+)
+
+// rejectLarge reject the storage request like memcached, the value of length
+// is swallowed without buffered.
+func (p *proxyConn) rejectLarge(m *proto.Message, mtype RequestType, key []byte, length int) {
+	p.withReq(m, mtype, key, crlfBytes)
+	m.WithError(ErrValueTooLarge)
+	p.limits.Reject(p.addr, key, ErrValueTooLarge)
+	p.swallow = length + 2
+}
+
+// swallowValue drop the buffered value of rejected request, returns false
+// when the value is not read completely.
+func (p *proxyConn) swallowValue() bool {
+	n := len(p.br.Buffer().Bytes())
+	if n > p.swallow {
+		n = p.swallow
+	}
+	p.br.Advance(n)
+	p.swallow -= n
+	return p.swallow == 0
+}
+
+// checkKeys reject the retrieval request of n bytes with too many keys.
+func (p *proxyConn) checkKeys(m *proto.Message, n int) {
+	reqs := m.Requests()
+	if len(reqs) == 0 {
+		return
+	}
+	var err error
+	if p.limits.TooManyKeys(len(reqs)) {
+		err = ErrTooManyKeys
+	} else if p.limits.RequestTooLarge(n) {
+		err = ErrValueTooLarge
+	} else {
+		return
+	}
+	m.WithError(err)
+	p.limits.Reject(p.addr, reqs[0].Key(), err)
+}
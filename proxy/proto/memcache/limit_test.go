@@ -0,0 +1,53 @@
+package memcache
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/ducesoft/overlord/pkg/mockconn"
+	libcon "github.com/ducesoft/overlord/pkg/net"
+	"github.com/ducesoft/overlord/proxy/proto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProxyConnLimits(t *testing.T) {
+	l := &proto.Limits{MaxValue: 5, MaxKeys: 2, BigValue: 3}
+	p, c, msgs := _decodeRead(t, "set a 0 0 10\r\n0123456789\r\nget a b c\r\nget a\r\nset b 0 0 4 noreply\r\nabcd\r\n", 4, "", func(p *proxyConn) { p.WithLimits(l) })
+	assert.True(t, msgs[0].Rejected())
+	assert.Equal(t, "a", string(msgs[0].Request().Key()))
+	assert.True(t, msgs[1].Rejected())
+	assert.False(t, msgs[2].Rejected())
+	assert.False(t, msgs[3].Rejected())
+	assert.Equal(t, "b", string(msgs[3].Request().Key()))
+	assert.Equal(t, int64(2), l.Rejected())
+	assert.Equal(t, int64(1), l.BigValues())
+
+	for _, msg := range msgs[:2] {
+		assert.NoError(t, p.Encode(msg))
+		msg.ResetSubs()
+	}
+	assert.NoError(t, p.Flush())
+	assert.Equal(t, "SERVER_ERROR object too large for cache\r\nSERVER_ERROR too many keys\r\n", c.Wbuf.String())
+}
+
+func TestProxyConnLimitsSwallow(t *testing.T) {
+	data := append([]byte("set a 0 0 4096\r\n"), bytes.Repeat([]byte("x"), 4096)...)
+	data = append(data, "\r\nget a\r\n"...)
+	conn := libcon.NewConn(mockconn.CreateConn(data, 1), time.Second, time.Second)
+	p := NewProxyConn(conn)
+	p.(*proxyConn).WithLimits(&proto.Limits{MaxValue: 1024})
+	var decoded []*proto.Message
+	for i := 0; i < 16 && len(decoded) < 2; i++ {
+		msgs, err := p.Decode(proto.GetMsgs(2))
+		assert.NoError(t, err)
+		decoded = append(decoded, msgs...)
+	}
+	assert.Len(t, decoded, 2)
+	assert.True(t, decoded[0].Rejected())
+	assert.False(t, decoded[1].Rejected())
+	assert.Equal(t, RequestTypeGet, decoded[1].Request().(*MCRequest).respType)
+	// NOTE: the value is never buffered
+	assert.True(t, len(p.(*proxyConn).br.Buffer().Bytes()) < 1024)
+}
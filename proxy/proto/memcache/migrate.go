@@ -0,0 +1,66 @@
+package memcache
+
+import (
+	"bytes"
+	"strconv"
+
+	"github.com/ducesoft/overlord/proxy/proto"
+)
+
+// IsReadOnly impl the proto.ReadOnlyer, get and gets only read data.
+func (r *MCRequest) IsReadOnly() bool {
+	switch r.respType {
+	case RequestTypeGet, RequestTypeGets, RequestTypeVersion, RequestTypeQuit:
+		return true
+	}
+	return false
+}
+
+// Fallback impl the proto.Migrater, the get and gets replied END fall back
+// by the copy whose data is reset to the request data.
+func (r *MCRequest) Fallback() proto.Request {
+	if (r.respType != RequestTypeGet && r.respType != RequestTypeGets) || !bytes.Equal(r.data, endBytes) {
+		return nil
+	}
+	nr := GetReq()
+	nr.respType = r.respType
+	nr.key = append(nr.key[:0], r.key...)
+	nr.data = append(nr.data[:0], crlfBytes...)
+	return nr
+}
+
+// Hit impl the proto.Migrater, the get and gets replied VALUE hit.
+func (r *MCRequest) Hit() bool {
+	return (r.respType == RequestTypeGet || r.respType == RequestTypeGets) && bytes.HasPrefix(r.data, valueBytes)
+}
+
+// Repair impl the proto.Migrater by add key flags ttl bytes.
+func (r *MCRequest) Repair(ttl int) proto.Request {
+	if !r.Hit() {
+		return nil
+	}
+	// NOTE: the reply is "VALUE <key> <flags> <bytes> [<cas unique>]\r\n<data>\r\nEND\r\n"
+	i := bytes.Index(r.data, crlfBytes)
+	if i < 0 {
+		return nil
+	}
+	fields := bytes.Fields(r.data[len(valueBytes):i])
+	if len(fields) < 3 {
+		return nil
+	}
+	n, err := strconv.Atoi(string(fields[2]))
+	if err != nil || n < 0 || i+2+n+2 > len(r.data) {
+		return nil
+	}
+	nr := GetReq()
+	nr.respType = RequestTypeAdd
+	nr.key = append(nr.key[:0], r.key...)
+	nr.data = append(nr.data[:0], ' ')
+	nr.data = append(nr.data, fields[1]...)
+	nr.data = append(nr.data, ' ')
+	nr.data = strconv.AppendInt(nr.data, int64(ttl), 10)
+	nr.data = append(nr.data, ' ')
+	nr.data = append(nr.data, fields[2]...)
+	nr.data = append(nr.data, r.data[i:i+2+n+2]...)
+	return nr
+}
@@ -0,0 +1,38 @@
+package memcache
+
+import (
+	"testing"
+
+	"github.com/ducesoft/overlord/proxy/proto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProxyConnMigrate(t *testing.T) {
+	_, _, msgs := _decodeRead(t, "get a\r\ngets a\r\ndelete a\r\n", 3, "END\r\n")
+	get := msgs[0].Request().(*MCRequest)
+	assert.True(t, get.IsReadOnly())
+	assert.False(t, msgs[2].Request().(*MCRequest).IsReadOnly())
+
+	assert.Nil(t, get.Repair(0))
+	assert.False(t, get.Hit())
+	fb := get.Fallback().(*MCRequest)
+	assert.Equal(t, "\r\n", string(fb.data))
+	assert.Equal(t, "END\r\n", string(get.data))
+	assert.Nil(t, fb.Fallback())
+
+	fm := proto.NewMessage()
+	fm.WithRequest(fb)
+	assert.NoError(t, _createNodeConn([]byte("VALUE a 3 5\r\nhello\r\nEND\r\n")).Read(fm))
+	assert.True(t, fb.Hit())
+	get.CopyReply(fb)
+	repair := get.Repair(60).(*MCRequest)
+	assert.Equal(t, RequestTypeAdd, repair.respType)
+	assert.Equal(t, "a", string(repair.key))
+	assert.Equal(t, " 3 60 5\r\nhello\r\n", string(repair.data))
+
+	assert.NoError(t, _createNodeConn([]byte("VALUE a 0 1 42\r\nx\r\nEND\r\n")).Read(msgs[1]))
+	repair = msgs[1].Request().(*MCRequest).Repair(0).(*MCRequest)
+	assert.Equal(t, " 0 0 1\r\nx\r\n", string(repair.data))
+	assert.Nil(t, msgs[2].Request().(*MCRequest).Repair(0))
+}
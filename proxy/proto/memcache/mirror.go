@@ -0,0 +1,25 @@
+package memcache
+
+import (
+	"bytes"
+
+	"github.com/ducesoft/overlord/proxy/proto"
+)
+
+// Clone impl the proto.Mirrorer, quit and version are replied by proxy and never mirrored.
+func (r *MCRequest) Clone() proto.Request {
+	if r.respType == RequestTypeQuit || r.respType == RequestTypeVersion {
+		return nil
+	}
+	nr := GetReq()
+	nr.respType = r.respType
+	nr.key = append(nr.key[:0], r.key...)
+	nr.data = append(nr.data[:0], r.data...)
+	return nr
+}
+
+// ReplyEqual impl the proto.Mirrorer.
+func (r *MCRequest) ReplyEqual(o proto.Request) bool {
+	or, ok := o.(*MCRequest)
+	return ok && bytes.Equal(r.data, or.data)
+}
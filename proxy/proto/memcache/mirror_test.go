@@ -0,0 +1,25 @@
+package memcache
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProxyConnMirror(t *testing.T) {
+	_, _, msgs := _decodeRead(t, "get a\r\nset a 0 0 1\r\nx\r\nversion\r\n", 3, "")
+	req := msgs[0].Request().(*MCRequest)
+	clone := req.Clone().(*MCRequest)
+	assert.Equal(t, req.respType, clone.respType)
+	assert.Equal(t, req.key, clone.key)
+	assert.Equal(t, req.data, clone.data)
+	set := msgs[1].Request().(*MCRequest).Clone().(*MCRequest)
+	assert.Equal(t, msgs[1].Request().(*MCRequest).data, set.data)
+	assert.Nil(t, msgs[2].Request().(*MCRequest).Clone())
+
+	assert.NoError(t, _createNodeConn([]byte("VALUE a 0 1\r\nx\r\nEND\r\n")).Read(msgs[0]))
+	clone.CopyReply(req)
+	assert.True(t, clone.ReplyEqual(req))
+	clone.data = []byte("END\r\n")
+	assert.False(t, clone.ReplyEqual(req))
+}
@@ -0,0 +1,35 @@
+package memcache
+
+import (
+	"bytes"
+
+	"github.com/ducesoft/overlord/proxy/proto"
+)
+
+var valueBytes = []byte("VALUE ")
+
+// NearCacheOp impl the proto.NearCacher, only the get of single key is cached
+// and the storage, delete, incr and decr are invalidated by key.
+func (r *MCRequest) NearCacheOp() proto.NearCacheOp {
+	switch r.respType {
+	case RequestTypeGet:
+		return proto.NearCacheRead
+	case RequestTypeSet, RequestTypeAdd, RequestTypeReplace, RequestTypeAppend, RequestTypePrepend,
+		RequestTypeCas, RequestTypeDelete, RequestTypeIncr, RequestTypeDecr, RequestTypeSetNoreply:
+		return proto.NearCacheInvalidate
+	}
+	return proto.NearCacheNone
+}
+
+// NearCacheReply impl the proto.NearCacher, only the reply with value is cached.
+func (r *MCRequest) NearCacheReply() []byte {
+	if r.respType != RequestTypeGet || !bytes.HasPrefix(r.data, valueBytes) {
+		return nil
+	}
+	return r.data
+}
+
+// ReplyNearCache impl the proto.NearCacher and replies the cached value.
+func (r *MCRequest) ReplyNearCache(data []byte) {
+	r.data = append(r.data[:0], data...)
+}
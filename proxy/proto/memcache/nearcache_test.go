@@ -0,0 +1,27 @@
+package memcache
+
+import (
+	"testing"
+
+	"github.com/ducesoft/overlord/proxy/proto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProxyConnNearCache(t *testing.T) {
+	p, c, msgs := _decodeRead(t, "get a\r\ngets a\r\nset a 0 0 1\r\nx\r\ntouch a 0\r\nget a\r\n", 5, "END\r\n")
+	ops := []proto.NearCacheOp{proto.NearCacheRead, proto.NearCacheNone, proto.NearCacheInvalidate, proto.NearCacheNone, proto.NearCacheRead}
+	for i, op := range ops {
+		assert.Equal(t, op, msgs[i].Request().(proto.NearCacher).NearCacheOp())
+	}
+
+	mcr := msgs[0].Request().(*MCRequest)
+	assert.Nil(t, mcr.NearCacheReply())
+	assert.NoError(t, _createNodeConn([]byte("VALUE a 0 1\r\nx\r\nEND\r\n")).Read(msgs[0]))
+	assert.Equal(t, "VALUE a 0 1\r\nx\r\nEND\r\n", string(mcr.NearCacheReply()))
+
+	msgs[4].Request().(proto.NearCacher).ReplyNearCache(mcr.NearCacheReply())
+	assert.NoError(t, p.Encode(msgs[4]))
+	assert.NoError(t, p.Flush())
+	assert.Equal(t, "VALUE a 0 1\r\nx\r\nEND\r\n", c.Wbuf.String())
+}
@@ -27,6 +27,9 @@ type nodeConn struct {
 	bw   *bufio.Writer
 	br   *bufio.Reader
 
+	// limits rejects the replies exceed the size limit of cluster.
+	limits *proto.Limits
+
 	state int32
 }
 
@@ -48,6 +51,12 @@ func NewNodeConnWithLibConn(cluster, addr string, conn *libnet.Conn) (nc proto.N
 	return
 }
 
+// WithLimits limit the size of replies, the conn is closed when the reply
+// exceeds because the rest of reply is never read.
+func (n *nodeConn) WithLimits(l *proto.Limits) {
+	n.limits = l
+}
+
 func (n *nodeConn) Addr() string {
 	return n.addr
 }
@@ -127,6 +136,19 @@ REREAD:
 		err = errors.WithStack(err)
 		return
 	}
+	if n.limits != nil {
+		if n.limits.MaxReply > 0 && length > n.limits.MaxReply {
+			// NOTE: drop the value to keep the conn usable for the rest replies.
+			if err = n.br.Discard(length + 2 + len(endBytes)); err != nil {
+				err = errors.WithStack(err)
+				return
+			}
+			err = errors.Wrapf(ErrReplyTooLarge, "node:%s limit:%d", n.addr, n.limits.MaxReply)
+			n.limits.Reject(n.addr, mcr.key, err)
+			return
+		}
+		n.limits.CheckBigValue(n.addr, mcr.key, length)
+	}
 	ds := length + 2 + len(endBytes)
 	mcr.data = append(mcr.data, bs...)
 
@@ -324,3 +324,23 @@ func TestNewNodeConnWithClosedBinder(t *testing.T) {
 	nc := NewNodeConn("anyName", addr.String(), time.Second, time.Second, time.Second)
 	assert.NotNil(t, nc)
 }
+
+func TestNodeConnMaxReply(t *testing.T) {
+	l := &proto.Limits{MaxReply: 5}
+	nc := _createNodeConn([]byte("VALUE a 0 10\r\n0123456789\r\nEND\r\nVALUE b 0 1\r\nx\r\nEND\r\n"))
+	nc.WithLimits(l)
+	req := &MCRequest{respType: RequestTypeGet, key: []byte("a"), data: []byte("\r\n")}
+	msg := proto.NewMessage()
+	msg.WithRequest(req)
+	err := nc.Read(msg)
+	assert.Equal(t, ErrReplyTooLarge, errors.Cause(err))
+	assert.True(t, proto.IsReplyError(err))
+	assert.Equal(t, int64(1), l.Rejected())
+
+	// the large value is dropped and the conn is kept
+	req = &MCRequest{respType: RequestTypeGet, key: []byte("b"), data: []byte("\r\n")}
+	msg = proto.NewMessage()
+	msg.WithRequest(req)
+	assert.NoError(t, nc.Read(msg))
+	assert.Equal(t, "VALUE b 0 1\r\nx\r\nEND\r\n", string(req.data))
+}
@@ -23,12 +23,25 @@ const (
 var (
 	serverErrorBytes  = []byte(serverErrorPrefix)
 	versionReplyBytes = []byte("VERSION ")
+	valueReplyBytes   = []byte("VALUE ")
 )
 
 type proxyConn struct {
 	br        *bufio.Reader
 	bw        *bufio.Writer
 	completed bool
+
+	// prefix is prepended to all the keys, eg: tenant:.
+	prefix []byte
+	keyBuf []byte
+	// limits rejects the requests exceed the size limits of cluster, and
+	// swallow is the bytes of rejected value which are not read yet.
+	limits  *proto.Limits
+	swallow int
+	addr    string
+	// batch counts the multi-key gets of which the keys of failed nodes
+	// are omitted.
+	batch *proto.BatchStats
 }
 
 // NewProxyConn new a memcache decoder and encode.
@@ -39,9 +52,28 @@ func NewProxyConn(rw *libnet.Conn) proto.ProxyConn {
 		bw:        bufio.NewWriter(rw),
 		completed: true,
 	}
+	if rw != nil && rw.Conn != nil {
+		p.addr = rw.RemoteAddr().String()
+	}
 	return p
 }
 
+// WithKeyPrefix namespace all the keys by prefix, the prefix is removed from
+// the keys in reply.
+func (p *proxyConn) WithKeyPrefix(prefix []byte) {
+	p.prefix = prefix
+}
+
+// WithLimits set the size limits of requests.
+func (p *proxyConn) WithLimits(l *proto.Limits) {
+	p.limits = l
+}
+
+// WithBatchStats set the stats of partially failed batch requests.
+func (p *proxyConn) WithBatchStats(s *proto.BatchStats) {
+	p.batch = s
+}
+
 func (p *proxyConn) Decode(msgs []*proto.Message) ([]*proto.Message, error) {
 	var err error
 	// if completed, means that we have parsed all the buffered
@@ -73,6 +105,10 @@ func (p *proxyConn) Decode(msgs []*proto.Message) ([]*proto.Message, error) {
 }
 
 func (p *proxyConn) decode(m *proto.Message) (err error) {
+	if p.swallow > 0 && !p.swallowValue() {
+		err = bufio.ErrBufferFull
+		return
+	}
 	// bufio reset buffer
 	line, err := p.br.ReadLine()
 	if err == bufio.ErrBufferFull {
@@ -156,6 +192,13 @@ func (p *proxyConn) decodeStorage(m *proto.Message, bs []byte, mtype RequestType
 		err = errors.WithStack(err)
 		return
 	}
+	if p.limits != nil {
+		if p.limits.ValueTooLarge(length) || p.limits.RequestTooLarge(len(mtype.Bytes())+len(bs)+length+2) {
+			p.rejectLarge(m, mtype, key, length)
+			return
+		}
+		p.limits.CheckBigValue(p.addr, key, length)
+	}
 
 	keyOffset := len(bs) - keyE
 	p.br.Advance(-keyOffset) // NOTE: data contains "<flags> <exptime> <bytes> <cas unique> [noreply]\r\n"
@@ -172,7 +215,7 @@ func (p *proxyConn) decodeStorage(m *proto.Message, bs []byte, mtype RequestType
 		return
 	}
 
-	WithReq(m, mtype, key, data)
+	p.withReq(m, mtype, key, data)
 	return
 }
 
@@ -191,7 +234,10 @@ func (p *proxyConn) decodeRetrieval(m *proto.Message, bs []byte, reqType Request
 		if b == len(ns)-2 {
 			break
 		}
-		WithReq(m, reqType, ns[b:e], crlfBytes)
+		p.withReq(m, reqType, ns[b:e], crlfBytes)
+	}
+	if p.limits != nil {
+		p.checkKeys(m, len(reqType.Bytes())+len(bs))
 	}
 	return
 }
@@ -203,7 +249,7 @@ func (p *proxyConn) decodeDelete(m *proto.Message, bs []byte, reqType RequestTyp
 		err = errors.WithStack(ErrBadKey)
 		return
 	}
-	WithReq(m, reqType, key, crlfBytes)
+	p.withReq(m, reqType, key, crlfBytes)
 	return
 }
 
@@ -223,7 +269,7 @@ func (p *proxyConn) decodeIncrDecr(m *proto.Message, bs []byte, reqType RequestT
 			return
 		}
 	}
-	WithReq(m, reqType, key, ns)
+	p.withReq(m, reqType, key, ns)
 	return
 }
 
@@ -243,7 +289,7 @@ func (p *proxyConn) decodeTouch(m *proto.Message, bs []byte, reqType RequestType
 			return
 		}
 	}
-	WithReq(m, reqType, key, ns)
+	p.withReq(m, reqType, key, ns)
 	return
 }
 
@@ -267,14 +313,26 @@ func (p *proxyConn) decodeGetAndTouch(m *proto.Message, bs []byte, reqType Reque
 			err = errors.WithStack(ErrBadKey)
 			return
 		}
-		WithReq(m, reqType, ns[b:e], expBs)
+		p.withReq(m, reqType, ns[b:e], expBs)
 		if e == len(ns)-2 {
 			break
 		}
 	}
+	if p.limits != nil {
+		p.checkKeys(m, len(reqType.Bytes())+len(bs))
+	}
 	return
 }
 
+// withReq fill the request with the key namespaced by the key prefix.
+func (p *proxyConn) withReq(m *proto.Message, rtype RequestType, key []byte, data []byte) {
+	if len(p.prefix) > 0 {
+		p.keyBuf = append(append(p.keyBuf[:0], p.prefix...), key...)
+		key = p.keyBuf
+	}
+	WithReq(m, rtype, key, data)
+}
+
 // WithReq will fill with memcache request.
 func WithReq(m *proto.Message, rtype RequestType, key []byte, data []byte) {
 	req := m.NextReq()
@@ -366,9 +424,25 @@ func revSpacIdx(bs []byte) int {
 	return -1
 }
 
-// Encode encode response and write into writer.
+// subFailed returns the count of failed keys of the multi-key get.
+func subFailed(m *proto.Message) (failed int) {
+	if m.Rejected() || !m.IsBatch() {
+		return
+	}
+	for i := range m.Requests() {
+		if m.SubErr(i) != nil {
+			failed++
+		}
+	}
+	return
+}
+
+// Encode encode response and write into writer. The multi-key get replies the
+// values of succeeded keys and omits the failed ones as missed, unless all
+// the keys are failed.
 func (p *proxyConn) Encode(m *proto.Message) (err error) {
-	if me := m.Err(); me != nil {
+	failed := subFailed(m)
+	if me := m.Err(); me != nil && (failed == 0 || failed == len(m.Requests())) {
 		se := errors.Cause(me).Error()
 		_ = p.bw.Write(serverErrorBytes)
 		_ = p.bw.Write([]byte(se))
@@ -399,11 +473,13 @@ func (p *proxyConn) Encode(m *proto.Message) (err error) {
 			return
 		}
 
+		p.trimPrefix(mcr)
 		err = p.bw.Write(mcr.data)
 		return
 	}
 
-	for _, req := range m.Requests() {
+	p.batch.Observe(failed)
+	for i, req := range m.Requests() {
 		mcr, ok := req.(*MCRequest)
 		if !ok {
 			_ = p.bw.Write(serverErrorBytes)
@@ -411,6 +487,10 @@ func (p *proxyConn) Encode(m *proto.Message) (err error) {
 			err = p.bw.Write(crlfBytes)
 			return
 		}
+		if m.SubErr(i) != nil {
+			continue
+		}
+		p.trimPrefix(mcr)
 		var bs []byte
 		if _, ok := withValueTypes[mcr.respType]; ok {
 			bs = bytes.TrimSuffix(mcr.data, endBytes)
@@ -427,6 +507,19 @@ func (p *proxyConn) Encode(m *proto.Message) (err error) {
 	return
 }
 
+// trimPrefix remove the key prefix from the "VALUE <key> ..." line of reply.
+func (p *proxyConn) trimPrefix(mcr *MCRequest) {
+	if len(p.prefix) == 0 {
+		return
+	}
+	if _, ok := withValueTypes[mcr.respType]; !ok {
+		return
+	}
+	if bytes.HasPrefix(mcr.data, valueReplyBytes) && bytes.HasPrefix(mcr.data[len(valueReplyBytes):], p.prefix) {
+		mcr.data = append(mcr.data[:len(valueReplyBytes)], mcr.data[len(valueReplyBytes)+len(p.prefix):]...)
+	}
+}
+
 func (p *proxyConn) Flush() (err error) {
 	return p.bw.Flush()
 }
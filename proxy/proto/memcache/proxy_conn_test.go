@@ -193,6 +193,23 @@ func _createRespMsg(t *testing.T, req []byte, resps [][]byte) *proto.Message {
 	return m
 }
 
+// _decodeRead decodes n messages of data by a new proxy conn set by opts, and
+// reads the reply of the first message from node unless reply is empty.
+func _decodeRead(t *testing.T, data string, n int, reply string, opts ...func(*proxyConn)) (*proxyConn, *mockconn.MockConn, []*proto.Message) {
+	conn := libcon.NewConn(mockconn.CreateConn([]byte(data), 1), time.Second, time.Second)
+	p := NewProxyConn(conn).(*proxyConn)
+	for _, opt := range opts {
+		opt(p)
+	}
+	msgs, err := p.Decode(proto.GetMsgs(8))
+	assert.NoError(t, err)
+	assert.Len(t, msgs, n)
+	if reply != "" {
+		assert.NoError(t, _createNodeConn([]byte(reply)).Read(msgs[0]))
+	}
+	return p, conn.Conn.(*mockconn.MockConn), msgs
+}
+
 func TestProxyConnEncodeOk(t *testing.T) {
 	ts := []struct {
 		Name   string
@@ -263,3 +280,43 @@ func TestEncodeErr(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Contains(t, string(buf[:size]), "SERVER_ERR")
 }
+
+func TestProxyConnEncodePartial(t *testing.T) {
+	s := &proto.BatchStats{}
+	encode := func(msg *proto.Message) string {
+		conn := libcon.NewConn(mockconn.CreateConn(nil, 1), time.Second, time.Second)
+		p := NewProxyConn(conn)
+		p.(*proxyConn).WithBatchStats(s)
+		assert.NoError(t, p.Encode(msg))
+		assert.NoError(t, p.Flush())
+		return conn.Conn.(*mockconn.MockConn).Wbuf.String()
+	}
+	msg := _createRespMsg(t, []byte("get a b c\r\n"), [][]byte{
+		[]byte("VALUE a 0 1\r\nx\r\nEND\r\n"), []byte("VALUE b 0 1\r\ny\r\nEND\r\n"), []byte("VALUE c 0 1\r\nz\r\nEND\r\n"),
+	})
+	subs := msg.Batch()
+	subs[1].WithError(fmt.Errorf("timeout"))
+	assert.Equal(t, "VALUE a 0 1\r\nx\r\nVALUE c 0 1\r\nz\r\nEND\r\n", encode(msg), "failed key omitted")
+	assert.Equal(t, int64(1), s.Partial())
+	assert.Equal(t, int64(1), s.Failed())
+
+	subs[0].WithError(fmt.Errorf("timeout"))
+	subs[2].WithError(fmt.Errorf("timeout"))
+	assert.Equal(t, "SERVER_ERROR timeout\r\n", encode(msg), "all keys failed")
+	assert.Equal(t, int64(1), s.Partial())
+}
+
+func TestProxyConnKeyPrefix(t *testing.T) {
+	p, c, msgs := _decodeRead(t, "get a b\r\nset c 0 0 1\r\nx\r\n", 2, "", func(p *proxyConn) { p.WithKeyPrefix([]byte("t:")) })
+	assert.Equal(t, "t:c", string(msgs[1].Request().Key()))
+
+	subs := msgs[0].Batch()
+	assert.Len(t, subs, 2)
+	for i, resp := range []string{"VALUE t:a 0 1\r\nx\r\nEND\r\n", "END\r\n"} {
+		assert.Equal(t, "t:"+string("ab"[i]), string(subs[i].Request().Key()))
+		assert.NoError(t, _createNodeConn([]byte(resp)).Read(subs[i]))
+	}
+	assert.NoError(t, p.Encode(msgs[0]))
+	assert.NoError(t, p.Flush())
+	assert.Equal(t, "VALUE a 0 1\r\nx\r\nEND\r\n", c.Wbuf.String())
+}
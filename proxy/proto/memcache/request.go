@@ -272,8 +272,9 @@ func (r *MCRequest) Key() []byte {
 	return r.key
 }
 
-func (r *MCRequest) Merge([]proto.Request) (err error) {
-	return
+// Merge impl the proto.Request, the requests of memcache are never merged.
+func (r *MCRequest) Merge([]proto.Request) error {
+	return proto.ErrMergeNotSupported
 }
 
 func (r *MCRequest) String() string {
@@ -69,6 +69,12 @@ type Message struct {
 	reqNum int
 	subs   []*Message
 	wg     *sync.WaitGroup
+	// merged is the subs merged into the message by forwarder, which fail
+	// together with it.
+	merged []*Message
+	// fresh marks the read following the write to the same key of the same
+	// client, which is never coalesced nor replied by near cache.
+	fresh bool
 
 	// Start Time, Write Time, ReadTime, EndTime, Start Pipe Time, End Pipe Time, Start Pipe Time, End Pipe Time
 	st, wt, rt, et, spt, ept, sit, eit time.Time
@@ -88,6 +94,8 @@ func (m *Message) Reset() {
 	m.reqNum = 0
 	m.st, m.wt, m.rt, m.et, m.spt, m.ept, m.sit, m.eit = defaultTime, defaultTime, defaultTime, defaultTime, defaultTime, defaultTime, defaultTime, defaultTime
 	m.err = nil
+	m.merged = m.merged[:0]
+	m.fresh = false
 }
 
 // clear will clean the msg
@@ -96,6 +104,7 @@ func (m *Message) clear() {
 	m.req = nil
 	m.wg = nil
 	m.subs = nil
+	m.merged = nil
 }
 
 // TotalDur will return the total duration of a command.
@@ -183,7 +192,8 @@ func (m *Message) ResetSubs() {
 	if !m.IsBatch() {
 		return
 	}
-	for i := range m.subs[:m.reqNum] {
+	// NOTE: the rejected message is never batched
+	for i := range m.subs[:minInt(len(m.subs), m.reqNum)] {
 		m.subs[i].Reset()
 	}
 	m.reqNum = 0
@@ -240,12 +250,14 @@ func (m *Message) Batch() []*Message {
 	var min = minInt(len(m.subs), slen)
 	for i := 0; i < min; i++ {
 		m.subs[i].Type = m.Type
+		m.subs[i].fresh = m.fresh
 		m.subs[i].setRequest(m.req[i])
 	}
 	delta := slen - len(m.subs)
 	for i := 0; i < delta; i++ {
 		msg := getMsg()
 		msg.Type = m.Type
+		msg.fresh = m.fresh
 		msg.st = m.st
 		msg.setRequest(m.req[min+i])
 		msg.WithWaitGroup(m.wg)
@@ -276,6 +288,15 @@ func (m *Message) Done() {
 // WithError with error.
 func (m *Message) WithError(err error) {
 	m.err = err
+	for _, s := range m.merged {
+		s.err = err
+	}
+}
+
+// WithMerged marks the subs whose requests are merged into the request of
+// message, the error of message is propagated to them.
+func (m *Message) WithMerged(subs []*Message) {
+	m.merged = append(m.merged[:0], subs...)
 }
 
 // Err returns error.
@@ -294,6 +315,65 @@ func (m *Message) Err() error {
 	return nil
 }
 
+// SubErr returns the error of the i-th request of batch, which is the error
+// of message itself if it is rejected or not batch.
+func (m *Message) SubErr(i int) error {
+	if m.err != nil || !m.IsBatch() {
+		return m.err
+	}
+	if i < len(m.subs) {
+		return m.subs[i].err
+	}
+	return nil
+}
+
+// Rejected returns whether the message is rejected with error when decoding,
+// which is not forwarded but replied the error directly, eg: value too large.
+func (m *Message) Rejected() bool {
+	return m.err != nil
+}
+
+// Fresh returns whether the message is the read following the write to the
+// same key, see MarkFresh.
+func (m *Message) Fresh() bool {
+	return m.fresh
+}
+
+// MarkFresh marks the reads of msgs following the write to the same key in
+// msgs fresh, which are never coalesced nor replied by near cache, otherwise
+// the read may be replied the value before the write and the client misses
+// its own write, eg: pipelined SET k v; GET k.
+func MarkFresh(msgs []*Message) {
+	var written map[string]struct{}
+	for _, m := range msgs {
+		req := m.Request()
+		if req == nil {
+			continue
+		}
+		if ro, ok := req.(ReadOnlyer); ok && ro.IsReadOnly() {
+			for _, r := range m.Requests() {
+				if _, ok := written[string(r.Key())]; ok {
+					m.fresh = true
+					break
+				}
+			}
+			continue
+		}
+		if written == nil {
+			written = make(map[string]struct{})
+		}
+		for _, r := range m.Requests() {
+			if mk, ok := r.(MultiKeyer); ok {
+				for _, key := range mk.Keys() {
+					written[string(key)] = struct{}{}
+				}
+			} else {
+				written[string(r.Key())] = struct{}{}
+			}
+		}
+	}
+}
+
 // ErrMessage return err Msg.
 func ErrMessage(err error) *Message {
 	return &Message{err: err}
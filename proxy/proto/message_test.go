@@ -62,3 +62,24 @@ func TestMessage(t *testing.T) {
 	err = emsg.Err()
 	assert.EqualError(t, err, "some error")
 }
+
+func TestMessageMerged(t *testing.T) {
+	msg := NewMessage()
+	for i := 0; i < 3; i++ {
+		msg.WithRequest(&mockRequest{})
+	}
+	subs := msg.Batch()
+	subs[0].WithMerged(subs[2:])
+	assert.NoError(t, msg.SubErr(0))
+
+	subs[0].WithError(errors.New("timeout"))
+	assert.EqualError(t, msg.SubErr(0), "timeout")
+	assert.NoError(t, msg.SubErr(1))
+	assert.EqualError(t, msg.SubErr(2), "timeout", "merged sub fails together")
+	assert.False(t, msg.Rejected())
+
+	msg.ResetSubs()
+	subs[0].Reset()
+	subs[0].WithError(errors.New("again"))
+	assert.NoError(t, subs[2].Err(), "merged subs are reset")
+}
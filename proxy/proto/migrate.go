@@ -0,0 +1,16 @@
+package proto
+
+// Migrater is the type of request which falls back to the old servers when
+// the cluster is migrating to the new servers.
+type Migrater interface {
+	// Fallback returns the copy of the read of single key missed in the new
+	// servers to be forwarded to the old servers, eg: redis GET replied nil,
+	// nil if not missed.
+	Fallback() Request
+	// Hit reports whether the read is replied with the value.
+	Hit() bool
+	// Repair returns the request which stores the value read from the old
+	// servers into the new servers if absent, ttl is the seconds the value
+	// expires after and 0 means never, nil if the read missed.
+	Repair(ttl int) Request
+}
@@ -0,0 +1,10 @@
+package proto
+
+// Mirrorer is the type of request which can be mirrored to the shadow cluster.
+type Mirrorer interface {
+	// Clone returns the copy of request without reply, nil if the request
+	// can't be mirrored, eg: the transaction bound to client conn.
+	Clone() Request
+	// ReplyEqual check whether the reply is the same as the reply of r.
+	ReplyEqual(r Request) bool
+}
@@ -2,9 +2,11 @@ package proto
 
 import (
 	"errors"
-	"github.com/ducesoft/overlord/pkg/hashkit"
 	"sync"
 	"sync/atomic"
+	"time"
+
+	"github.com/ducesoft/overlord/pkg/hashkit"
 )
 
 const (
@@ -27,13 +29,42 @@ type NodeConnPipe struct {
 
 	state        int32
 	pipeMaxCount int
+	// flights coalesces the identical reads in flight, nil means disabled.
+	flights *flights
+	// wait is the max duration to wait for the input chan when it's full,
+	// zero means fail fast.
+	wait time.Duration
+
+	fulls  int64
+	waited int64
+}
+
+// PipeStats is the stats of NodeConnPipe.
+type PipeStats struct {
+	// Queued is the count of messages waiting in the input chans.
+	Queued int
+	// Capacity is the total capacity of the input chans.
+	Capacity int
+	// Full is the count of messages failed by the full input chans.
+	Full int64
+	// Waited is the count of messages waited for the full input chans.
+	Waited int64
+}
+
+// DefaultPipeQueue returns the default capacity of each input chan.
+func DefaultPipeQueue(pipeMaxCount int) int {
+	return pipeMaxCount * pipeMaxCount * 16
 }
 
-// NewNodeConnPipe new NodeConnPipe.
-func NewNodeConnPipe(conns int32, pipeMaxCount int, newNc func() NodeConn) (ncp *NodeConnPipe) {
+// NewNodeConnPipe new NodeConnPipe, queue is the capacity of each input chan
+// and the default is used if queue <= 0.
+func NewNodeConnPipe(conns int32, pipeMaxCount, queue int, newNc func() NodeConn) (ncp *NodeConnPipe) {
 	if conns <= 0 {
 		panic("the number of connections cannot be zero")
 	}
+	if queue <= 0 {
+		queue = DefaultPipeQueue(pipeMaxCount)
+	}
 	ncp = &NodeConnPipe{
 		conns:        conns,
 		inputs:       make([]chan *Message, conns),
@@ -42,31 +73,68 @@ func NewNodeConnPipe(conns int32, pipeMaxCount int, newNc func() NodeConn) (ncp
 		pipeMaxCount: pipeMaxCount,
 	}
 	for i := int32(0); i < ncp.conns; i++ {
-		ncp.inputs[i] = make(chan *Message, pipeMaxCount*pipeMaxCount*16)
+		ncp.inputs[i] = make(chan *Message, queue)
 		ncp.mps[i] = newMsgPipe(pipeMaxCount, ncp.inputs[i], newNc, ncp)
 	}
 	return
 }
 
-// Push push message into input chan.
+// WithCoalesce enable the coalescing of identical reads in flight, the read
+// waits for the identical one and shares its reply instead of being sent to node.
+// It must be called before any message is pushed.
+func (ncp *NodeConnPipe) WithCoalesce() {
+	ncp.flights = newFlights()
+}
+
+// WithWait makes Push wait up to d for the full input chan instead of failing
+// the message immediately. It must be called before any message is pushed.
+func (ncp *NodeConnPipe) WithWait(d time.Duration) {
+	ncp.wait = d
+}
+
+// Push push message into input chan, the message fails with errPipeChanFull
+// if the input chan is still full after waiting.
 func (ncp *NodeConnPipe) Push(m *Message) {
+	ncp.push(m, nil)
+}
+
+// PushExcept push message into the input chan of conn other than the one of
+// key, eg: the conn which is reading never reads the message it pushes. The
+// pipe must have more than one conn.
+func (ncp *NodeConnPipe) PushExcept(m *Message, key []byte) {
+	ncp.push(m, key)
+}
+
+// Conns returns the number of conns of pipe.
+func (ncp *NodeConnPipe) Conns() int32 {
+	return ncp.conns
+}
+
+func (ncp *NodeConnPipe) push(m *Message, except []byte) {
 	m.Add()
+	if ncp.flights != nil && ncp.flights.join(m) {
+		return
+	}
 	var input chan *Message
 	ncp.l.RLock()
+	// NOTE: hold the read lock until pushed, so the input chan is never closed while waiting.
+	defer ncp.l.RUnlock()
 	if ncp.state == opened {
 		if ncp.conns == 1 {
 			input = ncp.inputs[0]
 		} else {
 			req := m.Request()
 			if req != nil {
-				crc := int32(hashkit.Crc16(req.Key()))
-				input = ncp.inputs[crc%ncp.conns]
+				idx := ncp.connOf(req.Key())
+				if except != nil && idx == ncp.connOf(except) {
+					idx = (idx + 1) % ncp.conns
+				}
+				input = ncp.inputs[idx]
 			} else {
 				// NOTE: impossible!!!
 			}
 		}
 	}
-	ncp.l.RUnlock()
 	if input != nil {
 		select {
 		case input <- m:
@@ -74,8 +142,45 @@ func (ncp *NodeConnPipe) Push(m *Message) {
 			return
 		default:
 		}
+		if ncp.wait > 0 {
+			atomic.AddInt64(&ncp.waited, 1)
+			timer := time.NewTimer(ncp.wait)
+			select {
+			case input <- m:
+				timer.Stop()
+				m.MarkStartInput()
+				return
+			case <-timer.C:
+			}
+		}
+		atomic.AddInt64(&ncp.fulls, 1)
+	}
+	ncp.done(m, errPipeChanFull)
+}
+
+// connOf returns the index of conn which the message of key is pushed into.
+func (ncp *NodeConnPipe) connOf(key []byte) int32 {
+	return int32(hashkit.Crc16(key)) % ncp.conns
+}
+
+// Stats returns the stats of pipe.
+func (ncp *NodeConnPipe) Stats() (s PipeStats) {
+	for _, input := range ncp.inputs {
+		s.Queued += len(input)
+		s.Capacity += cap(input)
 	}
-	m.WithError(errPipeChanFull)
+	s.Full = atomic.LoadInt64(&ncp.fulls)
+	s.Waited = atomic.LoadInt64(&ncp.waited)
+	return
+}
+
+// done mark the message done with err and shares the reply with the identical
+// reads waiting for it.
+func (ncp *NodeConnPipe) done(m *Message, err error) {
+	if ncp.flights != nil {
+		ncp.flights.land(m, err)
+	}
+	m.WithError(err) // NOTE: maybe err is nil
 	m.Done()
 }
 
@@ -167,6 +272,12 @@ func (mp *msgPipe) pipe() {
 					err = nc.Read(mp.batch[i])
 					mp.batch[i].MarkRead()
 					mp.batch[i].MarkAddr(nc.Addr())
+					if err != nil && IsReplyError(err) {
+						// NOTE: the reply is dropped by node conn, only the message fails and the conn is kept.
+						mp.ncp.done(mp.batch[i], err)
+						mp.batch[i] = nil
+						err = nil
+					}
 				} else {
 					goto MEND
 				}
@@ -174,9 +285,9 @@ func (mp *msgPipe) pipe() {
 		}
 	MEND:
 		for i := 0; i < mp.count; i++ {
-			msg := mp.batch[i]
-			msg.WithError(err) // NOTE: maybe err is nil
-			msg.Done()
+			if mp.batch[i] != nil {
+				mp.ncp.done(mp.batch[i], err)
+			}
 		}
 		mp.count = 0
 		if err != nil {
@@ -194,12 +305,16 @@ func (mp *msgPipe) pipe() {
 
 func (mp *msgPipe) reNewNc(nc NodeConn, err error) NodeConn {
 	if err != nil {
-		mp.ncp.l.Lock()
-		select {
-		case mp.ncp.errCh <- err: // NOTE: action
-		default:
+		// NOTE: read lock is enough to guard errCh from closing, and never
+		// waits for the Push blocked by the input chan drained by this pipe.
+		mp.ncp.l.RLock()
+		if mp.ncp.state == opened {
+			select {
+			case mp.ncp.errCh <- err: // NOTE: action
+			default:
+			}
 		}
-		mp.ncp.l.Unlock()
+		mp.ncp.l.RUnlock()
 	}
 	nc.Close()
 	mp.nc.Store(mp.newNc())
@@ -4,6 +4,7 @@ import (
 	"crypto/rand"
 	"errors"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -56,11 +57,11 @@ func (*mockRequest) Slowlog() *SlowlogEntry { return nil }
 
 func TestPipe(t *testing.T) {
 	nc1 := &mockNodeConn{}
-	ncp1 := NewNodeConnPipe(1, 32, func() NodeConn {
+	ncp1 := NewNodeConnPipe(1, 32, 0, func() NodeConn {
 		return nc1
 	})
 	nc2 := &mockNodeConn{}
-	ncp2 := NewNodeConnPipe(2, 32, func() NodeConn {
+	ncp2 := NewNodeConnPipe(2, 32, 0, func() NodeConn {
 		return nc2
 	})
 	wg := &sync.WaitGroup{}
@@ -82,7 +83,7 @@ func TestPipe(t *testing.T) {
 	nc3 := &mockNodeConn{}
 	nc3.num = whenErrNum
 	nc3.err = errors.New("some error")
-	ncp3 := NewNodeConnPipe(1, 32, func() NodeConn {
+	ncp3 := NewNodeConnPipe(1, 32, 0, func() NodeConn {
 		return nc3
 	})
 	wg = &sync.WaitGroup{}
@@ -101,3 +102,159 @@ func TestPipe(t *testing.T) {
 		assert.EqualError(t, msg.Err(), "some error")
 	}
 }
+
+// replyErrNodeConn fails the read of the nth message by the reply error.
+type replyErrNodeConn struct {
+	mockNodeConn
+	nth, reads int
+}
+
+func (n *replyErrNodeConn) Read(*Message) error {
+	n.reads++
+	if n.reads == n.nth {
+		return NewReplyError("ERR reply is too large")
+	}
+	return nil
+}
+
+func TestPipeReplyError(t *testing.T) {
+	nc := &replyErrNodeConn{nth: 2}
+	ncp := NewNodeConnPipe(1, 32, 0, func() NodeConn { return nc })
+	wg := &sync.WaitGroup{}
+	var msgs []*Message
+	for i := 0; i < 3; i++ {
+		m := getMsg()
+		m.WithRequest(&mockRequest{})
+		m.WithWaitGroup(wg)
+		ncp.Push(m)
+		msgs = append(msgs, m)
+	}
+	wg.Wait()
+	assert.NoError(t, msgs[0].Err())
+	assert.EqualError(t, msgs[1].Err(), "ERR reply is too large")
+	assert.NoError(t, msgs[2].Err())
+	assert.False(t, nc.closed)
+	select {
+	case err := <-ncp.ErrorEvent():
+		t.Fatalf("unexpected error event %v", err)
+	default:
+	}
+	ncp.Close()
+}
+
+// blockNodeConn blocks the write until released.
+type blockNodeConn struct {
+	mockNodeConn
+	release chan struct{}
+}
+
+func (n *blockNodeConn) Write(*Message) error {
+	<-n.release
+	return nil
+}
+
+func TestPipeQueueFull(t *testing.T) {
+	nc := &blockNodeConn{release: make(chan struct{})}
+	ncp := NewNodeConnPipe(1, 32, 1, func() NodeConn { return nc })
+	defer ncp.Close()
+	wg := &sync.WaitGroup{}
+	push := func() *Message {
+		m := getMsg()
+		m.WithRequest(&mockRequest{})
+		m.WithWaitGroup(wg)
+		ncp.Push(m)
+		return m
+	}
+	push() // NOTE: blocked in write
+	time.Sleep(10 * time.Millisecond)
+	push()
+	assert.Equal(t, PipeStats{Queued: 1, Capacity: 1}, ncp.Stats())
+	m := push()
+	assert.Equal(t, errPipeChanFull, m.Err())
+	assert.Equal(t, int64(1), ncp.Stats().Full)
+
+	ncp.WithWait(time.Second)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		close(nc.release)
+	}()
+	m = push()
+	wg.Wait()
+	assert.NoError(t, m.Err())
+	s := ncp.Stats()
+	assert.Equal(t, int64(1), s.Full)
+	assert.Equal(t, int64(1), s.Waited)
+	assert.Equal(t, 0, s.Queued)
+}
+
+func TestPipeQueueWaitTimeout(t *testing.T) {
+	nc := &blockNodeConn{release: make(chan struct{})}
+	ncp := NewNodeConnPipe(1, 32, 1, func() NodeConn { return nc })
+	ncp.WithWait(10 * time.Millisecond)
+	wg := &sync.WaitGroup{}
+	var msgs []*Message
+	for i := 0; i < 3; i++ {
+		m := getMsg()
+		m.WithRequest(&mockRequest{})
+		m.WithWaitGroup(wg)
+		ncp.Push(m)
+		msgs = append(msgs, m)
+		time.Sleep(5 * time.Millisecond)
+	}
+	assert.Equal(t, errPipeChanFull, msgs[2].Err())
+	s := ncp.Stats()
+	assert.Equal(t, int64(1), s.Full)
+	assert.Equal(t, int64(1), s.Waited)
+	close(nc.release)
+	wg.Wait()
+	ncp.Close()
+}
+
+// writeNodeConn counts the messages written.
+type writeNodeConn struct {
+	mockNodeConn
+	writes int32
+}
+
+func (n *writeNodeConn) Write(*Message) error {
+	atomic.AddInt32(&n.writes, 1)
+	return nil
+}
+
+// keyRequest is the request of fixed key.
+type keyRequest struct {
+	mockRequest
+	key []byte
+}
+
+func (r *keyRequest) Key() []byte { return r.key }
+
+func TestPipePushExcept(t *testing.T) {
+	var ncs []*writeNodeConn
+	ncp := NewNodeConnPipe(2, 32, 0, func() NodeConn {
+		nc := &writeNodeConn{}
+		ncs = append(ncs, nc)
+		return nc
+	})
+	defer ncp.Close()
+	assert.Equal(t, int32(2), ncp.Conns())
+	key := []byte("a")
+	idx := ncp.connOf(key)
+	wg := &sync.WaitGroup{}
+	push := func(except []byte) {
+		m := getMsg()
+		m.WithRequest(&keyRequest{key: key})
+		m.WithWaitGroup(wg)
+		if except != nil {
+			ncp.PushExcept(m, except)
+		} else {
+			ncp.Push(m)
+		}
+	}
+	push(nil)
+	push(key)
+	push(key)
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&ncs[idx].writes))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&ncs[1-idx].writes), "never pushed into the conn of except key")
+}
@@ -0,0 +1,300 @@
+package redis
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ducesoft/overlord/pkg/conv"
+	"github.com/ducesoft/overlord/proxy/proto"
+)
+
+const (
+	// slowlogDefaultCount is the count of SLOWLOG GET without count as redis.
+	slowlogDefaultCount = 10
+	// slowlogMaxArgs is the max args of each slowlog entry as redis.
+	slowlogMaxArgs = 32
+)
+
+var (
+	cmdSlowlogBytes = []byte("7\r\nSLOWLOG")
+	cmdProxyBytes   = []byte("5\r\nPROXY")
+
+	subGetBytes    = []byte("3\r\nGET")
+	subLenBytes    = []byte("3\r\nLEN")
+	subResetBytes  = []byte("5\r\nRESET")
+	subNodesBytes  = []byte("5\r\nNODES")
+	subConfigBytes = []byte("6\r\nCONFIG")
+	subReloadBytes = []byte("6\r\nRELOAD")
+
+	subHotKeysBytes = []byte("7\r\nHOTKEYS")
+	subMigrateBytes = []byte("7\r\nMIGRATE")
+	argFinishBytes  = []byte("FINISH")
+	subCaptureBytes = []byte("7\r\nCAPTURE")
+	argStartBytes   = []byte("START")
+	argStopBytes    = []byte("STOP")
+	argSecondsBytes = []byte("SECONDS")
+	argRepliesBytes = []byte("REPLIES")
+
+	errSlowlogSubCmd = []byte("ERR unknown subcommand or wrong number of arguments for 'slowlog' command")
+	errSlowlogCount  = []byte("ERR value is out of range, must be positive")
+	errProxySubCmd   = []byte("ERR unknown subcommand or wrong number of arguments for 'proxy' command")
+	errProxyNoAdmin  = []byte("ERR proxy admin is not available")
+	errAdminDisabled = []byte("ERR admin command is disabled, enable admin_commands of cluster")
+	errProxyReload   = []byte("ERR reload fail: ")
+	errProxyMigrate  = []byte("ERR migrate fail: ")
+	errProxyCapture  = []byte("ERR capture fail: ")
+)
+
+// admin returns the admin operations of proxy, nil if not available.
+func (c *client) admin() proto.Admin {
+	if admin, ok := c.info.(proto.Admin); ok {
+		return admin
+	}
+	return nil
+}
+
+// decodeSlowlog reply SLOWLOG GET [count]|LEN|RESET by the slowlog of cluster,
+// RESET is rejected unless the admin commands are enabled.
+func (c *client) decodeSlowlog(r *Request) {
+	if r.resp.arraySize < 2 {
+		r.replyLocal(respError, errSlowlogSubCmd)
+		return
+	}
+	var store proto.SlowlogStore
+	if admin := c.admin(); admin != nil {
+		store = admin.Slowlog()
+	}
+	sub := r.resp.array[1].data
+	conv.UpdateToUpper(sub)
+	switch {
+	case bytes.Equal(sub, subGetBytes) && r.resp.arraySize <= 3:
+		count := int64(slowlogDefaultCount)
+		if r.resp.arraySize == 3 {
+			var err error
+			if count, err = conv.Btoi(bulkData(r.resp.array[2])); err != nil || count < -1 {
+				r.replyLocal(respError, errSlowlogCount)
+				return
+			}
+		}
+		r.local = true
+		r.reply.setArray()
+		if store != nil {
+			for _, entry := range store.Get(int(count)) {
+				encodeSlowlogEntry(r.reply.next(), entry)
+			}
+		}
+		r.reply.setArraySize()
+	case bytes.Equal(sub, subLenBytes) && r.resp.arraySize == 2:
+		r.local = true
+		if store == nil {
+			r.reply.setInt(0)
+			return
+		}
+		r.reply.setInt(int64(store.Len()))
+	case bytes.Equal(sub, subResetBytes) && r.resp.arraySize == 2:
+		if !c.adminCmds {
+			r.replyLocal(respError, errAdminDisabled)
+			return
+		}
+		if store != nil {
+			store.Reset()
+		}
+		r.replyLocal(respString, justOkBytes)
+	default:
+		r.replyLocal(respError, errSlowlogSubCmd)
+	}
+}
+
+// encodeSlowlogEntry encode entry in the format of redis SLOWLOG GET:
+// id, timestamp, duration in microseconds, args, addr of backend node and cluster name.
+// The batch request has the 7th item of subcommands, each is duration, remote duration,
+// args and addr of backend node.
+func encodeSlowlogEntry(r *resp, entry *proto.SlowlogEntry) {
+	r.setArray()
+	r.next().setInt(entry.ID)
+	r.next().setInt(entry.StartTime.Unix())
+	r.next().setInt(int64(entry.TotalDur / time.Microsecond))
+	cmd := entry.Cmd
+	if len(cmd) == 0 {
+		for _, sub := range entry.Subs {
+			cmd = append(cmd, sub.Cmd...)
+		}
+	}
+	encodeSlowlogArgs(r.next(), cmd)
+	r.next().setBulk([]byte(entry.Addr))
+	r.next().setBulk([]byte(entry.Cluster))
+	if len(entry.Subs) > 0 {
+		subs := r.next()
+		subs.setArray()
+		for _, sub := range entry.Subs {
+			s := subs.next()
+			s.setArray()
+			s.next().setInt(int64(sub.TotalDur / time.Microsecond))
+			s.next().setInt(int64(sub.RemoteDur / time.Microsecond))
+			encodeSlowlogArgs(s.next(), sub.Cmd)
+			s.next().setBulk([]byte(sub.Addr))
+			s.setArraySize()
+		}
+		subs.setArraySize()
+	}
+	r.setArraySize()
+}
+
+func encodeSlowlogArgs(r *resp, args []string) {
+	r.setArray()
+	for i, arg := range args {
+		if i == slowlogMaxArgs-1 && len(args) > slowlogMaxArgs {
+			more := "... (" + strconv.Itoa(len(args)-i) + " more arguments)"
+			r.next().setBulk([]byte(more))
+			break
+		}
+		r.next().setBulk([]byte(arg))
+	}
+	r.setArraySize()
+}
+
+// decodeProxy reply PROXY NODES|CONFIG|RELOAD|HOTKEYS [count]|MIGRATE FINISH|CAPTURE START|STOP
+// by the admin operations of proxy. RELOAD, MIGRATE and CAPTURE change the state of
+// proxy, which are rejected unless the admin commands are enabled.
+func (c *client) decodeProxy(r *Request) {
+	if r.resp.arraySize < 2 {
+		r.replyLocal(respError, errProxySubCmd)
+		return
+	}
+	sub := r.resp.array[1].data
+	conv.UpdateToUpper(sub)
+	hotkeys := bytes.Equal(sub, subHotKeysBytes)
+	migrate := bytes.Equal(sub, subMigrateBytes)
+	capture := bytes.Equal(sub, subCaptureBytes)
+	if capture {
+		if r.resp.arraySize < 3 {
+			r.replyLocal(respError, errProxySubCmd)
+			return
+		}
+	} else if migrate {
+		if r.resp.arraySize != 3 || !bytes.EqualFold(bulkData(r.resp.array[2]), argFinishBytes) {
+			r.replyLocal(respError, errProxySubCmd)
+			return
+		}
+	} else if (!hotkeys && r.resp.arraySize != 2) || (hotkeys && r.resp.arraySize > 3) ||
+		(!hotkeys && !bytes.Equal(sub, subNodesBytes) && !bytes.Equal(sub, subConfigBytes) && !bytes.Equal(sub, subReloadBytes)) {
+		r.replyLocal(respError, errProxySubCmd)
+		return
+	}
+	if (migrate || capture || bytes.Equal(sub, subReloadBytes)) && !c.adminCmds {
+		r.replyLocal(respError, errAdminDisabled)
+		return
+	}
+	admin := c.admin()
+	if admin == nil {
+		r.replyLocal(respError, errProxyNoAdmin)
+		return
+	}
+	switch {
+	case bytes.Equal(sub, subNodesBytes):
+		r.local = true
+		r.reply.setArray()
+		for _, state := range admin.NodeStates() {
+			node := r.reply.next()
+			node.setArray()
+			node.next().setBulk([]byte(state.Addr))
+			node.next().setBulk([]byte(state.Alias))
+			node.next().setBulk([]byte(state.Role))
+			node.next().setBulk([]byte(state.Status))
+			node.setArraySize()
+		}
+		r.reply.setArraySize()
+	case bytes.Equal(sub, subConfigBytes):
+		r.local = true
+		r.reply.setArray()
+		for _, f := range admin.Config() {
+			r.reply.next().setBulk([]byte(f.Key))
+			r.reply.next().setBulk([]byte(f.Value))
+		}
+		r.reply.setArraySize()
+	case bytes.Equal(sub, subReloadBytes):
+		if err := admin.Reload(); err != nil {
+			msg := strings.NewReplacer("\r", " ", "\n", " ").Replace(err.Error())
+			r.replyLocal(respError, append(append([]byte{}, errProxyReload...), msg...))
+			return
+		}
+		r.replyLocal(respString, justOkBytes)
+	case migrate:
+		if err := admin.FinishMigration(); err != nil {
+			msg := strings.NewReplacer("\r", " ", "\n", " ").Replace(err.Error())
+			r.replyLocal(respError, append(append([]byte{}, errProxyMigrate...), msg...))
+			return
+		}
+		r.replyLocal(respString, justOkBytes)
+	case capture:
+		c.decodeCapture(r, admin)
+	case hotkeys:
+		count := int64(-1)
+		if r.resp.arraySize == 3 {
+			var err error
+			if count, err = conv.Btoi(bulkData(r.resp.array[2])); err != nil || count < -1 {
+				r.replyLocal(respError, errSlowlogCount)
+				return
+			}
+		}
+		r.local = true
+		r.reply.setArray()
+		for _, hk := range admin.HotKeys(int(count)) {
+			key := r.reply.next()
+			key.setArray()
+			key.next().setBulk([]byte(hk.Key))
+			key.next().setInt(hk.Count)
+			key.next().setBulk([]byte(hk.Node))
+			key.setArraySize()
+		}
+		r.reply.setArraySize()
+	}
+}
+
+// decodeCapture reply PROXY CAPTURE START [SECONDS n] [COUNT n] [REPLIES]|STOP by the admin of proxy.
+func (c *client) decodeCapture(r *Request, admin proto.Admin) {
+	var err error
+	switch arg := bulkData(r.resp.array[2]); {
+	case bytes.EqualFold(arg, argStopBytes) && r.resp.arraySize == 3:
+		err = admin.StopCapture()
+	case bytes.EqualFold(arg, argStartBytes):
+		var (
+			seconds, count int64
+			replies        bool
+		)
+		for i := 3; i < r.resp.arraySize; i++ {
+			opt := bulkData(r.resp.array[i])
+			if bytes.EqualFold(opt, argRepliesBytes) {
+				replies = true
+				continue
+			}
+			if i+1 >= r.resp.arraySize || (!bytes.EqualFold(opt, argSecondsBytes) && !bytes.EqualFold(opt, argCountBytes)) {
+				r.replyLocal(respError, errProxySubCmd)
+				return
+			}
+			i++
+			n, cerr := conv.Btoi(bulkData(r.resp.array[i]))
+			if cerr != nil || n < 0 {
+				r.replyLocal(respError, errSlowlogCount)
+				return
+			}
+			if bytes.EqualFold(opt, argSecondsBytes) {
+				seconds = n
+			} else {
+				count = n
+			}
+		}
+		err = admin.StartCapture(time.Duration(seconds)*time.Second, int(count), replies)
+	default:
+		r.replyLocal(respError, errProxySubCmd)
+		return
+	}
+	if err != nil {
+		msg := strings.NewReplacer("\r", " ", "\n", " ").Replace(err.Error())
+		r.replyLocal(respError, append(append([]byte{}, errProxyCapture...), msg...))
+		return
+	}
+	r.replyLocal(respString, justOkBytes)
+}
@@ -0,0 +1,169 @@
+package redis
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ducesoft/overlord/proxy/proto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type mockSlowlog struct {
+	entries []*proto.SlowlogEntry
+}
+
+func (s *mockSlowlog) Get(n int) []*proto.SlowlogEntry {
+	if n < 0 || n > len(s.entries) {
+		n = len(s.entries)
+	}
+	return s.entries[:n]
+}
+
+func (s *mockSlowlog) Len() int { return len(s.entries) }
+
+func (s *mockSlowlog) Reset() { s.entries = nil }
+
+type mockAdmin struct {
+	mockInfoer
+	slowlog    *mockSlowlog
+	reloadErr  error
+	migrateErr error
+	captureErr error
+
+	captureDur     time.Duration
+	captureCount   int
+	captureReplies bool
+	captureStopped bool
+}
+
+func (*mockAdmin) NodeStates() []*proto.NodeState {
+	return []*proto.NodeState{{Addr: "127.0.0.1:6379", Alias: "node1", Status: proto.NodeStatusOK}}
+}
+
+func (*mockAdmin) Config() []proto.InfoField {
+	return []proto.InfoField{{Key: "name", Value: "test"}}
+}
+
+func (a *mockAdmin) Reload() error { return a.reloadErr }
+
+func (a *mockAdmin) FinishMigration() error { return a.migrateErr }
+
+func (a *mockAdmin) StartCapture(d time.Duration, count int, replies bool) error {
+	a.captureDur, a.captureCount, a.captureReplies = d, count, replies
+	return a.captureErr
+}
+
+func (a *mockAdmin) StopCapture() error {
+	a.captureStopped = true
+	return a.captureErr
+}
+
+func (a *mockAdmin) Slowlog() proto.SlowlogStore { return a.slowlog }
+
+func (*mockAdmin) HotKeys(n int) []*proto.HotKey {
+	hks := []*proto.HotKey{{Key: "a", Count: 100, Node: "127.0.0.1:6379"}, {Key: "b", Count: 10, Node: "127.0.0.1:6380"}}
+	if n < 0 || n > len(hks) {
+		n = len(hks)
+	}
+	return hks[:n]
+}
+
+func TestDecodeSlowlog(t *testing.T) {
+	start := time.Unix(1500000000, 0)
+	admin := &mockAdmin{slowlog: &mockSlowlog{entries: []*proto.SlowlogEntry{
+		{ID: 2, Cluster: "test", StartTime: start, TotalDur: 2 * time.Millisecond, Addr: "127.0.0.1:6379", Cmd: []string{"GET", "a"}},
+		{ID: 1, Cluster: "test", StartTime: start, TotalDur: time.Millisecond, Subs: []*proto.SlowlogEntry{
+			{TotalDur: 900 * time.Microsecond, RemoteDur: 800 * time.Microsecond, Addr: "127.0.0.1:6380", Cmd: []string{"GET", "b"}},
+		}},
+	}}}
+	data := "SLOWLOG LEN\r\nSLOWLOG GET 1\r\nSLOWLOG GET\r\nSLOWLOG GET a\r\nSLOWLOG RESET\r\nSLOWLOG LEN\r\nSLOWLOG NOSUCH\r\n"
+	replies := _localReplies(t, data, admin, (*ProxyConn).WithAdminCommands)
+	assert.Len(t, replies, 7)
+	assert.Equal(t, ":2\r\n", replies[0])
+	assert.Equal(t, "*1\r\n*6\r\n:2\r\n:1500000000\r\n:2000\r\n*2\r\n$3\r\nGET\r\n$1\r\na\r\n$14\r\n127.0.0.1:6379\r\n$4\r\ntest\r\n", replies[1])
+	assert.Contains(t, replies[2], "*7\r\n:1\r\n:1500000000\r\n:1000\r\n*2\r\n$3\r\nGET\r\n$1\r\nb\r\n$0\r\n\r\n$4\r\ntest\r\n*1\r\n*4\r\n:900\r\n:800\r\n*2\r\n$3\r\nGET\r\n$1\r\nb\r\n$14\r\n127.0.0.1:6380\r\n")
+	assert.Equal(t, "-"+string(errSlowlogCount)+"\r\n", replies[3])
+	assert.Equal(t, "+OK\r\n", replies[4])
+	assert.Equal(t, ":0\r\n", replies[5])
+	assert.Equal(t, "-"+string(errSlowlogSubCmd)+"\r\n", replies[6])
+}
+
+func TestEncodeSlowlogArgs(t *testing.T) {
+	args := make([]string, slowlogMaxArgs+8)
+	for i := range args {
+		args[i] = "a"
+	}
+	r := &resp{}
+	encodeSlowlogArgs(r, args)
+	assert.Equal(t, slowlogMaxArgs, r.arraySize)
+	assert.Equal(t, "22\r\n... (9 more arguments)", string(r.array[slowlogMaxArgs-1].data))
+}
+
+func TestDecodeProxy(t *testing.T) {
+	admin := &mockAdmin{slowlog: &mockSlowlog{}, reloadErr: errors.New("bad\nconf")}
+	data := "PROXY NODES\r\nPROXY CONFIG\r\nPROXY RELOAD\r\nPROXY NOSUCH\r\n"
+	replies := _localReplies(t, data, admin, (*ProxyConn).WithAdminCommands)
+	assert.Len(t, replies, 4)
+	assert.Equal(t, "*1\r\n*4\r\n$14\r\n127.0.0.1:6379\r\n$5\r\nnode1\r\n$0\r\n\r\n$2\r\nok\r\n", replies[0])
+	assert.Equal(t, "*2\r\n$4\r\nname\r\n$4\r\ntest\r\n", replies[1])
+	assert.Equal(t, "-"+string(errProxyReload)+"bad conf\r\n", replies[2])
+	assert.Equal(t, "-"+string(errProxySubCmd)+"\r\n", replies[3])
+
+	replies = _localReplies(t, "PROXY HOTKEYS\r\nPROXY HOTKEYS 1\r\nPROXY HOTKEYS x\r\nPROXY NODES 1\r\n", admin)
+	assert.Len(t, replies, 4)
+	assert.Equal(t, "*2\r\n*3\r\n$1\r\na\r\n:100\r\n$14\r\n127.0.0.1:6379\r\n*3\r\n$1\r\nb\r\n:10\r\n$14\r\n127.0.0.1:6380\r\n", replies[0])
+	assert.Equal(t, "*1\r\n*3\r\n$1\r\na\r\n:100\r\n$14\r\n127.0.0.1:6379\r\n", replies[1])
+	assert.Equal(t, "-"+string(errSlowlogCount)+"\r\n", replies[2])
+	assert.Equal(t, "-"+string(errProxySubCmd)+"\r\n", replies[3])
+
+	replies = _localReplies(t, "PROXY MIGRATE finish\r\nPROXY MIGRATE\r\nPROXY MIGRATE START\r\n", admin, (*ProxyConn).WithAdminCommands)
+	assert.Len(t, replies, 3)
+	assert.Equal(t, "+OK\r\n", replies[0])
+	assert.Equal(t, "-"+string(errProxySubCmd)+"\r\n", replies[1])
+	assert.Equal(t, "-"+string(errProxySubCmd)+"\r\n", replies[2])
+	admin.migrateErr = errors.New("not migrating")
+	replies = _localReplies(t, "PROXY MIGRATE FINISH\r\n", admin, (*ProxyConn).WithAdminCommands)
+	assert.Equal(t, "-"+string(errProxyMigrate)+"not migrating\r\n", replies[0])
+
+	replies = _localReplies(t, "PROXY CAPTURE start SECONDS 10 count 100 REPLIES\r\nPROXY CAPTURE STOP\r\n", admin, (*ProxyConn).WithAdminCommands)
+	assert.Len(t, replies, 2)
+	assert.Equal(t, "+OK\r\n", replies[0])
+	assert.Equal(t, "+OK\r\n", replies[1])
+	assert.Equal(t, 10*time.Second, admin.captureDur)
+	assert.Equal(t, 100, admin.captureCount)
+	assert.True(t, admin.captureReplies)
+	assert.True(t, admin.captureStopped)
+	replies = _localReplies(t, "PROXY CAPTURE\r\nPROXY CAPTURE START SECONDS\r\nPROXY CAPTURE START COUNT -1\r\nPROXY CAPTURE STOP 1\r\n", admin, (*ProxyConn).WithAdminCommands)
+	assert.Len(t, replies, 4)
+	assert.Equal(t, "-"+string(errProxySubCmd)+"\r\n", replies[0])
+	assert.Equal(t, "-"+string(errProxySubCmd)+"\r\n", replies[1])
+	assert.Equal(t, "-"+string(errSlowlogCount)+"\r\n", replies[2])
+	assert.Equal(t, "-"+string(errProxySubCmd)+"\r\n", replies[3])
+	admin.captureErr = errors.New("capture is running")
+	replies = _localReplies(t, "PROXY CAPTURE START\r\n", admin, (*ProxyConn).WithAdminCommands)
+	assert.Equal(t, "-"+string(errProxyCapture)+"capture is running\r\n", replies[0])
+
+	replies = _localReplies(t, "PROXY NODES\r\nSLOWLOG LEN\r\n", &mockInfoer{})
+	assert.Equal(t, "-"+string(errProxyNoAdmin)+"\r\n", replies[0])
+	assert.Equal(t, ":0\r\n", replies[1])
+}
+
+func TestDecodeAdminDisabled(t *testing.T) {
+	admin := &mockAdmin{slowlog: &mockSlowlog{entries: []*proto.SlowlogEntry{{ID: 1, Cmd: []string{"GET", "a"}}}}}
+	data := "PROXY RELOAD\r\nPROXY MIGRATE FINISH\r\nPROXY CAPTURE START\r\nPROXY CAPTURE STOP\r\nSLOWLOG RESET\r\n" +
+		"PROXY NODES\r\nPROXY CONFIG\r\nPROXY HOTKEYS 1\r\nSLOWLOG LEN\r\nSLOWLOG GET\r\n"
+	replies := _localReplies(t, data, admin)
+	assert.Len(t, replies, 10)
+	for _, reply := range replies[:5] {
+		assert.Equal(t, "-"+string(errAdminDisabled)+"\r\n", reply)
+	}
+	assert.Equal(t, time.Duration(0), admin.captureDur)
+	assert.False(t, admin.captureStopped)
+	assert.Len(t, admin.slowlog.entries, 1)
+	for _, reply := range replies[5:] {
+		assert.NotEqual(t, '-', reply[0], reply)
+	}
+	assert.Equal(t, ":1\r\n", replies[8])
+}
@@ -0,0 +1,497 @@
+package redis
+
+import (
+	"bytes"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/ducesoft/overlord/pkg/conv"
+	"github.com/ducesoft/overlord/proxy/proto"
+
+	"github.com/pkg/errors"
+)
+
+type algebraOp uint8
+
+const (
+	algebraUnion algebraOp = iota
+	algebraInter
+	algebraDiff
+)
+
+type algebraAggregate uint8
+
+const (
+	aggregateSum algebraAggregate = iota
+	aggregateMin
+	aggregateMax
+)
+
+var (
+	cmdSUnionBytes      = []byte("6\r\nSUNION")
+	cmdSInterBytes      = []byte("6\r\nSINTER")
+	cmdSDiffBytes       = []byte("5\r\nSDIFF")
+	cmdSUnionStoreBytes = []byte("11\r\nSUNIONSTORE")
+	cmdSInterStoreBytes = []byte("11\r\nSINTERSTORE")
+	cmdSDiffStoreBytes  = []byte("10\r\nSDIFFSTORE")
+	cmdZUnionBytes      = []byte("6\r\nZUNION")
+	cmdZInterBytes      = []byte("6\r\nZINTER")
+
+	argWeightsBytes    = []byte("WEIGHTS")
+	argAggregateBytes  = []byte("AGGREGATE")
+	argWithScoresBytes = []byte("WITHSCORES")
+	argSumBytes        = []byte("SUM")
+	argMinBytes        = []byte("MIN")
+	argMaxBytes        = []byte("MAX")
+
+	// NOTE: SRANDMEMBER with positive count replies distinct members no more than count,
+	// so at most limit+1 members of each key are read from node.
+	algebraSetFetchScript  = []byte("local r = {} for i, k in ipairs(KEYS) do r[i] = redis.call('SRANDMEMBER', k, ARGV[1]) end return r")
+	algebraZSetFetchScript = []byte("local r = {} for i, k in ipairs(KEYS) do r[i] = redis.call('ZRANGE', k, 0, ARGV[1], 'WITHSCORES') end return r")
+	algebraSetStoreScript  = []byte("redis.call('DEL', KEYS[1]) for i = 1, #ARGV, 1000 do redis.call('SADD', KEYS[1], unpack(ARGV, i, math.min(i + 999, #ARGV))) end return #ARGV")
+	algebraZSetStoreScript = []byte("redis.call('DEL', KEYS[1]) for i = 1, #ARGV, 1000 do redis.call('ZADD', KEYS[1], unpack(ARGV, i, math.min(i + 999, #ARGV))) end return #ARGV / 2")
+
+	errAlgebraLimit = []byte("ERR the members of key exceed the limit of proxy")
+	errAlgebraReply = []byte("ERR bad reply of members from node")
+)
+
+// algebra is the set or sorted set algebra across nodes which is computed by
+// proxy, eg: SUNION, SINTERSTORE and ZUNION.
+type algebra struct {
+	op   algebraOp
+	zset bool
+	// dest is the destination key of STORE, nil if not STORE.
+	dest       []byte
+	keys       [][]byte
+	weights    []float64
+	aggregate  algebraAggregate
+	withScores bool
+	// limit is the max members of each key.
+	limit int
+
+	// store is the followup message which writes the result into dest.
+	store *proto.Message
+	// errReply is the error replied before store.
+	errReply []byte
+}
+
+// algebraMember is the member of result, score is only for sorted set.
+type algebraMember struct {
+	member *resp
+	score  float64
+}
+
+func isAlgebra(cmd []byte) bool {
+	return bytes.Equal(cmd, cmdSUnionBytes) || bytes.Equal(cmd, cmdSInterBytes) || bytes.Equal(cmd, cmdSDiffBytes) ||
+		bytes.Equal(cmd, cmdSUnionStoreBytes) || bytes.Equal(cmd, cmdSInterStoreBytes) || bytes.Equal(cmd, cmdSDiffStoreBytes) ||
+		isZAlgebra(cmd)
+}
+
+func isZAlgebra(cmd []byte) bool {
+	return bytes.Equal(cmd, cmdZUnionBytes) || bytes.Equal(cmd, cmdZInterBytes) ||
+		bytes.Equal(cmd, cmdZUnionStoreBytes) || bytes.Equal(cmd, cmdZInterStoreBytes)
+}
+
+// zalgebraKeys returns the keys of ZUNION|ZINTER numkeys key [key ...] and
+// ZUNIONSTORE|ZINTERSTORE destination numkeys key [key ...].
+func zalgebraKeys(keys [][]byte, r *resp) [][]byte {
+	cmd := r.array[0].data
+	nk := 1
+	if bytes.Equal(cmd, cmdZUnionStoreBytes) || bytes.Equal(cmd, cmdZInterStoreBytes) {
+		keys = append(keys, bulkData(r.array[1]))
+		nk = 2
+	}
+	if r.arraySize <= nk+1 {
+		if len(keys) == 0 {
+			keys = append(keys, bulkData(r.array[1]))
+		}
+		return keys
+	}
+	num, err := conv.Btoi(bulkData(r.array[nk]))
+	if err != nil || num < 1 || int(num) > r.arraySize-nk-1 {
+		if len(keys) == 0 {
+			keys = append(keys, bulkData(r.array[1]))
+		}
+		return keys
+	}
+	for i := nk + 1; i < nk+1+int(num); i++ {
+		keys = append(keys, bulkData(r.array[i]))
+	}
+	return keys
+}
+
+// parseAlgebra parse r as the algebra computed by proxy, nil if r is not
+// algebra, the args are invalid or all the keys are routed to one node by
+// router, which are left to node.
+func parseAlgebra(r *resp, limit int, router proto.KeyRouter) (a *algebra) {
+	if limit <= 0 || r.arraySize < 2 {
+		return nil
+	}
+	cmd := r.array[0].data
+	if !isAlgebra(cmd) {
+		return nil
+	}
+	a = &algebra{limit: limit}
+	switch {
+	case bytes.Equal(cmd, cmdSUnionBytes), bytes.Equal(cmd, cmdSUnionStoreBytes), bytes.Equal(cmd, cmdZUnionBytes), bytes.Equal(cmd, cmdZUnionStoreBytes):
+		a.op = algebraUnion
+	case bytes.Equal(cmd, cmdSInterBytes), bytes.Equal(cmd, cmdSInterStoreBytes), bytes.Equal(cmd, cmdZInterBytes), bytes.Equal(cmd, cmdZInterStoreBytes):
+		a.op = algebraInter
+	default:
+		a.op = algebraDiff
+	}
+	store := bytes.Equal(cmd, cmdSUnionStoreBytes) || bytes.Equal(cmd, cmdSInterStoreBytes) || bytes.Equal(cmd, cmdSDiffStoreBytes) ||
+		bytes.Equal(cmd, cmdZUnionStoreBytes) || bytes.Equal(cmd, cmdZInterStoreBytes)
+	first := 1
+	if store {
+		if r.arraySize < 3 {
+			return nil
+		}
+		a.dest = append([]byte{}, bulkData(r.array[1])...)
+		first = 2
+	}
+	if !isZAlgebra(cmd) {
+		for i := first; i < r.arraySize; i++ {
+			a.keys = append(a.keys, append([]byte{}, bulkData(r.array[i])...))
+		}
+		return a.crossNode(router)
+	}
+	a.zset = true
+	if r.arraySize < first+2 {
+		return nil
+	}
+	num, err := conv.Btoi(bulkData(r.array[first]))
+	if err != nil || num < 1 || int(num) > r.arraySize-first-1 {
+		return nil
+	}
+	n := int(num)
+	for i := first + 1; i <= first+n; i++ {
+		a.keys = append(a.keys, append([]byte{}, bulkData(r.array[i])...))
+	}
+	for i := first + n + 1; i < r.arraySize; i++ {
+		arg := bulkData(r.array[i])
+		switch {
+		case bytes.EqualFold(arg, argWeightsBytes) && i+n < r.arraySize:
+			a.weights = make([]float64, n)
+			for j := 0; j < n; j++ {
+				i++
+				w, err := strconv.ParseFloat(string(bulkData(r.array[i])), 64)
+				if err != nil || math.IsNaN(w) {
+					return nil
+				}
+				a.weights[j] = w
+			}
+		case bytes.EqualFold(arg, argAggregateBytes) && i+1 < r.arraySize:
+			i++
+			agg := bulkData(r.array[i])
+			switch {
+			case bytes.EqualFold(agg, argSumBytes):
+				a.aggregate = aggregateSum
+			case bytes.EqualFold(agg, argMinBytes):
+				a.aggregate = aggregateMin
+			case bytes.EqualFold(agg, argMaxBytes):
+				a.aggregate = aggregateMax
+			default:
+				return nil
+			}
+		case bytes.EqualFold(arg, argWithScoresBytes) && !store:
+			a.withScores = true
+		default:
+			return nil
+		}
+	}
+	return a.crossNode(router)
+}
+
+// crossNode returns nil if a only touches one key, or all the keys and dest
+// are routed to one node by router, which is left to node.
+func (a *algebra) crossNode(router proto.KeyRouter) *algebra {
+	if len(a.keys) < 2 && a.dest == nil {
+		return nil
+	}
+	if router == nil {
+		return a
+	}
+	node := router.KeyNode(a.keys[0])
+	for _, key := range a.keys[1:] {
+		if router.KeyNode(key) != node {
+			return a
+		}
+	}
+	if a.dest != nil && router.KeyNode(a.dest) != node {
+		return a
+	}
+	return nil
+}
+
+// decodeAlgebra split the algebra into the fetch of each key by script, the
+// fetches of the same node are merged again by forwarder.
+func (pc *proxyConn) decodeAlgebra(msg *proto.Message, a *algebra) {
+	script, limit := algebraSetFetchScript, a.limit+1
+	if a.zset {
+		script, limit = algebraZSetFetchScript, a.limit
+	}
+	for _, key := range a.keys {
+		r := nextReq(msg)
+		r.mType = mergeTypeAlgebra
+		r.alg = a
+		r.resp.setArray()
+		r.resp.next().setPlain(respBulk, cmdEvalBytes)
+		r.resp.next().setBulk(script)
+		r.resp.next().setBulk([]byte("1"))
+		r.resp.next().setBulk(key)
+		r.resp.next().setBulk([]byte(strconv.Itoa(limit)))
+		r.resp.setArraySize()
+	}
+}
+
+// mergeAlgebra merge the keys of reqs into the fetch script of r as
+// EVAL script numkeys key [key ...] limit.
+func (r *Request) mergeAlgebra(reqs []proto.Request) {
+	limit := append([]byte{}, r.resp.array[r.resp.arraySize-1].data...)
+	r.resp.arraySize--
+	for i := range reqs {
+		req := reqs[i].(*Request)
+		req.merged = true
+		r.resp.next().copy(req.resp.array[3])
+	}
+	r.resp.next().setPlain(respBulk, limit)
+	r.resp.array[2].setBulk([]byte(strconv.Itoa(r.resp.arraySize - 4)))
+	r.resp.setArraySize()
+}
+
+// fetched returns the members of each key fetched by m, the error reply is
+// returned if any fetch failed or the members exceed the limit.
+func (a *algebra) fetched(m *proto.Message) (members map[string][]algebraMember, errReply []byte) {
+	members = make(map[string][]algebraMember, len(a.keys))
+	for _, mreq := range m.Requests() {
+		req := mreq.(*Request)
+		if req.merged {
+			continue
+		}
+		if req.reply.respType == respError {
+			return nil, req.reply.data
+		}
+		nkeys := req.resp.arraySize - 4
+		if req.reply.respType != respArray || req.reply.arraySize != nkeys {
+			return nil, errAlgebraReply
+		}
+		for i := 0; i < nkeys; i++ {
+			key := string(bulkData(req.resp.array[3+i]))
+			items := req.reply.array[i]
+			if items.respType != respArray {
+				return nil, errAlgebraReply
+			}
+			var ms []algebraMember
+			if a.zset {
+				if items.arraySize%2 != 0 {
+					return nil, errAlgebraReply
+				}
+				for j := 0; j < items.arraySize; j += 2 {
+					score, err := strconv.ParseFloat(string(bulkData(items.array[j+1])), 64)
+					if err != nil {
+						return nil, errAlgebraReply
+					}
+					ms = append(ms, algebraMember{member: items.array[j], score: score})
+				}
+			} else {
+				for j := 0; j < items.arraySize; j++ {
+					ms = append(ms, algebraMember{member: items.array[j], score: 1})
+				}
+			}
+			if len(ms) > a.limit {
+				return nil, errAlgebraLimit
+			}
+			members[key] = ms
+		}
+	}
+	return
+}
+
+// algebraState is the state of member when computing result.
+type algebraState struct {
+	// idx is the index of member in result.
+	idx int
+	// last is the index of the last key which has member.
+	last  int
+	count int
+}
+
+// result computes the result of a by the fetched members, sorted set is
+// ordered by score and member as redis.
+func (a *algebra) result(members map[string][]algebraMember) []algebraMember {
+	var (
+		result []algebraMember
+		states = map[string]*algebraState{}
+	)
+	for i, key := range a.keys {
+		weight := 1.0
+		if a.weights != nil {
+			weight = a.weights[i]
+		}
+		for _, m := range members[string(key)] {
+			score := m.score * weight
+			if math.IsNaN(score) {
+				score = 0
+			}
+			name := string(bulkData(m.member))
+			st, ok := states[name]
+			if !ok {
+				// NOTE: only union has the members which are not in the first key
+				if i > 0 && a.op != algebraUnion {
+					continue
+				}
+				states[name] = &algebraState{idx: len(result), last: i, count: 1}
+				result = append(result, algebraMember{member: m.member, score: score})
+				continue
+			}
+			if st.last == i {
+				continue
+			}
+			st.last = i
+			st.count++
+			result[st.idx].score = a.aggregateScore(result[st.idx].score, score)
+		}
+	}
+	filtered := result[:0]
+	for _, m := range result {
+		count := states[string(bulkData(m.member))].count
+		if (a.op == algebraInter && count != len(a.keys)) || (a.op == algebraDiff && count != 1) {
+			continue
+		}
+		filtered = append(filtered, m)
+	}
+	if a.zset {
+		sort.SliceStable(filtered, func(i, j int) bool {
+			if filtered[i].score != filtered[j].score {
+				return filtered[i].score < filtered[j].score
+			}
+			return bytes.Compare(bulkData(filtered[i].member), bulkData(filtered[j].member)) < 0
+		})
+	}
+	return filtered
+}
+
+func (a *algebra) aggregateScore(target, score float64) float64 {
+	switch a.aggregate {
+	case aggregateMin:
+		return math.Min(target, score)
+	case aggregateMax:
+		return math.Max(target, score)
+	}
+	if target += score; math.IsNaN(target) {
+		return 0
+	}
+	return target
+}
+
+// formatScore format score as redis, eg: 1.5, inf and -inf.
+func formatScore(score float64) []byte {
+	switch {
+	case math.IsInf(score, 1):
+		return []byte("inf")
+	case math.IsInf(score, -1):
+		return []byte("-inf")
+	}
+	return strconv.AppendFloat(nil, score, 'g', -1, 64)
+}
+
+// Followup impl the proto.Followuper and writes the results of algebra STORE
+// into the node of destination key.
+func (pc *proxyConn) Followup(msgs []*proto.Message) (fmsgs []*proto.Message) {
+	for _, m := range msgs {
+		if m.Err() != nil {
+			continue
+		}
+		req, ok := m.Request().(*Request)
+		if !ok || req.mType != mergeTypeAlgebra || req.alg.dest == nil {
+			continue
+		}
+		a := req.alg
+		members, errReply := a.fetched(m)
+		if errReply != nil {
+			a.errReply = errReply
+			continue
+		}
+		sr := getReq()
+		sr.resp.setArray()
+		sr.resp.next().setPlain(respBulk, cmdEvalBytes)
+		if a.zset {
+			sr.resp.next().setBulk(algebraZSetStoreScript)
+		} else {
+			sr.resp.next().setBulk(algebraSetStoreScript)
+		}
+		sr.resp.next().setBulk([]byte("1"))
+		sr.resp.next().setBulk(a.dest)
+		for _, member := range a.result(members) {
+			if a.zset {
+				sr.resp.next().setBulk(formatScore(member.score))
+			}
+			sr.resp.next().copy(member.member)
+		}
+		sr.resp.setArraySize()
+		sr.db = req.db
+		fm := proto.NewMessage()
+		fm.Type = m.Type
+		fm.WithRequest(sr)
+		fm.MarkStart()
+		a.store = fm
+		fmsgs = append(fmsgs, fm)
+	}
+	return
+}
+
+// mergeAlgebra reply the result of algebra, or the reply of STORE.
+func (pc *proxyConn) mergeAlgebra(m *proto.Message) (err error) {
+	req, ok := m.Request().(*Request)
+	if !ok {
+		return ErrBadAssert
+	}
+	a := req.alg
+	if a.dest != nil {
+		switch {
+		case a.errReply != nil:
+			return pc.encodeError(a.errReply)
+		case a.store == nil:
+			return pc.encodeError(errAlgebraReply)
+		case a.store.Err() != nil:
+			return pc.encodeError([]byte(errors.Cause(a.store.Err()).Error()))
+		}
+		return a.store.Request().(*Request).reply.encode(pc.bw)
+	}
+	members, errReply := a.fetched(m)
+	if errReply != nil {
+		return pc.encodeError(errReply)
+	}
+	result := a.result(members)
+	size := len(result)
+	if a.withScores {
+		size *= 2
+	}
+	_ = pc.bw.Write(respArrayBytes)
+	_ = pc.bw.Write([]byte(strconv.Itoa(size)))
+	if err = pc.bw.Write(crlfBytes); err != nil {
+		return
+	}
+	for _, member := range result {
+		if err = member.member.encode(pc.bw); err != nil {
+			return
+		}
+		if a.withScores {
+			// NOTE: new resp for each score because the data is referenced by writer until flush
+			score := &resp{}
+			score.setBulk(formatScore(member.score))
+			if err = score.encode(pc.bw); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+func (pc *proxyConn) encodeError(data []byte) error {
+	_ = pc.bw.Write(respErrorBytes)
+	_ = pc.bw.Write(data)
+	return pc.bw.Write(crlfBytes)
+}
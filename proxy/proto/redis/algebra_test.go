@@ -0,0 +1,227 @@
+package redis
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ducesoft/overlord/pkg/mockconn"
+	libnet "github.com/ducesoft/overlord/pkg/net"
+	"github.com/ducesoft/overlord/proxy/proto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type _algebraConn struct {
+	*ProxyConn
+	mconn *mockconn.MockConn
+}
+
+func _decodeAlgebra(t *testing.T, data string, limit int) (*_algebraConn, []*proto.Message) {
+	conn := libnet.NewConn(mockconn.CreateConn([]byte(data), 1), time.Second, time.Second)
+	pc := NewProxyConn(conn, true).(*ProxyConn)
+	pc.WithAlgebra(limit, nil)
+	nmsgs, err := pc.Decode(proto.GetMsgs(16))
+	assert.NoError(t, err)
+	return &_algebraConn{ProxyConn: pc, mconn: conn.Conn.(*mockconn.MockConn)}, nmsgs
+}
+
+// _replyAlgebra fill the fetch replies of m by members of each key.
+func _replyAlgebra(m *proto.Message, members map[string][]string) {
+	m.Batch()
+	for _, mreq := range m.Requests() {
+		req := mreq.(*Request)
+		if req.merged {
+			continue
+		}
+		req.reply.setArray()
+		for i := 3; i < req.resp.arraySize-1; i++ {
+			items := req.reply.next()
+			items.setArray()
+			for _, member := range members[string(bulkData(req.resp.array[i]))] {
+				items.next().setBulk([]byte(member))
+			}
+			items.setArraySize()
+		}
+		req.reply.setArraySize()
+	}
+}
+
+func _encodeAlgebra(t *testing.T, pc *_algebraConn, m *proto.Message) string {
+	pc.mconn.Wbuf.Reset()
+	assert.NoError(t, pc.Encode(m))
+	assert.NoError(t, pc.Flush())
+	return pc.mconn.Wbuf.String()
+}
+
+func TestParseAlgebra(t *testing.T) {
+	ts := []struct {
+		Name string
+		Args []string
+		Nil  bool
+	}{
+		{"disabled", []string{"SUNION", "a", "b"}, true},
+		{"single key", []string{"SUNION", "a"}, true},
+		{"not algebra", []string{"SMEMBERS", "a"}, true},
+		{"store single key", []string{"SUNIONSTORE", "d", "a"}, false},
+		{"bad numkeys", []string{"ZUNION", "3", "a", "b"}, true},
+		{"bad weights", []string{"ZUNION", "2", "a", "b", "WEIGHTS", "1", "nan"}, true},
+		{"bad aggregate", []string{"ZINTER", "2", "a", "b", "AGGREGATE", "AVG"}, true},
+		{"store withscores", []string{"ZUNIONSTORE", "d", "2", "a", "b", "WITHSCORES"}, true},
+		{"zunion", []string{"ZUNION", "2", "a", "b", "weights", "2", "3", "aggregate", "max", "withscores"}, false},
+	}
+	for i, tt := range ts {
+		t.Run(tt.Name, func(t *testing.T) {
+			limit := 10
+			if i == 0 {
+				limit = 0
+			}
+			r := newArrayResp(tt.Args...)
+			a := parseAlgebra(r, limit, nil)
+			assert.Equal(t, tt.Nil, a == nil)
+		})
+	}
+	a := parseAlgebra(newArrayResp("ZUNION", "2", "a", "b", "WEIGHTS", "2", "3", "AGGREGATE", "MAX", "WITHSCORES"), 10, nil)
+	assert.Equal(t, algebraUnion, a.op)
+	assert.True(t, a.zset)
+	assert.True(t, a.withScores)
+	assert.Equal(t, aggregateMax, a.aggregate)
+	assert.Equal(t, []float64{2, 3}, a.weights)
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("b")}, a.keys)
+
+	keys := zalgebraKeys(nil, newArrayResp("ZINTERSTORE", "d", "2", "a", "b", "WEIGHTS", "1", "2"))
+	assert.Equal(t, [][]byte{[]byte("d"), []byte("a"), []byte("b")}, keys)
+}
+
+// _keyRouter routes the key to the node of its first byte.
+type _keyRouter struct{}
+
+func (_keyRouter) KeyNode(key []byte) string {
+	return string(key[:1])
+}
+
+func TestParseAlgebraRouter(t *testing.T) {
+	ts := []struct {
+		Name string
+		Args []string
+		Nil  bool
+	}{
+		{"one node", []string{"SUNION", "a1", "a2"}, true},
+		{"cross node", []string{"SINTER", "a1", "b1"}, false},
+		{"store one node", []string{"SDIFFSTORE", "ad", "a1", "a2"}, true},
+		{"store dest cross node", []string{"SUNIONSTORE", "bd", "a1", "a2"}, false},
+		{"zunion one node", []string{"ZUNION", "2", "a1", "a2", "WITHSCORES"}, true},
+		{"zinterstore cross node", []string{"ZINTERSTORE", "ad", "2", "a1", "b1"}, false},
+	}
+	for _, tt := range ts {
+		t.Run(tt.Name, func(t *testing.T) {
+			a := parseAlgebra(newArrayResp(tt.Args...), 10, _keyRouter{})
+			assert.Equal(t, tt.Nil, a == nil)
+		})
+	}
+
+	conn := libnet.NewConn(mockconn.CreateConn([]byte("SUNION a1 a2\r\nSUNION a1 b1\r\n"), 1), time.Second, time.Second)
+	pc := NewProxyConn(conn, true).(*ProxyConn)
+	pc.WithAlgebra(10, _keyRouter{})
+	nmsgs, err := pc.Decode(proto.GetMsgs(16))
+	assert.NoError(t, err)
+	assert.Len(t, nmsgs, 2)
+	assert.False(t, nmsgs[0].IsBatch())
+	assert.Equal(t, mergeTypeNo, nmsgs[0].Request().(*Request).mType)
+	assert.True(t, nmsgs[1].IsBatch())
+	assert.Equal(t, mergeTypeAlgebra, nmsgs[1].Requests()[0].(*Request).mType)
+}
+
+func TestDecodeAlgebra(t *testing.T) {
+	_, nmsgs := _decodeAlgebra(t, "SINTER a b c\r\nSUNION a\r\n", 2)
+	assert.Len(t, nmsgs, 2)
+	assert.False(t, nmsgs[1].IsBatch())
+	assert.Equal(t, mergeTypeNo, nmsgs[1].Request().(*Request).mType)
+
+	reqs := nmsgs[0].Requests()
+	assert.Len(t, reqs, 3)
+	for i, key := range []string{"a", "b", "c"} {
+		req := reqs[i].(*Request)
+		assert.Equal(t, mergeTypeAlgebra, req.mType)
+		assert.Equal(t, key, string(req.Key()))
+		assert.Equal(t, "*5\r\n$4\r\nEVAL\r\n$"+strconv.Itoa(len(algebraSetFetchScript))+"\r\n"+string(algebraSetFetchScript)+"\r\n$1\r\n1\r\n$1\r\n"+key+"\r\n$1\r\n3\r\n", _encodeResp(t, req.resp))
+	}
+	// NOTE: merged again when in the same node
+	main := reqs[1].(*Request)
+	assert.NoError(t, main.Merge([]proto.Request{reqs[2]}))
+	assert.True(t, reqs[2].(*Request).merged)
+	assert.Equal(t, "*6\r\n$4\r\nEVAL\r\n$"+strconv.Itoa(len(algebraSetFetchScript))+"\r\n"+string(algebraSetFetchScript)+"\r\n$1\r\n2\r\n$1\r\nb\r\n$1\r\nc\r\n$1\r\n3\r\n", _encodeResp(t, main.resp))
+	assert.Len(t, main.Keys(), 2)
+}
+
+func TestEncodeSetAlgebra(t *testing.T) {
+	members := map[string][]string{"a": {"1", "2", "3"}, "b": {"2", "3", "4"}, "c": {"3", "5"}}
+	ts := []struct {
+		Name   string
+		Data   string
+		Expect string
+	}{
+		{"union", "SUNION a b c\r\n", "*5\r\n$1\r\n1\r\n$1\r\n2\r\n$1\r\n3\r\n$1\r\n4\r\n$1\r\n5\r\n"},
+		{"inter", "SINTER a b c\r\n", "*1\r\n$1\r\n3\r\n"},
+		{"inter empty", "SINTER a b nokey\r\n", "*0\r\n"},
+		{"diff", "SDIFF a b\r\n", "*1\r\n$1\r\n1\r\n"},
+		{"limit", "SUNION a b d\r\n", "-" + string(errAlgebraLimit) + "\r\n"},
+	}
+	members["d"] = []string{"1", "2", "3", "4"}
+	for _, tt := range ts {
+		t.Run(tt.Name, func(t *testing.T) {
+			pc, nmsgs := _decodeAlgebra(t, tt.Data, 3)
+			_replyAlgebra(nmsgs[0], members)
+			assert.Equal(t, tt.Expect, _encodeAlgebra(t, pc, nmsgs[0]))
+		})
+	}
+}
+
+func TestEncodeZSetAlgebra(t *testing.T) {
+	members := map[string][]string{"a": {"x", "1", "y", "2"}, "b": {"y", "3", "z", "inf"}}
+	ts := []struct {
+		Name   string
+		Data   string
+		Expect string
+	}{
+		{"union", "ZUNION 2 a b\r\n", "*3\r\n$1\r\nx\r\n$1\r\ny\r\n$1\r\nz\r\n"},
+		{"union withscores", "ZUNION 2 a b WITHSCORES\r\n", "*6\r\n$1\r\nx\r\n$1\r\n1\r\n$1\r\ny\r\n$1\r\n5\r\n$1\r\nz\r\n$3\r\ninf\r\n"},
+		{"inter weights max", "ZINTER 2 a b WEIGHTS 2 0.5 AGGREGATE MAX WITHSCORES\r\n", "*2\r\n$1\r\ny\r\n$1\r\n4\r\n"},
+		{"inter min", "ZINTER 2 a b AGGREGATE MIN WITHSCORES\r\n", "*2\r\n$1\r\ny\r\n$1\r\n2\r\n"},
+		{"nan", "ZUNION 2 a b WEIGHTS 1 0 WITHSCORES\r\n", "*6\r\n$1\r\nz\r\n$1\r\n0\r\n$1\r\nx\r\n$1\r\n1\r\n$1\r\ny\r\n$1\r\n2\r\n"},
+	}
+	for _, tt := range ts {
+		t.Run(tt.Name, func(t *testing.T) {
+			pc, nmsgs := _decodeAlgebra(t, tt.Data, 10)
+			_replyAlgebra(nmsgs[0], members)
+			assert.Equal(t, tt.Expect, _encodeAlgebra(t, pc, nmsgs[0]))
+		})
+	}
+}
+
+func TestFollowupAlgebraStore(t *testing.T) {
+	pc, nmsgs := _decodeAlgebra(t, "ZUNIONSTORE d 2 a b WEIGHTS 1 2\r\nSDIFFSTORE d a b\r\nSINTERSTORE d a b\r\nGET a\r\n", 10)
+	assert.Len(t, nmsgs, 4)
+	_replyAlgebra(nmsgs[0], map[string][]string{"a": {"x", "1"}, "b": {"x", "1.5", "y", "1"}})
+	_replyAlgebra(nmsgs[1], map[string][]string{"a": {"1", "2"}, "b": {"2"}})
+	// NOTE: the error of fetch is replied without store
+	nmsgs[2].Batch()
+	for _, mreq := range nmsgs[2].Requests() {
+		mreq.(*Request).reply.setPlain(respError, []byte("WRONGTYPE"))
+	}
+
+	fmsgs := pc.Followup(nmsgs)
+	assert.Len(t, fmsgs, 2)
+	zstore := fmsgs[0].Request().(*Request)
+	assert.Equal(t, "d", string(zstore.Key()))
+	assert.Equal(t, "*8\r\n$4\r\nEVAL\r\n$"+strconv.Itoa(len(algebraZSetStoreScript))+"\r\n"+string(algebraZSetStoreScript)+"\r\n$1\r\n1\r\n$1\r\nd\r\n$1\r\n2\r\n$1\r\ny\r\n$1\r\n4\r\n$1\r\nx\r\n", _encodeResp(t, zstore.resp))
+	store := fmsgs[1].Request().(*Request)
+	assert.Equal(t, "*5\r\n$4\r\nEVAL\r\n$"+strconv.Itoa(len(algebraSetStoreScript))+"\r\n"+string(algebraSetStoreScript)+"\r\n$1\r\n1\r\n$1\r\nd\r\n$1\r\n1\r\n", _encodeResp(t, store.resp))
+
+	zstore.reply.setInt(2)
+	fmsgs[1].WithError(proto.ErrCrossSlot)
+	assert.Equal(t, ":2\r\n", _encodeAlgebra(t, pc, nmsgs[0]))
+	assert.Equal(t, "-"+proto.ErrCrossSlot.Error()+"\r\n", _encodeAlgebra(t, pc, nmsgs[1]))
+	assert.Equal(t, "-WRONGTYPE\r\n", _encodeAlgebra(t, pc, nmsgs[2]))
+	proto.PutMsgs(fmsgs)
+}
@@ -0,0 +1,176 @@
+package redis
+
+import (
+	"fmt"
+
+	"github.com/ducesoft/overlord/pkg/conv"
+	"github.com/ducesoft/overlord/proxy/proto"
+
+	"github.com/pkg/errors"
+)
+
+// batchErrs returns the errors of requests of batch which can be replied
+// partially, eg: MGET. The request merged into a request which is failed or
+// replied an error by node is failed too. It returns nil if the message is
+// rejected or can't be replied partially.
+func batchErrs(m *proto.Message) (errs []error, failed int) {
+	if m.Rejected() || !m.IsBatch() {
+		return
+	}
+	reqs := m.Requests()
+	if req, ok := reqs[0].(*Request); !ok || (req.mType != mergeTypeOK && req.mType != mergeTypeJoin && req.mType != mergeTypeCount) {
+		return
+	}
+	idx := batchIndex(reqs)
+	errs = make([]error, len(reqs))
+	for i := range reqs {
+		errs[i] = m.SubErr(i)
+	}
+	for i, mreq := range reqs {
+		req := mreq.(*Request)
+		if req.merged {
+			continue
+		}
+		err := errs[i]
+		if err == nil && req.reply.respType == respError {
+			err = proto.NewReplyError(string(req.reply.data))
+			errs[i] = err
+		}
+		if err == nil {
+			continue
+		}
+		for _, br := range req.batch {
+			if j, ok := idx[br]; ok && errs[j] == nil {
+				errs[j] = err
+			}
+		}
+	}
+	for _, err := range errs {
+		if err != nil {
+			failed++
+		}
+	}
+	return
+}
+
+// batchIndex returns the index of requests in batch.
+func batchIndex(reqs []proto.Request) map[*Request]int {
+	idx := make(map[*Request]int, len(reqs))
+	for i, mreq := range reqs {
+		if req, ok := mreq.(*Request); ok {
+			idx[req] = i
+		}
+	}
+	return idx
+}
+
+// partialErr returns the error replied to the batch of which failed requests
+// are failed, the cause of first failed request is kept.
+func partialErr(errs []error, failed int) error {
+	var cause error
+	for _, err := range errs {
+		if err != nil {
+			cause = err
+			break
+		}
+	}
+	if cause == nil || failed == len(errs) {
+		return cause
+	}
+	return proto.NewReplyError(fmt.Sprintf("ERR partial failure: %d of %d keys failed: %s", failed, len(errs), errors.Cause(cause).Error()))
+}
+
+// joinValues splits the values replied to the request into the requests
+// merged into it in order, the failed requests have no value.
+func joinValues(reqs []proto.Request, errs []error) (vals [][]*resp, err error) {
+	idx := batchIndex(reqs)
+	vals = make([][]*resp, len(reqs))
+	for i, mreq := range reqs {
+		req, ok := mreq.(*Request)
+		if !ok {
+			return nil, ErrBadAssert
+		}
+		if req.merged || (errs != nil && errs[i] != nil) {
+			continue
+		}
+		if req.reply.respType != respArray {
+			vals[i] = []*resp{req.reply}
+			continue
+		}
+		all := req.reply.array[:req.reply.arraySize]
+		ops := len(req.batch) + 1
+		if ops == 1 || len(all)%ops != 0 {
+			// NOTE: the values can't be split, all belongs to the request itself.
+			vals[i] = all
+			continue
+		}
+		n := len(all) / ops
+		vals[i] = all[:n]
+		for j, br := range req.batch {
+			if k, ok := idx[br]; ok {
+				vals[k] = all[(j+1)*n : (j+2)*n]
+			}
+		}
+	}
+	return
+}
+
+// Unmerge returns the requests of each op of the request merged by Merge, eg:
+// MGET a b is returned as MGET a and MGET b, which are replied one by one and
+// joined back by MergeReplies. It returns nil if the request isn't merged or
+// can't be split.
+func (r *Request) Unmerge() []*Request {
+	if len(r.batch) == 0 || r.cmd != nil || (r.mType != mergeTypeOK && r.mType != mergeTypeJoin && r.mType != mergeTypeCount) {
+		return nil
+	}
+	own := newReq()
+	own.mType = r.mType
+	own.batchOpCount = r.batchOpCount
+	own.resp.setArray()
+	for i := 0; i <= r.batchOpCount && i < r.resp.arraySize; i++ {
+		own.resp.next().copy(r.resp.array[i])
+	}
+	own.resp.setArraySize()
+	reqs := make([]*Request, 0, len(r.batch)+1)
+	reqs = append(reqs, own)
+	return append(reqs, r.batch...)
+}
+
+// MergeReplies joins the replies of reqs returned by Unmerge into the reply of
+// r, as if r is replied by node at once.
+func (r *Request) MergeReplies(reqs []*Request) {
+	if r.mType == mergeTypeJoin {
+		r.reply.setArray()
+		for _, req := range reqs {
+			if req.reply.respType != respArray {
+				r.reply.next().copy(req.reply)
+				continue
+			}
+			for _, val := range req.reply.array[:req.reply.arraySize] {
+				r.reply.next().copy(val)
+			}
+		}
+		r.reply.setArraySize()
+		return
+	}
+	var sum int64
+	for _, req := range reqs {
+		if req.reply.respType == respError {
+			r.reply.copy(req.reply)
+			return
+		}
+		if r.mType == mergeTypeCount {
+			n, err := conv.Btoi(req.reply.data)
+			if err != nil {
+				r.reply.setPlain(respError, []byte(ErrBadCount.Error()))
+				return
+			}
+			sum += n
+		}
+	}
+	if r.mType == mergeTypeCount {
+		r.reply.setInt(sum)
+	} else {
+		r.reply.setPlain(respString, justOkBytes)
+	}
+}
@@ -0,0 +1,42 @@
+package redis
+
+import (
+	"testing"
+
+	"github.com/ducesoft/overlord/proxy/proto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnmergeReplies(t *testing.T) {
+	msg := _decodeMessage(t, "*4\r\n$4\r\nMGET\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n")[0]
+	subs := msg.Batch()
+	main := subs[0].Request().(*Request)
+	assert.Nil(t, main.Unmerge(), "not merged")
+	assert.NoError(t, main.Merge([]proto.Request{subs[1].Request(), subs[2].Request()}))
+
+	reqs := main.Unmerge()
+	assert.Len(t, reqs, 3)
+	assert.Equal(t, 2, reqs[0].resp.arraySize)
+	assert.Equal(t, "a", string(bulkData(reqs[0].resp.array[1])))
+	assert.Equal(t, subs[1].Request(), reqs[1])
+	reqs[0].reply = _bulks("va")
+	reqs[1].reply.setPlain(respError, []byte("ERR x"))
+	reqs[2].reply = _bulks("")
+	main.MergeReplies(reqs)
+	assert.Equal(t, 3, main.reply.arraySize)
+	assert.Equal(t, respError, main.reply.array[1].respType)
+
+	msg = _decodeMessage(t, "*3\r\n$3\r\nDEL\r\n$1\r\na\r\n$1\r\nb\r\n")[0]
+	subs = msg.Batch()
+	main = subs[0].Request().(*Request)
+	assert.NoError(t, main.Merge([]proto.Request{subs[1].Request()}))
+	reqs = main.Unmerge()
+	reqs[0].reply.setInt(1)
+	reqs[1].reply.setInt(1)
+	main.MergeReplies(reqs)
+	assert.Equal(t, ":2", string(main.reply.respType)+string(main.reply.data))
+	reqs[1].reply.setPlain(respError, []byte("TRYAGAIN"))
+	main.MergeReplies(reqs)
+	assert.Equal(t, "-TRYAGAIN", string(main.reply.respType)+string(main.reply.data))
+}
@@ -0,0 +1,38 @@
+package redis
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/ducesoft/overlord/proxy/proto"
+)
+
+// decodeBlocking mark the request which may block on node, it is sent on
+// the dedicated conn of client and the conn is released after reply.
+func (pc *proxyConn) decodeBlocking(r *Request, block time.Duration) {
+	r.blocking = true
+	r.block = block
+	r.sess = pc.block
+	r.sessOp = proto.SessionRelease
+}
+
+// decodeBLMove decode BLMOVE source destination LEFT|RIGHT LEFT|RIGHT timeout,
+// the timeout is seconds in float and it is checked by node.
+func decodeBLMove(pc *proxyConn, r *Request) {
+	var block time.Duration
+	if r.resp.arraySize == 6 {
+		if sec, err := strconv.ParseFloat(string(bulkData(r.resp.array[5])), 64); err == nil && sec > 0 {
+			block = time.Duration(sec * float64(time.Second))
+		}
+	}
+	pc.decodeBlocking(r, block)
+}
+
+// blockReadTimeout returns the read timeout of blocking request, the block
+// time is added to the read timeout of conn and 0 means blocking forever.
+func blockReadTimeout(timeout, block time.Duration) time.Duration {
+	if timeout == 0 || block == 0 {
+		return 0
+	}
+	return timeout + block
+}
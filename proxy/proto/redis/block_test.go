@@ -0,0 +1,33 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ducesoft/overlord/proxy/proto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeBLMove(t *testing.T) {
+	nmsgs := _decodeMessage(t, "BLMOVE a b LEFT RIGHT 1.5\r\nBLMOVE a b LEFT RIGHT 0\r\nLMOVE a b LEFT RIGHT\r\n")
+	assert.Len(t, nmsgs, 3)
+	req := nmsgs[0].Request().(*Request)
+	assert.True(t, req.blocking)
+	assert.Equal(t, 1500*time.Millisecond, req.block)
+	assert.True(t, proto.IsSession(nmsgs[0]))
+	assert.Len(t, req.Keys(), 2)
+
+	req = nmsgs[1].Request().(*Request)
+	assert.True(t, req.blocking)
+	assert.Equal(t, time.Duration(0), req.block)
+
+	assert.False(t, nmsgs[2].Request().(*Request).blocking)
+	assert.False(t, proto.IsSession(nmsgs[2]))
+}
+
+func TestBlockReadTimeout(t *testing.T) {
+	assert.Equal(t, time.Duration(0), blockReadTimeout(time.Second, 0))
+	assert.Equal(t, time.Duration(0), blockReadTimeout(0, time.Second))
+	assert.Equal(t, 3*time.Second, blockReadTimeout(time.Second, 2*time.Second))
+}
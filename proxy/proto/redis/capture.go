@@ -0,0 +1,42 @@
+package redis
+
+// AppendRequest impl the proto.Capturer, the request is appended as sent to
+// node after rewritten by proxy, eg: key_prefix. The requests replied by proxy,
+// bound to the client conn or computed by proxy are never captured.
+func (r *Request) AppendRequest(dst []byte) []byte {
+	if r.IsCtl() || !r.IsSupport() || r.isTxn() || r.sess != nil || r.blocking || r.alg != nil {
+		return dst
+	}
+	return r.resp.append(dst)
+}
+
+// AppendReply impl the proto.Capturer.
+func (r *Request) AppendReply(dst []byte) []byte {
+	return r.reply.append(dst)
+}
+
+// append appends the resp in the protocol into dst as encode, nothing is
+// appended if the resp is unknown.
+func (r *resp) append(dst []byte) []byte {
+	switch r.respType {
+	case respInt, respString, respError:
+		dst = append(dst, r.respType)
+		dst = append(dst, r.data...)
+	case respBulk, respArray:
+		dst = append(dst, r.respType)
+		if len(r.data) > 0 {
+			dst = append(dst, r.data...)
+		} else {
+			dst = append(dst, nullDataBytes...)
+		}
+	default:
+		return dst
+	}
+	dst = append(dst, crlfBytes...)
+	if r.respType == respArray {
+		for i := 0; i < r.arraySize; i++ {
+			dst = r.array[i].append(dst)
+		}
+	}
+	return dst
+}
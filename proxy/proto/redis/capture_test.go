@@ -0,0 +1,41 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ducesoft/overlord/pkg/mockconn"
+	libnet "github.com/ducesoft/overlord/pkg/net"
+	"github.com/ducesoft/overlord/proxy/proto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCaptureAppend(t *testing.T) {
+	conn := libnet.NewConn(mockconn.CreateConn([]byte("GET a\r\nPING\r\nMGET a b\r\nMULTI\r\n"), 1), time.Second, time.Second)
+	pc := NewProxyConn(conn, true)
+	nmsgs, err := pc.Decode(proto.GetMsgs(8))
+	assert.NoError(t, err)
+	assert.Len(t, nmsgs, 4)
+
+	req := nmsgs[0].Request().(*Request)
+	assert.Equal(t, "*2\r\n$3\r\nGET\r\n$1\r\na\r\n", string(req.AppendRequest(nil)))
+	assert.Len(t, nmsgs[1].Request().(*Request).AppendRequest(nil), 0)
+	var batch []byte
+	for _, sub := range nmsgs[2].Requests() {
+		batch = sub.(*Request).AppendRequest(batch)
+	}
+	assert.Equal(t, "*2\r\n$4\r\nMGET\r\n$1\r\na\r\n*2\r\n$4\r\nMGET\r\n$1\r\nb\r\n", string(batch))
+	assert.Len(t, nmsgs[3].Request().(*Request).AppendRequest(nil), 0)
+
+	assert.Len(t, req.AppendReply(nil), 0)
+	req.reply.setBulk(nil)
+	assert.Equal(t, "$-1\r\n", string(req.AppendReply(nil)))
+	req.reply.setArray()
+	req.reply.next().setBulk([]byte("hello"))
+	req.reply.next().setInt(1)
+	req.reply.next().setPlain(respError, []byte("ERR x"))
+	req.reply.setArraySize()
+	assert.Equal(t, "*3\r\n$5\r\nhello\r\n:1\r\n-ERR x\r\n", string(req.AppendReply(nil)))
+	assert.Equal(t, _encodeResp(t, req.reply), string(req.AppendReply(nil)))
+}
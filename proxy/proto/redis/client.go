@@ -0,0 +1,379 @@
+package redis
+
+import (
+	"bytes"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/ducesoft/overlord/pkg/conv"
+	libnet "github.com/ducesoft/overlord/pkg/net"
+	"github.com/ducesoft/overlord/pkg/types"
+	"github.com/ducesoft/overlord/proxy/proto"
+)
+
+const (
+	// redisVersion is the version of redis which proxy reports to client.
+	redisVersion = "6.0.0"
+
+	redisModeStandalone = "standalone"
+	redisModeCluster    = "cluster"
+
+	infoSectionServer   = "Server"
+	infoSectionAll      = "all"
+	infoSectionDefault  = "default"
+	infoSectionEveryone = "everything"
+)
+
+var (
+	cmdInfoBytes   = []byte("4\r\nINFO")
+	cmdHelloBytes  = []byte("5\r\nHELLO")
+	cmdClientBytes = []byte("6\r\nCLIENT")
+	cmdSelectBytes = []byte("6\r\nSELECT")
+
+	subSetNameBytes = []byte("7\r\nSETNAME")
+	subGetNameBytes = []byte("7\r\nGETNAME")
+	subIDBytes      = []byte("2\r\nID")
+	subSetInfoBytes = []byte("7\r\nSETINFO")
+	argAuthBytes    = []byte("4\r\nAUTH")
+
+	libNameBytes = []byte("LIB-NAME")
+	libVerBytes  = []byte("LIB-VER")
+	helloProto2  = []byte("2")
+
+	errClientSubCmd  = []byte("ERR unknown subcommand or wrong number of arguments for 'client' command")
+	errClientName    = []byte("ERR Client names cannot contain spaces, newlines or special characters.")
+	errClientSetInfo = []byte("ERR Unrecognized option")
+	errHelloNoProto  = []byte("NOPROTO unsupported protocol version")
+	errHelloProtoVer = []byte("ERR Protocol version is not an integer or out of range")
+	errHelloSyntax   = []byte("ERR Syntax error in HELLO option")
+	errHelloNoAuth   = []byte("ERR AUTH is not supported by proxy")
+	errSelectArgs    = []byte("ERR wrong number of arguments for 'select' command")
+	errSelectInvalid = []byte("ERR value is not an integer or out of range")
+	errSelectRange   = []byte("ERR DB index is out of range")
+)
+
+var clientID int64
+
+// client is the state of client conn which is replied by proxy itself,
+// eg: CLIENT SETNAME|GETNAME|ID|INFO, HELLO, INFO, SELECT, SLOWLOG and PROXY.
+type client struct {
+	id      int64
+	db      int
+	name    []byte
+	libName []byte
+	libVer  []byte
+	addr    string
+	laddr   string
+	ctime   time.Time
+	atime   time.Time
+
+	// databases is the number of db can be selected.
+	databases int
+	info      proto.Infoer
+	// adminCmds enable the admin commands which change the state of proxy,
+	// eg: PROXY RELOAD and SLOWLOG RESET.
+	adminCmds bool
+	// cmds is the command table of cluster.
+	cmds *Commands
+}
+
+func newClient(conn *libnet.Conn) *client {
+	c := &client{
+		id:        atomic.AddInt64(&clientID, 1),
+		ctime:     time.Now(),
+		databases: 1,
+		cmds:      defaultCommands,
+	}
+	c.atime = c.ctime
+	if conn != nil && conn.Conn != nil {
+		c.addr = addrString(conn.RemoteAddr())
+		c.laddr = addrString(conn.LocalAddr())
+	}
+	return c
+}
+
+func addrString(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	return addr.String()
+}
+
+// isLocal check whether the cmd is replied by client state.
+func (c *client) isLocal(cmd []byte) bool {
+	return bytes.Equal(cmd, cmdInfoBytes) || bytes.Equal(cmd, cmdCommandBytes) ||
+		bytes.Equal(cmd, cmdHelloBytes) || bytes.Equal(cmd, cmdClientBytes) ||
+		bytes.Equal(cmd, cmdSelectBytes) || bytes.Equal(cmd, cmdSlowlogBytes) ||
+		bytes.Equal(cmd, cmdProxyBytes)
+}
+
+func (c *client) decode(r *Request) {
+	cmd := r.resp.array[0].data
+	switch {
+	case bytes.Equal(cmd, cmdInfoBytes):
+		c.decodeInfo(r)
+	case bytes.Equal(cmd, cmdCommandBytes):
+		decodeCommand(r, c.cmds)
+	case bytes.Equal(cmd, cmdHelloBytes):
+		c.decodeHello(r)
+	case bytes.Equal(cmd, cmdClientBytes):
+		c.decodeClient(r)
+	case bytes.Equal(cmd, cmdSelectBytes):
+		c.decodeSelect(r)
+	case bytes.Equal(cmd, cmdSlowlogBytes):
+		c.decodeSlowlog(r)
+	case bytes.Equal(cmd, cmdProxyBytes):
+		c.decodeProxy(r)
+	}
+}
+
+// decodeSelect switch the db of client, the following requests are sent to
+// backend with the db and node conn selects it when its own db differs.
+func (c *client) decodeSelect(r *Request) {
+	if r.resp.arraySize != 2 {
+		r.replyLocal(respError, errSelectArgs)
+		return
+	}
+	db, err := conv.Btoi(bulkData(r.resp.array[1]))
+	if err != nil {
+		r.replyLocal(respError, errSelectInvalid)
+		return
+	}
+	if db < 0 || db >= int64(c.databases) {
+		r.replyLocal(respError, errSelectRange)
+		return
+	}
+	c.db = int(db)
+	r.replyLocal(respString, justOkBytes)
+}
+
+func (c *client) decodeClient(r *Request) {
+	if r.resp.arraySize < 2 {
+		r.replyLocal(respError, errClientSubCmd)
+		return
+	}
+	sub := r.resp.array[1].data
+	conv.UpdateToUpper(sub)
+	switch {
+	case bytes.Equal(sub, subSetNameBytes) && r.resp.arraySize == 3:
+		name := bulkData(r.resp.array[2])
+		if !validClientName(name) {
+			r.replyLocal(respError, errClientName)
+			return
+		}
+		c.name = append(c.name[:0], name...)
+		r.replyLocal(respString, justOkBytes)
+	case bytes.Equal(sub, subGetNameBytes) && r.resp.arraySize == 2:
+		r.local = true
+		if len(c.name) == 0 {
+			r.reply.setBulk(nil)
+			return
+		}
+		r.reply.setBulk(c.name)
+	case bytes.Equal(sub, subIDBytes) && r.resp.arraySize == 2:
+		r.local = true
+		r.reply.setInt(c.id)
+	case bytes.Equal(sub, subInfoBytes) && r.resp.arraySize == 2:
+		r.local = true
+		r.reply.setBulk([]byte(c.String() + "\n"))
+	case bytes.Equal(sub, subSetInfoBytes) && r.resp.arraySize == 4:
+		attr := bytes.ToUpper(bulkData(r.resp.array[2]))
+		val := bulkData(r.resp.array[3])
+		switch {
+		case bytes.Equal(attr, libNameBytes):
+			c.libName = append(c.libName[:0], val...)
+		case bytes.Equal(attr, libVerBytes):
+			c.libVer = append(c.libVer[:0], val...)
+		default:
+			r.replyLocal(respError, errClientSetInfo)
+			return
+		}
+		r.replyLocal(respString, justOkBytes)
+	default:
+		r.replyLocal(respError, errClientSubCmd)
+	}
+}
+
+func validClientName(name []byte) bool {
+	for _, b := range name {
+		if b < '!' || b > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns the client info in the format of CLIENT INFO.
+func (c *client) String() string {
+	now := time.Now()
+	var sb strings.Builder
+	sb.WriteString("id=")
+	sb.WriteString(strconv.FormatInt(c.id, 10))
+	sb.WriteString(" addr=")
+	sb.WriteString(c.addr)
+	sb.WriteString(" laddr=")
+	sb.WriteString(c.laddr)
+	sb.WriteString(" name=")
+	sb.Write(c.name)
+	sb.WriteString(" age=")
+	sb.WriteString(strconv.FormatInt(int64(now.Sub(c.ctime)/time.Second), 10))
+	sb.WriteString(" idle=")
+	sb.WriteString(strconv.FormatInt(int64(now.Sub(c.atime)/time.Second), 10))
+	sb.WriteString(" db=")
+	sb.WriteString(strconv.Itoa(c.db))
+	sb.WriteString(" lib-name=")
+	sb.Write(c.libName)
+	sb.WriteString(" lib-ver=")
+	sb.Write(c.libVer)
+	return sb.String()
+}
+
+// decodeHello reply HELLO [protover [AUTH username password] [SETNAME clientname]],
+// only RESP2 is supported.
+func (c *client) decodeHello(r *Request) {
+	args := r.resp.array[1:r.resp.arraySize]
+	if len(args) > 0 {
+		ver, err := conv.Btoi(bulkData(args[0]))
+		if err != nil || ver < 2 || ver > 3 {
+			r.replyLocal(respError, errHelloProtoVer)
+			return
+		}
+		if ver != 2 {
+			r.replyLocal(respError, errHelloNoProto)
+			return
+		}
+		args = args[1:]
+	}
+	var name []byte
+	for len(args) > 0 {
+		opt := args[0].data
+		conv.UpdateToUpper(opt)
+		switch {
+		case bytes.Equal(opt, argAuthBytes) && len(args) >= 3:
+			r.replyLocal(respError, errHelloNoAuth)
+			return
+		case bytes.Equal(opt, subSetNameBytes) && len(args) >= 2:
+			name = bulkData(args[1])
+			if !validClientName(name) {
+				r.replyLocal(respError, errClientName)
+				return
+			}
+			args = args[2:]
+		default:
+			r.replyLocal(respError, errHelloSyntax)
+			return
+		}
+	}
+	if name != nil {
+		c.name = append(c.name[:0], name...)
+	}
+	r.local = true
+	reply := r.reply
+	reply.setArray()
+	reply.next().setBulk([]byte("server"))
+	reply.next().setBulk([]byte("redis"))
+	reply.next().setBulk([]byte("version"))
+	reply.next().setBulk([]byte(redisVersion))
+	reply.next().setBulk([]byte("proto"))
+	reply.next().setPlain(respInt, helloProto2)
+	reply.next().setBulk([]byte("id"))
+	reply.next().setInt(c.id)
+	reply.next().setBulk([]byte("mode"))
+	reply.next().setBulk([]byte(c.mode()))
+	reply.next().setBulk([]byte("role"))
+	reply.next().setBulk([]byte("master"))
+	reply.next().setBulk([]byte("modules"))
+	modules := reply.next()
+	modules.setArray()
+	modules.setArraySize()
+	reply.setArraySize()
+}
+
+// mode returns the redis mode of proxy.
+func (c *client) mode() string {
+	if c.info == nil {
+		return redisModeStandalone
+	}
+	for _, s := range c.info.Info() {
+		if s.Name != infoSectionServer {
+			continue
+		}
+		if ct, ok := s.Get("cache_type"); ok && ct == string(types.CacheTypeRedisCluster) {
+			return redisModeCluster
+		}
+	}
+	return redisModeStandalone
+}
+
+// sections returns the proxy state with redis version and mode in server section.
+func (c *client) sections() []*proto.InfoSection {
+	server := &proto.InfoSection{
+		Name: infoSectionServer,
+		Fields: []proto.InfoField{
+			{Key: "redis_version", Value: redisVersion},
+			{Key: "redis_mode", Value: c.mode()},
+		},
+	}
+	sections := []*proto.InfoSection{server}
+	if c.info == nil {
+		return sections
+	}
+	for _, s := range c.info.Info() {
+		if s.Name == infoSectionServer {
+			server.Fields = append(server.Fields, s.Fields...)
+			continue
+		}
+		sections = append(sections, s)
+	}
+	return sections
+}
+
+// decodeInfo reply INFO [section [section ...]] by proxy state.
+func (c *client) decodeInfo(r *Request) {
+	var names []string
+	for _, arg := range r.resp.array[1:r.resp.arraySize] {
+		name := string(bulkData(arg))
+		if strings.EqualFold(name, infoSectionAll) || strings.EqualFold(name, infoSectionDefault) ||
+			strings.EqualFold(name, infoSectionEveryone) {
+			names = nil
+			break
+		}
+		names = append(names, name)
+	}
+	var buf bytes.Buffer
+	for _, s := range c.sections() {
+		if len(names) > 0 && !containsFold(names, s.Name) {
+			continue
+		}
+		if buf.Len() > 0 {
+			buf.WriteString("\r\n")
+		}
+		buf.WriteString("# ")
+		buf.WriteString(s.Name)
+		buf.WriteString("\r\n")
+		for _, f := range s.Fields {
+			buf.WriteString(f.Key)
+			buf.WriteByte(':')
+			buf.WriteString(f.Value)
+			buf.WriteString("\r\n")
+		}
+	}
+	data := buf.Bytes()
+	if data == nil {
+		data = emptyBytes
+	}
+	r.local = true
+	r.reply.setBulk(data)
+}
+
+func containsFold(names []string, name string) bool {
+	for _, n := range names {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
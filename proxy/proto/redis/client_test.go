@@ -0,0 +1,133 @@
+package redis
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ducesoft/overlord/pkg/mockconn"
+	libnet "github.com/ducesoft/overlord/pkg/net"
+	"github.com/ducesoft/overlord/proxy/proto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type mockInfoer struct{}
+
+func (*mockInfoer) Info() []*proto.InfoSection {
+	return []*proto.InfoSection{
+		{Name: "Server", Fields: []proto.InfoField{{Key: "cache_type", Value: "redis_cluster"}}},
+		{Name: "Nodes", Fields: []proto.InfoField{{Key: "node0", Value: "addr=127.0.0.1:6379,status=ok"}}},
+	}
+}
+
+// _localReplies decode and encode data by the same proxy conn, returns the replies.
+func _localReplies(t *testing.T, data string, info proto.Infoer, opts ...func(*ProxyConn)) []string {
+	conn := libnet.NewConn(mockconn.CreateConn([]byte(data), 1), time.Second, time.Second)
+	pc := NewProxyConn(conn, true)
+	if info != nil {
+		pc.(*ProxyConn).WithInfo(info)
+	}
+	for _, opt := range opts {
+		opt(pc.(*ProxyConn))
+	}
+	nmsgs, err := pc.Decode(proto.GetMsgs(16))
+	assert.NoError(t, err)
+	mconn := conn.Conn.(*mockconn.MockConn)
+	var replies []string
+	for _, msg := range nmsgs {
+		assert.True(t, msg.Request().(*Request).IsCtl())
+		mconn.Wbuf.Reset()
+		assert.NoError(t, pc.Encode(msg))
+		assert.NoError(t, pc.Flush())
+		replies = append(replies, mconn.Wbuf.String())
+	}
+	return replies
+}
+
+func TestDecodeClient(t *testing.T) {
+	data := "CLIENT GETNAME\r\nCLIENT SETNAME foo\r\nCLIENT GETNAME\r\n*3\r\n$6\r\nCLIENT\r\n$7\r\nSETNAME\r\n$3\r\na b\r\nclient setinfo lib-name baka\r\nCLIENT INFO\r\nCLIENT KILL\r\nCLIENT ID\r\n"
+	replies := _localReplies(t, data, nil)
+	assert.Len(t, replies, 8)
+	assert.Equal(t, "$-1\r\n", replies[0])
+	assert.Equal(t, "+OK\r\n", replies[1])
+	assert.Equal(t, "$3\r\nfoo\r\n", replies[2])
+	assert.Equal(t, "-"+string(errClientName)+"\r\n", replies[3])
+	assert.Equal(t, "+OK\r\n", replies[4])
+	assert.Contains(t, replies[5], " name=foo ")
+	assert.Contains(t, replies[5], " lib-name=baka ")
+	assert.Equal(t, "-"+string(errClientSubCmd)+"\r\n", replies[6])
+	assert.True(t, strings.HasPrefix(replies[7], ":"))
+}
+
+func TestDecodeSelect(t *testing.T) {
+	data := "SELECT 3\r\nSELECT\r\nSELECT a\r\nSELECT 16\r\nCLIENT INFO\r\n"
+	conn := libnet.NewConn(mockconn.CreateConn([]byte(data+"GET a\r\nMGET a b\r\n"), 1), time.Second, time.Second)
+	pc := NewProxyConn(conn, true)
+	pc.(*ProxyConn).WithDatabases(16)
+	nmsgs, err := pc.Decode(proto.GetMsgs(16))
+	assert.NoError(t, err)
+	assert.Len(t, nmsgs, 7)
+	expects := [][]byte{justOkBytes, errSelectArgs, errSelectInvalid, errSelectRange}
+	for i, expect := range expects {
+		req := nmsgs[i].Request().(*Request)
+		assert.True(t, req.IsCtl())
+		assert.Equal(t, expect, req.reply.data)
+	}
+	assert.Contains(t, string(nmsgs[4].Request().(*Request).reply.data), " db=3 ")
+	for _, msg := range nmsgs[5:] {
+		for _, req := range msg.Requests() {
+			assert.Equal(t, 3, req.(*Request).db)
+		}
+	}
+
+	nmsgs = _decodeMessage(t, "SELECT 1\r\nSELECT 0\r\n")
+	assert.Equal(t, errSelectRange, nmsgs[0].Request().(*Request).reply.data)
+	assert.Equal(t, justOkBytes, nmsgs[1].Request().(*Request).reply.data)
+}
+
+func TestDecodeHello(t *testing.T) {
+	data := "HELLO 3\r\nHELLO 4\r\nHELLO 2 AUTH a b\r\nHELLO 2 SETNAME bar\r\nCLIENT GETNAME\r\n"
+	replies := _localReplies(t, data, &mockInfoer{})
+	assert.Len(t, replies, 5)
+	assert.Equal(t, "-"+string(errHelloNoProto)+"\r\n", replies[0])
+	assert.Equal(t, "-"+string(errHelloProtoVer)+"\r\n", replies[1])
+	assert.Equal(t, "-"+string(errHelloNoAuth)+"\r\n", replies[2])
+	assert.True(t, strings.HasPrefix(replies[3], "*14\r\n$6\r\nserver\r\n$5\r\nredis\r\n"))
+	assert.Contains(t, replies[3], "$4\r\nmode\r\n$7\r\ncluster\r\n")
+	assert.Equal(t, "$3\r\nbar\r\n", replies[4])
+}
+
+func TestDecodeInfo(t *testing.T) {
+	data := "INFO\r\nINFO nodes\r\nINFO nothing\r\n"
+	replies := _localReplies(t, data, &mockInfoer{})
+	assert.Len(t, replies, 3)
+	assert.Contains(t, replies[0], "# Server\r\nredis_version:"+redisVersion+"\r\nredis_mode:cluster\r\ncache_type:redis_cluster\r\n")
+	assert.Contains(t, replies[0], "\r\n# Nodes\r\nnode0:addr=127.0.0.1:6379,status=ok\r\n")
+	assert.NotContains(t, replies[1], "# Server")
+	assert.Contains(t, replies[1], "# Nodes")
+	assert.Equal(t, "$0\r\n\r\n", replies[2])
+}
+
+func TestDecodeCommand(t *testing.T) {
+	data := "COMMAND COUNT\r\nCOMMAND INFO get nosuch\r\nCOMMAND GETKEYS mset a 1 b 2\r\nCOMMAND GETKEYS ping\r\nCOMMAND GETKEYS nosuch a\r\nCOMMAND DOCS get\r\nCOMMAND NOSUCH\r\nCOMMAND\r\n"
+	replies := _localReplies(t, data, nil)
+	assert.Len(t, replies, 8)
+	assert.Equal(t, ":"+strconv.Itoa(len(commands))+"\r\n", replies[0])
+	assert.Equal(t, "*2\r\n*6\r\n$3\r\nget\r\n:2\r\n*1\r\n+readonly\r\n:1\r\n:1\r\n:1\r\n*-1\r\n", replies[1])
+	assert.Equal(t, "*2\r\n$1\r\na\r\n$1\r\nb\r\n", replies[2])
+	assert.Equal(t, "-"+string(errCommandNoKeys)+"\r\n", replies[3])
+	assert.Equal(t, "-"+string(errCommandInvalid)+"\r\n", replies[4])
+	assert.Equal(t, "*2\r\n$3\r\nget\r\n*0\r\n", replies[5])
+	assert.Equal(t, "-"+string(errCommandSubCmd)+"\r\n", replies[6])
+	assert.True(t, strings.HasPrefix(replies[7], "*"+strconv.Itoa(len(commands))+"\r\n"))
+}
+
+func TestCommandTableCoverSupports(t *testing.T) {
+	for cmd := range reqSupportCmdMap {
+		name := cmd[strings.Index(cmd, "\r\n")+2:]
+		_, ok := commandMap[name]
+		assert.True(t, ok, "command %s not in command table", name)
+	}
+}
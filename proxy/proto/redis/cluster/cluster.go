@@ -4,6 +4,7 @@ import (
 	"bytes"
 	errs "errors"
 	"net"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -14,6 +15,9 @@ import (
 	"github.com/ducesoft/overlord/pkg/log"
 	libnet "github.com/ducesoft/overlord/pkg/net"
 	"github.com/ducesoft/overlord/proxy/proto"
+	"github.com/ducesoft/overlord/proxy/proto/redis"
+
+	"github.com/pkg/errors"
 )
 
 const (
@@ -25,7 +29,8 @@ const (
 
 // errors
 var (
-	ErrClusterClosed = errs.New("cluster executor already closed")
+	ErrClusterClosed   = errs.New("cluster executor already closed")
+	ErrRedirectTimeout = errs.New("cluster redirect timeout")
 )
 
 const (
@@ -49,7 +54,9 @@ type cluster struct {
 	hashTag       []byte
 
 	slotNode atomic.Value
-	action   chan struct{}
+	// lock serializes the updates of slotNode by fetching and redirecting.
+	lock   sync.Mutex
+	action chan struct{}
 
 	fakeNodesBytes []byte
 	fakeSlotsBytes []byte
@@ -57,20 +64,45 @@ type cluster struct {
 
 	state     int32
 	pipeCount int
+
+	// readPolicy routes the read only requests to replicas, which fall back
+	// to master when the replication lag exceeds replicaMaxLag.
+	readPolicy    proto.ReadPolicy
+	replicaMaxLag time.Duration
+	rr            uint32
+
+	// limits rejects the replies exceed the size limit.
+	limits *proto.Limits
+	// coalesce the identical reads in flight to the same node.
+	coalesce bool
+	// pipeQueue is the capacity of input chans of node pipe, pipeWait is the
+	// max duration to wait for the full input chan.
+	pipeQueue int
+	pipeWait  time.Duration
+	// scripts is the script cache shared by all the node conns.
+	scripts *redis.ScriptCache
 }
 
 // NewForwarder new proto Forwarder.
-func NewForwarder(name, listen string, servers []string, conns int32, pipeCount int, dto, rto, wto time.Duration, hashTag []byte) proto.Forwarder {
+func NewForwarder(name, listen string, servers []string, conns int32, pipeCount int, dto, rto, wto time.Duration, hashTag []byte,
+	readPolicy proto.ReadPolicy, replicaMaxLag time.Duration, limits *proto.Limits, coalesce bool, pipeQueue int, pipeWait time.Duration) proto.Forwarder {
 	c := &cluster{
-		name:      name,
-		servers:   servers,
-		conns:     conns,
-		dto:       dto,
-		rto:       rto,
-		wto:       wto,
-		hashTag:   hashTag,
-		action:    make(chan struct{}),
-		pipeCount: pipeCount,
+		name:          name,
+		servers:       servers,
+		conns:         conns,
+		dto:           dto,
+		rto:           rto,
+		wto:           wto,
+		hashTag:       hashTag,
+		action:        make(chan struct{}, 1), // NOTE: coalesce the fetches triggered in the meantime
+		pipeCount:     pipeCount,
+		readPolicy:    readPolicy,
+		limits:        limits,
+		coalesce:      coalesce,
+		pipeQueue:     pipeQueue,
+		pipeWait:      pipeWait,
+		replicaMaxLag: replicaMaxLag,
+		scripts:       redis.NewScriptCache(),
 	}
 	if !c.tryFetch() {
 		_ = c.Close()
@@ -79,6 +111,9 @@ func NewForwarder(name, listen string, servers []string, conns int32, pipeCount
 	}
 	c.fake(listen)
 	go c.fetchproc()
+	if readPolicy.ReadReplicas() {
+		go c.replicaproc()
+	}
 	return c
 }
 
@@ -86,22 +121,71 @@ func (c *cluster) Forward(msgs []*proto.Message) error {
 	if state := atomic.LoadInt32(&c.state); state == closed {
 		return ErrClusterClosed
 	}
+	var sessMsgs []*proto.Message
 	for _, m := range msgs {
-		if m.IsBatch() {
-			for _, subm := range m.Batch() {
-				ncp := c.getPipe(subm.Request().Key())
-				subm.MarkStartPipe()
-				ncp.Push(subm)
-			}
+		if proto.IsBroadcast(m) {
+			proto.Broadcast(m, c.allPipes())
+		} else if m.IsBatch() {
+			c.batchPush(m.Batch())
+		} else if proto.IsSession(m) {
+			sessMsgs = append(sessMsgs, m)
 		} else {
-			ncp := c.getPipe(m.Request().Key())
+			if mk, ok := m.Request().(proto.MultiKeyer); ok && len(mk.Keys()) > 1 {
+				if _, err := c.route(m); err != nil {
+					m.WithError(err)
+					continue
+				}
+			}
+			ncp := c.getPipe(m.Request())
 			m.MarkStartPipe()
 			ncp.Push(m)
 		}
 	}
+	proto.ForwardSession(sessMsgs, c.route, func(addr string) proto.NodeConn {
+		return newNodeConn(c, addr)
+	})
 	return nil
 }
 
+// batchPush merges the sub messages of the same slot into one request, eg:
+// MGET a b whose keys are of the same slot, and pushes it to the node of slot.
+// The slots are redirected independently when some of them are moved.
+func (c *cluster) batchPush(subs []*proto.Message) {
+	var (
+		slots = make(map[uint16][]*proto.Message)
+		order []uint16
+	)
+	for _, subm := range subs {
+		slot := c.slot(subm.Request().Key())
+		if _, ok := slots[slot]; !ok {
+			order = append(order, slot)
+		}
+		slots[slot] = append(slots[slot], subm)
+		subm.MarkStartPipe()
+	}
+	for _, slot := range order {
+		msgs := slots[slot]
+		mainMsg := msgs[0]
+		if len(msgs) > 1 {
+			reqs := make([]proto.Request, 0, len(msgs)-1)
+			for _, subm := range msgs[1:] {
+				reqs = append(reqs, subm.Request())
+			}
+			mainMsg.WithMerged(msgs[1:])
+			if err := mainMsg.Request().Merge(reqs); err != nil {
+				mainMsg.WithError(errors.WithStack(err))
+				continue
+			}
+		}
+		c.getPipe(mainMsg.Request()).Push(mainMsg)
+	}
+}
+
+// Scripts returns the script cache of cluster.
+func (c *cluster) Scripts() *redis.ScriptCache {
+	return c.scripts
+}
+
 // Don't support update backend server list now
 func (c *cluster) Update([]string) error {
 	return nil
@@ -117,20 +201,104 @@ func (c *cluster) Close() error {
 		for _, npc := range np.nodePipe {
 			npc.Close()
 		}
+		for _, r := range np.replicas {
+			r.close()
+		}
 		return nil
 	}
 	return nil
 }
 
-func (c *cluster) getPipe(key []byte) (ncp *proto.NodeConnPipe) {
-	realKey := c.trimHashTag(key)
-	crc := hashkit.Crc16(realKey) & musk
+// NodeStates impl the proto.NodeStater.
+func (c *cluster) NodeStates() (states []*proto.NodeState) {
+	sn, ok := c.slotNode.Load().(*slotNode)
+	if !ok || sn == nil {
+		return
+	}
+	addrs := make([]string, 0, len(sn.nSlots.nodes))
+	for addr := range sn.nSlots.nodes {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	for _, addr := range addrs {
+		n := sn.nSlots.nodes[addr]
+		state := &proto.NodeState{Addr: n.addr, Role: n.role, Status: proto.NodeStatusOK}
+		if ncp, ok := sn.nodePipe[addr]; ok {
+			state.Pipe = ncp.Stats()
+		}
+		if !n.isNormal() {
+			state.Status = proto.NodeStatusFail
+		}
+		states = append(states, state)
+	}
+	return
+}
+
+func (c *cluster) newNodeConnPipe(newNc func() proto.NodeConn) *proto.NodeConnPipe {
+	ncp := proto.NewNodeConnPipe(c.conns, c.pipeCount, c.pipeQueue, newNc)
+	if c.coalesce {
+		ncp.WithCoalesce()
+	}
+	if c.pipeWait > 0 {
+		ncp.WithWait(c.pipeWait)
+	}
+	return ncp
+}
+
+func (c *cluster) getPipe(req proto.Request) (ncp *proto.NodeConnPipe) {
 	sn := c.slotNode.Load().(*slotNode)
-	addr := sn.nSlots.slots[crc]
+	slot := c.slot(req.Key())
+	if r := c.readReplica(sn, slot, req); r != nil {
+		return r.ncp
+	}
+	addr := sn.nSlots.slots[slot]
 	ncp = sn.nodePipe[addr]
 	return
 }
 
+// KeyNode impl the proto.KeyRouter and returns the master addr of key's slot.
+func (c *cluster) KeyNode(key []byte) string {
+	sn, ok := c.slotNode.Load().(*slotNode)
+	if !ok || sn == nil {
+		return ""
+	}
+	return sn.nSlots.slots[c.slot(key)]
+}
+
+func (c *cluster) allPipes() (ncps []*proto.NodeConnPipe) {
+	sn := c.slotNode.Load().(*slotNode)
+	for _, ncp := range sn.nodePipe {
+		ncps = append(ncps, ncp)
+	}
+	return
+}
+
+// route returns the node addr of message, all the keys of message must
+// be hashed into the same slot.
+func (c *cluster) route(m *proto.Message) (addr string, err error) {
+	req := m.Request()
+	keys := [][]byte{req.Key()}
+	if mk, ok := req.(proto.MultiKeyer); ok {
+		if mkeys := mk.Keys(); len(mkeys) > 0 {
+			keys = mkeys
+		}
+	}
+	slot := c.slot(keys[0])
+	for _, key := range keys[1:] {
+		if c.slot(key) != slot {
+			err = proto.ErrCrossSlot
+			return
+		}
+	}
+	sn := c.slotNode.Load().(*slotNode)
+	addr = sn.nSlots.slots[slot]
+	return
+}
+
+func (c *cluster) slot(key []byte) uint16 {
+	return hashkit.Crc16(c.trimHashTag(key)) & musk
+}
+
 func (c *cluster) trimHashTag(key []byte) []byte {
 	if len(c.hashTag) != 2 {
 		return key
@@ -193,6 +361,8 @@ func (c *cluster) tryFetch() bool {
 }
 
 func (c *cluster) initSlotNode(nSlots *nodeSlots) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
 	osn, ok := c.slotNode.Load().(*slotNode) // old slotNode
 	oncp := map[string]*proto.NodeConnPipe{} // old nodeConn
 	if ok && osn != nil {
@@ -206,13 +376,9 @@ func (c *cluster) initSlotNode(nSlots *nodeSlots) {
 	for _, addr := range masters {
 		ncp, ok := oncp[addr]
 		if !ok {
-			toAddr := addr // NOTE: avoid closure
-			ncp = proto.NewNodeConnPipe(c.conns, c.pipeCount, func() proto.NodeConn {
-				return newNodeConn(c, toAddr)
-			})
-			go c.pipeEvent(ncp.ErrorEvent())
+			ncp = c.newPipe(addr)
 			if log.V(4) {
-				log.Infof("Redis Cluster renew slot node and add addr:%s", toAddr)
+				log.Infof("Redis Cluster renew slot node and add addr:%s", addr)
 			}
 		} else {
 			delete(oncp, addr)
@@ -227,6 +393,7 @@ func (c *cluster) initSlotNode(nSlots *nodeSlots) {
 			return
 		}
 	}
+	c.initReplicas(sn, osn)
 	c.servers = masters
 	c.slotNode.Store(sn)
 	for addr, ncp := range oncp {
@@ -237,6 +404,105 @@ func (c *cluster) initSlotNode(nSlots *nodeSlots) {
 	}
 }
 
+// newPipe new the node pipe to master addr.
+func (c *cluster) newPipe(addr string) *proto.NodeConnPipe {
+	ncp := c.newNodeConnPipe(func() proto.NodeConn {
+		return newNodeConn(c, addr)
+	})
+	go c.pipeEvent(ncp.ErrorEvent())
+	return ncp
+}
+
+// moved updates the slot to addr replied by MOVED at once, and returns the
+// pipe of addr which is created if absent. The slots are fetched again in
+// background to catch up with the other changes of cluster. It returns nil
+// if the cluster isn't fetched yet or already closed.
+func (c *cluster) moved(slot int, addr string) *proto.NodeConnPipe {
+	defer c.toFetch()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	sn, ok := c.slotNode.Load().(*slotNode)
+	if !ok || sn == nil || atomic.LoadInt32(&c.state) == closed || slot < 0 || slot >= len(sn.nSlots.slots) {
+		return nil
+	}
+	if ncp, ok := sn.nodePipe[addr]; ok && sn.nSlots.slots[slot] == addr {
+		return ncp
+	}
+	nsn := sn.copy()
+	nsn.nSlots.slots[slot] = addr
+	ncp := nsn.addPipe(c, addr)
+	c.slotNode.Store(nsn)
+	if log.V(4) {
+		log.Infof("Redis Cluster slot:%d moved to addr:%s", slot, addr)
+	}
+	return ncp
+}
+
+// askPipe returns the pipe of addr replied by ASK, which is created if absent
+// but the slot is kept until the migration is finished and fetched.
+func (c *cluster) askPipe(addr string) *proto.NodeConnPipe {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	sn, ok := c.slotNode.Load().(*slotNode)
+	if !ok || sn == nil || atomic.LoadInt32(&c.state) == closed {
+		return nil
+	}
+	if ncp, ok := sn.nodePipe[addr]; ok {
+		return ncp
+	}
+	nsn := sn.copy()
+	ncp := nsn.addPipe(c, addr)
+	c.slotNode.Store(nsn)
+	return ncp
+}
+
+// nodePipe returns the pipe of master or replica addr, nil if absent.
+func (c *cluster) nodePipe(addr string) *proto.NodeConnPipe {
+	sn, ok := c.slotNode.Load().(*slotNode)
+	if !ok || sn == nil || atomic.LoadInt32(&c.state) == closed {
+		return nil
+	}
+	if ncp, ok := sn.nodePipe[addr]; ok {
+		return ncp
+	}
+	if r, ok := sn.replicas[addr]; ok {
+		return r.ncp
+	}
+	return nil
+}
+
+// initReplicas keep the replicas of old slotNode and add the new ones.
+func (c *cluster) initReplicas(sn, osn *slotNode) {
+	if !c.readPolicy.ReadReplicas() {
+		return
+	}
+	ors := map[string]*replica{}
+	if osn != nil {
+		for addr, r := range osn.replicas {
+			ors[addr] = r // COPY
+		}
+	}
+	sn.replicas = make(map[string]*replica)
+	for _, addr := range sn.nSlots.getReplicas() {
+		r, ok := ors[addr]
+		if !ok {
+			r = newReplica(c, addr)
+			if log.V(4) {
+				log.Infof("Redis Cluster renew slot node and add replica addr:%s", addr)
+			}
+		} else {
+			delete(ors, addr)
+		}
+		sn.replicas[addr] = r
+	}
+	for addr, r := range ors {
+		r.close()
+		if log.V(4) {
+			log.Infof("Redis Cluster renew slot node and close replica addr:%s", addr)
+		}
+	}
+}
+
 func (c *cluster) pipeEvent(errCh <-chan error) {
 	for {
 		err, ok := <-errCh
@@ -291,4 +557,37 @@ func (c *cluster) fake(listen string) {
 type slotNode struct {
 	nSlots   *nodeSlots
 	nodePipe map[string]*proto.NodeConnPipe
+	// replicas is the slaves which serve the read only requests.
+	replicas map[string]*replica
+}
+
+// copy returns the copy of slotNode which can be updated without affecting
+// the readers of sn, the replicas are shared.
+func (sn *slotNode) copy() *slotNode {
+	nSlots := &nodeSlots{
+		nodes:      make(map[string]*node, len(sn.nSlots.nodes)+1),
+		slots:      append([]string(nil), sn.nSlots.slots...),
+		slaveSlots: sn.nSlots.slaveSlots,
+	}
+	for addr, n := range sn.nSlots.nodes {
+		nSlots.nodes[addr] = n
+	}
+	nsn := &slotNode{nSlots: nSlots, nodePipe: make(map[string]*proto.NodeConnPipe, len(sn.nodePipe)+1), replicas: sn.replicas}
+	for addr, ncp := range sn.nodePipe {
+		nsn.nodePipe[addr] = ncp
+	}
+	return nsn
+}
+
+// addPipe adds the master addr and returns its pipe, which is created if absent.
+func (sn *slotNode) addPipe(c *cluster, addr string) *proto.NodeConnPipe {
+	if _, ok := sn.nSlots.nodes[addr]; !ok {
+		sn.nSlots.nodes[addr] = &node{addr: addr, role: roleMaster, flags: []string{roleMaster}}
+	}
+	ncp, ok := sn.nodePipe[addr]
+	if !ok {
+		ncp = c.newPipe(addr)
+		sn.nodePipe[addr] = ncp
+	}
+	return ncp
 }
@@ -0,0 +1,371 @@
+package cluster
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ducesoft/overlord/pkg/hashkit"
+	"github.com/ducesoft/overlord/pkg/mockconn"
+	libnet "github.com/ducesoft/overlord/pkg/net"
+	"github.com/ducesoft/overlord/proxy/proto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// _node is a fake node of redis cluster which serves GET, MGET, MSET and DEL
+// of the keys in data, the other keys are redirected by moved or ask.
+type _node struct {
+	l net.Listener
+
+	lock    sync.Mutex
+	data    map[string]string
+	moved   map[uint16]string // slot => addr replied MOVED
+	ask     map[uint16]string // slot => addr replied ASK when keys are absent
+	cmds    []string
+	accepts int
+}
+
+func _newNode(t *testing.T, data map[string]string) *_node {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	n := &_node{l: l, data: data, moved: map[uint16]string{}, ask: map[uint16]string{}}
+	go n.serve()
+	t.Cleanup(func() { l.Close() })
+	return n
+}
+
+func (n *_node) addr() string {
+	return n.l.Addr().String()
+}
+
+func (n *_node) commands() []string {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return append([]string{}, n.cmds...)
+}
+
+// update updates the data or redirects of node while serving.
+func (n *_node) update(fn func()) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	fn()
+}
+
+func (n *_node) conns() int {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return n.accepts
+}
+
+func (n *_node) serve() {
+	for {
+		conn, err := n.l.Accept()
+		if err != nil {
+			return
+		}
+		n.lock.Lock()
+		n.accepts++
+		n.lock.Unlock()
+		go func(conn net.Conn) {
+			defer conn.Close()
+			br := bufio.NewReader(conn)
+			var asking bool
+			for {
+				args, err := _readArgs(br)
+				if err != nil {
+					return
+				}
+				reply := n.do(args, asking)
+				asking = strings.ToUpper(args[0]) == "ASKING"
+				if _, err = conn.Write([]byte(reply)); err != nil {
+					return
+				}
+			}
+		}(conn)
+	}
+}
+
+func _readArgs(br *bufio.Reader) (args []string, err error) {
+	line, err := br.ReadString('\n')
+	if err != nil {
+		return
+	}
+	cnt, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	for i := 0; i < cnt; i++ {
+		if _, err = br.ReadString('\n'); err != nil {
+			return
+		}
+		var arg string
+		if arg, err = br.ReadString('\n'); err != nil {
+			return
+		}
+		args = append(args, strings.TrimSpace(arg))
+	}
+	return
+}
+
+func (n *_node) do(args []string, asking bool) string {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	cmd := strings.ToUpper(args[0])
+	n.cmds = append(n.cmds, strings.Join(args, " "))
+	if cmd == "ASKING" {
+		return "+OK\r\n"
+	}
+	step := 1
+	if cmd == "MSET" {
+		step = 2
+	}
+	var keys []string
+	for i := 1; i < len(args); i += step {
+		keys = append(keys, args[i])
+	}
+	slot := _slot(keys[0])
+	if addr, ok := n.moved[slot]; ok {
+		return fmt.Sprintf("-MOVED %d %s\r\n", slot, addr)
+	}
+	if addr, ok := n.ask[slot]; ok && !asking {
+		var absent int
+		for _, key := range keys {
+			if _, ok := n.data[key]; !ok {
+				absent++
+			}
+		}
+		if absent == len(keys) {
+			return fmt.Sprintf("-ASK %d %s\r\n", slot, addr)
+		} else if absent > 0 {
+			return "-TRYAGAIN Multiple keys request during rehashing of slot\r\n"
+		}
+	}
+	switch cmd {
+	case "GET":
+		return _bulk(n.data, keys[0])
+	case "MGET":
+		reply := "*" + strconv.Itoa(len(keys)) + "\r\n"
+		for _, key := range keys {
+			reply += _bulk(n.data, key)
+		}
+		return reply
+	case "MSET":
+		for i := 1; i < len(args); i += 2 {
+			n.data[args[i]] = args[i+1]
+		}
+		return "+OK\r\n"
+	case "DEL":
+		var cnt int
+		for _, key := range keys {
+			if _, ok := n.data[key]; ok {
+				delete(n.data, key)
+				cnt++
+			}
+		}
+		return ":" + strconv.Itoa(cnt) + "\r\n"
+	}
+	return "-ERR unknown command\r\n"
+}
+
+// _slot returns the slot of key with hash tag like redis.
+func _slot(key string) uint16 {
+	if b := strings.IndexByte(key, '{'); b >= 0 {
+		if e := strings.IndexByte(key[b+1:], '}'); e > 0 {
+			key = key[b+1 : b+1+e]
+		}
+	}
+	return hashkit.Crc16([]byte(key)) & musk
+}
+
+func _bulk(data map[string]string, key string) string {
+	val, ok := data[key]
+	if !ok {
+		return "$-1\r\n"
+	}
+	return "$" + strconv.Itoa(len(val)) + "\r\n" + val + "\r\n"
+}
+
+// _newCluster new the cluster of which all slots are served by nodes[0]
+// except the slots of keys which are served by the other nodes in order.
+func _newCluster(t *testing.T, nodes []*_node, keys ...string) *cluster {
+	return _newClusterConns(t, 1, nodes, keys...)
+}
+
+// _newClusterConns new the cluster like _newCluster with conns to each node.
+func _newClusterConns(t *testing.T, conns int32, nodes []*_node, keys ...string) *cluster {
+	c := &cluster{name: "test", hashTag: []byte("{}"), conns: conns, pipeCount: 1, dto: time.Second, rto: time.Second, wto: time.Second, action: make(chan struct{}, 1)}
+	sn := &slotNode{nSlots: &nodeSlots{nodes: map[string]*node{}, slots: make([]string, slotsCount)}, nodePipe: map[string]*proto.NodeConnPipe{}}
+	for i := range sn.nSlots.slots {
+		sn.nSlots.slots[i] = nodes[0].addr()
+	}
+	for i, key := range keys {
+		sn.nSlots.slots[c.slot([]byte(key))] = nodes[i+1].addr()
+	}
+	for _, n := range nodes {
+		addr := n.addr()
+		sn.nSlots.nodes[addr] = &node{addr: addr, role: roleMaster}
+		sn.nodePipe[addr] = c.newNodeConnPipe(func() proto.NodeConn { return newNodeConn(c, addr) })
+	}
+	c.slotNode.Store(sn)
+	t.Cleanup(func() { _ = c.Close() })
+	return c
+}
+
+// _forward decodes the request, forwards it by cluster and returns the reply.
+func _forward(t *testing.T, c *cluster, req string) string {
+	conn := libnet.NewConn(mockconn.CreateConn([]byte(req), 1), time.Second, time.Second)
+	pc := NewProxyConn(conn, c)
+	msgs, err := pc.Decode(proto.GetMsgs(1))
+	assert.NoError(t, err)
+	wg := &sync.WaitGroup{}
+	msgs[0].WithWaitGroup(wg)
+	assert.NoError(t, c.Forward(msgs))
+	wg.Wait()
+
+	dconn, buf := mockconn.CreateDownStreamConn()
+	pc = NewProxyConn(libnet.NewConn(dconn, time.Second, time.Second), c)
+	assert.NoError(t, pc.Encode(msgs[0]))
+	assert.NoError(t, pc.Flush())
+	data := make([]byte, 4096)
+	size, _ := buf.Read(data)
+	return string(data[:size])
+}
+
+func TestBatchPushBySlot(t *testing.T) {
+	n1 := _newNode(t, map[string]string{"{x}a": "1", "{x}b": "2"})
+	n2 := _newNode(t, map[string]string{"{y}c": "3"})
+	c := _newCluster(t, []*_node{n1, n2}, "{y}")
+
+	reply := _forward(t, c, "*5\r\n$4\r\nMGET\r\n$4\r\n{x}a\r\n$4\r\n{y}c\r\n$4\r\n{x}b\r\n$4\r\n{x}d\r\n")
+	assert.Equal(t, "*4\r\n$1\r\n1\r\n$1\r\n3\r\n$1\r\n2\r\n$-1\r\n", reply, "replies in order of keys")
+	assert.Equal(t, []string{"MGET {x}a {x}b {x}d"}, n1.commands())
+	assert.Equal(t, []string{"MGET {y}c"}, n2.commands())
+
+	assert.Equal(t, "+OK\r\n", _forward(t, c, "*5\r\n$4\r\nMSET\r\n$4\r\n{x}e\r\n$1\r\n5\r\n$4\r\n{x}f\r\n$1\r\n6\r\n"))
+	assert.Equal(t, "MSET {x}e 5 {x}f 6", n1.commands()[1])
+	assert.Equal(t, ":3\r\n", _forward(t, c, "*4\r\n$3\r\nDEL\r\n$4\r\n{x}e\r\n$4\r\n{x}f\r\n$4\r\n{y}c\r\n"))
+	assert.Equal(t, "DEL {x}e {x}f", n1.commands()[2])
+}
+
+func TestBatchPushRedirect(t *testing.T) {
+	n1 := _newNode(t, map[string]string{"{x}a": "1", "{z}c": "3"})
+	n2 := _newNode(t, map[string]string{"{y}b": "2", "{y}d": "4"})
+	c := _newCluster(t, []*_node{n1, n2})
+
+	// NOTE: the slot of {y} is moved to n2 but the slot map is stale
+	n1.update(func() { n1.moved[c.slot([]byte("{y}"))] = n2.addr() })
+	reply := _forward(t, c, "*5\r\n$4\r\nMGET\r\n$4\r\n{x}a\r\n$4\r\n{y}b\r\n$4\r\n{z}c\r\n$4\r\n{y}d\r\n")
+	assert.Equal(t, "*4\r\n$1\r\n1\r\n$1\r\n2\r\n$1\r\n3\r\n$1\r\n4\r\n", reply)
+	assert.Equal(t, []string{"MGET {y}b {y}d"}, n2.commands(), "moved slot is redirected as a whole")
+
+	// NOTE: the slot of {z} is migrating to n2 and {z}e is already migrated
+	slot := c.slot([]byte("{z}"))
+	n1.update(func() { n1.ask[slot] = n2.addr() })
+	n2.update(func() { n2.data["{z}e"] = "5" })
+	reply = _forward(t, c, "*4\r\n$4\r\nMGET\r\n$4\r\n{z}c\r\n$4\r\n{z}e\r\n$4\r\n{x}a\r\n")
+	assert.Equal(t, "*3\r\n$1\r\n3\r\n$1\r\n5\r\n$1\r\n1\r\n", reply, "migrating slot is split into keys")
+	cmds := n2.commands()
+	assert.Equal(t, []string{"ASKING", "MGET {z}e"}, cmds[len(cmds)-2:])
+}
+
+func TestSplitPooledPipe(t *testing.T) {
+	n1 := _newNode(t, map[string]string{"{z}c": "3", "{z}f": "6"})
+	n2 := _newNode(t, map[string]string{"{z}e": "5"})
+	// NOTE: the split requests are sent by the conn other than the one reading.
+	c := _newClusterConns(t, 2, []*_node{n1, n2})
+	slot := c.slot([]byte("{z}"))
+	n1.update(func() { n1.ask[slot] = n2.addr() })
+
+	reply := _forward(t, c, "*4\r\n$4\r\nMGET\r\n$4\r\n{z}c\r\n$4\r\n{z}e\r\n$4\r\n{z}f\r\n")
+	assert.Equal(t, "*3\r\n$1\r\n3\r\n$1\r\n5\r\n$1\r\n6\r\n", reply, "migrating slot is split into keys")
+	assert.ElementsMatch(t, []string{"MGET {z}c {z}e {z}f", "MGET {z}c", "MGET {z}e", "MGET {z}f"}, n1.commands())
+	assert.Equal(t, []string{"ASKING", "MGET {z}e"}, n2.commands())
+	assert.Equal(t, 2, n1.conns(), "split reuses the pooled conns")
+}
+
+func TestMovedUpdateSlotNode(t *testing.T) {
+	n1 := _newNode(t, map[string]string{})
+	n2 := _newNode(t, map[string]string{"{y}a": "1", "{y}b": "2"})
+	c := _newCluster(t, []*_node{n1, n2})
+	slot := c.slot([]byte("{y}"))
+	n1.update(func() { n1.moved[slot] = n2.addr() })
+
+	assert.Equal(t, "$1\r\n1\r\n", _forward(t, c, "*2\r\n$3\r\nGET\r\n$4\r\n{y}a\r\n"))
+	assert.Equal(t, n2.addr(), c.KeyNode([]byte("{y}")), "slot is updated at once")
+	sn := c.slotNode.Load().(*slotNode)
+	assert.Equal(t, sn.nodePipe[n2.addr()], c.getPipe(&_keyReq{key: []byte("{y}b")}))
+
+	assert.Equal(t, "$1\r\n2\r\n", _forward(t, c, "*2\r\n$3\r\nGET\r\n$4\r\n{y}b\r\n"))
+	assert.Equal(t, []string{"GET {y}a"}, n1.commands(), "moved slot is sent to new node directly")
+	assert.Equal(t, []string{"GET {y}a", "GET {y}b"}, n2.commands())
+	assert.Equal(t, 1, n2.conns(), "redirect reuses the pooled conn")
+	select {
+	case <-c.action:
+	default:
+		t.Fatal("slots must be fetched again")
+	}
+}
+
+func TestAskPooledConn(t *testing.T) {
+	n1 := _newNode(t, map[string]string{})
+	n2 := _newNode(t, map[string]string{"{z}a": "1", "{z}b": "2"})
+	n3 := _newNode(t, map[string]string{})
+	c := _newCluster(t, []*_node{n1, n2})
+	slot := c.slot([]byte("{z}"))
+	n1.update(func() { n1.ask[slot] = n2.addr() })
+
+	assert.Equal(t, "$1\r\n1\r\n", _forward(t, c, "*2\r\n$3\r\nGET\r\n$4\r\n{z}a\r\n"))
+	assert.Equal(t, "$1\r\n2\r\n", _forward(t, c, "*2\r\n$3\r\nGET\r\n$4\r\n{z}b\r\n"))
+	assert.Equal(t, []string{"ASKING", "GET {z}a", "ASKING", "GET {z}b"}, n2.commands())
+	assert.Equal(t, 1, n2.conns(), "redirect reuses the pooled conn")
+	assert.Equal(t, n1.addr(), c.KeyNode([]byte("{z}")), "slot is kept by ASK")
+
+	// NOTE: the node absent in slot map is added for ASK
+	n1.update(func() { n1.ask[slot] = n3.addr() })
+	n3.update(func() { n3.data["{z}c"] = "3" })
+	assert.Equal(t, "$1\r\n3\r\n", _forward(t, c, "*2\r\n$3\r\nGET\r\n$4\r\n{z}c\r\n"))
+	assert.Equal(t, []string{"ASKING", "GET {z}c"}, n3.commands())
+	assert.Equal(t, n1.addr(), c.KeyNode([]byte("{z}")))
+}
+
+func TestRedirectFail(t *testing.T) {
+	n1 := _newNode(t, map[string]string{"{x}a": "1"})
+	// NOTE: the node redirected to never replies.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(io.Discard, conn)
+			}()
+		}
+	}()
+	c := _newCluster(t, []*_node{n1})
+	c.dto, c.wto, c.rto = 100*time.Millisecond, 100*time.Millisecond, 100*time.Millisecond
+	n1.update(func() { n1.moved[c.slot([]byte("{y}"))] = l.Addr().String() })
+
+	reply := _forward(t, c, "*2\r\n$3\r\nGET\r\n$4\r\n{y}a\r\n")
+	assert.True(t, strings.HasPrefix(reply, "-ERR cluster redirect fail: "), reply)
+	assert.Equal(t, "$1\r\n1\r\n", _forward(t, c, "*2\r\n$3\r\nGET\r\n$4\r\n{x}a\r\n"))
+	assert.Equal(t, 1, n1.conns(), "the conn redirecting from is kept")
+}
+
+// _keyReq is the request of key only for routing.
+type _keyReq struct {
+	proto.Request
+	key []byte
+}
+
+func (r *_keyReq) Key() []byte {
+	return r.key
+}
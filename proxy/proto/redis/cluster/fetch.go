@@ -27,10 +27,11 @@ type fetcher struct {
 }
 
 var (
-	cmdClusterNodesBytes = []byte("*2\r\n$7\r\nCLUSTER\r\n$5\r\nNODES\r\n")
+	cmdClusterNodesBytes    = []byte("*2\r\n$7\r\nCLUSTER\r\n$5\r\nNODES\r\n")
+	cmdInfoReplicationBytes = []byte("*2\r\n$4\r\nINFO\r\n$11\r\nreplication\r\n")
 
 	// ErrBadReplyType error bad reply type
-	ErrBadReplyType = errs.New("fetcher CLUSTER NODES bad reply type")
+	ErrBadReplyType = errs.New("fetcher bad reply type")
 )
 
 // newFetcher will create new fetcher
@@ -45,7 +46,21 @@ func newFetcher(conn *libnet.Conn) *fetcher {
 
 // Fetch new CLUSTER NODES result
 func (f *fetcher) fetch() (ns *nodeSlots, err error) {
-	if err = f.bw.Write(cmdClusterNodesBytes); err != nil {
+	data, err := f.bulk(cmdClusterNodesBytes)
+	if err != nil {
+		return
+	}
+	return parseSlots(data)
+}
+
+// replication returns the result of INFO replication.
+func (f *fetcher) replication() (data []byte, err error) {
+	return f.bulk(cmdInfoReplicationBytes)
+}
+
+// bulk execute cmd and returns the data of bulk reply.
+func (f *fetcher) bulk(cmd []byte) (data []byte, err error) {
+	if err = f.bw.Write(cmd); err != nil {
 		err = errors.WithStack(err)
 		return
 	}
@@ -53,7 +68,6 @@ func (f *fetcher) fetch() (ns *nodeSlots, err error) {
 		err = errors.WithStack(err)
 		return
 	}
-	var data []byte
 	begin := f.br.Mark()
 	for {
 		err = f.br.Read()
@@ -76,9 +90,8 @@ func (f *fetcher) fetch() (ns *nodeSlots, err error) {
 		data = reply.Data()
 		idx := bytes.Index(data, crlfBytes)
 		data = data[idx+2:]
-		break
+		return
 	}
-	return parseSlots(data)
 }
 
 // Close enable to close the conneciton of backend.
@@ -19,8 +19,9 @@ const (
 )
 
 var (
-	askBytes   = []byte("ASK")
-	movedBytes = []byte("MOVED")
+	askBytes      = []byte("ASK")
+	movedBytes    = []byte("MOVED")
+	tryAgainBytes = []byte("TRYAGAIN")
 
 	askingResp = []byte("*1\r\n$6\r\nASKING\r\n")
 )
@@ -38,14 +39,26 @@ type nodeConn struct {
 }
 
 func newNodeConn(c *cluster, addr string) (nc proto.NodeConn) {
+	rnc := redis.NewNodeConn(c.name, addr, c.dto, c.rto, c.wto)
+	if c.limits != nil {
+		rnc.(*redis.NodeConn).WithLimits(c.limits)
+	}
+	rnc.(*redis.NodeConn).WithScripts(c.scripts)
 	nc = &nodeConn{
 		c:    c,
 		addr: addr,
-		nc:   redis.NewNodeConn(c.name, addr, c.dto, c.rto, c.wto),
+		nc:   rnc,
 	}
 	return
 }
 
+// newReplicaNodeConn new the node conn to replica which sends READONLY first.
+func newReplicaNodeConn(c *cluster, addr string) proto.NodeConn {
+	nc := newNodeConn(c, addr)
+	nc.(*nodeConn).nc.(*redis.NodeConn).WithReadOnly()
+	return nc
+}
+
 func (nc *nodeConn) Addr() string {
 	return nc.addr
 }
@@ -55,6 +68,9 @@ func (nc *nodeConn) Cluster() string {
 }
 
 func (nc *nodeConn) Write(m *proto.Message) (err error) {
+	if rd, ok := m.Request().(*redirect); ok && !rd.split {
+		return nc.writeRedirect(m, rd)
+	}
 	if err = nc.nc.Write(m); err != nil {
 		err = errors.WithStack(err)
 	}
@@ -66,11 +82,17 @@ func (nc *nodeConn) Flush() error {
 }
 
 func (nc *nodeConn) Read(m *proto.Message) (err error) {
+	if rd, ok := m.Request().(*redirect); ok && !rd.split {
+		return nc.readRedirect(m, rd)
+	}
+	// NOTE: the conn of redirect never splits the request, which is split by
+	// the conn of the node where the keys are migrating from.
+	redirected := nc.redirects > 0
 	if err = nc.nc.Read(m); err != nil {
 		err = errors.WithStack(err)
 		return
 	}
-	req := m.Request().(*redis.Request)
+	req, _ := redis.RequestOf(m)
 	// check request
 	if !req.IsSupport() || req.IsCtl() {
 		return
@@ -86,27 +108,86 @@ func (nc *nodeConn) Read(m *proto.Message) (err error) {
 		return
 	}
 	data := reply.Data()
-	if !bytes.HasPrefix(data, askBytes) && !bytes.HasPrefix(data, movedBytes) {
-		return
+	if bytes.HasPrefix(data, askBytes) || bytes.HasPrefix(data, movedBytes) {
+		addrBs, slot, isAsk, _ := parseRedirect(data)
+		nc.sb.Reset()
+		nc.sb.Write(addrBs)
+		addr := nc.sb.String()
+		// redirect process
+		err = nc.redirectProcess(m, req, slot, addr, isAsk)
+		nc.redirects = 0
+		if err != nil {
+			if log.V(2) {
+				log.Errorf("Redis Cluster NodeConn redirectProcess addr:%s error:%v", addr, err)
+			}
+			return redirectErr(err)
+		}
+	}
+	if reply = req.Reply(); !redirected && reply.Type() == respRedirect && bytes.HasPrefix(reply.Data(), tryAgainBytes) {
+		if err = nc.splitProcess(req); err != nil {
+			if log.V(2) {
+				log.Errorf("Redis Cluster NodeConn splitProcess addr:%s error:%v", nc.addr, err)
+			}
+			return redirectErr(err)
+		}
 	}
+	return
+}
 
-	addrBs, _, isAsk, _ := parseRedirect(data)
-	if !isAsk {
-		// tryFetch when key moved
-		nc.c.toFetch()
+// redirectProcess sends the request to the node replied by MOVED or ASK through
+// its pooled pipe, MOVED updates the slot map at once. The request which can't
+// be sent by pipe, eg: blocking commands, is sent by a new conn to node.
+func (nc *nodeConn) redirectProcess(m *proto.Message, req *redis.Request, slot int, addr string, isAsk bool) (err error) {
+	for nc.redirects < maxRedirects {
+		var ncp *proto.NodeConnPipe
+		if isAsk {
+			ncp = nc.c.askPipe(addr)
+		} else {
+			ncp = nc.c.moved(slot, addr)
+		}
+		if ncp == nil || addr == nc.addr {
+			return nc.dialRedirect(m, req, addr, isAsk)
+		}
+		rreq, ok := req.Clone().(*redis.Request)
+		if !ok {
+			return nc.dialRedirect(m, req, addr, isAsk)
+		}
+		// next redirect
+		nc.redirects++
+		if log.V(5) {
+			log.Infof("Redis Cluster NodeConn key(%s) redirect count(%d)", req.Key(), nc.redirects)
+		}
+		if err = nc.c.redirect(ncp, nil, &redirect{Request: rreq, ask: isAsk}); err != nil {
+			return
+		}
+		req.CopyReply(rreq)
+		rreq.Put()
+		// NOTE: even if the client waits a long time before reissuing the query, and in the meantime the cluster configuration
+		// changed, the destination node will reply again with a MOVED error if the hash slot is now served by another node.
+		reply := req.Reply()
+		if reply.Type() != respRedirect {
+			return
+		}
+		data := reply.Data()
+		if !bytes.HasPrefix(data, askBytes) && !bytes.HasPrefix(data, movedBytes) {
+			return
+		}
+		var addrBs []byte
+		addrBs, slot, isAsk, _ = parseRedirect(data)
+		addr = string(addrBs)
 	}
-	nc.sb.Reset()
-	nc.sb.Write(addrBs)
-	addr := nc.sb.String()
-	// redirect process
-	if err = nc.redirectProcess(m, req, addr, isAsk); err != nil && log.V(2) {
-		log.Errorf("Redis Cluster NodeConn redirectProcess addr:%s error:%v", addr, err)
+	if log.V(4) {
+		log.Infof("Redis Cluster NodeConn key(%s) already max redirects", req.Key())
 	}
-	nc.redirects = 0
 	return
 }
 
-func (nc *nodeConn) redirectProcess(m *proto.Message, req *redis.Request, addr string, isAsk bool) (err error) {
+// dialRedirect sends the request to the node replied by MOVED or ASK by a new conn.
+func (nc *nodeConn) dialRedirect(m *proto.Message, req *redis.Request, addr string, isAsk bool) (err error) {
+	if !isAsk {
+		// tryFetch when key moved
+		nc.c.toFetch()
+	}
 	// next redirect
 	nc.redirects++
 	if log.V(5) {
@@ -132,14 +213,123 @@ func (nc *nodeConn) redirectProcess(m *proto.Message, req *redis.Request, addr s
 		err = errors.WithStack(err)
 		return
 	}
-	// NOTE: even if the client waits a long time before reissuing the query, and in the meantime the cluster configuration
-	// changed, the destination node will reply again with a MOVED error if the hash slot is now served by another node.
+	if isAsk {
+		if err = rnc.Discard(); err != nil {
+			err = errors.WithStack(err)
+			return
+		}
+	}
 	if err = nnc.Read(m); err != nil {
 		err = errors.WithStack(err)
 	}
 	return
 }
 
+// writeRedirect writes the redirected request, which is preceded by ASKING
+// if redirected by ASK.
+func (nc *nodeConn) writeRedirect(m *proto.Message, rd *redirect) (err error) {
+	if rd.ask {
+		if err = nc.nc.(*redis.NodeConn).Bw().Write(askingResp); err != nil {
+			err = errors.WithStack(err)
+			return
+		}
+	}
+	if err = nc.nc.Write(m); err != nil {
+		err = errors.WithStack(err)
+	}
+	return
+}
+
+// readRedirect reads the reply of redirected request, which is never
+// redirected again but replied to the conn redirecting from.
+func (nc *nodeConn) readRedirect(m *proto.Message, rd *redirect) (err error) {
+	if rd.ask {
+		if err = nc.nc.(*redis.NodeConn).Discard(); err != nil {
+			err = errors.WithStack(err)
+			return
+		}
+	}
+	if err = nc.nc.Read(m); err != nil {
+		err = errors.WithStack(err)
+	}
+	return
+}
+
+// splitProcess splits the merged request into the requests of each key when
+// the slot is migrating and only part of keys are migrated, eg: MGET a b is
+// replied TRYAGAIN, and sends them by the pooled pipe of node, which are
+// redirected by ASK.
+func (nc *nodeConn) splitProcess(req *redis.Request) (err error) {
+	reqs := req.Unmerge()
+	if reqs == nil {
+		return
+	}
+	if log.V(5) {
+		log.Infof("Redis Cluster NodeConn key(%s) split into %d requests", req.Key(), len(reqs))
+	}
+	ncp := nc.c.nodePipe(nc.addr)
+	if ncp == nil || ncp.Conns() == 1 {
+		// NOTE: the only conn of node is the conn itself, which is reading.
+		err = nc.dialSplit(reqs)
+	} else {
+		err = nc.pipeSplit(ncp, req.Key(), reqs)
+	}
+	if err != nil {
+		return
+	}
+	req.MergeReplies(reqs)
+	return
+}
+
+// pipeSplit sends the copies of split requests by the conns of pipe other than
+// the one of key, the requests belong to the client and are never left in the
+// pipe when timeout.
+func (nc *nodeConn) pipeSplit(ncp *proto.NodeConnPipe, key []byte, reqs []*redis.Request) (err error) {
+	rds := make([]*redirect, len(reqs))
+	for i, sub := range reqs {
+		rreq, ok := sub.Clone().(*redis.Request)
+		if !ok {
+			return nc.dialSplit(reqs)
+		}
+		rds[i] = &redirect{Request: rreq, split: true}
+	}
+	if err = nc.c.redirect(ncp, key, rds...); err != nil {
+		return
+	}
+	for i, sub := range reqs {
+		sub.CopyReply(rds[i].Request)
+		rds[i].Request.Put()
+	}
+	return
+}
+
+// dialSplit sends the split requests one by one by a new conn to node.
+func (nc *nodeConn) dialSplit(reqs []*redis.Request) (err error) {
+	nnc := newNodeConn(nc.c, nc.addr)
+	rnc := nnc.(*nodeConn).nc.(*redis.NodeConn)
+	defer nnc.Close()
+	for _, sub := range reqs {
+		if err = sub.RESP().Encode(rnc.Bw()); err != nil {
+			err = errors.WithStack(err)
+			return
+		}
+	}
+	if err = rnc.Bw().Flush(); err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+	msgs := proto.GetMsgs(len(reqs))
+	defer proto.PutMsgs(msgs)
+	for i, sub := range reqs {
+		msgs[i].WithRequest(&redirect{Request: sub, split: true})
+		if err = nnc.Read(msgs[i]); err != nil {
+			err = errors.WithStack(err)
+			return
+		}
+	}
+	return
+}
+
 func (nc *nodeConn) Close() (err error) {
 	if atomic.CompareAndSwapInt32(&nc.state, opening, closed) {
 		return nc.nc.Close()
@@ -46,6 +46,9 @@ func TestNodeConnMaxRedirect(t *testing.T) {
 	monkey.PatchInstanceMethod(reflect.TypeOf(rnc), "Close", func(_ *redis.NodeConn) error {
 		return nil
 	})
+	monkey.PatchInstanceMethod(reflect.TypeOf(rnc), "Discard", func(_ *redis.NodeConn) error {
+		return nil
+	})
 	// req stub
 	monkey.PatchInstanceMethod(reflect.TypeOf(req), "IsSupport", func(_ *redis.Request) bool {
 		return true
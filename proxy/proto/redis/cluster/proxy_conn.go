@@ -7,6 +7,8 @@ import (
 	libnet "github.com/ducesoft/overlord/pkg/net"
 	"github.com/ducesoft/overlord/proxy/proto"
 	"github.com/ducesoft/overlord/proxy/proto/redis"
+	"io"
+	"strconv"
 
 	"github.com/pkg/errors"
 )
@@ -23,6 +25,16 @@ var (
 	ErrInvalidArgument = errs.New("cluster command with wrong argument")
 )
 
+// slotRouter impl the proto.KeyRouter which routes the keys by slot of cluster.
+type slotRouter struct {
+	c *cluster
+}
+
+// KeyNode returns the slot of key.
+func (r slotRouter) KeyNode(key []byte) string {
+	return strconv.Itoa(int(r.c.slot(key)))
+}
+
 type proxyConn struct {
 	c  *cluster
 	pc proto.ProxyConn
@@ -36,11 +48,60 @@ func NewProxyConn(conn *libnet.Conn, fer proto.Forwarder) proto.ProxyConn {
 	}
 	r := &proxyConn{
 		c:  c,
-		pc: redis.NewProxyConn(conn, false),
+		pc: redis.NewProxyConn(conn, true),
 	}
 	return r
 }
 
+// WithInfo set the proxy state which is replied by INFO.
+func (pc *proxyConn) WithInfo(info proto.Infoer) {
+	pc.pc.(*redis.ProxyConn).WithInfo(info)
+}
+
+// WithAdminCommands enable the admin commands which change the state of proxy.
+func (pc *proxyConn) WithAdminCommands() {
+	pc.pc.(*redis.ProxyConn).WithAdminCommands()
+}
+
+// WithCommands set the command table of cluster.
+func (pc *proxyConn) WithCommands(cmds *redis.Commands) {
+	pc.pc.(*redis.ProxyConn).WithCommands(cmds)
+}
+
+// WithAlgebra enable the set algebra across nodes computed by proxy, the keys
+// are routed by slot as redis cluster rejects the keys across slots.
+func (pc *proxyConn) WithAlgebra(limit int, router proto.KeyRouter) {
+	if pc.c != nil {
+		router = slotRouter{c: pc.c}
+	}
+	pc.pc.(*redis.ProxyConn).WithAlgebra(limit, router)
+}
+
+// WithScripts set the script cache of cluster.
+func (pc *proxyConn) WithScripts(s *redis.ScriptCache) {
+	pc.pc.(*redis.ProxyConn).WithScripts(s)
+}
+
+// WithKeyPrefix namespace all the keys by prefix.
+func (pc *proxyConn) WithKeyPrefix(prefix []byte) {
+	pc.pc.(*redis.ProxyConn).WithKeyPrefix(prefix)
+}
+
+// WithLimits set the size limits of requests and replies.
+func (pc *proxyConn) WithLimits(l *proto.Limits) {
+	pc.pc.(*redis.ProxyConn).WithLimits(l)
+}
+
+// WithBatchStats set the stats of partially failed batch requests.
+func (pc *proxyConn) WithBatchStats(s *proto.BatchStats) {
+	pc.pc.(*redis.ProxyConn).WithBatchStats(s)
+}
+
+// Followup impl the proto.Followuper.
+func (pc *proxyConn) Followup(msgs []*proto.Message) []*proto.Message {
+	return pc.pc.(*redis.ProxyConn).Followup(msgs)
+}
+
 func (pc *proxyConn) Decode(msgs []*proto.Message) ([]*proto.Message, error) {
 	return pc.pc.Decode(msgs)
 }
@@ -79,3 +140,11 @@ func (pc *proxyConn) Encode(m *proto.Message) (err error) {
 func (pc *proxyConn) Flush() (err error) {
 	return pc.pc.Flush()
 }
+
+// Close release the session conn of client.
+func (pc *proxyConn) Close() error {
+	if closer, ok := pc.pc.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
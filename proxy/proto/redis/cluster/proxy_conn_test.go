@@ -2,6 +2,7 @@ package cluster
 
 import (
 	"reflect"
+	"strconv"
 	"testing"
 
 	"github.com/ducesoft/overlord/pkg/bufio"
@@ -114,3 +115,10 @@ func TestProxyConn(t *testing.T) {
 	err = pc.Encode(msg)
 	assert.EqualError(t, errors.Cause(err), ErrInvalidArgument.Error())
 }
+
+func TestSlotRouter(t *testing.T) {
+	r := slotRouter{c: &cluster{hashTag: []byte("{}")}}
+	assert.Equal(t, r.KeyNode([]byte("{t}a")), r.KeyNode([]byte("{t}b")))
+	assert.NotEqual(t, r.KeyNode([]byte("a")), r.KeyNode([]byte("b")))
+	assert.Equal(t, strconv.Itoa(int(_slot("a"))), r.KeyNode([]byte("a")))
+}
@@ -0,0 +1,88 @@
+package cluster
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ducesoft/overlord/proxy/proto"
+	"github.com/ducesoft/overlord/proxy/proto/redis"
+
+	"github.com/pkg/errors"
+)
+
+// redirect is the request sent by the pooled pipe of node for the conn
+// redirecting from, eg: the copy of request redirected by MOVED or ASK, which
+// is never redirected again, or the request split by TRYAGAIN, which is
+// redirected by ASK as usual.
+type redirect struct {
+	*redis.Request
+	ask   bool
+	split bool
+}
+
+// Unwrap impl the redis.Wrapper, the wrapped request is written and read.
+func (r *redirect) Unwrap() *redis.Request {
+	return r.Request
+}
+
+// Put impl the proto.Request, the wrapped request is released by the conn
+// redirecting from but never with the message.
+func (r *redirect) Put() {}
+
+// CoalesceKey impl the proto.Coalescer, the redirected request is never coalesced.
+func (r *redirect) CoalesceKey(dst []byte) []byte {
+	return nil
+}
+
+// redirect pushes the redirected requests to the pipe and waits for their
+// replies, the messages are released once replied. The requests are never
+// pushed into the conn of except key unless except is nil.
+// NOTE: the conn redirecting from waits at most the timeout of dial, write and
+// read, so that the nodes redirecting to each other never block forever, and
+// the messages of timeout are left to the pipe, the message redirecting fails.
+func (c *cluster) redirect(ncp *proto.NodeConnPipe, except []byte, rds ...*redirect) (err error) {
+	msgs := proto.GetMsgs(len(rds))
+	wg := &sync.WaitGroup{}
+	for i, m := range msgs {
+		m.WithRequest(rds[i])
+		m.WithWaitGroup(wg)
+		if except != nil {
+			ncp.PushExcept(m, except)
+		} else {
+			ncp.Push(m)
+		}
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	timeout := c.dto + c.wto + c.rto
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		for _, m := range msgs {
+			if err = m.Err(); err != nil {
+				break
+			}
+		}
+		proto.PutMsgs(msgs)
+		return
+	case <-timer.C:
+		return errors.WithStack(ErrRedirectTimeout)
+	}
+}
+
+// redirectErr fails the message redirected or split by err instead of replying
+// MOVED, ASK or TRYAGAIN to client, the conn redirecting from is kept as its
+// reply is read.
+func redirectErr(err error) error {
+	if proto.IsReplyError(err) {
+		return err
+	}
+	return errors.WithStack(proto.NewReplyError("ERR cluster redirect fail: " + errors.Cause(err).Error()))
+}
@@ -0,0 +1,192 @@
+package cluster
+
+import (
+	"bytes"
+	errs "errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ducesoft/overlord/pkg/log"
+	libnet "github.com/ducesoft/overlord/pkg/net"
+	"github.com/ducesoft/overlord/proxy/proto"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	replicaCheckInterval = time.Second
+)
+
+// errors
+var (
+	ErrReplicaRole     = errs.New("replica is not slave")
+	ErrReplicaLinkDown = errs.New("replica link to master is down")
+	ErrReplicaLag      = errs.New("replica lag exceeds the limit")
+)
+
+var (
+	roleSlaveBytes        = []byte("role:slave")
+	linkStatusUpBytes     = []byte("master_link_status:up")
+	syncInProgressBytes   = []byte("master_sync_in_progress:1")
+	lastIOSecondsAgoBytes = []byte("master_last_io_seconds_ago:")
+)
+
+// replica is the slave of redis cluster which serves the read only requests,
+// it is healthy only when the replication is up and the lag is in the limit.
+type replica struct {
+	addr string
+	ncp  *proto.NodeConnPipe
+
+	healthy int32
+
+	// f is the conn to check the replication.
+	f      *fetcher
+	closed bool
+	lock   sync.Mutex
+}
+
+func newReplica(c *cluster, addr string) *replica {
+	r := &replica{addr: addr}
+	r.ncp = c.newNodeConnPipe(func() proto.NodeConn {
+		return newReplicaNodeConn(c, addr)
+	})
+	go c.replicaEvent(r, r.ncp.ErrorEvent())
+	return r
+}
+
+func (r *replica) isHealthy() bool {
+	return atomic.LoadInt32(&r.healthy) == 1
+}
+
+// check the replication by INFO replication and update the healthy state.
+func (r *replica) check(c *cluster) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.closed {
+		return
+	}
+	if r.f == nil {
+		r.f = newFetcher(libnet.DialWithTimeout(r.addr, c.dto, c.rto, c.wto))
+	}
+	data, err := r.f.replication()
+	if err != nil {
+		_ = r.f.Close()
+		r.f = nil
+	} else {
+		err = checkReplication(data, c.replicaMaxLag)
+	}
+	if err != nil {
+		if atomic.SwapInt32(&r.healthy, 0) == 1 && log.V(2) {
+			log.Warnf("Redis Cluster cluster:%s replica:%s fall back to master error:%v", c.name, r.addr, err)
+		}
+		return
+	}
+	if atomic.SwapInt32(&r.healthy, 1) == 0 && log.V(4) {
+		log.Infof("Redis Cluster cluster:%s replica:%s is healthy to read", c.name, r.addr)
+	}
+}
+
+func (r *replica) close() {
+	r.lock.Lock()
+	r.closed = true
+	if r.f != nil {
+		_ = r.f.Close()
+		r.f = nil
+	}
+	r.lock.Unlock()
+	r.ncp.Close()
+}
+
+// checkReplication check the result of INFO replication, maxLag is the max
+// seconds since the last interaction with master and 0 means no limit.
+func checkReplication(data []byte, maxLag time.Duration) error {
+	var role, linkUp bool
+	for _, line := range bytes.Split(data, crlfBytes) {
+		switch {
+		case bytes.Equal(line, roleSlaveBytes):
+			role = true
+		case bytes.Equal(line, linkStatusUpBytes):
+			linkUp = true
+		case bytes.Equal(line, syncInProgressBytes):
+			return errors.Wrap(ErrReplicaLinkDown, "sync in progress")
+		case bytes.HasPrefix(line, lastIOSecondsAgoBytes) && maxLag > 0:
+			sec, err := strconv.Atoi(string(line[len(lastIOSecondsAgoBytes):]))
+			if err == nil && time.Duration(sec)*time.Second > maxLag {
+				return errors.Wrapf(ErrReplicaLag, "last io %ds ago", sec)
+			}
+		}
+	}
+	if !role {
+		return errors.WithStack(ErrReplicaRole)
+	}
+	if !linkUp {
+		return errors.WithStack(ErrReplicaLinkDown)
+	}
+	return nil
+}
+
+// readReplica returns the healthy replica of slot which serves the read only
+// request by read policy, nil means the request is sent to master.
+func (c *cluster) readReplica(sn *slotNode, slot uint16, req proto.Request) *replica {
+	if len(sn.replicas) == 0 {
+		return nil
+	}
+	if ro, ok := req.(proto.ReadOnlyer); !ok || !ro.IsReadOnly() {
+		return nil
+	}
+	addrs := sn.nSlots.slaveSlots[slot]
+	n := 0
+	for _, addr := range addrs {
+		if r, ok := sn.replicas[addr]; ok && r.isHealthy() {
+			n++
+		}
+	}
+	if n == 0 {
+		return nil
+	}
+	i := c.readPolicy.Pick(atomic.AddUint32(&c.rr, 1), n)
+	if i < 0 {
+		return nil
+	}
+	for _, addr := range addrs {
+		if r, ok := sn.replicas[addr]; ok && r.isHealthy() {
+			if i == 0 {
+				return r
+			}
+			i--
+		}
+	}
+	return nil
+}
+
+// replicaproc check the replication of replicas in every second.
+func (c *cluster) replicaproc() {
+	for atomic.LoadInt32(&c.state) != closed {
+		time.Sleep(replicaCheckInterval)
+		sn, ok := c.slotNode.Load().(*slotNode)
+		if !ok || sn == nil {
+			continue
+		}
+		for _, r := range sn.replicas {
+			r.check(c)
+		}
+	}
+}
+
+// replicaEvent fall back to master when the pipe of replica fails, it is
+// healthy again after the next check.
+func (c *cluster) replicaEvent(r *replica, errCh <-chan error) {
+	for {
+		err, ok := <-errCh
+		if !ok {
+			return
+		}
+		atomic.StoreInt32(&r.healthy, 0)
+		if log.V(2) {
+			log.Errorf("Redis Cluster replica:%s NodeConnPipe action error:%v", r.addr, err)
+		}
+		c.toFetch()
+	}
+}
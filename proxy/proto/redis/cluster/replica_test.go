@@ -0,0 +1,75 @@
+package cluster
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ducesoft/overlord/pkg/mockconn"
+	libnet "github.com/ducesoft/overlord/pkg/net"
+	"github.com/ducesoft/overlord/proxy/proto"
+	"github.com/ducesoft/overlord/proxy/proto/redis"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckReplication(t *testing.T) {
+	const info = "# Replication\r\nrole:slave\r\nmaster_host:172.17.0.2\r\nmaster_port:7000\r\n"
+	ts := []struct {
+		Name  string
+		Data  string
+		Cause error
+	}{
+		{"ok", info + "master_link_status:up\r\nmaster_last_io_seconds_ago:3\r\nmaster_sync_in_progress:0\r\n", nil},
+		{"master", "# Replication\r\nrole:master\r\nconnected_slaves:1\r\n", ErrReplicaRole},
+		{"link down", info + "master_link_status:down\r\nmaster_last_io_seconds_ago:-1\r\nmaster_sync_in_progress:0\r\n", ErrReplicaLinkDown},
+		{"sync", info + "master_link_status:up\r\nmaster_last_io_seconds_ago:1\r\nmaster_sync_in_progress:1\r\n", ErrReplicaLinkDown},
+		{"lag", info + "master_link_status:up\r\nmaster_last_io_seconds_ago:16\r\nmaster_sync_in_progress:0\r\n", ErrReplicaLag},
+	}
+	for _, tt := range ts {
+		t.Run(tt.Name, func(t *testing.T) {
+			err := checkReplication([]byte(tt.Data), 15*time.Second)
+			assert.Equal(t, tt.Cause, errors.Cause(err))
+		})
+	}
+	assert.NoError(t, checkReplication([]byte(info+"master_link_status:up\r\nmaster_last_io_seconds_ago:16\r\n"), 0))
+}
+
+func TestGetReplicas(t *testing.T) {
+	ns, err := parseSlots([]byte(_slotDemo + "e2b1b1e0298da177606394f7dff2f7b0fbda82c7 172.17.0.2:7006@17006 slave,fail b1798ba2171a4bd765846ddb5d5bdc9f3ca6fdf3 0 1532770704437 6 connected\n"))
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"172.17.0.2:7003", "172.17.0.2:7004", "172.17.0.2:7005"}, ns.getReplicas())
+}
+
+func TestReadReplica(t *testing.T) {
+	ns, err := parseSlots([]byte(_slotDemo))
+	assert.NoError(t, err)
+	sn := &slotNode{nSlots: ns, replicas: map[string]*replica{}}
+	for _, addr := range ns.getReplicas() {
+		sn.replicas[addr] = &replica{addr: addr, healthy: 1}
+	}
+	conn := libnet.NewConn(mockconn.CreateConn([]byte("GET a\r\nSET a 1\r\n"), 1), time.Second, time.Second)
+	msgs, err := redis.NewProxyConn(conn, true).Decode(proto.GetMsgs(2))
+	assert.NoError(t, err)
+	get, set := msgs[0].Requests()[0], msgs[1].Requests()[0]
+	const slot = 0 // NOTE: 172.17.0.2:7000 with replica 172.17.0.2:7005
+
+	c := &cluster{readPolicy: proto.ReadPolicyPreferReplica}
+	assert.Nil(t, c.readReplica(sn, slot, set))
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, "172.17.0.2:7005", c.readReplica(sn, slot, get).addr)
+	}
+	c.readPolicy = proto.ReadPolicyRoundRobin
+	var masters int
+	for i := 0; i < 4; i++ {
+		if c.readReplica(sn, slot, get) == nil {
+			masters++
+		}
+	}
+	assert.Equal(t, 2, masters)
+
+	// NOTE: fall back to master when replica is unhealthy
+	sn.replicas["172.17.0.2:7005"].healthy = 0
+	c.readPolicy = proto.ReadPolicyPreferReplica
+	assert.Nil(t, c.readReplica(sn, slot, get))
+}
@@ -101,6 +101,23 @@ func (ns *nodeSlots) getMasters() []string {
 	return masters
 }
 
+// getReplicas return all the healthy slaves address of masters.
+func (ns *nodeSlots) getReplicas() []string {
+	masters := make(map[string]struct{})
+	for _, node := range ns.nodes {
+		if node.role == roleMaster {
+			masters[node.ID] = struct{}{}
+		}
+	}
+	replicas := make([]string, 0)
+	for _, node := range ns.nodes {
+		if _, ok := masters[node.slaveOf]; ok && node.role == roleSlave && node.isNormal() {
+			replicas = append(replicas, node.addr)
+		}
+	}
+	return replicas
+}
+
 // node is a struct for each CLUSTER NODES response line.
 type node struct {
 	// 有别于 runID
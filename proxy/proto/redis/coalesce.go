@@ -0,0 +1,28 @@
+package redis
+
+import (
+	"strconv"
+
+	"github.com/ducesoft/overlord/proxy/proto"
+)
+
+// CoalesceKey impl the proto.Coalescer, the read only request which is not
+// merged is identified by the db and all the arguments.
+func (r *Request) CoalesceKey(dst []byte) []byte {
+	if r.mType != mergeTypeNo || r.sess != nil || !r.IsReadOnly() {
+		return nil
+	}
+	dst = strconv.AppendInt(dst, int64(r.db), 10)
+	for _, arg := range r.resp.Array() {
+		dst = append(dst, ' ')
+		dst = append(dst, arg.data...)
+	}
+	return dst
+}
+
+// CopyReply impl the proto.ReplyCopier.
+func (r *Request) CopyReply(src proto.Request) {
+	if s, ok := src.(*Request); ok {
+		r.reply.copy(s.reply)
+	}
+}
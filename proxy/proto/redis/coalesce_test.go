@@ -0,0 +1,37 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ducesoft/overlord/pkg/mockconn"
+	libnet "github.com/ducesoft/overlord/pkg/net"
+	"github.com/ducesoft/overlord/proxy/proto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCoalesceKey(t *testing.T) {
+	conn := libnet.NewConn(mockconn.CreateConn([]byte("GET a\r\nGET a\r\nGETRANGE a 0 1\r\nSET a 1\r\nMGET a b\r\n"), 1), time.Second, time.Second)
+	pc := NewProxyConn(conn, true)
+	nmsgs, err := pc.Decode(proto.GetMsgs(8))
+	assert.NoError(t, err)
+	assert.Len(t, nmsgs, 5)
+	key := nmsgs[0].Request().(*Request).CoalesceKey(nil)
+	assert.Equal(t, "0 3\r\nGET 1\r\na", string(key))
+	assert.Equal(t, key, nmsgs[1].Request().(*Request).CoalesceKey(nil))
+	assert.NotEqual(t, key, nmsgs[2].Request().(*Request).CoalesceKey(nil))
+	assert.Nil(t, nmsgs[3].Request().(*Request).CoalesceKey(nil))
+	for _, req := range nmsgs[4].Requests() {
+		assert.Nil(t, req.(*Request).CoalesceKey(nil))
+	}
+
+	// the reply is copied and encoded by the waiter
+	leader := nmsgs[0].Request().(*Request)
+	leader.reply.setBulk([]byte("hello"))
+	nmsgs[1].Request().(*Request).CopyReply(leader)
+	leader.reply.setBulk([]byte("reused"))
+	assert.NoError(t, pc.Encode(nmsgs[1]))
+	assert.NoError(t, pc.Flush())
+	assert.Equal(t, "$5\r\nhello\r\n", conn.Conn.(*mockconn.MockConn).Wbuf.String())
+}
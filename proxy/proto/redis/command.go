@@ -0,0 +1,363 @@
+package redis
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/ducesoft/overlord/pkg/conv"
+)
+
+var (
+	cmdCommandBytes = []byte("7\r\nCOMMAND")
+	subCountBytes   = []byte("5\r\nCOUNT")
+	subInfoBytes    = []byte("4\r\nINFO")
+	subDocsBytes    = []byte("4\r\nDOCS")
+	subListBytes    = []byte("4\r\nLIST")
+	subGetKeysBytes = []byte("7\r\nGETKEYS")
+
+	errCommandSubCmd  = []byte("ERR unknown subcommand or wrong number of arguments for 'command' command")
+	errCommandInvalid = []byte("ERR Invalid command specified")
+	errCommandNoKeys  = []byte("ERR The command has no key arguments")
+)
+
+var (
+	flagsRead    = []string{"readonly"}
+	flagsWrite   = []string{"write"}
+	flagsMovable = []string{"write", "movablekeys"}
+	flagsReadMov = []string{"readonly", "movablekeys"}
+	flagsFast    = []string{"fast"}
+	flagsTxn     = []string{"noscript", "fast"}
+	flagsLocal   = []string{"loading", "stale"}
+)
+
+// command is the entry of command table which is replied by COMMAND.
+//
+// first, last and step is the position of keys, last is negative means
+// count from the end, 0 means the command has no key or the keys are movable.
+type command struct {
+	name  string
+	arity int
+	flags []string
+	first int
+	last  int
+	step  int
+}
+
+// commands is the table of commands supported by proxy.
+var commands = []*command{
+	// read
+	{"DUMP", 2, flagsRead, 1, 1, 1},
+	{"EXISTS", -2, flagsRead, 1, -1, 1},
+	{"PTTL", 2, flagsRead, 1, 1, 1},
+	{"TTL", 2, flagsRead, 1, 1, 1},
+	{"TYPE", 2, flagsRead, 1, 1, 1},
+	{"BITCOUNT", -2, flagsRead, 1, 1, 1},
+	{"BITPOS", -3, flagsRead, 1, 1, 1},
+	{"GET", 2, flagsRead, 1, 1, 1},
+	{"GETBIT", 3, flagsRead, 1, 1, 1},
+	{"GETRANGE", 4, flagsRead, 1, 1, 1},
+	{"MGET", -2, flagsRead, 1, -1, 1},
+	{"STRLEN", 2, flagsRead, 1, 1, 1},
+	{"HEXISTS", 3, flagsRead, 1, 1, 1},
+	{"HGET", 3, flagsRead, 1, 1, 1},
+	{"HGETALL", 2, flagsRead, 1, 1, 1},
+	{"HKEYS", 2, flagsRead, 1, 1, 1},
+	{"HLEN", 2, flagsRead, 1, 1, 1},
+	{"HMGET", -3, flagsRead, 1, 1, 1},
+	{"HSTRLEN", 3, flagsRead, 1, 1, 1},
+	{"HVALS", 2, flagsRead, 1, 1, 1},
+	{"HSCAN", -3, flagsRead, 1, 1, 1},
+	{"SCARD", 2, flagsRead, 1, 1, 1},
+	{"SDIFF", -2, flagsRead, 1, -1, 1},
+	{"SINTER", -2, flagsRead, 1, -1, 1},
+	{"SISMEMBER", 3, flagsRead, 1, 1, 1},
+	{"SMEMBERS", 2, flagsRead, 1, 1, 1},
+	{"SRANDMEMBER", -2, flagsRead, 1, 1, 1},
+	{"SUNION", -2, flagsRead, 1, -1, 1},
+	{"SSCAN", -3, flagsRead, 1, 1, 1},
+	{"ZCARD", 2, flagsRead, 1, 1, 1},
+	{"ZCOUNT", 4, flagsRead, 1, 1, 1},
+	{"ZLEXCOUNT", 4, flagsRead, 1, 1, 1},
+	{"ZRANGE", -4, flagsRead, 1, 1, 1},
+	{"ZRANGEBYLEX", -4, flagsRead, 1, 1, 1},
+	{"ZRANGEBYSCORE", -4, flagsRead, 1, 1, 1},
+	{"ZRANK", 3, flagsRead, 1, 1, 1},
+	{"ZREVRANGE", -4, flagsRead, 1, 1, 1},
+	{"ZREVRANGEBYLEX", -4, flagsRead, 1, 1, 1},
+	{"ZREVRANGEBYSCORE", -4, flagsRead, 1, 1, 1},
+	{"ZREVRANK", 3, flagsRead, 1, 1, 1},
+	{"ZSCORE", 3, flagsRead, 1, 1, 1},
+	{"ZSCAN", -3, flagsRead, 1, 1, 1},
+	{"LINDEX", 3, flagsRead, 1, 1, 1},
+	{"LLEN", 2, flagsRead, 1, 1, 1},
+	{"LRANGE", 4, flagsRead, 1, 1, 1},
+	{"PFCOUNT", -2, flagsRead, 1, -1, 1},
+	{"LPOS", -3, flagsRead, 1, 1, 1},
+	{"SMISMEMBER", -3, flagsRead, 1, 1, 1},
+	{"ZMSCORE", -3, flagsRead, 1, 1, 1},
+	{"ZRANDMEMBER", -2, flagsRead, 1, 1, 1},
+	{"ZUNION", -3, flagsReadMov, 0, 0, 0},
+	{"ZINTER", -3, flagsReadMov, 0, 0, 0},
+	{"HRANDFIELD", -2, flagsRead, 1, 1, 1},
+	{"OBJECT", -2, flagsRead, 2, 2, 1},
+	{"MEMORY", -2, flagsRead, 2, 2, 1},
+	{"EXPIRETIME", 2, flagsRead, 1, 1, 1},
+	{"PEXPIRETIME", 2, flagsRead, 1, 1, 1},
+	{"TOUCH", -2, flagsRead, 1, -1, 1},
+	{"BITFIELD_RO", -2, flagsRead, 1, 1, 1},
+	{"XRANGE", -4, flagsRead, 1, 1, 1},
+	{"XREVRANGE", -4, flagsRead, 1, 1, 1},
+	{"XLEN", 2, flagsRead, 1, 1, 1},
+	{"XREAD", -4, flagsReadMov, 0, 0, 0},
+	{"XPENDING", -3, flagsRead, 1, 1, 1},
+	{"XINFO", -2, flagsRead, 2, 2, 1},
+	// write
+	{"DEL", -2, flagsWrite, 1, -1, 1},
+	{"EXPIRE", 3, flagsWrite, 1, 1, 1},
+	{"EXPIREAT", 3, flagsWrite, 1, 1, 1},
+	{"PERSIST", 2, flagsWrite, 1, 1, 1},
+	{"PEXPIRE", 3, flagsWrite, 1, 1, 1},
+	{"PEXPIREAT", 3, flagsWrite, 1, 1, 1},
+	{"RESTORE", -4, flagsWrite, 1, 1, 1},
+	{"SORT", -2, flagsWrite, 1, 1, 1},
+	{"APPEND", 3, flagsWrite, 1, 1, 1},
+	{"DECR", 2, flagsWrite, 1, 1, 1},
+	{"DECRBY", 3, flagsWrite, 1, 1, 1},
+	{"GETSET", 3, flagsWrite, 1, 1, 1},
+	{"INCR", 2, flagsWrite, 1, 1, 1},
+	{"INCRBY", 3, flagsWrite, 1, 1, 1},
+	{"INCRBYFLOAT", 3, flagsWrite, 1, 1, 1},
+	{"MSET", -3, flagsWrite, 1, -1, 2},
+	{"PSETEX", 4, flagsWrite, 1, 1, 1},
+	{"SET", -3, flagsWrite, 1, 1, 1},
+	{"SETBIT", 4, flagsWrite, 1, 1, 1},
+	{"SETEX", 4, flagsWrite, 1, 1, 1},
+	{"SETNX", 3, flagsWrite, 1, 1, 1},
+	{"SETRANGE", 4, flagsWrite, 1, 1, 1},
+	{"HDEL", -3, flagsWrite, 1, 1, 1},
+	{"HINCRBY", 4, flagsWrite, 1, 1, 1},
+	{"HINCRBYFLOAT", 4, flagsWrite, 1, 1, 1},
+	{"HMSET", -4, flagsWrite, 1, 1, 1},
+	{"HSET", -4, flagsWrite, 1, 1, 1},
+	{"HSETNX", 4, flagsWrite, 1, 1, 1},
+	{"LINSERT", 5, flagsWrite, 1, 1, 1},
+	{"LPOP", -2, flagsWrite, 1, 1, 1},
+	{"LPUSH", -3, flagsWrite, 1, 1, 1},
+	{"LPUSHX", -3, flagsWrite, 1, 1, 1},
+	{"LREM", 4, flagsWrite, 1, 1, 1},
+	{"LSET", 4, flagsWrite, 1, 1, 1},
+	{"LTRIM", 4, flagsWrite, 1, 1, 1},
+	{"RPOP", -2, flagsWrite, 1, 1, 1},
+	{"RPOPLPUSH", 3, flagsWrite, 1, 2, 1},
+	{"RPUSH", -3, flagsWrite, 1, 1, 1},
+	{"RPUSHX", -3, flagsWrite, 1, 1, 1},
+	{"SADD", -3, flagsWrite, 1, 1, 1},
+	{"SMOVE", 4, flagsWrite, 1, 2, 1},
+	{"SPOP", -2, flagsWrite, 1, 1, 1},
+	{"SREM", -3, flagsWrite, 1, 1, 1},
+	{"ZADD", -4, flagsWrite, 1, 1, 1},
+	{"ZINCRBY", 4, flagsWrite, 1, 1, 1},
+	{"ZINTERSTORE", -4, flagsMovable, 1, 1, 1},
+	{"ZREM", -3, flagsWrite, 1, 1, 1},
+	{"ZREMRANGEBYLEX", 4, flagsWrite, 1, 1, 1},
+	{"ZREMRANGEBYRANK", 4, flagsWrite, 1, 1, 1},
+	{"ZREMRANGEBYSCORE", 4, flagsWrite, 1, 1, 1},
+	{"PFADD", -2, flagsWrite, 1, 1, 1},
+	{"PFMERGE", -2, flagsWrite, 1, -1, 1},
+	{"EVAL", -3, flagsMovable, 0, 0, 0},
+	{"EVALSHA", -3, flagsMovable, 0, 0, 0},
+	{"SCRIPT", -2, flagsTxn, 0, 0, 0},
+	{"SUNIONSTORE", -3, flagsWrite, 1, -1, 1},
+	{"SDIFFSTORE", -3, flagsWrite, 1, -1, 1},
+	{"SINTERSTORE", -3, flagsWrite, 1, -1, 1},
+	{"MSETNX", -3, flagsWrite, 1, -1, 2},
+	{"RENAME", 3, flagsWrite, 1, 2, 1},
+	{"RENAMENX", 3, flagsWrite, 1, 2, 1},
+	{"BITOP", -4, flagsWrite, 2, -1, 1},
+	{"ZUNIONSTORE", -4, flagsMovable, 1, 1, 1},
+	{"XADD", -5, flagsWrite, 1, 1, 1},
+	{"XREADGROUP", -7, flagsMovable, 0, 0, 0},
+	{"XACK", -4, flagsWrite, 1, 1, 1},
+	{"XCLAIM", -6, flagsWrite, 1, 1, 1},
+	{"XAUTOCLAIM", -6, flagsWrite, 1, 1, 1},
+	{"XTRIM", -4, flagsWrite, 1, 1, 1},
+	{"XDEL", -3, flagsWrite, 1, 1, 1},
+	{"XGROUP", -2, flagsWrite, 2, 2, 1},
+	{"GETEX", -2, flagsWrite, 1, 1, 1},
+	{"GETDEL", 2, flagsWrite, 1, 1, 1},
+	{"LMOVE", 5, flagsWrite, 1, 2, 1},
+	{"BLMOVE", 6, flagsWrite, 1, 2, 1},
+	{"ZRANGESTORE", -5, flagsWrite, 1, 2, 1},
+	{"ZPOPMIN", -2, flagsWrite, 1, 1, 1},
+	{"ZPOPMAX", -2, flagsWrite, 1, 1, 1},
+	{"COPY", -3, flagsWrite, 1, 2, 1},
+	{"UNLINK", -2, flagsWrite, 1, -1, 1},
+	{"BITFIELD", -2, flagsWrite, 1, 1, 1},
+	// control
+	{"QUIT", -1, flagsFast, 0, 0, 0},
+	{"PING", -1, flagsFast, 0, 0, 0},
+	// transaction
+	{"MULTI", 1, flagsTxn, 0, 0, 0},
+	{"EXEC", 1, flagsTxn, 0, 0, 0},
+	{"DISCARD", 1, flagsTxn, 0, 0, 0},
+	{"WATCH", -2, flagsTxn, 1, -1, 1},
+	{"UNWATCH", 1, flagsTxn, 0, 0, 0},
+	// replied by proxy
+	{"INFO", -1, flagsLocal, 0, 0, 0},
+	{"COMMAND", -1, flagsLocal, 0, 0, 0},
+	{"HELLO", -1, flagsLocal, 0, 0, 0},
+	{"CLIENT", -2, flagsLocal, 0, 0, 0},
+	{"SELECT", 2, flagsLocal, 0, 0, 0},
+	{"SLOWLOG", -2, flagsLocal, 0, 0, 0},
+	{"PROXY", -2, flagsLocal, 0, 0, 0},
+}
+
+// commandMap is the commands by upper name.
+var commandMap = map[string]*command{}
+
+func init() {
+	for _, c := range commands {
+		commandMap[c.name] = c
+	}
+}
+
+// movable check whether the keys of command are found by args.
+func (c *command) movable() bool {
+	for _, flag := range c.flags {
+		if flag == "movablekeys" {
+			return true
+		}
+	}
+	return false
+}
+
+// appendKeys append the keys of r by the key positions of command.
+func (c *command) appendKeys(keys [][]byte, r *resp) [][]byte {
+	last := c.last
+	if last < 0 {
+		last += r.arraySize
+	}
+	for i := c.first; i <= last && i < r.arraySize; i += c.step {
+		keys = append(keys, bulkData(r.array[i]))
+	}
+	return keys
+}
+
+// encode the command entry as COMMAND INFO reply.
+func (c *command) encode(r *resp) {
+	r.setArray()
+	r.next().setBulk([]byte(strings.ToLower(c.name)))
+	r.next().setInt(int64(c.arity))
+	flags := r.next()
+	flags.setArray()
+	for _, f := range c.flags {
+		flags.next().setPlain(respString, []byte(f))
+	}
+	flags.setArraySize()
+	r.next().setInt(int64(c.first))
+	r.next().setInt(int64(c.last))
+	r.next().setInt(int64(c.step))
+	r.setArraySize()
+}
+
+// decodeCommand reply COMMAND [COUNT|INFO|DOCS|LIST|GETKEYS] by proxy command table.
+func decodeCommand(r *Request, cmds *Commands) {
+	r.local = true
+	reply := r.reply
+	if r.resp.arraySize == 1 {
+		reply.setArray()
+		for _, c := range cmds.list {
+			c.encode(reply.next())
+		}
+		reply.setArraySize()
+		return
+	}
+	sub := r.resp.array[1].data
+	conv.UpdateToUpper(sub)
+	args := r.resp.array[2:r.resp.arraySize]
+	switch {
+	case bytes.Equal(sub, subCountBytes) && len(args) == 0:
+		reply.setInt(int64(len(cmds.list)))
+	case bytes.Equal(sub, subListBytes) && len(args) == 0:
+		reply.setArray()
+		for _, c := range cmds.list {
+			reply.next().setBulk([]byte(strings.ToLower(c.name)))
+		}
+		reply.setArraySize()
+	case bytes.Equal(sub, subInfoBytes):
+		reply.setArray()
+		for _, arg := range args {
+			c, ok := cmds.byName[strings.ToUpper(string(bulkData(arg)))]
+			if !ok {
+				// NOTE: null array for unknown command
+				reply.next().respType = respArray
+				continue
+			}
+			c.encode(reply.next())
+		}
+		reply.setArraySize()
+	case bytes.Equal(sub, subDocsBytes):
+		reply.setArray()
+		for _, c := range cmds.list {
+			if len(args) > 0 && !hasCommand(args, c.name) {
+				continue
+			}
+			reply.next().setBulk([]byte(strings.ToLower(c.name)))
+			// NOTE: proxy has no docs, reply empty map
+			docs := reply.next()
+			docs.setArray()
+			docs.setArraySize()
+		}
+		reply.setArraySize()
+	case bytes.Equal(sub, subGetKeysBytes) && len(args) > 0:
+		commandGetKeys(r, args, cmds)
+	default:
+		reply.setPlain(respError, errCommandSubCmd)
+	}
+}
+
+func hasCommand(args []*resp, name string) bool {
+	for _, arg := range args {
+		if strings.EqualFold(string(bulkData(arg)), name) {
+			return true
+		}
+	}
+	return false
+}
+
+// commandGetKeys reply the keys of command args by the same way of routing.
+func commandGetKeys(r *Request, args []*resp, cmds *Commands) {
+	cmd := &resp{respType: respArray}
+	for _, arg := range args {
+		cmd.next().copy(arg)
+	}
+	cmd.setArraySize()
+	conv.UpdateToUpper(cmd.array[0].data)
+	c, ok := cmds.byName[string(bulkData(cmd.array[0]))]
+	if !ok {
+		r.reply.setPlain(respError, errCommandInvalid)
+		return
+	}
+	var keys [][]byte
+	o, _ := cmds.override(cmd.array[0].data)
+	if o != nil && o.custom != nil {
+		if c.first > 0 {
+			keys = c.appendKeys(keys, cmd)
+		}
+	} else if c.first > 0 || c.movable() {
+		if o != nil && o.data != nil {
+			// NOTE: renamed command
+			cmd.array[0].data = append(cmd.array[0].data[:0], o.data...)
+		}
+		keys = appendKeys(keys, cmd)
+	}
+	if len(keys) == 0 {
+		r.reply.setPlain(respError, errCommandNoKeys)
+		return
+	}
+	r.reply.setArray()
+	for _, key := range keys {
+		r.reply.next().setBulk(key)
+	}
+	r.reply.setArraySize()
+}
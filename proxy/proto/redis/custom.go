@@ -0,0 +1,326 @@
+package redis
+
+import (
+	errs "errors"
+	"strconv"
+	"strings"
+
+	"github.com/ducesoft/overlord/proxy/proto"
+
+	"github.com/pkg/errors"
+)
+
+// command flags of CommandConfig
+const (
+	CommandFlagRead  = "read"
+	CommandFlagWrite = "write"
+)
+
+// merge strategies of CommandConfig
+const (
+	CommandMergeNone  = "none"
+	CommandMergeCount = "count"
+	CommandMergeOK    = "ok"
+	CommandMergeJoin  = "join"
+)
+
+// errors of CommandConfig
+var (
+	ErrCommandName     = errs.New("command name is empty or invalid")
+	ErrCommandFlag     = errs.New("command flag must be read or write")
+	ErrCommandKeys     = errs.New("command key positions are invalid")
+	ErrCommandMerge    = errs.New("command merge must be none, count, ok or join")
+	ErrCommandRename   = errs.New("command rename conflicts with other command")
+	ErrCommandConflict = errs.New("command is configured more than once")
+)
+
+// CommandConfig is the command of cluster config which extends or overrides
+// the command table of proxy, eg: module commands like JSON.GET.
+//
+// First, Last and Step are the key positions in the style of COMMAND INFO,
+// Merge is the strategy of multi keys command which is split by keys:
+//
+//	none:  not split and all the keys must be in the same node.
+//	count: replies the sum of integers, eg: DEL.
+//	ok:    replies OK, eg: MSET.
+//	join:  replies the joined array, eg: MGET.
+//
+// Rename makes the command only callable by the new name, Disable rejects it.
+type CommandConfig struct {
+	Name    string `toml:"name"`
+	Flag    string `toml:"flag"`
+	First   int    `toml:"first"`
+	Last    int    `toml:"last"`
+	Step    int    `toml:"step"`
+	Merge   string `toml:"merge"`
+	Rename  string `toml:"rename"`
+	Disable bool   `toml:"disable"`
+}
+
+// isCustom check whether the command is decoded by config instead of builtin.
+func (cc *CommandConfig) isCustom() bool {
+	_, builtin := commandMap[strings.ToUpper(cc.Name)]
+	return !builtin || cc.Flag != "" || cc.First != 0 || cc.Merge != ""
+}
+
+// customCommand is the command decoded by cluster config instead of builtin.
+type customCommand struct {
+	command
+	// mType is the merge type of command which is split by keys.
+	mType mergeType
+}
+
+// cmdOverride is the client command which is overridden by cluster config.
+type cmdOverride struct {
+	// cmd is nil when the command is disabled.
+	cmd *command
+	// custom is not nil when the command is decoded by config.
+	custom *customCommand
+	// data is the command sent to node when renamed, eg: "4\r\nEVAL".
+	data []byte
+}
+
+// Commands is the command table of cluster.
+type Commands struct {
+	// list is the commands replied by COMMAND.
+	list []*command
+	// byName is the commands by upper name which is called by client.
+	byName map[string]*command
+	// overrides is the overridden commands by the cmd data of client, eg: "4\r\nEVAL".
+	overrides map[string]*cmdOverride
+}
+
+var defaultCommands = &Commands{list: commands, byName: commandMap}
+
+// NewCommands creates the command table of cluster by the builtin commands and configs.
+func NewCommands(ccs []*CommandConfig) (cmds *Commands, err error) {
+	if len(ccs) == 0 {
+		return defaultCommands, nil
+	}
+	configs := map[string]*CommandConfig{}
+	for _, cc := range ccs {
+		name := strings.ToUpper(cc.Name)
+		if !validCommandName(name) {
+			err = errors.Wrapf(ErrCommandName, "name:%q", cc.Name)
+			return
+		}
+		if _, ok := configs[name]; ok {
+			err = errors.Wrapf(ErrCommandConflict, "name:%s", name)
+			return
+		}
+		configs[name] = cc
+	}
+	aliases := map[string]struct{}{}
+	for _, cc := range ccs {
+		if cc.Rename == "" || cc.Disable {
+			continue
+		}
+		alias := strings.ToUpper(cc.Rename)
+		if !validCommandName(alias) {
+			err = errors.Wrapf(ErrCommandName, "rename:%q", cc.Rename)
+			return
+		}
+		_, builtin := commandMap[alias]
+		_, configured := configs[alias]
+		_, renamed := aliases[alias]
+		if builtin || configured || renamed {
+			err = errors.Wrapf(ErrCommandRename, "name:%s rename:%s", cc.Name, alias)
+			return
+		}
+		aliases[alias] = struct{}{}
+	}
+	cmds = &Commands{byName: map[string]*command{}, overrides: map[string]*cmdOverride{}}
+	for _, c := range commands {
+		cc, ok := configs[c.name]
+		switch {
+		case !ok:
+			cmds.add(c)
+		case cc.Disable:
+			cmds.overrides[cmdData(c.name)] = &cmdOverride{}
+		case cc.isCustom():
+			var custom *customCommand
+			if custom, err = newCustomCommand(cc); err != nil {
+				return
+			}
+			cmds.addOverride(cc, &custom.command, custom)
+		default:
+			cmds.addOverride(cc, c, nil)
+		}
+	}
+	for _, cc := range ccs {
+		name := strings.ToUpper(cc.Name)
+		if _, builtin := commandMap[name]; builtin {
+			continue
+		}
+		if cc.Disable {
+			cmds.overrides[cmdData(name)] = &cmdOverride{}
+			continue
+		}
+		var custom *customCommand
+		if custom, err = newCustomCommand(cc); err != nil {
+			return
+		}
+		cmds.addOverride(cc, &custom.command, custom)
+	}
+	return
+}
+
+func (cmds *Commands) add(c *command) {
+	cmds.list = append(cmds.list, c)
+	cmds.byName[c.name] = c
+}
+
+// addOverride add the command c which is called by client with the name of
+// config, the origin name is disabled when renamed.
+func (cmds *Commands) addOverride(cc *CommandConfig, c *command, custom *customCommand) {
+	o := &cmdOverride{cmd: c, custom: custom}
+	if cc.Rename != "" {
+		cmds.overrides[cmdData(c.name)] = &cmdOverride{}
+		o.data = []byte(cmdData(c.name))
+		alias := *c
+		alias.name = strings.ToUpper(cc.Rename)
+		o.cmd = &alias
+	}
+	if o.custom != nil || o.data != nil {
+		cmds.overrides[cmdData(o.cmd.name)] = o
+	}
+	cmds.add(o.cmd)
+}
+
+func newCustomCommand(cc *CommandConfig) (c *customCommand, err error) {
+	c = &customCommand{command: command{name: strings.ToUpper(cc.Name), arity: -1, first: cc.First, last: cc.Last, step: cc.Step}}
+	switch cc.Flag {
+	case CommandFlagRead:
+		c.flags = flagsRead
+	case CommandFlagWrite:
+		c.flags = flagsWrite
+	default:
+		err = errors.Wrapf(ErrCommandFlag, "name:%s flag:%q", c.name, cc.Flag)
+		return
+	}
+	if c.first < 0 || (c.first == 0 && (c.last != 0 || c.step != 0)) {
+		err = errors.Wrapf(ErrCommandKeys, "name:%s first:%d last:%d step:%d", c.name, c.first, c.last, c.step)
+		return
+	}
+	if c.first > 0 {
+		if c.last == 0 {
+			c.last = c.first
+		}
+		if c.step == 0 {
+			c.step = 1
+		}
+		if c.step < 0 || (c.last > 0 && c.last < c.first) {
+			err = errors.Wrapf(ErrCommandKeys, "name:%s first:%d last:%d step:%d", c.name, c.first, c.last, c.step)
+			return
+		}
+		c.arity = -(c.first + 1)
+	}
+	switch cc.Merge {
+	case "", CommandMergeNone:
+		c.mType = mergeTypeNo
+	case CommandMergeCount:
+		c.mType = mergeTypeCount
+	case CommandMergeOK:
+		c.mType = mergeTypeOK
+	case CommandMergeJoin:
+		c.mType = mergeTypeJoin
+	default:
+		err = errors.Wrapf(ErrCommandMerge, "name:%s merge:%q", c.name, cc.Merge)
+		return
+	}
+	if c.mType != mergeTypeNo && c.first == 0 {
+		err = errors.Wrapf(ErrCommandKeys, "name:%s merge:%s without keys", c.name, cc.Merge)
+		return
+	}
+	return
+}
+
+func validCommandName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, b := range []byte(name) {
+		if b <= ' ' || b > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// cmdData returns the cmd data of resp bulk, eg: "4\r\nEVAL".
+func cmdData(name string) string {
+	return strconv.Itoa(len(name)) + "\r\n" + name
+}
+
+// override returns the overridden command of cmd data.
+func (cmds *Commands) override(cmd []byte) (o *cmdOverride, ok bool) {
+	if len(cmds.overrides) == 0 {
+		return
+	}
+	o, ok = cmds.overrides[string(cmd)]
+	return
+}
+
+// decodeCustom decode the command by config, the multi keys command is split
+// by keys when merge strategy is set.
+func (pc *proxyConn) decodeCustom(msg *proto.Message, c *customCommand) {
+	if c.mType == mergeTypeNo {
+		r := nextReq(msg)
+		r.resp.copy(pc.resp)
+		r.cmd = c
+		return
+	}
+	last := c.last
+	if last < 0 {
+		last += pc.resp.arraySize
+	}
+	// NOTE: the args after keys are sent with each key, eg: CMD prefix key [key ...] suffix
+	tail := last + c.step
+	if c.first >= pc.resp.arraySize || last < c.first || tail > pc.resp.arraySize || (last-c.first)%c.step != 0 {
+		r := nextReq(msg)
+		r.resp.copy(pc.resp)
+		r.cmd = c
+		return
+	}
+	for i := c.first; i <= last; i += c.step {
+		r := nextReq(msg)
+		r.cmd = c
+		r.mType = c.mType
+		r.batchOpCount = c.step
+		r.resp.setArray()
+		for j := 0; j < c.first; j++ {
+			r.resp.next().copy(pc.resp.array[j])
+		}
+		for j := i; j < i+c.step; j++ {
+			r.resp.next().copy(pc.resp.array[j])
+		}
+		for j := tail; j < pc.resp.arraySize; j++ {
+			r.resp.next().copy(pc.resp.array[j])
+		}
+		r.resp.setArraySize()
+	}
+}
+
+// mergeCustom merge the keys of reqs which are split by decodeCustom into r,
+// the args after keys are kept at the end.
+func (r *Request) mergeCustom(reqs []proto.Request) {
+	c := r.cmd
+	end := c.first + c.step
+	suffix := r.resp.arraySize - end
+	for i := range reqs {
+		req := reqs[i].(*Request)
+		req.merged = true
+		r.batch = append(r.batch, req)
+		for j := c.first; j < c.first+c.step; j++ {
+			r.resp.next().copy(req.resp.array[j])
+		}
+	}
+	if suffix > 0 {
+		tail := r.resp.array[end:r.resp.arraySize]
+		rotated := make([]*resp, 0, len(tail))
+		rotated = append(rotated, tail[suffix:]...)
+		rotated = append(rotated, tail[:suffix]...)
+		copy(tail, rotated)
+	}
+	r.resp.setArraySize()
+}
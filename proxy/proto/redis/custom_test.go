@@ -0,0 +1,128 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ducesoft/overlord/pkg/conv"
+	"github.com/ducesoft/overlord/pkg/mockconn"
+	libnet "github.com/ducesoft/overlord/pkg/net"
+	"github.com/ducesoft/overlord/proxy/proto"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func _decodeWithCommands(t *testing.T, data string, cmds *Commands) (*ProxyConn, []*proto.Message) {
+	conn := libnet.NewConn(mockconn.CreateConn([]byte(data), 1), time.Second, time.Second)
+	pc := NewProxyConn(conn, true).(*ProxyConn)
+	pc.WithCommands(cmds)
+	nmsgs, err := pc.Decode(proto.GetMsgs(16))
+	assert.NoError(t, err)
+	return pc, nmsgs
+}
+
+func TestNewCommandsValidate(t *testing.T) {
+	cmds, err := NewCommands(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, defaultCommands, cmds)
+
+	ts := []struct {
+		Name  string
+		Conf  []*CommandConfig
+		Cause error
+	}{
+		{"empty name", []*CommandConfig{{Name: ""}}, ErrCommandName},
+		{"duplicate", []*CommandConfig{{Name: "eval", Disable: true}, {Name: "EVAL", Rename: "X"}}, ErrCommandConflict},
+		{"rename builtin", []*CommandConfig{{Name: "EVAL", Rename: "get"}}, ErrCommandRename},
+		{"rename twice", []*CommandConfig{{Name: "EVAL", Rename: "X"}, {Name: "EVALSHA", Rename: "x"}}, ErrCommandRename},
+		{"no flag", []*CommandConfig{{Name: "JSON.GET", First: 1}}, ErrCommandFlag},
+		{"bad keys", []*CommandConfig{{Name: "JSON.GET", Flag: "read", First: 2, Last: 1}}, ErrCommandKeys},
+		{"bad merge", []*CommandConfig{{Name: "JSON.MGET", Flag: "read", First: 1, Last: -2, Merge: "sum"}}, ErrCommandMerge},
+		{"merge without keys", []*CommandConfig{{Name: "FT.SEARCH", Flag: "read", Merge: "join"}}, ErrCommandKeys},
+	}
+	for _, tt := range ts {
+		t.Run(tt.Name, func(t *testing.T) {
+			_, err := NewCommands(tt.Conf)
+			assert.Equal(t, tt.Cause, errors.Cause(err))
+		})
+	}
+}
+
+func TestCommandsDisableRename(t *testing.T) {
+	cmds, err := NewCommands([]*CommandConfig{
+		{Name: "setex", Disable: true},
+		{Name: "EVAL", Rename: "myeval"},
+	})
+	assert.NoError(t, err)
+	pc, nmsgs := _decodeWithCommands(t, "SETEX a 1 b\r\nMULTI\r\nEVAL s 1 a\r\nMYEVAL s 1 b\r\nEXEC\r\n", cmds)
+	assert.Len(t, nmsgs, 5)
+
+	disabled := nmsgs[2].Request().(*Request)
+	assert.True(t, disabled.IsCtl())
+	assert.Equal(t, notSupportDataBytes, disabled.reply.data)
+	// NOTE: the disabled command aborts the transaction as unknown command of redis
+	assert.True(t, pc.txn.aborted)
+
+	renamed := nmsgs[3].Request().(*Request)
+	assert.Equal(t, []byte("4\r\nEVAL"), renamed.resp.array[0].data)
+	assert.Equal(t, "b", string(renamed.Key()))
+
+	_, ok := cmds.byName["EVAL"]
+	assert.False(t, ok)
+	_, ok = cmds.byName["MYEVAL"]
+	assert.True(t, ok)
+	_, ok = cmds.byName["SETEX"]
+	assert.False(t, ok)
+	assert.Equal(t, len(commands)-1, len(cmds.list))
+}
+
+func TestCommandsCustom(t *testing.T) {
+	cmds, err := NewCommands([]*CommandConfig{
+		{Name: "JSON.GET", Flag: "read", First: 1},
+		{Name: "JSON.MGET", Flag: "read", First: 1, Last: -2, Merge: "join"},
+		{Name: "BF.ADD", Flag: "write", First: 1},
+		{Name: "FT.SEARCH", Flag: "read"},
+	})
+	assert.NoError(t, err)
+	_, nmsgs := _decodeWithCommands(t, "JSON.GET a $\r\nJSON.MGET a b c $\r\nbf.add f x\r\nFT.SEARCH idx q\r\nCOMMAND COUNT\r\nCOMMAND GETKEYS JSON.MGET a b $\r\n", cmds)
+	assert.Len(t, nmsgs, 6)
+
+	get := nmsgs[0].Request().(*Request)
+	assert.True(t, get.IsSupport())
+	assert.Equal(t, "a", string(get.Key()))
+
+	reqs := nmsgs[1].Requests()
+	assert.True(t, nmsgs[1].IsBatch())
+	assert.Len(t, reqs, 3)
+	for i, key := range []string{"a", "b", "c"} {
+		req := reqs[i].(*Request)
+		assert.Equal(t, mergeTypeJoin, req.mType)
+		assert.Equal(t, "*3\r\n$9\r\nJSON.MGET\r\n$1\r\n"+key+"\r\n$1\r\n$\r\n", _encodeResp(t, req.resp))
+	}
+	// NOTE: merged again when in the same node
+	main := reqs[0].(*Request)
+	assert.NoError(t, main.Merge(reqs[1:]))
+	assert.Equal(t, "*5\r\n$9\r\nJSON.MGET\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n$1\r\n$\r\n", _encodeResp(t, main.resp))
+	assert.Len(t, main.Keys(), 3)
+
+	bf := nmsgs[2].Request().(*Request)
+	assert.True(t, bf.IsSupport())
+	assert.Equal(t, "f", string(bf.Key()))
+
+	search := nmsgs[3].Request().(*Request)
+	assert.True(t, search.IsSupport())
+	assert.Empty(t, search.Keys())
+
+	count := nmsgs[4].Request().(*Request)
+	assert.Equal(t, int64(len(commands)+4), mustInt(t, count.reply))
+	getkeys := nmsgs[5].Request().(*Request)
+	assert.Equal(t, "*2\r\n$1\r\na\r\n$1\r\nb\r\n", _encodeResp(t, getkeys.reply))
+}
+
+func mustInt(t *testing.T, r *resp) int64 {
+	assert.Equal(t, respInt, r.respType)
+	n, err := conv.Btoi(r.data)
+	assert.NoError(t, err)
+	return n
+}
@@ -0,0 +1,96 @@
+package redis
+
+import (
+	"github.com/ducesoft/overlord/proxy/proto"
+)
+
+// ErrReplyTooLarge is the error of reply exceeds the size limit, the reply is
+// dropped by node conn and the error only fails the request itself.
+var ErrReplyTooLarge = proto.NewReplyError("ERR reply is too large")
+
+var (
+	errArgTooLarge = []byte("ERR Protocol error: argument is too large")
+	errReqTooLarge = []byte("ERR Protocol error: request is too large")
+	errTooManyKeys = []byte("ERR too many keys")
+)
+
+// sizeLimit returns the limit of request decoded which begins at mark, nil if unlimited.
+func (pc *proxyConn) sizeLimit(mark int) *sizeLimit {
+	if pc.limits == nil || (pc.limits.MaxValue == 0 && pc.limits.MaxRequest == 0) {
+		return nil
+	}
+	pc.limit = sizeLimit{bulk: pc.limits.MaxValue, total: pc.limits.MaxRequest, start: mark}
+	return &pc.limit
+}
+
+// rejectLarge reply the protocol error like redis proto-max-bulk-len, the conn
+// is closed after replied because the rest of request is never read.
+func (pc *proxyConn) rejectLarge(msg *proto.Message, err error) {
+	r := nextReq(msg)
+	copyHead(r.resp, pc.resp)
+	if err == ErrBulkTooLarge {
+		r.replyLocal(respError, errArgTooLarge)
+	} else {
+		r.replyLocal(respError, errReqTooLarge)
+	}
+	pc.limits.Reject(pc.client.addr, r.Key(), err)
+	pc.err = err
+}
+
+// tooManyKeys reply error when the keys of request exceed the limit.
+func (pc *proxyConn) tooManyKeys(msg *proto.Message, c *customCommand) bool {
+	if pc.limits.MaxKeys == 0 || pc.resp.arraySize-1 <= pc.limits.MaxKeys {
+		return false
+	}
+	pc.keyIdx = keyIndex(pc.keyIdx[:0], pc.resp, c)
+	if !pc.limits.TooManyKeys(len(pc.keyIdx)) {
+		return false
+	}
+	r := nextReq(msg)
+	copyHead(r.resp, pc.resp)
+	r.replyLocal(respError, errTooManyKeys)
+	pc.limits.Reject(pc.client.addr, r.Key(), ErrTooManyKeys)
+	return true
+}
+
+// copyHead copy the command and the first argument of src which are decoded,
+// the rest arguments of the rejected request are not copied.
+func copyHead(dst, src *resp) {
+	dst.setArray()
+	for i := 0; i < src.arraySize && i < 2; i++ {
+		if len(src.array[i].data) == 0 {
+			// NOTE: the bulk is not decoded
+			break
+		}
+		dst.next().copy(src.array[i])
+	}
+	dst.setArraySize()
+}
+
+// checkBigValues log and count the big arguments of request.
+func (pc *proxyConn) checkBigValues() {
+	if pc.limits.BigValue == 0 || pc.resp.arraySize < 2 {
+		return
+	}
+	for i := 1; i < pc.resp.arraySize; i++ {
+		if n := len(bulkData(pc.resp.array[i])); n > pc.limits.BigValue {
+			pc.limits.CheckBigValue(pc.client.addr, firstKey(pc.resp), n)
+		}
+	}
+}
+
+// checkBigReplies log and count the big bulk replies of message.
+func (pc *proxyConn) checkBigReplies(m *proto.Message) {
+	if pc.limits.BigValue == 0 {
+		return
+	}
+	for _, mreq := range m.Requests() {
+		r := mreq.(*Request)
+		if r.reply.respType != respBulk || len(r.reply.data) == 0 {
+			continue
+		}
+		if n := len(bulkData(r.reply)); n > pc.limits.BigValue {
+			pc.limits.CheckBigValue(pc.client.addr, r.Key(), n)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ducesoft/overlord/pkg/bufio"
+	"github.com/ducesoft/overlord/pkg/mockconn"
+	libnet "github.com/ducesoft/overlord/pkg/net"
+	"github.com/ducesoft/overlord/proxy/proto"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func _encodeMsgs(t *testing.T, msgs []*proto.Message) string {
+	conn, buf := mockconn.CreateDownStreamConn()
+	pc := NewProxyConn(libnet.NewConn(conn, time.Second, time.Second), true)
+	for _, msg := range msgs {
+		assert.NoError(t, pc.Encode(msg))
+	}
+	assert.NoError(t, pc.Flush())
+	return buf.String()
+}
+
+func TestLimitsRejectLarge(t *testing.T) {
+	ts := []struct {
+		Name   string
+		Data   string
+		Limits *proto.Limits
+		Err    error
+		Reply  string
+	}{
+		{"argument", "*3\r\n$3\r\nSET\r\n$1\r\na\r\n$10\r\n0123456789\r\n", &proto.Limits{MaxValue: 5}, ErrBulkTooLarge, "-ERR Protocol error: argument is too large\r\n"},
+		{"key", "*2\r\n$3\r\nGET\r\n$10\r\n0123456789\r\n", &proto.Limits{MaxValue: 5}, ErrBulkTooLarge, "-ERR Protocol error: argument is too large\r\n"},
+		{"request", "*4\r\n$3\r\nDEL\r\n$4\r\nabcd\r\n$4\r\nefgh\r\n$4\r\nijkl\r\n", &proto.Limits{MaxRequest: 32}, ErrRespTooLarge, "-ERR Protocol error: request is too large\r\n"},
+	}
+	for _, tt := range ts {
+		t.Run(tt.Name, func(t *testing.T) {
+			// NOTE: the request after rejected is never decoded
+			conn := libnet.NewConn(mockconn.CreateConn([]byte("*1\r\n$4\r\nPING\r\n"+tt.Data+"*1\r\n$4\r\nPING\r\n"), 1), time.Second, time.Second)
+			pc := NewProxyConn(conn, true).(*ProxyConn)
+			pc.WithLimits(tt.Limits)
+			msgs, err := pc.Decode(proto.GetMsgs(4))
+			assert.NoError(t, err)
+			assert.Len(t, msgs, 2)
+			req := msgs[1].Request().(*Request)
+			assert.True(t, req.IsCtl())
+			assert.Equal(t, tt.Reply, _encodeMsgs(t, msgs[1:]))
+			assert.Equal(t, int64(1), tt.Limits.Rejected())
+
+			_, err = pc.Decode(proto.GetMsgs(4))
+			assert.Equal(t, tt.Err, errors.Cause(err))
+		})
+	}
+}
+
+func TestLimitsTooManyKeys(t *testing.T) {
+	l := &proto.Limits{MaxKeys: 2}
+	conn := libnet.NewConn(mockconn.CreateConn([]byte("MGET a b c\r\nMSET a 1 b 2\r\nDEL a b c\r\nMGET a b\r\n"), 1), time.Second, time.Second)
+	pc := NewProxyConn(conn, true).(*ProxyConn)
+	pc.WithLimits(l)
+	msgs, err := pc.Decode(proto.GetMsgs(8))
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 4)
+	for _, i := range []int{0, 2} {
+		assert.Len(t, msgs[i].Requests(), 1)
+		assert.True(t, msgs[i].Request().(*Request).IsCtl())
+		assert.Equal(t, "a", string(msgs[i].Request().Key()))
+	}
+	assert.Len(t, msgs[1].Requests(), 2)
+	assert.Len(t, msgs[3].Requests(), 2)
+	assert.Equal(t, "-ERR too many keys\r\n-ERR too many keys\r\n", _encodeMsgs(t, []*proto.Message{msgs[0], msgs[2]}))
+	assert.Equal(t, int64(2), l.Rejected())
+}
+
+func TestLimitsBigValue(t *testing.T) {
+	l := &proto.Limits{BigValue: 4}
+	conn := libnet.NewConn(mockconn.CreateConn([]byte("SET a 12345\r\nSET b 1234\r\nGET c\r\n"), 1), time.Second, time.Second)
+	pc := NewProxyConn(conn, true).(*ProxyConn)
+	pc.WithLimits(l)
+	msgs, err := pc.Decode(proto.GetMsgs(4))
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 3)
+	assert.False(t, msgs[0].Request().(*Request).IsCtl())
+	assert.Equal(t, int64(1), l.BigValues())
+
+	msgs[2].Request().(*Request).reply.setBulk([]byte("abcdef"))
+	conn2, _ := mockconn.CreateDownStreamConn()
+	out := NewProxyConn(libnet.NewConn(conn2, time.Second, time.Second), true).(*ProxyConn)
+	out.WithLimits(l)
+	assert.NoError(t, out.Encode(msgs[2]))
+	assert.Equal(t, int64(2), l.BigValues())
+	assert.Equal(t, int64(0), l.Rejected())
+}
+
+func TestNodeConnMaxReply(t *testing.T) {
+	l := &proto.Limits{MaxReply: 16}
+	conn := libnet.NewConn(mockconn.CreateConn([]byte("$3\r\nabc\r\n$10\r\n0123456789\r\n*2\r\n$1\r\nx\r\n$20\r\n01234567890123456789\r\n$1\r\nc\r\n"), 1), time.Second, time.Second)
+	nc := newNodeConn("baka", "127.0.0.1:12345", conn).(*nodeConn)
+	nc.WithLimits(l)
+	msg := proto.NewMessage()
+	msg.WithRequest(newRequest("GET", "a"))
+	assert.NoError(t, nc.Read(msg))
+	assert.Equal(t, "abc", string(bulkData(msg.Request().(*Request).reply)))
+
+	msg = proto.NewMessage()
+	msg.WithRequest(newRequest("GET", "b"))
+	err := nc.Read(msg)
+	assert.Equal(t, ErrReplyTooLarge, errors.Cause(err))
+	assert.True(t, proto.IsReplyError(err))
+	assert.Equal(t, int64(1), l.Rejected())
+
+	// the large replies are dropped and the conn is kept
+	msg = proto.NewMessage()
+	msg.WithRequest(newRequest("LRANGE", "b", "0", "-1"))
+	assert.Equal(t, ErrReplyTooLarge, errors.Cause(nc.Read(msg)))
+	msg = proto.NewMessage()
+	msg.WithRequest(newRequest("GET", "c"))
+	assert.NoError(t, nc.Read(msg))
+	assert.Equal(t, "c", string(bulkData(msg.Request().(*Request).reply)))
+}
+
+func TestRespDecodeLimit(t *testing.T) {
+	conn := libnet.NewConn(mockconn.CreateConn([]byte("*2\r\n$3\r\nGET\r\n$100000000\r\n"), 1), time.Second, time.Second)
+	pc := NewProxyConn(conn, true).(*ProxyConn)
+	assert.NoError(t, pc.br.Read())
+	mark := pc.br.Mark()
+	r := &resp{}
+	err := r.decodeLimit(pc.br, &sizeLimit{bulk: 1024, start: mark})
+	assert.Equal(t, ErrBulkTooLarge, err)
+	assert.Equal(t, mark, pc.br.Mark())
+	// NOTE: without limit, the announced length is waited to be buffered
+	err = r.decode(pc.br)
+	assert.Equal(t, bufio.ErrBufferFull, err)
+}
@@ -0,0 +1,57 @@
+package redis
+
+import (
+	"bytes"
+	"strconv"
+
+	"github.com/ducesoft/overlord/proxy/proto"
+)
+
+var (
+	argNxBytes = []byte("NX")
+	argExBytes = []byte("EX")
+)
+
+// isPlainGet check whether the request is GET of single key.
+func (r *Request) isPlainGet() bool {
+	return r.resp.arraySize == 2 && r.mType == mergeTypeNo && r.cmd == nil && r.sess == nil &&
+		bytes.Equal(r.resp.array[0].data, cmdGetBytes)
+}
+
+// isNullReply check whether the reply is null bulk.
+func (r *Request) isNullReply() bool {
+	return r.reply.respType == respBulk && len(r.reply.data) == 0
+}
+
+// Fallback impl the proto.Migrater, only the GET replied nil falls back.
+func (r *Request) Fallback() proto.Request {
+	if !r.isPlainGet() || !r.isNullReply() {
+		return nil
+	}
+	return r.Clone()
+}
+
+// Hit impl the proto.Migrater, the GET replied the value hits.
+func (r *Request) Hit() bool {
+	return r.isPlainGet() && r.reply.respType == respBulk && !r.isNullReply()
+}
+
+// Repair impl the proto.Migrater by SET key value NX [EX ttl].
+func (r *Request) Repair(ttl int) proto.Request {
+	if !r.Hit() {
+		return nil
+	}
+	nr := getReq()
+	nr.resp.setArray()
+	nr.resp.next().setPlain(respBulk, cmdSetBytes)
+	nr.resp.next().copy(r.resp.array[1])
+	nr.resp.next().copy(r.reply)
+	nr.resp.next().setBulk(argNxBytes)
+	if ttl > 0 {
+		nr.resp.next().setBulk(argExBytes)
+		nr.resp.next().setBulk([]byte(strconv.Itoa(ttl)))
+	}
+	nr.resp.setArraySize()
+	nr.db = r.db
+	return nr
+}
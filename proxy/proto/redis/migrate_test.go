@@ -0,0 +1,43 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ducesoft/overlord/pkg/mockconn"
+	libnet "github.com/ducesoft/overlord/pkg/net"
+	"github.com/ducesoft/overlord/proxy/proto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrateFallback(t *testing.T) {
+	conn := libnet.NewConn(mockconn.CreateConn([]byte("GET a\r\nHGET a b\r\n"), 1), time.Second, time.Second)
+	pc := NewProxyConn(conn, true)
+	nmsgs, err := pc.Decode(proto.GetMsgs(4))
+	assert.NoError(t, err)
+	assert.Len(t, nmsgs, 2)
+
+	get := nmsgs[0].Request().(*Request)
+	get.reply.setBulk(nil)
+	fb := get.Fallback().(*Request)
+	assert.Equal(t, "*2\r\n$3\r\nGET\r\n$1\r\na\r\n", _encodeResp(t, fb.resp))
+	assert.True(t, get.isNullReply())
+	assert.False(t, get.Hit())
+	assert.Nil(t, get.Repair(0))
+	get.reply.setBulk([]byte{})
+	assert.Nil(t, get.Fallback())
+	hget := nmsgs[1].Request().(*Request)
+	hget.reply.setBulk(nil)
+	assert.Nil(t, hget.Fallback())
+	assert.Nil(t, hget.Repair(0))
+
+	fb.reply.setBulk([]byte("v"))
+	assert.True(t, fb.Hit())
+	get.CopyReply(fb)
+	assert.True(t, get.Hit())
+	repair := get.Repair(0).(*Request)
+	assert.Equal(t, "*4\r\n$3\r\nSET\r\n$1\r\na\r\n$1\r\nv\r\n$2\r\nNX\r\n", _encodeResp(t, repair.resp))
+	repair = get.Repair(60).(*Request)
+	assert.Equal(t, "*6\r\n$3\r\nSET\r\n$1\r\na\r\n$1\r\nv\r\n$2\r\nNX\r\n$2\r\nEX\r\n$2\r\n60\r\n", _encodeResp(t, repair.resp))
+}
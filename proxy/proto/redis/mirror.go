@@ -0,0 +1,41 @@
+package redis
+
+import (
+	"bytes"
+
+	"github.com/ducesoft/overlord/proxy/proto"
+)
+
+// Clone impl the proto.Mirrorer, the requests replied by proxy, bound to the
+// client conn or computed by proxy are never mirrored.
+func (r *Request) Clone() proto.Request {
+	if r.IsCtl() || !r.IsSupport() || r.isTxn() || r.sess != nil || r.blocking || r.alg != nil {
+		return nil
+	}
+	nr := getReq()
+	nr.resp.copy(r.resp)
+	nr.mType = r.mType
+	nr.batchOpCount = r.batchOpCount
+	nr.broadcast = r.broadcast
+	nr.db = r.db
+	nr.cmd = r.cmd
+	return nr
+}
+
+// ReplyEqual impl the proto.Mirrorer.
+func (r *Request) ReplyEqual(o proto.Request) bool {
+	or, ok := o.(*Request)
+	return ok && respEqual(r.reply, or.reply)
+}
+
+func respEqual(a, b *resp) bool {
+	if a.respType != b.respType || !bytes.Equal(a.data, b.data) || a.arraySize != b.arraySize {
+		return false
+	}
+	for i := 0; i < a.arraySize; i++ {
+		if !respEqual(a.array[i], b.array[i]) {
+			return false
+		}
+	}
+	return true
+}
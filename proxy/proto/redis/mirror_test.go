@@ -0,0 +1,43 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ducesoft/overlord/pkg/mockconn"
+	libnet "github.com/ducesoft/overlord/pkg/net"
+	"github.com/ducesoft/overlord/proxy/proto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMirrorClone(t *testing.T) {
+	conn := libnet.NewConn(mockconn.CreateConn([]byte("GET a\r\nPING\r\nMGET a b\r\nMULTI\r\n"), 1), time.Second, time.Second)
+	pc := NewProxyConn(conn, true)
+	nmsgs, err := pc.Decode(proto.GetMsgs(8))
+	assert.NoError(t, err)
+	assert.Len(t, nmsgs, 4)
+
+	req := nmsgs[0].Request().(*Request)
+	clone := req.Clone().(*Request)
+	assert.True(t, respEqual(req.resp, clone.resp))
+	assert.Equal(t, req.mType, clone.mType)
+	assert.Equal(t, "GET", clone.CmdString())
+	assert.Nil(t, nmsgs[1].Request().(*Request).Clone())
+	for _, sub := range nmsgs[2].Requests() {
+		assert.NotNil(t, sub.(*Request).Clone())
+	}
+	assert.Nil(t, nmsgs[3].Request().(*Request).Clone())
+
+	// the clone never shares the buffers of request
+	req.resp.array[1].setBulk([]byte("b"))
+	assert.Equal(t, "1\r\na", string(clone.resp.array[1].data))
+
+	req.reply.setBulk([]byte("hello"))
+	clone.reply.setBulk([]byte("hello"))
+	assert.True(t, clone.ReplyEqual(req))
+	clone.reply.setBulk([]byte("world"))
+	assert.False(t, clone.ReplyEqual(req))
+	clone.reply.setBulk(nil)
+	assert.False(t, clone.ReplyEqual(req))
+}
@@ -0,0 +1,36 @@
+package redis
+
+import (
+	"bytes"
+
+	"github.com/ducesoft/overlord/proxy/proto"
+)
+
+// NearCacheOp impl the proto.NearCacher, only the plain GET of db 0 is cached
+// and all the requests may write are invalidated by keys.
+func (r *Request) NearCacheOp() proto.NearCacheOp {
+	if r.IsCtl() || r.resp.arraySize < 2 {
+		return proto.NearCacheNone
+	}
+	if !r.IsReadOnly() {
+		return proto.NearCacheInvalidate
+	}
+	if r.resp.arraySize == 2 && r.mType == mergeTypeNo && r.cmd == nil && r.db == 0 && r.sess == nil &&
+		bytes.Equal(r.resp.array[0].data, cmdGetBytes) {
+		return proto.NearCacheRead
+	}
+	return proto.NearCacheNone
+}
+
+// NearCacheReply impl the proto.NearCacher, only the non-null bulk is cached.
+func (r *Request) NearCacheReply() []byte {
+	if r.reply.respType != respBulk || len(r.reply.data) == 0 {
+		return nil
+	}
+	return r.reply.data
+}
+
+// ReplyNearCache impl the proto.NearCacher and replies the cached bulk.
+func (r *Request) ReplyNearCache(data []byte) {
+	r.replyLocal(respBulk, data)
+}
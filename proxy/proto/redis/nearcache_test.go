@@ -0,0 +1,61 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ducesoft/overlord/pkg/mockconn"
+	libnet "github.com/ducesoft/overlord/pkg/net"
+	"github.com/ducesoft/overlord/proxy/proto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNearCacheOp(t *testing.T) {
+	ts := []struct {
+		Name string
+		Data string
+		Op   proto.NearCacheOp
+	}{
+		{"get", "GET a\r\n", proto.NearCacheRead},
+		{"get extra", "GET a b\r\n", proto.NearCacheNone},
+		{"mget", "MGET a b\r\n", proto.NearCacheNone},
+		{"strlen", "STRLEN a\r\n", proto.NearCacheNone},
+		{"set", "SET a 1\r\n", proto.NearCacheInvalidate},
+		{"del", "DEL a\r\n", proto.NearCacheInvalidate},
+		{"ping", "PING\r\n", proto.NearCacheNone},
+	}
+	for _, tt := range ts {
+		t.Run(tt.Name, func(t *testing.T) {
+			conn := libnet.NewConn(mockconn.CreateConn([]byte(tt.Data), 1), time.Second, time.Second)
+			nmsgs, err := NewProxyConn(conn, true).Decode(proto.GetMsgs(4))
+			assert.NoError(t, err)
+			assert.Len(t, nmsgs, 1)
+			assert.Equal(t, tt.Op, nmsgs[0].Request().(proto.NearCacher).NearCacheOp())
+		})
+	}
+}
+
+func TestNearCacheReply(t *testing.T) {
+	conn := libnet.NewConn(mockconn.CreateConn([]byte("GET a\r\nGET a\r\n"), 1), time.Second, time.Second)
+	pc := NewProxyConn(conn, true)
+	nmsgs, err := pc.Decode(proto.GetMsgs(4))
+	assert.NoError(t, err)
+	assert.Len(t, nmsgs, 2)
+
+	req := nmsgs[0].Request().(*Request)
+	req.reply.setBulk(nil)
+	assert.Nil(t, req.NearCacheReply())
+	req.reply.setPlain(respError, []byte("ERR"))
+	assert.Nil(t, req.NearCacheReply())
+	req.reply.setBulk([]byte("hello"))
+	assert.Equal(t, []byte("5\r\nhello"), req.NearCacheReply())
+
+	hit := nmsgs[1].Request().(*Request)
+	hit.ReplyNearCache(req.NearCacheReply())
+	assert.True(t, hit.IsCtl())
+	assert.Equal(t, proto.NearCacheNone, hit.NearCacheOp())
+	assert.NoError(t, pc.Encode(nmsgs[1]))
+	assert.NoError(t, pc.Flush())
+	assert.Equal(t, "$5\r\nhello\r\n", conn.Conn.(*mockconn.MockConn).Wbuf.String())
+}
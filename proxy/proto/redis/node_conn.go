@@ -2,10 +2,12 @@ package redis
 
 import (
 	errs "errors"
+	"strconv"
 	"sync/atomic"
 	"time"
 
 	"github.com/ducesoft/overlord/pkg/bufio"
+	"github.com/ducesoft/overlord/pkg/conv"
 	libnet "github.com/ducesoft/overlord/pkg/net"
 	"github.com/ducesoft/overlord/proxy/proto"
 
@@ -19,19 +21,84 @@ const (
 	nodeReadBufSize = 2 * 1024 * 1024 // NOTE: 2MB
 )
 
+// states of READONLY
+const (
+	readOnlyNone = iota
+	readOnlyPending
+	readOnlyWritten
+	readOnlyDone
+)
+
 var (
 	// ErrNodeConnClosed err node conn closed.
 	ErrNodeConnClosed = errs.New("redis node conn closed")
+	// ErrSelectDB err node conn fail to select db, which only fails the request.
+	ErrSelectDB = proto.NewReplyError("ERR redis node conn select db fail")
+	// ErrReadOnly err node conn fail to send READONLY to replica.
+	ErrReadOnly = errs.New("redis node conn readonly fail")
+
+	selectPrefixBytes = []byte("*2\r\n$6\r\nSELECT\r\n$")
+	readOnlyBytes     = []byte("*1\r\n$8\r\nREADONLY\r\n")
+	configDBsBytes    = []byte("*3\r\n$6\r\nCONFIG\r\n$3\r\nGET\r\n$9\r\ndatabases\r\n")
 )
 
 // NodeConn is export type by nodeConn for redis-cluster.
 type NodeConn = nodeConn
 
+// Wrapper is the request wrapping the redis request, eg: the request redirected
+// by redis-cluster, which is written to and read from node as the wrapped one.
+type Wrapper interface {
+	Unwrap() *Request
+}
+
+// RequestOf returns the redis request of message, which may be wrapped.
+func RequestOf(m *proto.Message) (req *Request, ok bool) {
+	switch r := m.Request().(type) {
+	case *Request:
+		return r, true
+	case Wrapper:
+		return r.Unwrap(), true
+	}
+	return
+}
+
 // Bw return bufio.Writer.
 func (nc *NodeConn) Bw() *bufio.Writer {
 	return nc.bw
 }
 
+// Discard reads and drops the reply of command written by Bw, eg: ASKING.
+func (nc *NodeConn) Discard() error {
+	return nc.readReply(nc.scratch)
+}
+
+// WithReadOnly send READONLY before the first request, the conn is used to
+// read from the replica of redis cluster.
+func (nc *NodeConn) WithReadOnly() {
+	nc.readonly = readOnlyPending
+}
+
+// WithDatabases check the databases of node by CONFIG GET databases before the
+// first request, the request of db out of range of node is never sent.
+func (nc *NodeConn) WithDatabases(n int) {
+	if n > 1 {
+		nc.databases = n
+		nc.dbsPending = true
+	}
+}
+
+// WithLimits limit the size of replies, the conn is closed when the reply
+// exceeds because the rest of reply is never read.
+func (nc *NodeConn) WithLimits(l *proto.Limits) {
+	nc.limits = l
+}
+
+// WithScripts set the script cache of cluster, the EVALSHA answered NOSCRIPT
+// is retried after SCRIPT LOAD on the conn.
+func (nc *NodeConn) WithScripts(s *ScriptCache) {
+	nc.scripts = s
+}
+
 type nodeConn struct {
 	cluster string
 	addr    string
@@ -39,6 +106,29 @@ type nodeConn struct {
 	bw      *bufio.Writer
 	br      *bufio.Reader
 
+	// scratch is used to read the replies of SELECT, MULTI and queued commands.
+	scratch *resp
+	// db is the current db of conn written, -1 means unknown after SELECT fails.
+	// readDB is the db of conn when the reply is read, the reply of request
+	// which is sent in another db after SELECT fails is dropped.
+	db     int
+	readDB int
+	// databases is the number of db can be selected, which is checked by
+	// CONFIG GET databases of node before the first request if pending.
+	databases  int
+	dbsPending bool
+	// readonly is the state of READONLY sent to replica.
+	readonly int
+	// limits rejects the replies exceed the size limit of cluster.
+	limits *proto.Limits
+	limit  sizeLimit
+	// scripts is the script cache of cluster to reload the scripts answered
+	// NOSCRIPT, pending is the count of requests written but not read and the
+	// reloads are read after them.
+	scripts *ScriptCache
+	pending int
+	reloads []scriptReload
+
 	state int32
 }
 
@@ -55,6 +145,7 @@ func newNodeConn(cluster, addr string, conn *libnet.Conn) proto.NodeConn {
 		conn:    conn,
 		br:      bufio.NewReader(conn, bufio.Get(nodeReadBufSize)),
 		bw:      bufio.NewWriter(conn),
+		scratch: &resp{},
 	}
 }
 
@@ -71,7 +162,7 @@ func (nc *nodeConn) Write(m *proto.Message) (err error) {
 		err = errors.WithStack(ErrNodeConnClosed)
 		return
 	}
-	req, ok := m.Request().(*Request)
+	req, ok := RequestOf(m)
 	if !ok {
 		err = errors.WithStack(ErrBadAssert)
 		return
@@ -79,9 +170,37 @@ func (nc *nodeConn) Write(m *proto.Message) (err error) {
 	if !req.IsSupport() || req.IsCtl() {
 		return
 	}
+	if nc.dbsPending {
+		if err = nc.checkDatabases(); err != nil {
+			return
+		}
+	}
+	if req.db > 0 && nc.databases > 0 && req.db >= nc.databases {
+		req.dbSkipped = true
+		return
+	}
+	if nc.readonly == readOnlyPending {
+		_ = nc.bw.Write(readOnlyBytes)
+		nc.readonly = readOnlyWritten
+	}
+	if req.db != nc.db {
+		nc.writeSelect(req.db)
+		req.dbSelected = true
+	}
+	if req.isTxn() {
+		_ = nc.bw.Write(multiBytes)
+		for _, cmd := range req.txn.Array() {
+			if err = cmd.encode(nc.bw); err != nil {
+				err = errors.WithStack(err)
+				return
+			}
+		}
+	}
 	if err = req.resp.encode(nc.bw); err != nil {
 		err = errors.WithStack(err)
+		return
 	}
+	nc.pending++
 	return
 }
 
@@ -97,7 +216,7 @@ func (nc *nodeConn) Read(m *proto.Message) (err error) {
 		err = errors.WithStack(ErrNodeConnClosed)
 		return
 	}
-	req, ok := m.Request().(*Request)
+	req, ok := RequestOf(m)
 	if !ok {
 		err = errors.WithStack(ErrBadAssert)
 		return
@@ -105,13 +224,119 @@ func (nc *nodeConn) Read(m *proto.Message) (err error) {
 	if !req.IsSupport() || req.IsCtl() {
 		return
 	}
+	if req.dbSkipped {
+		err = errors.Wrapf(ErrSelectDB, "db:%d databases:%d", req.db, nc.databases)
+		return
+	}
+	if nc.pending > 0 {
+		nc.pending--
+	}
+	if req.blocking {
+		rto := nc.conn.ReadTimeout()
+		nc.conn.SetReadTimeout(blockReadTimeout(rto, req.block))
+		defer nc.conn.SetReadTimeout(rto)
+	}
+	if nc.readonly == readOnlyWritten {
+		if err = nc.readReply(nc.scratch); err != nil {
+			return
+		}
+		if nc.scratch.respType == respError {
+			err = errors.Wrapf(ErrReadOnly, "reply:%s", nc.scratch.data)
+			return
+		}
+		nc.readonly = readOnlyDone
+	}
+	var selectErr error
+	if req.dbSelected {
+		if err = nc.readReply(nc.scratch); err != nil {
+			return
+		}
+		if nc.scratch.respType == respError {
+			selectErr = errors.Wrapf(ErrSelectDB, "db:%d reply:%s", req.db, nc.scratch.data)
+			// NOTE: the db of conn is kept, SELECT again before the next request.
+			nc.db = -1
+		} else {
+			nc.readDB = req.db
+		}
+	}
+	if selectErr == nil && req.db != nc.readDB {
+		// NOTE: the request is sent after SELECT fails and runs in another db.
+		selectErr = errors.Wrapf(ErrSelectDB, "db:%d sent in db:%d", req.db, nc.readDB)
+	}
+	if req.isTxn() {
+		// NOTE: drop the replies of MULTI and queued commands, EXEC replies all
+		for i := 0; i <= req.txn.arraySize; i++ {
+			if err = nc.readReply(nc.scratch); err != nil {
+				return
+			}
+		}
+	}
+	if err = nc.readReply(req.reply); err != nil {
+		if errors.Cause(err) == ErrReplyTooLarge {
+			nc.limits.Reject(nc.addr, req.Key(), err)
+		}
+		return
+	}
+	if selectErr != nil {
+		// NOTE: the reply of wrong db is dropped and only the request fails.
+		err = selectErr
+		return
+	}
+	nc.reloadScript(req)
+	if nc.pending == 0 && len(nc.reloads) > 0 {
+		err = nc.reloadScripts()
+	}
+	return
+}
+
+// checkDatabases reads the databases of node by CONFIG GET databases before the
+// first request, the check is skipped if CONFIG is not allowed, eg: renamed.
+func (nc *nodeConn) checkDatabases() (err error) {
+	nc.dbsPending = false
+	_ = nc.bw.Write(configDBsBytes)
+	if err = nc.bw.Flush(); err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+	if err = nc.readReply(nc.scratch); err != nil {
+		return
+	}
+	if nc.scratch.respType != respArray || nc.scratch.arraySize != 2 {
+		return
+	}
+	if n, cerr := conv.Btoi(bulkData(nc.scratch.array[1])); cerr == nil && n > 0 && int(n) < nc.databases {
+		nc.databases = int(n)
+	}
+	return
+}
+
+// writeSelect write SELECT db before request and switch the db of conn.
+func (nc *nodeConn) writeSelect(db int) {
+	dbs := strconv.Itoa(db)
+	_ = nc.bw.Write(selectPrefixBytes)
+	_ = nc.bw.Write([]byte(strconv.Itoa(len(dbs))))
+	_ = nc.bw.Write(crlfBytes)
+	_ = nc.bw.Write([]byte(dbs))
+	_ = nc.bw.Write(crlfBytes)
+	nc.db = db
+}
+
+func (nc *nodeConn) readReply(reply *resp) (err error) {
 	for {
-		if err = req.reply.decode(nc.br); err == bufio.ErrBufferFull {
+		if err = reply.decodeLimit(nc.br, nc.sizeLimit()); err == bufio.ErrBufferFull {
 			if err = nc.br.Read(); err != nil {
 				err = errors.WithStack(err)
 				return
 			}
 			continue
+		} else if err == ErrBulkTooLarge || err == ErrRespTooLarge {
+			// NOTE: drop the reply to keep the conn usable for the rest replies.
+			if err = discardResp(nc.br); err != nil {
+				err = errors.WithStack(err)
+				return
+			}
+			err = errors.Wrapf(ErrReplyTooLarge, "node:%s limit:%d", nc.addr, nc.limits.MaxReply)
+			return
 		} else if err != nil {
 			err = errors.WithStack(err)
 			return
@@ -120,6 +345,15 @@ func (nc *nodeConn) Read(m *proto.Message) (err error) {
 	}
 }
 
+// sizeLimit returns the limit of reply which begins at the current mark, nil if unlimited.
+func (nc *nodeConn) sizeLimit() *sizeLimit {
+	if nc.limits == nil || nc.limits.MaxReply == 0 {
+		return nil
+	}
+	nc.limit = sizeLimit{bulk: nc.limits.MaxReply, total: nc.limits.MaxReply, start: nc.br.Mark()}
+	return &nc.limit
+}
+
 func (nc *nodeConn) Close() (err error) {
 	if atomic.CompareAndSwapInt32(&nc.state, opened, closed) {
 		return nc.conn.Close()
@@ -116,6 +116,108 @@ func TestNodeConnWriteHasErr(t *testing.T) {
 	assert.EqualError(t, err, "write error")
 }
 
+func TestNodeConnSelectDB(t *testing.T) {
+	reply := "+OK\r\n$1\r\n1\r\n$1\r\n2\r\n+OK\r\n$1\r\n3\r\n-ERR DB index is out of range\r\n$1\r\n4\r\n$1\r\n5\r\n+OK\r\n$1\r\n6\r\n"
+	conn := libnet.NewConn(mockconn.CreateConn([]byte(reply), 1), time.Second, time.Second)
+	nc := newNodeConn("baka", "127.0.0.1:12345", conn)
+
+	var msgs []*proto.Message
+	for _, db := range []int{1, 1, 0, 16, 16} {
+		req := getReq()
+		req.resp.copy(newArrayResp("GET", "a"))
+		req.db = db
+		msg := proto.NewMessage()
+		msg.WithRequest(req)
+		assert.NoError(t, nc.Write(msg))
+		msgs = append(msgs, msg)
+	}
+	assert.NoError(t, nc.Flush())
+	mconn := conn.Conn.(*mockconn.MockConn)
+	get := "*2\r\n$3\r\nGET\r\n$1\r\na\r\n"
+	assert.Equal(t, "*2\r\n$6\r\nSELECT\r\n$1\r\n1\r\n"+get+get+
+		"*2\r\n$6\r\nSELECT\r\n$1\r\n0\r\n"+get+
+		"*2\r\n$6\r\nSELECT\r\n$2\r\n16\r\n"+get+get, mconn.Wbuf.String())
+
+	for i, msg := range msgs[:3] {
+		assert.NoError(t, nc.Read(msg))
+		assert.Equal(t, []byte(fmt.Sprintf("1\r\n%d", i+1)), msg.Request().(*Request).reply.data)
+	}
+	// NOTE: the replies of the requests sent in db 0 after SELECT fails are dropped.
+	for _, msg := range msgs[3:] {
+		err := nc.Read(msg)
+		assert.Equal(t, ErrSelectDB, errors.Cause(err))
+		assert.True(t, proto.IsReplyError(err))
+	}
+
+	req := getReq()
+	req.resp.copy(newArrayResp("GET", "a"))
+	msg := proto.NewMessage()
+	msg.WithRequest(req)
+	mconn.Wbuf.Reset()
+	assert.NoError(t, nc.Write(msg))
+	assert.NoError(t, nc.Flush())
+	assert.Equal(t, "*2\r\n$6\r\nSELECT\r\n$1\r\n0\r\n"+get, mconn.Wbuf.String(), "select again after SELECT fails")
+	assert.NoError(t, nc.Read(msg))
+	assert.Equal(t, []byte("1\r\n6"), req.reply.data)
+}
+
+func TestNodeConnDatabases(t *testing.T) {
+	reply := "*2\r\n$9\r\ndatabases\r\n$1\r\n4\r\n+OK\r\n$1\r\n1\r\n"
+	conn := libnet.NewConn(mockconn.CreateConn([]byte(reply), 1), time.Second, time.Second)
+	nc := newNodeConn("baka", "127.0.0.1:12345", conn).(*nodeConn)
+	nc.WithDatabases(16)
+
+	var msgs []*proto.Message
+	for _, db := range []int{3, 8} {
+		req := getReq()
+		req.resp.copy(newArrayResp("GET", "a"))
+		req.db = db
+		msg := proto.NewMessage()
+		msg.WithRequest(req)
+		assert.NoError(t, nc.Write(msg))
+		msgs = append(msgs, msg)
+	}
+	assert.NoError(t, nc.Flush())
+	assert.Equal(t, 4, nc.databases)
+	assert.Equal(t, "*3\r\n$6\r\nCONFIG\r\n$3\r\nGET\r\n$9\r\ndatabases\r\n*2\r\n$6\r\nSELECT\r\n$1\r\n3\r\n*2\r\n$3\r\nGET\r\n$1\r\na\r\n",
+		conn.Conn.(*mockconn.MockConn).Wbuf.String(), "db out of range of node is never sent")
+	assert.NoError(t, nc.Read(msgs[0]))
+	assert.Equal(t, []byte("1\r\n1"), msgs[0].Request().(*Request).reply.data)
+	err := nc.Read(msgs[1])
+	assert.Equal(t, ErrSelectDB, errors.Cause(err))
+	assert.True(t, proto.IsReplyError(err))
+}
+
+func TestNodeConnReadOnly(t *testing.T) {
+	conn := libnet.NewConn(mockconn.CreateConn([]byte("+OK\r\n$1\r\n1\r\n$1\r\n2\r\n"), 1), time.Second, time.Second)
+	nc := newNodeConn("baka", "127.0.0.1:12345", conn).(*nodeConn)
+	nc.WithReadOnly()
+
+	var msgs []*proto.Message
+	for i := 0; i < 2; i++ {
+		req := getReq()
+		req.resp.copy(newArrayResp("GET", "a"))
+		msg := proto.NewMessage()
+		msg.WithRequest(req)
+		assert.NoError(t, nc.Write(msg))
+		msgs = append(msgs, msg)
+	}
+	assert.NoError(t, nc.Flush())
+	get := "*2\r\n$3\r\nGET\r\n$1\r\na\r\n"
+	assert.Equal(t, "*1\r\n$8\r\nREADONLY\r\n"+get+get, conn.Conn.(*mockconn.MockConn).Wbuf.String())
+	for i, msg := range msgs {
+		assert.NoError(t, nc.Read(msg))
+		assert.Equal(t, []byte(fmt.Sprintf("1\r\n%d", i+1)), msg.Request().(*Request).reply.data)
+	}
+
+	conn = libnet.NewConn(mockconn.CreateConn([]byte("-ERR This instance has cluster support disabled\r\n"), 1), time.Second, time.Second)
+	nc = newNodeConn("baka", "127.0.0.1:12345", conn).(*nodeConn)
+	nc.WithReadOnly()
+	assert.NoError(t, nc.Write(msgs[0]))
+	assert.NoError(t, nc.Flush())
+	assert.Equal(t, ErrReadOnly, errors.Cause(nc.Read(msgs[0])))
+}
+
 func TestReadOk(t *testing.T) {
 	data := ":1\r\n"
 	conn := libnet.NewConn(mockconn.CreateConn([]byte(data), 1), time.Second, time.Second)
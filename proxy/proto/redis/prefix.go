@@ -0,0 +1,111 @@
+package redis
+
+import (
+	"bytes"
+	"strconv"
+
+	"github.com/ducesoft/overlord/pkg/conv"
+)
+
+// keyIndex returns the positions of keys in r, the same keys as Request.Keys but
+// the command without known key positions has no key, eg: SCRIPT LOAD.
+func keyIndex(idx []int, r *resp, c *customCommand) []int {
+	if r.arraySize < 2 {
+		return idx
+	}
+	cmd := r.array[0].data
+	switch {
+	case c != nil:
+		if c.first > 0 {
+			idx = c.appendIndex(idx, r)
+		}
+	case isEval(cmd):
+		if r.arraySize < 4 {
+			return idx
+		}
+		num, err := conv.Btoi(bulkData(r.array[2]))
+		if err != nil || num < 1 || int(num) > r.arraySize-3 {
+			return idx
+		}
+		for i := 3; i < 3+int(num); i++ {
+			idx = append(idx, i)
+		}
+	case isXRead(cmd):
+		x := parseXRead(r)
+		for i := x.streams + 1; x.streams > 0 && i <= x.streams+x.count; i++ {
+			idx = append(idx, i)
+		}
+	case isZAlgebra(cmd):
+		nk := 1
+		if bytes.Equal(cmd, cmdZUnionStoreBytes) || bytes.Equal(cmd, cmdZInterStoreBytes) {
+			idx = append(idx, 1)
+			nk = 2
+		}
+		if r.arraySize <= nk+1 {
+			return idx
+		}
+		num, err := conv.Btoi(bulkData(r.array[nk]))
+		if err != nil || num < 1 || int(num) > r.arraySize-nk-1 {
+			return idx
+		}
+		for i := nk + 1; i < nk+1+int(num); i++ {
+			idx = append(idx, i)
+		}
+	default:
+		if c, ok := commandMap[string(bulkData(r.array[0]))]; ok && c.first > 0 {
+			idx = c.appendIndex(idx, r)
+		}
+	}
+	return idx
+}
+
+func (c *command) appendIndex(idx []int, r *resp) []int {
+	last := c.last
+	if last < 0 {
+		last += r.arraySize
+	}
+	for i := c.first; i <= last && i < r.arraySize; i += c.step {
+		idx = append(idx, i)
+	}
+	return idx
+}
+
+// prefixKeys namespace the keys of pc.resp by the key prefix of cluster, the
+// prefixed keys are used to hash and sent to node.
+func (pc *proxyConn) prefixKeys(c *customCommand) {
+	pc.keyIdx = keyIndex(pc.keyIdx[:0], pc.resp, c)
+	for _, i := range pc.keyIdx {
+		k := pc.resp.array[i]
+		pc.keyBuf = append(append(pc.keyBuf[:0], pc.prefix...), bulkData(k)...)
+		k.setBulk(pc.keyBuf)
+	}
+}
+
+// trimPrefix remove the key prefix of cluster from the bulk r in place.
+func trimPrefix(r *resp, prefix []byte) {
+	if r.respType != respBulk {
+		return
+	}
+	key := bulkData(r)
+	if !bytes.HasPrefix(key, prefix) {
+		return
+	}
+	key = key[len(prefix):]
+	// NOTE: the new length is not longer than the old one, so the key is never overwritten.
+	r.data = strconv.AppendInt(r.data[:0], int64(len(key)), 10)
+	r.data = append(r.data, crlfBytes...)
+	r.data = append(r.data, key...)
+}
+
+// trimReplyKeys remove the key prefix of cluster from the keys in reply,
+// only the stream names of XREAD and XREADGROUP are replied by the supported commands.
+func (pc *proxyConn) trimReplyKeys(req *Request) {
+	if req.resp.arraySize == 0 || !isXRead(req.resp.array[0].data) || req.reply.respType != respArray {
+		return
+	}
+	for i := 0; i < req.reply.arraySize; i++ {
+		if stream := req.reply.array[i]; stream.respType == respArray && stream.arraySize > 0 {
+			trimPrefix(stream.array[0], pc.prefix)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ducesoft/overlord/pkg/mockconn"
+	libnet "github.com/ducesoft/overlord/pkg/net"
+	"github.com/ducesoft/overlord/proxy/proto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func _decodeWithPrefix(t *testing.T, data string, cmds *Commands) (*ProxyConn, []*proto.Message) {
+	conn := libnet.NewConn(mockconn.CreateConn([]byte(data), 1), time.Second, time.Second)
+	pc := NewProxyConn(conn, true).(*ProxyConn)
+	pc.WithCommands(cmds)
+	pc.WithKeyPrefix([]byte("t:"))
+	nmsgs, err := pc.Decode(proto.GetMsgs(16))
+	assert.NoError(t, err)
+	return pc, nmsgs
+}
+
+func TestKeyPrefixDecode(t *testing.T) {
+	cmds, err := NewCommands([]*CommandConfig{{Name: "JSON.GET", Flag: "read", First: 1}})
+	assert.NoError(t, err)
+	ts := []struct {
+		Name string
+		Data string
+		Keys []string
+		Resp []string
+	}{
+		{"get", "GET a\r\n", []string{"t:a"}, []string{"*2\r\n$3\r\nGET\r\n$3\r\nt:a\r\n"}},
+		{"no key", "SCRIPT LOAD a\r\n", nil, []string{"*3\r\n$6\r\nSCRIPT\r\n$4\r\nLOAD\r\n$1\r\na\r\n"}},
+		{"mset", "MSET a 1 b 2\r\n", []string{"t:a", "t:b"}, []string{"*3\r\n$4\r\nMSET\r\n$3\r\nt:a\r\n$1\r\n1\r\n", "*3\r\n$4\r\nMSET\r\n$3\r\nt:b\r\n$1\r\n2\r\n"}},
+		{"eval", "EVAL s 2 a b c\r\n", []string{"t:a", "t:b"}, []string{"*6\r\n$4\r\nEVAL\r\n$1\r\ns\r\n$1\r\n2\r\n$3\r\nt:a\r\n$3\r\nt:b\r\n$1\r\nc\r\n"}},
+		{"zunionstore", "ZUNIONSTORE d 1 a WEIGHTS 2\r\n", []string{"t:d", "t:a"}, []string{"*6\r\n$11\r\nZUNIONSTORE\r\n$3\r\nt:d\r\n$1\r\n1\r\n$3\r\nt:a\r\n$7\r\nWEIGHTS\r\n$1\r\n2\r\n"}},
+		{"xgroup", "XGROUP CREATE s g $\r\n", []string{"t:s"}, []string{"*5\r\n$6\r\nXGROUP\r\n$6\r\nCREATE\r\n$3\r\nt:s\r\n$1\r\ng\r\n$1\r\n$\r\n"}},
+		{"custom", "JSON.GET a $\r\n", []string{"t:a"}, []string{"*3\r\n$8\r\nJSON.GET\r\n$3\r\nt:a\r\n$1\r\n$\r\n"}},
+	}
+	for _, tt := range ts {
+		t.Run(tt.Name, func(t *testing.T) {
+			_, nmsgs := _decodeWithPrefix(t, tt.Data, cmds)
+			assert.Len(t, nmsgs, 1)
+			var keys []string
+			for i, mreq := range nmsgs[0].Requests() {
+				for _, k := range mreq.(*Request).Keys() {
+					keys = append(keys, string(k))
+				}
+				assert.Equal(t, tt.Resp[i], _encodeResp(t, mreq.(*Request).resp))
+			}
+			if tt.Keys != nil {
+				assert.Equal(t, tt.Keys, keys)
+			}
+		})
+	}
+}
+
+func TestKeyPrefixXReadReply(t *testing.T) {
+	_, nmsgs := _decodeWithPrefix(t, "XREAD STREAMS a b 0 0\r\n", nil)
+	m := nmsgs[0]
+	m.Batch()
+	for _, mreq := range m.Requests() {
+		req := mreq.(*Request)
+		req.reply.setArray()
+		stream := req.reply.next()
+		stream.setArray()
+		stream.next().setBulk(bulkData(req.resp.array[2]))
+		stream.next().setArray()
+		stream.array[1].setArraySize()
+		stream.setArraySize()
+		req.reply.setArraySize()
+	}
+	mconn, buf := mockconn.CreateDownStreamConn()
+	out := NewProxyConn(libnet.NewConn(mconn, time.Second, time.Second), true).(*ProxyConn)
+	out.WithKeyPrefix([]byte("t:"))
+	assert.NoError(t, out.Encode(m))
+	assert.NoError(t, out.Flush())
+	assert.Equal(t, "*2\r\n*2\r\n$1\r\na\r\n*0\r\n*2\r\n$1\r\nb\r\n*0\r\n", buf.String())
+}
+
+func TestTrimPrefix(t *testing.T) {
+	r := &resp{}
+	r.setBulk([]byte("tenant:0123456789"))
+	trimPrefix(r, []byte("tenant:"))
+	assert.Equal(t, "10\r\n0123456789", string(r.data))
+	r.setBulk([]byte("other:a"))
+	trimPrefix(r, []byte("tenant:"))
+	assert.Equal(t, "7\r\nother:a", string(r.data))
+}
@@ -3,6 +3,7 @@ package redis
 import (
 	"bytes"
 	"strconv"
+	"time"
 
 	"github.com/ducesoft/overlord/pkg/bufio"
 	"github.com/ducesoft/overlord/pkg/conv"
@@ -23,6 +24,7 @@ var (
 	pongDataBytes       = []byte("PONG")
 	justOkBytes         = []byte("OK")
 	notSupportDataBytes = []byte("Error: command not support")
+	errSubCmdNoKey      = []byte("ERR the subcommand without key is not supported by proxy")
 )
 
 // ProxyConn is export for redis cluster.
@@ -33,12 +35,89 @@ func (pc *ProxyConn) Bw() *bufio.Writer {
 	return pc.bw
 }
 
+// WithDatabases set the number of db which can be selected by client.
+func (pc *ProxyConn) WithDatabases(n int) {
+	if n > 0 {
+		pc.client.databases = n
+	}
+}
+
+// WithAdminCommands enable the admin commands which change the state of proxy,
+// eg: PROXY RELOAD, PROXY MIGRATE FINISH, PROXY CAPTURE and SLOWLOG RESET.
+func (pc *ProxyConn) WithAdminCommands() {
+	pc.client.adminCmds = true
+}
+
+// WithCommands set the command table of cluster.
+func (pc *ProxyConn) WithCommands(cmds *Commands) {
+	if cmds != nil {
+		pc.client.cmds = cmds
+	}
+}
+
+// WithAlgebra enable the set algebra across nodes computed by proxy, eg: SUNION,
+// limit is the max members of each key. The algebra of keys routed to one node
+// by router is sent to node as is, nil router means always computed by proxy.
+func (pc *ProxyConn) WithAlgebra(limit int, router proto.KeyRouter) {
+	pc.algebraLimit = limit
+	pc.algebraRouter = router
+}
+
+// WithKeyPrefix namespace all the keys by prefix, the prefix is removed from
+// the keys in reply.
+func (pc *ProxyConn) WithKeyPrefix(prefix []byte) {
+	pc.prefix = prefix
+}
+
+// WithLimits set the size limits of requests and replies.
+func (pc *ProxyConn) WithLimits(l *proto.Limits) {
+	pc.limits = l
+}
+
+// WithBatchStats set the stats of partially failed batch requests.
+func (pc *ProxyConn) WithBatchStats(s *proto.BatchStats) {
+	pc.batch = s
+}
+
+// WithScripts set the script cache of cluster which records the scripts of
+// EVAL and SCRIPT LOAD.
+func (pc *ProxyConn) WithScripts(s *ScriptCache) {
+	pc.scripts = s
+}
+
+// WithInfo set the proxy state which is replied by INFO.
+func (pc *ProxyConn) WithInfo(info proto.Infoer) {
+	pc.client.info = info
+}
+
 type proxyConn struct {
 	br        *bufio.Reader
 	bw        *bufio.Writer
 	completed bool
 
-	resp *resp
+	resp   *resp
+	txn    *txnState
+	client *client
+	// block is the session of blocking request which runs on dedicated conn.
+	block *proto.Session
+	// algebraLimit is the max members of each key when set algebra across
+	// nodes is computed by proxy, 0 means disabled.
+	algebraLimit int
+	// algebraRouter reports the node of keys, the algebra of one node is left to node.
+	algebraRouter proto.KeyRouter
+	// prefix is prepended to all the keys, eg: tenant:.
+	prefix []byte
+	keyBuf []byte
+	keyIdx []int
+	// limits rejects the requests exceed the size limits of cluster.
+	limits *proto.Limits
+	limit  sizeLimit
+	// batch counts the batch requests which are partially failed.
+	batch *proto.BatchStats
+	// scripts is the script cache of cluster.
+	scripts *ScriptCache
+	// err closes the conn after the rejected request is replied.
+	err error
 
 	mgetCmd []byte
 	msetCmd []byte
@@ -51,6 +130,9 @@ func NewProxyConn(conn *libnet.Conn, useBatchCmd bool) proto.ProxyConn {
 		bw:        bufio.NewWriter(conn),
 		completed: true,
 		resp:      &resp{},
+		txn:       newTxnState(),
+		client:    newClient(conn),
+		block:     &proto.Session{},
 	}
 	if useBatchCmd {
 		r.mgetCmd = cmdMGetBytes
@@ -64,11 +146,15 @@ func NewProxyConn(conn *libnet.Conn, useBatchCmd bool) proto.ProxyConn {
 
 func (pc *proxyConn) Decode(msgs []*proto.Message) ([]*proto.Message, error) {
 	var err error
+	if pc.err != nil {
+		return nil, pc.err
+	}
 	if pc.completed {
 		if err = pc.br.Read(); err != nil {
 			return nil, err
 		}
 		pc.completed = false
+		pc.client.atime = time.Now()
 	}
 	for i := range msgs {
 		msgs[i].Type = types.CacheTypeRedis
@@ -79,7 +165,14 @@ func (pc *proxyConn) Decode(msgs []*proto.Message) ([]*proto.Message, error) {
 		} else if err != nil {
 			return nil, err
 		}
+		for _, req := range msgs[i].Requests() {
+			req.(*Request).db = pc.client.db
+		}
 		msgs[i].MarkStart()
+		if pc.err != nil {
+			// NOTE: the rest of rejected request is not read, close after replied
+			return msgs[:i+1], nil
+		}
 	}
 	return msgs, nil
 }
@@ -88,9 +181,12 @@ func (pc *proxyConn) decode(msg *proto.Message) (err error) {
 	// for migrate sync PING process
 	for {
 		mark := pc.br.Mark()
-		if err = pc.resp.decode(pc.br); err != nil {
+		if err = pc.resp.decodeLimit(pc.br, pc.sizeLimit(mark)); err != nil {
 			if err == bufio.ErrBufferFull {
 				pc.br.AdvanceTo(mark)
+			} else if err == ErrBulkTooLarge || err == ErrRespTooLarge {
+				pc.rejectLarge(msg, err)
+				err = nil
 			}
 			return
 		}
@@ -108,7 +204,63 @@ func (pc *proxyConn) decode(msg *proto.Message) (err error) {
 	conv.UpdateToUpper(pc.resp.array[0].data)
 	cmd := pc.resp.array[0].data // NOTE: when array, first is command
 
-	if bytes.Equal(cmd, cmdMSetBytes) {
+	var custom *customCommand
+	if o, ok := pc.client.cmds.override(cmd); ok {
+		if o.cmd == nil {
+			// NOTE: disabled by cluster config
+			r := nextReq(msg)
+			r.resp.copy(pc.resp)
+			r.replyLocal(respError, notSupportDataBytes)
+			pc.txn.abort()
+			return
+		}
+		if o.data != nil {
+			// NOTE: renamed by cluster config, send the origin command to node
+			pc.resp.array[0].data = append(pc.resp.array[0].data[:0], o.data...)
+			cmd = pc.resp.array[0].data
+		}
+		custom = o.custom
+	}
+	if pc.limits != nil {
+		if pc.tooManyKeys(msg, custom) {
+			pc.txn.abort()
+			return
+		}
+		pc.checkBigValues()
+	}
+	if len(pc.prefix) > 0 {
+		pc.prefixKeys(custom)
+	}
+
+	if pc.txn.isTxn(cmd) {
+		r := nextReq(msg)
+		r.resp.copy(pc.resp)
+		r.cmd = custom
+		pc.txn.decode(r)
+	} else if pc.client.isLocal(cmd) {
+		r := nextReq(msg)
+		r.resp.copy(pc.resp)
+		pc.client.decode(r)
+	} else if custom != nil {
+		pc.decodeCustom(msg, custom)
+	} else if a := parseAlgebra(pc.resp, pc.algebraLimit, pc.algebraRouter); a != nil {
+		pc.decodeAlgebra(msg, a)
+	} else if isXRead(cmd) {
+		pc.decodeXRead(msg)
+	} else if bytes.Equal(cmd, cmdBLMoveBytes) {
+		r := nextReq(msg)
+		r.resp.copy(pc.resp)
+		decodeBLMove(pc, r)
+	} else if (bytes.Equal(cmd, cmdObjectBytes) || bytes.Equal(cmd, cmdMemoryBytes)) && pc.resp.arraySize < 3 {
+		r := nextReq(msg)
+		r.resp.copy(pc.resp)
+		// NOTE: the subcommands without key are the state of single node, eg: MEMORY STATS
+		r.replyLocal(respError, errSubCmdNoKey)
+	} else if bytes.Equal(cmd, cmdScriptBytes) {
+		r := nextReq(msg)
+		r.resp.copy(pc.resp)
+		pc.decodeScript(r)
+	} else if bytes.Equal(cmd, cmdMSetBytes) {
 		if pc.resp.arraySize < 3 || pc.resp.arraySize%2 == 0 {
 			err = ErrBadRequest
 			return
@@ -154,7 +306,7 @@ func (pc *proxyConn) decode(msg *proto.Message) (err error) {
 			nre2 := r.resp.next() // NOTE: $klen\r\nkey\r\n
 			nre2.copy(pc.resp.array[i])
 		}
-	} else if bytes.Equal(cmd, cmdDelBytes) || bytes.Equal(cmd, cmdExistsBytes) {
+	} else if isMultiKeyCount(cmd) {
 		if pc.resp.arraySize < 2 {
 			err = ErrBadRequest
 			return
@@ -167,7 +319,7 @@ func (pc *proxyConn) decode(msg *proto.Message) (err error) {
 			r.resp.respType = respArray
 			r.resp.data = append(r.resp.data, arrayLenTwo...)
 			// array resp: get
-			nre1 := r.resp.next() // NOTE: $3\r\nDEL\r\n | $6\r\nEXISTS\r\n
+			nre1 := r.resp.next() // NOTE: $3\r\nDEL\r\n | $6\r\nEXISTS\r\n | $6\r\nUNLINK\r\n | $5\r\nTOUCH\r\n
 			nre1.copy(pc.resp.array[0])
 			// array resp: key
 			nre2 := r.resp.next() // NOTE: $klen\r\nkey\r\n
@@ -176,6 +328,9 @@ func (pc *proxyConn) decode(msg *proto.Message) (err error) {
 	} else {
 		r := nextReq(msg)
 		r.resp.copy(pc.resp)
+		if bytes.Equal(cmd, cmdEvalBytes) && pc.resp.arraySize > 1 {
+			pc.scripts.add(bulkData(pc.resp.array[1]))
+		}
 	}
 	return
 }
@@ -189,30 +344,72 @@ func nextReq(m *proto.Message) *Request {
 	}
 	r := req.(*Request)
 	r.mType = mergeTypeNo
+	r.merged = false
+	r.batch = r.batch[:0]
+	r.local = false
+	r.broadcast = false
+	r.db = 0
+	r.dbSelected = false
+	r.dbSkipped = false
+	r.blocking = false
+	r.block = 0
+	r.cmd = nil
+	r.alg = nil
+	r.txn.reset()
+	r.sess = nil
+	r.sessOp = proto.SessionKeep
 	return r
 }
 
 func (pc *proxyConn) Encode(m *proto.Message) (err error) {
-	if err = m.Err(); err != nil {
-		se := errors.Cause(err).Error()
-		pc.bw.Write(respErrorBytes)
-		pc.bw.Write([]byte(se))
-		pc.bw.Write(crlfBytes)
+	errs, failed := batchErrs(m)
+	if err = m.Err(); err == nil && failed > 0 && failed == len(errs) {
+		// NOTE: all the requests are replied errors by nodes.
+		err = errs[0]
+	}
+	if err != nil && (failed == 0 || failed == len(errs)) {
+		pc.writeErr(err)
+		if proto.IsReplyError(err) {
+			err = nil
+		}
 		return
 	}
 	req, ok := m.Request().(*Request)
 	if !ok {
 		return ErrBadAssert
 	}
+	if failed > 0 && failed < len(errs) {
+		pc.batch.Observe(failed)
+	}
+	if len(pc.prefix) > 0 {
+		for _, mreq := range m.Requests() {
+			if r := mreq.(*Request); !r.merged {
+				pc.trimReplyKeys(r)
+			}
+		}
+	}
+	if pc.limits != nil {
+		pc.checkBigReplies(m)
+	}
 	switch req.mType {
 	case mergeTypeOK:
-		err = pc.mergeOK(m)
+		err = pc.mergeOK(m, errs, failed)
 	case mergeTypeJoin:
-		err = pc.mergeJoin(m)
+		err = pc.mergeJoin(m, errs)
 	case mergeTypeCount:
-		err = pc.mergeCount(m)
+		err = pc.mergeCount(m, errs, failed)
+	case mergeTypeFirst:
+		err = pc.mergeFirst(m)
+	case mergeTypeAnd:
+		err = pc.mergeAnd(m)
+	case mergeTypeStreams:
+		err = pc.mergeStreams(m)
+	case mergeTypeAlgebra:
+		err = pc.mergeAlgebra(m)
 	default:
-		if !req.IsSupport() {
+		if req.local {
+			// NOTE: reply already filled by proxy
+		} else if !req.IsSupport() {
 			req.reply.respType = respError
 			req.reply.data = req.reply.data[:0]
 			req.reply.data = append(req.reply.data, notSupportDataBytes...)
@@ -236,13 +433,21 @@ func (pc *proxyConn) Encode(m *proto.Message) (err error) {
 	return
 }
 
-func (pc *proxyConn) mergeOK(m *proto.Message) (err error) {
+func (pc *proxyConn) mergeOK(m *proto.Message, errs []error, failed int) (err error) {
+	if failed > 0 {
+		pc.writeErr(partialErr(errs, failed))
+		return
+	}
 	_ = pc.bw.Write(respStringBytes)
 	err = pc.bw.Write(okBytes)
 	return
 }
 
-func (pc *proxyConn) mergeCount(m *proto.Message) (err error) {
+func (pc *proxyConn) mergeCount(m *proto.Message, errs []error, failed int) (err error) {
+	if failed > 0 {
+		pc.writeErr(partialErr(errs, failed))
+		return
+	}
 	var sum = 0
 	for _, mreq := range m.Requests() {
 		req, ok := mreq.(*Request)
@@ -264,48 +469,43 @@ func (pc *proxyConn) mergeCount(m *proto.Message) (err error) {
 	return
 }
 
-func (pc *proxyConn) mergeJoin(m *proto.Message) (err error) {
+// mergeJoin replies the values of requests in the order of keys, the values
+// of request are split from the reply of request merged into and the failed
+// request is replied null.
+func (pc *proxyConn) mergeJoin(m *proto.Message, errs []error) (err error) {
 	reqs := m.Requests()
-
-	var finalReqs []*Request
+	if len(reqs) == 0 {
+		_ = pc.bw.Write(respArrayBytes)
+		err = pc.bw.Write(nullBytes)
+		return
+	}
+	vals, err := joinValues(reqs, errs)
+	if err != nil {
+		return
+	}
 	sum := 0
-	for _, mreq := range reqs {
-		req, ok := mreq.(*Request)
-		if !ok {
-			return ErrBadAssert
-		}
-		if req.merged {
-			continue
-		}
-
-		finalReqs = append(finalReqs, req)
-		if req.reply.respType == respArray {
-			ival, err := conv.Btoi(req.reply.data)
-			if err != nil {
-				return ErrBadCount
-			}
-			sum += int(ival)
+	for i := range vals {
+		if errs != nil && errs[i] != nil {
+			sum++
 		} else {
-			sum += 1
+			sum += len(vals[i])
 		}
 	}
-
 	_ = pc.bw.Write(respArrayBytes)
-	if len(finalReqs) == 0 {
-		err = pc.bw.Write(nullBytes)
-		return
-	}
 	_ = pc.bw.Write([]byte(strconv.Itoa(sum)))
 	if err = pc.bw.Write(crlfBytes); err != nil {
 		return
 	}
-	for _, req := range finalReqs {
-		if req.reply.respType == respArray {
-			if err = req.reply.encodeArrayData(pc.bw); err != nil {
+	for i := range vals {
+		if errs != nil && errs[i] != nil {
+			_ = pc.bw.Write(respBulkBytes)
+			if err = pc.bw.Write(nullBytes); err != nil {
 				return
 			}
-		} else {
-			if err = req.reply.encode(pc.bw); err != nil {
+			continue
+		}
+		for _, val := range vals[i] {
+			if err = val.encode(pc.bw); err != nil {
 				return
 			}
 		}
@@ -313,6 +513,19 @@ func (pc *proxyConn) mergeJoin(m *proto.Message) (err error) {
 	return
 }
 
+// writeErr writes the cause of err as an error reply.
+func (pc *proxyConn) writeErr(err error) {
+	_ = pc.bw.Write(respErrorBytes)
+	_ = pc.bw.Write([]byte(errors.Cause(err).Error()))
+	_ = pc.bw.Write(crlfBytes)
+}
+
 func (pc *proxyConn) Flush() (err error) {
 	return pc.bw.Flush()
 }
+
+// Close release the session conns of client.
+func (pc *proxyConn) Close() error {
+	_ = pc.block.Close()
+	return pc.txn.close()
+}
@@ -353,3 +353,88 @@ func TestEncodeWithPing(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, "+PONG\r\n", string(data[:size]))
 }
+
+func _bulks(vals ...string) *resp {
+	r := &resp{}
+	r.setArray()
+	for _, v := range vals {
+		if v == "" {
+			r.next().setBulk(nil)
+		} else {
+			r.next().setBulk([]byte(v))
+		}
+	}
+	r.setArraySize()
+	return r
+}
+
+// _mergeBatch merges the requests of subs idx into the first one like forwarder.
+func _mergeBatch(t *testing.T, subs []*proto.Message, idx ...int) {
+	var (
+		reqs   []proto.Request
+		merged []*proto.Message
+	)
+	for _, i := range idx[1:] {
+		reqs = append(reqs, subs[i].Request())
+		merged = append(merged, subs[i])
+	}
+	subs[idx[0]].WithMerged(merged)
+	assert.NoError(t, subs[idx[0]].Request().Merge(reqs))
+}
+
+// _encode encodes msg and returns the reply, the error of encoding is ignored.
+func _encode(t *testing.T, msg *proto.Message, s *proto.BatchStats) string {
+	conn, buf := mockconn.CreateDownStreamConn()
+	pc := NewProxyConn(libnet.NewConn(conn, time.Second, time.Second), true)
+	pc.(*ProxyConn).WithBatchStats(s)
+	_ = pc.Encode(msg)
+	assert.NoError(t, pc.Flush())
+	data := make([]byte, 2048)
+	size, err := buf.Read(data)
+	assert.NoError(t, err)
+	return string(data[:size])
+}
+
+func TestEncodeBatchPartial(t *testing.T) {
+	s := &proto.BatchStats{}
+	msg := _decodeMessage(t, "*4\r\n$4\r\nMGET\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n")[0]
+	subs := msg.Batch()
+	// NOTE: a and c are of the same node
+	_mergeBatch(t, subs, 0, 2)
+	subs[0].Request().(*Request).reply = _bulks("va", "vc")
+	subs[1].Request().(*Request).reply = _bulks("vb")
+	assert.Equal(t, "*3\r\n$2\r\nva\r\n$2\r\nvb\r\n$2\r\nvc\r\n", _encode(t, msg, s), "keys in order")
+
+	subs[0].WithError(errors.New("timeout"))
+	assert.Equal(t, "*3\r\n$-1\r\n$2\r\nvb\r\n$-1\r\n", _encode(t, msg, s))
+	assert.Equal(t, int64(1), s.Partial())
+	assert.Equal(t, int64(2), s.Failed())
+	subs[1].WithError(errors.New("timeout"))
+	assert.Equal(t, "-timeout\r\n", _encode(t, msg, s), "all keys failed")
+
+	msg = _decodeMessage(t, "*5\r\n$4\r\nMSET\r\n$1\r\na\r\n$1\r\n1\r\n$1\r\nb\r\n$1\r\n2\r\n")[0]
+	subs = msg.Batch()
+	subs[0].Request().(*Request).reply.setPlain(respString, justOkBytes)
+	subs[1].WithError(errors.New("timeout"))
+	assert.Equal(t, "-ERR partial failure: 1 of 2 keys failed: timeout\r\n", _encode(t, msg, s))
+
+	msg = _decodeMessage(t, "*3\r\n$3\r\nDEL\r\n$1\r\na\r\n$1\r\nb\r\n")[0]
+	subs = msg.Batch()
+	subs[0].Request().(*Request).reply.setInt(1)
+	subs[1].Request().(*Request).reply.setPlain(respError, []byte("OOM command not allowed"))
+	assert.Equal(t, "-ERR partial failure: 1 of 2 keys failed: OOM command not allowed\r\n", _encode(t, msg, s))
+	subs[0].Request().(*Request).reply.setPlain(respError, []byte("OOM command not allowed"))
+	assert.Equal(t, "-OOM command not allowed\r\n", _encode(t, msg, s), "all replied errors")
+	assert.Equal(t, int64(3), s.Partial())
+}
+
+func TestNextReqResetMerged(t *testing.T) {
+	msg := proto.NewMessage()
+	r := nextReq(msg)
+	r.merged = true
+	r.batch = append(r.batch, getReq())
+	msg.Reset()
+	r = nextReq(msg)
+	assert.False(t, r.merged)
+	assert.Len(t, r.batch, 0)
+}
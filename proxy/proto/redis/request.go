@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"strconv"
 	"sync"
+	"time"
 
 	"github.com/ducesoft/overlord/pkg/types"
 	"github.com/ducesoft/overlord/proxy/proto"
@@ -27,20 +28,36 @@ var (
 	cmdGetBytes    = []byte("3\r\nGET")
 	cmdDelBytes    = []byte("3\r\nDEL")
 	cmdExistsBytes = []byte("6\r\nEXISTS")
+	cmdUnlinkBytes = []byte("6\r\nUNLINK")
+	cmdTouchBytes  = []byte("5\r\nTOUCH")
+	cmdObjectBytes = []byte("6\r\nOBJECT")
+	cmdMemoryBytes = []byte("6\r\nMEMORY")
+	cmdBLMoveBytes = []byte("6\r\nBLMOVE")
+	cmdWatchBytes  = []byte("5\r\nWATCH")
+	cmdBitOpBytes  = []byte("5\r\nBITOP")
 
-	reqSupportCmdMap = map[string]struct{}{}
-	reqControlCmdMap = map[string]struct{}{}
+	cmdZUnionStoreBytes = []byte("11\r\nZUNIONSTORE")
+	cmdZInterStoreBytes = []byte("11\r\nZINTERSTORE")
+
+	reqSupportCmdMap  = map[string]struct{}{}
+	reqControlCmdMap  = map[string]struct{}{}
+	reqReadOnlyCmdMap = map[string]struct{}{}
 )
 
 func init() {
 	supports := append(readCmds, writeCmds...)
 	supports = append(supports, controlCmds...)
+	supports = append(supports, txnCmds...)
+	supports = append(supports, localCmds...)
 	for _, key := range supports {
 		reqSupportCmdMap[key] = struct{}{}
 	}
 	for _, key := range controlCmds {
 		reqControlCmdMap[key] = struct{}{}
 	}
+	for _, key := range readCmds {
+		reqReadOnlyCmdMap[key] = struct{}{}
+	}
 }
 
 // errors
@@ -50,6 +67,9 @@ var (
 	ErrBadRequest      = errs.New("bad request")
 	ErrWrongParamCount = errs.New("wrong param count")
 	ErrIgnoreMerged    = errs.New("ignore merged request")
+	ErrBulkTooLarge    = errs.New("bulk is too large")
+	ErrRespTooLarge    = errs.New("resp is too large")
+	ErrTooManyKeys     = errs.New("too many keys")
 )
 
 // mergeType is used to decript the merge operation.
@@ -61,6 +81,14 @@ const (
 	mergeTypeCount
 	mergeTypeOK
 	mergeTypeJoin
+	// mergeTypeFirst replies the first error or the first reply, eg: SCRIPT LOAD
+	mergeTypeFirst
+	// mergeTypeAnd replies the logical AND of integer arrays, eg: SCRIPT EXISTS
+	mergeTypeAnd
+	// mergeTypeStreams replies the joined streams or null array, eg: XREAD
+	mergeTypeStreams
+	// mergeTypeAlgebra replies the set algebra computed by proxy, eg: SUNION
+	mergeTypeAlgebra
 )
 
 // Request is the type of a complete redis command
@@ -70,6 +98,31 @@ type Request struct {
 	mType        mergeType
 	merged       bool
 	batchOpCount int
+	// batch is the requests merged into the request in order.
+	batch []*Request
+
+	// local is the request which is replied by proxy itself.
+	local bool
+	// broadcast is the request which must be sent to every node.
+	broadcast bool
+	// db is the db selected by client, dbSelected is set by node conn
+	// which writes SELECT before request, dbSkipped is set by node conn
+	// which never sends the request as db is out of range of node.
+	db         int
+	dbSelected bool
+	dbSkipped  bool
+	// blocking is the request which may block on node, eg: XREAD BLOCK,
+	// block is the max time and 0 means blocking forever.
+	blocking bool
+	block    time.Duration
+	// cmd is the custom command of cluster config.
+	cmd *customCommand
+	// alg is the set algebra which is computed by proxy.
+	alg *algebra
+	// txn is the queued commands between MULTI and EXEC.
+	txn    *resp
+	sess   *proto.Session
+	sessOp proto.SessionOp
 }
 
 var reqPool = &sync.Pool{
@@ -87,6 +140,7 @@ func newReq() *Request {
 	r := &Request{}
 	r.resp = &resp{}
 	r.reply = &resp{}
+	r.txn = &resp{}
 	r.merged = false
 	return r
 }
@@ -127,41 +181,162 @@ func (r *Request) Key() []byte {
 	if r.resp.arraySize == 1 {
 		return r.resp.array[0].data
 	}
+	if r.cmd != nil {
+		if r.cmd.first > 0 && r.cmd.first < r.resp.arraySize {
+			return bulkData(r.resp.array[r.cmd.first])
+		}
+	}
+	return firstKey(r.resp)
+}
 
-	k := r.resp.array[1]
+// Keys impl the proto.MultiKeyer and get all the keys of request,
+// the keys of queued commands are returned for EXEC.
+func (r *Request) Keys() (keys [][]byte) {
+	if r.isTxn() {
+		for _, cmd := range r.txn.Array() {
+			keys = appendKeys(keys, cmd)
+		}
+		return
+	}
+	if r.cmd != nil {
+		if r.cmd.first > 0 {
+			return r.cmd.appendKeys(keys, r.resp)
+		}
+		return
+	}
+	return appendKeys(keys, r.resp)
+}
+
+// isTxn check whether the request is EXEC with queued commands.
+func (r *Request) isTxn() bool {
+	return r.txn != nil && r.txn.arraySize > 0
+}
+
+// Session impl the proto.SessionRequest.
+func (r *Request) Session() (*proto.Session, proto.SessionOp) {
+	return r.sess, r.sessOp
+}
+
+// isMultiKeyCount check whether the cmd is split by keys and replies the sum, eg: DEL.
+func isMultiKeyCount(cmd []byte) bool {
+	return bytes.Equal(cmd, cmdDelBytes) || bytes.Equal(cmd, cmdExistsBytes) ||
+		bytes.Equal(cmd, cmdUnlinkBytes) || bytes.Equal(cmd, cmdTouchBytes)
+}
+
+// isSubKey check whether the key of cmd is after the subcommand or operation,
+// eg: XGROUP CREATE key and BITOP AND destkey key.
+func isSubKey(cmd []byte) bool {
+	return bytes.Equal(cmd, cmdXGroupBytes) || bytes.Equal(cmd, cmdXInfoBytes) ||
+		bytes.Equal(cmd, cmdObjectBytes) || bytes.Equal(cmd, cmdMemoryBytes) ||
+		bytes.Equal(cmd, cmdBitOpBytes)
+}
+
+func firstKey(r *resp) []byte {
+	k := r.array[1]
+	cmd := r.array[0].data
+	if isXRead(cmd) {
+		if x := parseXRead(r); x.streams > 0 {
+			k = r.array[x.streams+1]
+		}
+	} else if isSubKey(cmd) && r.arraySize > 2 {
+		k = r.array[2]
+	} else if (bytes.Equal(cmd, cmdZUnionBytes) || bytes.Equal(cmd, cmdZInterBytes)) && r.arraySize > 2 {
+		// NOTE: the first key is after numkeys, eg: ZUNION numkeys key [key ...].
+		k = r.array[2]
+	}
 	// SUPPORT EVAL command
 	const evalArgsMinCount int = 4
-	if r.resp.arraySize >= evalArgsMinCount {
-		if bytes.Equal(r.resp.array[0].data, cmdEvalBytes) {
+	if r.arraySize >= evalArgsMinCount {
+		if isEval(r.array[0].data) {
 			// find the 4th key with index 3
-			k = r.resp.array[3]
+			k = r.array[3]
+		}
+	}
+	return bulkData(k)
+}
+
+func appendKeys(keys [][]byte, r *resp) [][]byte {
+	if r.arraySize < 2 {
+		return keys
+	}
+	cmd := r.array[0].data
+	switch {
+	case bytes.Equal(cmd, cmdMGetBytes), isMultiKeyCount(cmd), bytes.Equal(cmd, cmdWatchBytes):
+		for i := 1; i < r.arraySize; i++ {
+			keys = append(keys, bulkData(r.array[i]))
+		}
+	case bytes.Equal(cmd, cmdMSetBytes):
+		for i := 1; i < r.arraySize; i += 2 {
+			keys = append(keys, bulkData(r.array[i]))
+		}
+	case isEval(cmd):
+		keys = evalKeys(keys, r)
+	case isXRead(cmd):
+		keys = xreadKeys(keys, r)
+	case isZAlgebra(cmd):
+		keys = zalgebraKeys(keys, r)
+	default:
+		if c, ok := commandMap[string(bulkData(r.array[0]))]; ok && c.first > 0 {
+			keys = c.appendKeys(keys, r)
+		} else {
+			keys = append(keys, firstKey(r))
 		}
 	}
+	return keys
+}
 
+// bulkData returns the data of bulk without length prefix.
+func bulkData(r *resp) []byte {
 	var pos int
-	if k.respType == respBulk {
-		pos = bytes.Index(k.data, crlfBytes) + 2
+	if r.respType == respBulk {
+		pos = bytes.Index(r.data, crlfBytes) + 2
 	}
-	return k.data[pos:]
+	return r.data[pos:]
 }
 
 // Put the resource back to pool
 func (r *Request) Put() {
 	r.resp.reset()
 	r.reply.reset()
+	r.txn.reset()
 	r.mType = mergeTypeNo
 	r.merged = false
 	r.batchOpCount = 0
+	r.batch = r.batch[:0]
+	r.local = false
+	r.broadcast = false
+	r.db = 0
+	r.dbSelected = false
+	r.dbSkipped = false
+	r.blocking = false
+	r.block = 0
+	r.cmd = nil
+	r.alg = nil
+	r.sess = nil
+	r.sessOp = proto.SessionKeep
 	reqPool.Put(r)
 }
 
 func (r *Request) Merge(reqs []proto.Request) (err error) {
+	if r.mType == mergeTypeStreams {
+		r.mergeXRead(reqs)
+		return
+	}
+	if r.mType == mergeTypeAlgebra {
+		r.mergeAlgebra(reqs)
+		return
+	}
+	if r.cmd != nil {
+		r.mergeCustom(reqs)
+		return
+	}
 	for i := range reqs {
 		req := reqs[i].(*Request)
 		if (req.resp.arraySize-1)%r.batchOpCount != 0 {
 			return ErrWrongParamCount
 		}
 		req.merged = true
+		r.batch = append(r.batch, req)
 		for i := 1; i < req.resp.arraySize; i++ {
 			nr := r.resp.next()
 			nr.copy(req.resp.array[i])
@@ -186,6 +361,9 @@ func (r *Request) Reply() *RESP {
 // NOTE: use string([]byte) as a map key, it is very specific!!!
 // https://dave.cheney.net/high-performance-go-workshop/dotgo-paris.html#using_byte_as_a_map_key
 func (r *Request) IsSupport() bool {
+	if r.cmd != nil {
+		return true
+	}
 	if r.resp.arraySize < 1 {
 		return false
 	}
@@ -193,11 +371,14 @@ func (r *Request) IsSupport() bool {
 	return ok
 }
 
-// IsCtl is control command.
+// IsCtl is control command or replied by proxy itself.
 //
 // NOTE: use string([]byte) as a map key, it is very specific!!!
 // https://dave.cheney.net/high-performance-go-workshop/dotgo-paris.html#using_byte_as_a_map_key
 func (r *Request) IsCtl() bool {
+	if r.local {
+		return true
+	}
 	if r.resp.arraySize < 1 {
 		return false
 	}
@@ -205,6 +386,22 @@ func (r *Request) IsCtl() bool {
 	return ok
 }
 
+// IsReadOnly impl the proto.ReadOnlyer, the request only reads data and can be
+// served by replicas. The transaction and blocking requests are not read only.
+func (r *Request) IsReadOnly() bool {
+	if r.local || r.isTxn() || r.blocking || r.alg != nil {
+		return false
+	}
+	if r.cmd != nil {
+		return len(r.cmd.flags) > 0 && r.cmd.flags[0] == flagsRead[0]
+	}
+	if r.resp.arraySize < 1 {
+		return false
+	}
+	_, ok := reqReadOnlyCmdMap[string(r.resp.array[0].data)]
+	return ok
+}
+
 const maxArray = 32
 
 func collapseArray(rs []*resp) (collapsed []string) {
@@ -259,6 +456,8 @@ var (
 		"8\r\nSMEMBERS",
 		"11\r\nSRANDMEMBER",
 		"6\r\nSUNION",
+		"6\r\nZUNION",
+		"6\r\nZINTER",
 		"5\r\nSSCAN",
 		"5\r\nZCARD",
 		"6\r\nZCOUNT",
@@ -277,6 +476,23 @@ var (
 		"4\r\nLLEN",
 		"6\r\nLRANGE",
 		"7\r\nPFCOUNT",
+		"6\r\nXRANGE",
+		"9\r\nXREVRANGE",
+		"4\r\nXLEN",
+		"5\r\nXREAD",
+		"8\r\nXPENDING",
+		"5\r\nXINFO",
+		"4\r\nLPOS",
+		"10\r\nSMISMEMBER",
+		"7\r\nZMSCORE",
+		"11\r\nZRANDMEMBER",
+		"10\r\nHRANDFIELD",
+		"6\r\nOBJECT",
+		"6\r\nMEMORY",
+		"10\r\nEXPIRETIME",
+		"11\r\nPEXPIRETIME",
+		"5\r\nTOUCH",
+		"11\r\nBITFIELD_RO",
 	}
 	writeCmds = []string{
 		"3\r\nDEL",
@@ -332,39 +548,68 @@ var (
 		"5\r\nPFADD",
 		"7\r\nPFMERGE",
 		"4\r\nEVAL",
+		"7\r\nEVALSHA",
+		"6\r\nSCRIPT",
 		"11\r\nSUNIONSTORE",
 		"11\r\nZUNIONSTORE",
-	}
-	notSupportCmds = []string{
 		"6\r\nMSETNX",
 		"10\r\nSDIFFSTORE",
 		"11\r\nSINTERSTORE",
+		"6\r\nRENAME",
+		"8\r\nRENAMENX",
+		"5\r\nBITOP",
+		"4\r\nXADD",
+		"10\r\nXREADGROUP",
+		"4\r\nXACK",
+		"6\r\nXCLAIM",
+		"10\r\nXAUTOCLAIM",
+		"5\r\nXTRIM",
+		"4\r\nXDEL",
+		"6\r\nXGROUP",
+		"5\r\nGETEX",
+		"6\r\nGETDEL",
+		"5\r\nLMOVE",
+		"6\r\nBLMOVE",
+		"11\r\nZRANGESTORE",
+		"7\r\nZPOPMIN",
+		"7\r\nZPOPMAX",
+		"4\r\nCOPY",
+		"6\r\nUNLINK",
+		"8\r\nBITFIELD",
+	}
+	notSupportCmds = []string{
 		"5\r\nBLPOP",
 		"5\r\nBRPOP",
 		"10\r\nBRPOPLPUSH",
 		"4\r\nKEYS",
 		"7\r\nMIGRATE",
 		"4\r\nMOVE",
-		"6\r\nOBJECT",
 		"9\r\nRANDOMKEY",
-		"6\r\nRENAME",
-		"8\r\nRENAMENX",
 		"4\r\nSCAN",
 		"4\r\nWAIT",
-		"5\r\nBITOP",
-		"7\r\nEVALSHA",
 		"4\r\nAUTH",
 		"4\r\nECHO",
-		"4\r\nINFO",
-		"5\r\nPROXY",
-		"7\r\nSLOWLOG",
-		"6\r\nSELECT",
 		"4\r\nTIME",
 		"6\r\nCONFIG",
-		"8\r\nCOMMANDS",
 	}
 	controlCmds = []string{
 		"4\r\nQUIT",
 		"4\r\nPING",
 	}
+	localCmds = []string{
+		"4\r\nINFO",
+		"7\r\nCOMMAND",
+		"5\r\nHELLO",
+		"6\r\nCLIENT",
+		"6\r\nSELECT",
+		"7\r\nSLOWLOG",
+		"5\r\nPROXY",
+	}
+	txnCmds = []string{
+		"5\r\nMULTI",
+		"4\r\nEXEC",
+		"7\r\nDISCARD",
+		"5\r\nWATCH",
+		"7\r\nUNWATCH",
+	}
 )
@@ -61,6 +61,60 @@ func TestMergeRequest(t *testing.T) {
 	assert.Equal(t, []byte("2\r\nv3"), mainReq.resp.array[6].data)
 }
 
+func TestRequestKeysByCommandTable(t *testing.T) {
+	data := "LMOVE a b LEFT RIGHT\r\nCOPY a b DB 1\r\nZRANGESTORE a b 0 -1\r\nOBJECT ENCODING a\r\nMEMORY USAGE a SAMPLES 5\r\nGETEX a PX 10\r\nPFCOUNT a b c\r\n"
+	nmsgs := _decodeMessage(t, data)
+	assert.Len(t, nmsgs, 7)
+	expects := [][]string{{"a", "b"}, {"a", "b"}, {"a", "b"}, {"a"}, {"a"}, {"a"}, {"a", "b", "c"}}
+	for i, expect := range expects {
+		req := nmsgs[i].Request().(*Request)
+		assert.True(t, req.IsSupport(), req.CmdString())
+		assert.Equal(t, "a", string(req.Key()))
+		var keys []string
+		for _, key := range req.Keys() {
+			keys = append(keys, string(key))
+		}
+		assert.Equal(t, expect, keys)
+	}
+}
+
+func TestRequestKeysSameNode(t *testing.T) {
+	data := "RENAME a b\r\nRENAMENX a b\r\nBITOP AND a b c\r\nSDIFFSTORE a b c\r\nSINTERSTORE a b\r\nMSETNX a 1 b 2\r\n" +
+		"SUNIONSTORE a b c\r\nZUNIONSTORE a 2 b c WEIGHTS 1 2\r\nZINTERSTORE a 1 b AGGREGATE MAX\r\nSMOVE a b m\r\nRPOPLPUSH a b\r\nSDIFF a b\r\nSINTER a b c\r\n"
+	nmsgs := _decodeMessage(t, data)
+	assert.Len(t, nmsgs, 13)
+	expects := [][]string{{"a", "b"}, {"a", "b"}, {"a", "b", "c"}, {"a", "b", "c"}, {"a", "b"}, {"a", "b"},
+		{"a", "b", "c"}, {"a", "b", "c"}, {"a", "b"}, {"a", "b"}, {"a", "b"}, {"a", "b"}, {"a", "b", "c"}}
+	for i, expect := range expects {
+		assert.False(t, nmsgs[i].IsBatch())
+		req := nmsgs[i].Request().(*Request)
+		assert.True(t, req.IsSupport(), req.CmdString())
+		assert.Equal(t, "a", string(req.Key()), req.CmdString())
+		var keys []string
+		for _, key := range req.Keys() {
+			keys = append(keys, string(key))
+		}
+		assert.Equal(t, expect, keys, req.CmdString())
+	}
+}
+
+func TestDecodeMultiKeyCount(t *testing.T) {
+	nmsgs := _decodeMessage(t, "UNLINK a b c\r\nTOUCH a b\r\nMEMORY STATS\r\nOBJECT HELP\r\n")
+	assert.Len(t, nmsgs, 4)
+	assert.Len(t, nmsgs[0].Requests(), 3)
+	assert.Len(t, nmsgs[1].Requests(), 2)
+	for _, msg := range nmsgs[:2] {
+		for _, req := range msg.Requests() {
+			assert.Equal(t, mergeTypeCount, req.(*Request).mType)
+		}
+	}
+	for _, msg := range nmsgs[2:] {
+		req := msg.Request().(*Request)
+		assert.True(t, req.IsCtl())
+		assert.Equal(t, errSubCmdNoKey, req.reply.data)
+	}
+}
+
 func BenchmarkCmdTypeCheck(b *testing.B) {
 	req := getReq()
 	req.resp.array = append(req.resp.array, &resp{
@@ -70,3 +124,13 @@ func BenchmarkCmdTypeCheck(b *testing.B) {
 		req.IsSupport()
 	}
 }
+
+func TestRequestIsReadOnly(t *testing.T) {
+	cmds, err := NewCommands([]*CommandConfig{{Name: "JSON.GET", Flag: "read", First: 1}, {Name: "BF.ADD", Flag: "write", First: 1}})
+	assert.NoError(t, err)
+	_, nmsgs := _decodeWithCommands(t, "GET a\r\nSET a 1\r\nJSON.GET a\r\nBF.ADD a b\r\nPING\r\nMULTI\r\nGET a\r\nEXEC\r\n", cmds)
+	assert.Len(t, nmsgs, 8)
+	for i, ro := range []bool{true, false, true, false, false, false, false, false} {
+		assert.Equal(t, ro, nmsgs[i].Request().(*Request).IsReadOnly(), "request %d", i)
+	}
+}
@@ -100,7 +100,68 @@ func (r *resp) next() *resp {
 	return subResp
 }
 
+// setBulk set resp as bulk string, nil data means null bulk.
+func (r *resp) setBulk(data []byte) {
+	r.reset()
+	r.respType = respBulk
+	if data == nil {
+		return
+	}
+	r.data = strconv.AppendInt(r.data, int64(len(data)), 10)
+	r.data = append(r.data, crlfBytes...)
+	r.data = append(r.data, data...)
+}
+
+// setPlain set resp as simple string, error or integer.
+func (r *resp) setPlain(rtype respType, data []byte) {
+	r.reset()
+	r.respType = rtype
+	r.data = append(r.data, data...)
+}
+
+// setInt set resp as integer.
+func (r *resp) setInt(i int64) {
+	r.reset()
+	r.respType = respInt
+	r.data = strconv.AppendInt(r.data, i, 10)
+}
+
+// setArray set resp as empty array, items are appended by next and
+// the count must be fixed by setArraySize.
+func (r *resp) setArray() {
+	r.reset()
+	r.respType = respArray
+}
+
+// setArraySize fix the count of array by the appended items.
+func (r *resp) setArraySize() {
+	r.data = strconv.AppendInt(r.data[:0], int64(r.arraySize), 10)
+}
+
+// sizeLimit limits the resp decoded before the bulk is buffered, 0 means unlimited.
+type sizeLimit struct {
+	bulk  int // max bytes of each bulk
+	total int // max bytes of the whole resp
+	start int // the mark of reader where the resp begins
+}
+
+// check the bulk of n bytes which starts at the current mark of reader.
+func (l *sizeLimit) check(br *bufio.Reader, n int) error {
+	if l.bulk > 0 && n > l.bulk {
+		return ErrBulkTooLarge
+	}
+	if l.total > 0 && br.Mark()-l.start+n+2 > l.total {
+		return ErrRespTooLarge
+	}
+	return nil
+}
+
 func (r *resp) decode(br *bufio.Reader) (err error) {
+	return r.decodeLimit(br, nil)
+}
+
+// decodeLimit decode the resp limited by l, which is nil means unlimited.
+func (r *resp) decodeLimit(br *bufio.Reader, l *sizeLimit) (err error) {
 	r.reset()
 	// start read
 	line, err := br.ReadLine()
@@ -113,15 +174,47 @@ func (r *resp) decode(br *bufio.Reader) (err error) {
 	case respString, respInt, respError:
 		r.data = append(r.data, line[1:len(line)-2]...)
 	case respBulk:
-		err = r.decodeBulk(line, br)
+		err = r.decodeBulk(line, br, l)
 	case respArray:
-		err = r.decodeArray(line, br)
+		err = r.decodeArray(line, br, l)
 	default:
 		err = r.decodeInline(line)
 	}
 	return
 }
 
+// discardResp drop the resp which begins at the current mark of reader, the
+// bulks are dropped without buffered.
+func discardResp(br *bufio.Reader) error {
+	for n := 1; n > 0; n-- {
+		line, err := br.ReadLine()
+		for err == bufio.ErrBufferFull {
+			if err = br.Read(); err != nil {
+				return err
+			}
+			line, err = br.ReadLine()
+		}
+		if err != nil {
+			return err
+		}
+		if line[0] != respBulk && line[0] != respArray {
+			continue
+		}
+		size, err := conv.Btoi(line[1 : len(line)-2])
+		if err != nil {
+			return err
+		}
+		if line[0] == respArray && size > 0 {
+			n += int(size)
+		} else if line[0] == respBulk && size >= 0 {
+			if err = br.Discard(int(size) + 2); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 // decodeInline Handle Telnet requests
 func (r *resp) decodeInline(line []byte) (err error) {
 	fields := bytes.Fields(line)
@@ -145,7 +238,7 @@ func (r *resp) decodeInline(line []byte) (err error) {
 	return
 }
 
-func (r *resp) decodeBulk(line []byte, br *bufio.Reader) (err error) {
+func (r *resp) decodeBulk(line []byte, br *bufio.Reader, l *sizeLimit) (err error) {
 	ls := len(line)
 	bulkLengthBytes := line[1 : ls-2]
 	bulkLength, err := conv.Btoi(bulkLengthBytes)
@@ -156,6 +249,13 @@ func (r *resp) decodeBulk(line []byte, br *bufio.Reader) (err error) {
 		r.data = r.data[:0]
 		return
 	}
+	if l != nil {
+		// NOTE: check before the announced length is buffered
+		if err = l.check(br, int(bulkLength)); err != nil {
+			br.Advance(-ls)
+			return
+		}
+	}
 	br.Advance(-ls)
 	all := ls + int(bulkLength) + 2
 	data, err := br.ReadExact(all)
@@ -168,7 +268,7 @@ func (r *resp) decodeBulk(line []byte, br *bufio.Reader) (err error) {
 	return
 }
 
-func (r *resp) decodeArray(line []byte, br *bufio.Reader) (err error) {
+func (r *resp) decodeArray(line []byte, br *bufio.Reader, l *sizeLimit) (err error) {
 	ls := len(line)
 	arrayLengthBytes := line[1 : ls-2]
 	arrayLength, err := conv.Btoi(arrayLengthBytes)
@@ -183,7 +283,7 @@ func (r *resp) decodeArray(line []byte, br *bufio.Reader) (err error) {
 	mark := br.Mark()
 	for i := 0; i < int(arrayLength); i++ {
 		nre := r.next()
-		if err = nre.decode(br); err != nil {
+		if err = nre.decodeLimit(br, l); err != nil {
 			br.AdvanceTo(mark)
 			br.Advance(-ls)
 			return
@@ -0,0 +1,274 @@
+package redis
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha1"
+	"encoding/hex"
+	"strconv"
+	"sync"
+
+	"github.com/ducesoft/overlord/pkg/conv"
+	"github.com/ducesoft/overlord/proxy/proto"
+
+	"github.com/pkg/errors"
+)
+
+const maxScripts = 4096
+
+var (
+	cmdScriptBytes  = []byte("6\r\nSCRIPT")
+	cmdEvalShaBytes = []byte("7\r\nEVALSHA")
+	subLoadBytes    = []byte("4\r\nLOAD")
+	subExistsBytes  = []byte("6\r\nEXISTS")
+	subFlushBytes   = []byte("5\r\nFLUSH")
+
+	noScriptBytes    = []byte("NOSCRIPT")
+	scriptLoadBytes  = []byte("*3\r\n$6\r\nSCRIPT\r\n$4\r\nLOAD\r\n")
+	errScriptSubCmd  = []byte("ERR unknown subcommand or wrong number of arguments for 'script' command")
+	errScriptExists  = []byte("ERR script exists replies mismatch")
+	scriptExistsZero = []byte("0")
+	scriptExistsOne  = []byte("1")
+)
+
+// scriptReload is the EVALSHA retried after SCRIPT LOAD, selected is set if
+// SELECT is written before.
+type scriptReload struct {
+	req      *Request
+	selected bool
+}
+
+// ScriptCache keeps the script bodies of a cluster by sha1 hex, so the proxy
+// can load the script into node which answers NOSCRIPT for EVALSHA, eg: after
+// failover. The least recently used script is evicted when full.
+type ScriptCache struct {
+	lock    sync.Mutex
+	max     int
+	lru     *list.List
+	scripts map[string]*list.Element
+}
+
+type scriptEntry struct {
+	sha    string
+	script []byte
+}
+
+// NewScriptCache new the script cache of cluster.
+func NewScriptCache() *ScriptCache {
+	return &ScriptCache{max: maxScripts, lru: list.New(), scripts: make(map[string]*list.Element)}
+}
+
+func (s *ScriptCache) get(sha []byte) (script []byte, ok bool) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	e, ok := s.scripts[string(bytes.ToLower(sha))]
+	if !ok {
+		return
+	}
+	s.lru.MoveToFront(e)
+	script = e.Value.(*scriptEntry).script
+	return
+}
+
+func (s *ScriptCache) add(script []byte) {
+	if s == nil {
+		return
+	}
+	sum := sha1.Sum(script)
+	sha := hex.EncodeToString(sum[:])
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if e, ok := s.scripts[sha]; ok {
+		s.lru.MoveToFront(e)
+		return
+	}
+	if s.lru.Len() >= s.max {
+		e := s.lru.Back()
+		s.lru.Remove(e)
+		delete(s.scripts, e.Value.(*scriptEntry).sha)
+	}
+	s.scripts[sha] = s.lru.PushFront(&scriptEntry{sha: sha, script: append([]byte{}, script...)})
+}
+
+func (s *ScriptCache) flush() {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	s.lru.Init()
+	s.scripts = make(map[string]*list.Element)
+	s.lock.Unlock()
+}
+
+// decodeScript decode SCRIPT LOAD|EXISTS|FLUSH which are broadcast to every node.
+func (pc *proxyConn) decodeScript(r *Request) {
+	if r.resp.arraySize < 2 {
+		r.replyLocal(respError, errScriptSubCmd)
+		return
+	}
+	sub := r.resp.array[1].data
+	conv.UpdateToUpper(sub)
+	switch {
+	case bytes.Equal(sub, subLoadBytes) && r.resp.arraySize == 3:
+		pc.scripts.add(bulkData(r.resp.array[2]))
+		r.mType = mergeTypeFirst
+	case bytes.Equal(sub, subExistsBytes) && r.resp.arraySize > 2:
+		r.mType = mergeTypeAnd
+	case bytes.Equal(sub, subFlushBytes) && r.resp.arraySize <= 3:
+		pc.scripts.flush()
+		r.mType = mergeTypeFirst
+	default:
+		r.replyLocal(respError, errScriptSubCmd)
+		return
+	}
+	r.broadcast = true
+}
+
+// IsBroadcast impl the proto.Broadcaster.
+func (r *Request) IsBroadcast() bool {
+	return r.broadcast
+}
+
+// Broadcast impl the proto.Broadcaster.
+func (r *Request) Broadcast(m *proto.Message, n int) {
+	for i := 1; i < n; i++ {
+		nr := nextReq(m)
+		nr.resp.copy(r.resp)
+		nr.mType = r.mType
+		nr.broadcast = true
+		nr.db = r.db
+	}
+}
+
+func isEval(cmd []byte) bool {
+	return bytes.Equal(cmd, cmdEvalBytes) || bytes.Equal(cmd, cmdEvalShaBytes)
+}
+
+// evalKeys returns the keys of EVAL|EVALSHA script numkeys key [key ...] arg [arg ...].
+func evalKeys(keys [][]byte, r *resp) [][]byte {
+	if r.arraySize < 4 {
+		return append(keys, firstKey(r))
+	}
+	num, err := conv.Btoi(bulkData(r.array[2]))
+	if err != nil || num < 1 || int(num) > r.arraySize-3 {
+		return append(keys, firstKey(r))
+	}
+	for i := 3; i < 3+int(num); i++ {
+		keys = append(keys, bulkData(r.array[i]))
+	}
+	return keys
+}
+
+// reloadScript writes SCRIPT LOAD and the retry of EVALSHA which is answered
+// NOSCRIPT into the conn itself, whose replies follow the replies of pending
+// requests and are read by reloadScripts.
+func (nc *nodeConn) reloadScript(req *Request) {
+	if req.resp.arraySize < 2 || req.reply.respType != respError ||
+		!bytes.HasPrefix(req.reply.data, noScriptBytes) ||
+		!bytes.Equal(req.resp.array[0].data, cmdEvalShaBytes) {
+		return
+	}
+	script, ok := nc.scripts.get(bulkData(req.resp.array[1]))
+	if !ok {
+		return
+	}
+	rl := scriptReload{req: req}
+	if req.db != nc.db {
+		nc.writeSelect(req.db)
+		rl.selected = true
+	}
+	_ = nc.bw.Write(scriptLoadBytes)
+	_ = nc.bw.Write(respBulkBytes)
+	_ = nc.bw.Write([]byte(strconv.Itoa(len(script))))
+	_ = nc.bw.Write(crlfBytes)
+	_ = nc.bw.Write(script)
+	_ = nc.bw.Write(crlfBytes)
+	_ = req.resp.encode(nc.bw)
+	nc.reloads = append(nc.reloads, rl)
+}
+
+// reloadScripts flush the reloads and read their replies, the reply of EVALSHA
+// retried replaces the NOSCRIPT.
+// NOTE: the node pipe replies the messages after the whole batch is read, so
+// the retried EVALSHA is replied in the same batch.
+func (nc *nodeConn) reloadScripts() (err error) {
+	if err = nc.bw.Flush(); err != nil {
+		return errors.WithStack(err)
+	}
+	for _, rl := range nc.reloads {
+		if rl.selected {
+			if err = nc.readReply(nc.scratch); err != nil {
+				return
+			}
+		}
+		// NOTE: the EVALSHA is answered NOSCRIPT again if SCRIPT LOAD fails
+		if err = nc.readReply(nc.scratch); err != nil {
+			return
+		}
+		if err = nc.readReply(rl.req.reply); err != nil {
+			return
+		}
+	}
+	nc.reloads = nc.reloads[:0]
+	return
+}
+
+func (pc *proxyConn) mergeFirst(m *proto.Message) (err error) {
+	var first *Request
+	for _, mreq := range m.Requests() {
+		req, ok := mreq.(*Request)
+		if !ok {
+			return ErrBadAssert
+		}
+		if req.reply.respType == respError {
+			return req.reply.encode(pc.bw)
+		}
+		if first == nil {
+			first = req
+		}
+	}
+	return first.reply.encode(pc.bw)
+}
+
+func (pc *proxyConn) mergeAnd(m *proto.Message) (err error) {
+	var and []bool
+	for _, mreq := range m.Requests() {
+		req, ok := mreq.(*Request)
+		if !ok {
+			return ErrBadAssert
+		}
+		if req.reply.respType == respError {
+			return req.reply.encode(pc.bw)
+		}
+		if and == nil {
+			and = make([]bool, req.reply.arraySize)
+			for i := range and {
+				and[i] = true
+			}
+		}
+		if req.reply.respType != respArray || req.reply.arraySize != len(and) {
+			_ = pc.bw.Write(respErrorBytes)
+			_ = pc.bw.Write(errScriptExists)
+			return pc.bw.Write(crlfBytes)
+		}
+		for i, r := range req.reply.Array() {
+			and[i] = and[i] && bytes.Equal(r.data, scriptExistsOne)
+		}
+	}
+	_ = pc.bw.Write(respArrayBytes)
+	_ = pc.bw.Write([]byte(strconv.Itoa(len(and))))
+	_ = pc.bw.Write(crlfBytes)
+	for _, ok := range and {
+		_ = pc.bw.Write(respIntBytes)
+		if ok {
+			_ = pc.bw.Write(scriptExistsOne)
+		} else {
+			_ = pc.bw.Write(scriptExistsZero)
+		}
+		err = pc.bw.Write(crlfBytes)
+	}
+	return
+}
@@ -0,0 +1,167 @@
+package redis
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ducesoft/overlord/pkg/mockconn"
+	libnet "github.com/ducesoft/overlord/pkg/net"
+	"github.com/ducesoft/overlord/proxy/proto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeScript(t *testing.T) {
+	data := "SCRIPT load return\r\nSCRIPT EXISTS a b\r\nSCRIPT FLUSH\r\nSCRIPT KILL\r\nEVALSHA abc 2 {a}1 {a}2 arg\r\n"
+	nmsgs := _decodeMessage(t, data)
+	assert.Len(t, nmsgs, 5)
+
+	req := nmsgs[0].Request().(*Request)
+	assert.True(t, req.IsBroadcast())
+	assert.Equal(t, mergeTypeFirst, req.mType)
+	req.Broadcast(nmsgs[0], 3)
+	assert.Len(t, nmsgs[0].Batch(), 3)
+	for _, r := range nmsgs[0].Requests() {
+		assert.Equal(t, "SCRIPT", r.CmdString())
+		assert.True(t, r.(*Request).IsBroadcast())
+	}
+
+	req = nmsgs[1].Request().(*Request)
+	assert.True(t, req.IsBroadcast())
+	assert.Equal(t, mergeTypeAnd, req.mType)
+	req = nmsgs[2].Request().(*Request)
+	assert.True(t, req.IsBroadcast())
+	req = nmsgs[3].Request().(*Request)
+	assert.False(t, req.IsBroadcast())
+	assert.Equal(t, errScriptSubCmd, req.reply.data)
+
+	req = nmsgs[4].Request().(*Request)
+	assert.True(t, req.IsSupport())
+	assert.False(t, req.IsBroadcast())
+	assert.Equal(t, "{a}1", string(req.Key()))
+	keys := req.Keys()
+	assert.Len(t, keys, 2)
+	assert.Equal(t, "{a}2", string(keys[1]))
+}
+
+func TestEncodeScriptExists(t *testing.T) {
+	msg := proto.NewMessage()
+	for _, ints := range [][]string{{"1", "1", "0"}, {"1", "0", "0"}} {
+		req := getReq()
+		req.mType = mergeTypeAnd
+		req.reply.respType = respArray
+		for _, i := range ints {
+			nr := req.reply.next()
+			nr.respType = respInt
+			nr.data = append(nr.data, i...)
+		}
+		msg.WithRequest(req)
+	}
+	msg.Batch()
+	conn, buf := mockconn.CreateDownStreamConn()
+	pc := NewProxyConn(libnet.NewConn(conn, time.Second, time.Second), true)
+	assert.NoError(t, pc.Encode(msg))
+	assert.NoError(t, pc.Flush())
+	assert.Equal(t, "*3\r\n:1\r\n:0\r\n:0\r\n", buf.String())
+}
+
+// _readCmds reads n commands of RESP and returns them joined by space.
+func _readCmds(br *bufio.Reader, n int) (cmds []string) {
+	for i := 0; i < n; i++ {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cnt, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		var args []string
+		for j := 0; j < cnt; j++ {
+			_, _ = br.ReadString('\n')
+			arg, _ := br.ReadString('\n')
+			args = append(args, strings.TrimSpace(arg))
+		}
+		cmds = append(cmds, strings.Join(args, " "))
+	}
+	return
+}
+
+func TestNodeConnReloadScript(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer l.Close()
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		br := bufio.NewReader(conn)
+		cmds := _readCmds(br, 2)
+		conn.Write([]byte("-NOSCRIPT No matching script.\r\n$1\r\nv\r\n"))
+		// NOTE: the reload is sent by the same conn after the pending replies
+		cmds = append(cmds, _readCmds(br, 2)...)
+		conn.Write([]byte("$40\r\n9ed6bbf7f6b9d08e1ae4d3dc3c5eaf1c3d2f6d16\r\n:1\r\n"))
+		received <- cmds
+	}()
+	scripts := NewScriptCache()
+	scripts.add([]byte("return 1"))
+	conn := libnet.DialWithTimeout(l.Addr().String(), time.Second, time.Second, time.Second)
+	nc := newNodeConn("baka", l.Addr().String(), conn).(*nodeConn)
+	nc.WithScripts(scripts)
+	defer nc.Close()
+
+	msgs := proto.GetMsgs(2)
+	defer proto.PutMsgs(msgs)
+	eval := getReq()
+	eval.resp.copy(newArrayResp("EVALSHA", "e0e1f9fabfc9d4800c877a703b823ac0578ff8db", "1", "a"))
+	msgs[0].WithRequest(eval)
+	get := getReq()
+	get.resp.copy(newArrayResp("GET", "a"))
+	msgs[1].WithRequest(get)
+	for _, m := range msgs {
+		assert.NoError(t, nc.Write(m))
+	}
+	assert.NoError(t, nc.Flush())
+	assert.NoError(t, nc.Read(msgs[0]))
+	assert.NoError(t, nc.Read(msgs[1]))
+	assert.Equal(t, []string{"EVALSHA e0e1f9fabfc9d4800c877a703b823ac0578ff8db 1 a", "GET a", "SCRIPT LOAD return 1", "EVALSHA e0e1f9fabfc9d4800c877a703b823ac0578ff8db 1 a"}, <-received)
+	assert.Equal(t, respInt, eval.reply.respType)
+	assert.Equal(t, []byte("1"), eval.reply.data)
+	assert.Equal(t, []byte("v"), bulkData(get.reply))
+}
+
+func TestScriptCacheLRU(t *testing.T) {
+	s := NewScriptCache()
+	s.max = 2
+	sha := func(script string) []byte {
+		sum := sha1.Sum([]byte(script))
+		return []byte(hex.EncodeToString(sum[:]))
+	}
+	s.add([]byte("return 1"))
+	s.add([]byte("return 2"))
+	_, ok := s.get(sha("return 1"))
+	assert.True(t, ok)
+	s.add([]byte("return 3"))
+	_, ok = s.get(sha("return 2"))
+	assert.False(t, ok, "the least recently used is evicted")
+	script, ok := s.get(bytes.ToUpper(sha("return 1")))
+	assert.True(t, ok)
+	assert.Equal(t, "return 1", string(script))
+
+	other := NewScriptCache()
+	other.add([]byte("return 1"))
+	s.flush()
+	_, ok = s.get(sha("return 3"))
+	assert.False(t, ok)
+	_, ok = other.get(sha("return 1"))
+	assert.True(t, ok, "flush never affects the other clusters")
+}
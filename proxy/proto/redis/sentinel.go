@@ -0,0 +1,163 @@
+package redis
+
+import (
+	"bytes"
+	errs "errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/ducesoft/overlord/pkg/bufio"
+	libnet "github.com/ducesoft/overlord/pkg/net"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	sentinelBufferSize = 512
+)
+
+// errors
+var (
+	ErrSentinelClosed   = errs.New("sentinel conn has been closed")
+	ErrSentinelNoMaster = errs.New("sentinel has no master of name")
+	ErrSentinelBadReply = errs.New("sentinel reply is bad")
+)
+
+var (
+	switchMasterChannel = "+switch-master"
+
+	messageBytes             = []byte("7\r\nmessage")
+	cmdSubSwitchMasterBytes  = []byte("*2\r\n$9\r\nSUBSCRIBE\r\n$14\r\n+switch-master\r\n")
+	cmdGetMasterAddrByPrefix = "*3\r\n$8\r\nSENTINEL\r\n$23\r\nget-master-addr-by-name\r\n"
+)
+
+// Sentinel is the conn to redis sentinel which resolves the addr of master
+// and receives the +switch-master events after Subscribe.
+type Sentinel struct {
+	conn *libnet.Conn
+
+	br    *bufio.Reader
+	bw    *bufio.Writer
+	reply *resp
+
+	state int32
+}
+
+// NewSentinel new a sentinel conn.
+func NewSentinel(conn *libnet.Conn) *Sentinel {
+	return &Sentinel{
+		conn:  conn,
+		br:    bufio.NewReader(conn, bufio.NewBuffer(sentinelBufferSize)),
+		bw:    bufio.NewWriter(conn),
+		reply: &resp{},
+		state: opened,
+	}
+}
+
+// MasterAddr returns the addr of master name by SENTINEL get-master-addr-by-name.
+func (s *Sentinel) MasterAddr(name string) (addr string, err error) {
+	cmd := append([]byte(cmdGetMasterAddrByPrefix), respBulkBytes...)
+	cmd = append(strconv.AppendInt(cmd, int64(len(name)), 10), crlfBytes...)
+	cmd = append(append(cmd, name...), crlfBytes...)
+	if err = s.write(cmd); err != nil {
+		return
+	}
+	if err = s.read(); err != nil {
+		return
+	}
+	if s.reply.respType == respError {
+		err = errors.Wrapf(ErrSentinelBadReply, "reply:%s", s.reply.data)
+		return
+	}
+	if s.reply.respType != respArray {
+		err = errors.WithStack(ErrSentinelBadReply)
+		return
+	}
+	if s.reply.arraySize != 2 {
+		err = errors.Wrapf(ErrSentinelNoMaster, "name:%s", name)
+		return
+	}
+	addr = net.JoinHostPort(string(bulkData(s.reply.array[0])), string(bulkData(s.reply.array[1])))
+	return
+}
+
+// Subscribe subscribe the +switch-master channel, the conn can only receive
+// the events by SwitchMaster after that.
+func (s *Sentinel) Subscribe() (err error) {
+	if err = s.write(cmdSubSwitchMasterBytes); err != nil {
+		return
+	}
+	if err = s.read(); err != nil {
+		return
+	}
+	if s.reply.respType != respArray || s.reply.arraySize != 3 {
+		err = errors.WithStack(ErrSentinelBadReply)
+	}
+	return
+}
+
+// SwitchMaster blocks until the next +switch-master event and returns the
+// name and the new addr of master.
+func (s *Sentinel) SwitchMaster() (name, addr string, err error) {
+	for {
+		if err = s.read(); err != nil {
+			return
+		}
+		r := s.reply
+		if r.respType != respArray || r.arraySize != 3 || !bytes.Equal(r.array[0].data, messageBytes) ||
+			string(bulkData(r.array[1])) != switchMasterChannel {
+			continue
+		}
+		// NOTE: <master name> <old ip> <old port> <new ip> <new port>
+		fields := strings.Fields(string(bulkData(r.array[2])))
+		if len(fields) != 5 {
+			err = errors.Wrapf(ErrSentinelBadReply, "switch-master:%s", bulkData(r.array[2]))
+			return
+		}
+		name = fields[0]
+		addr = net.JoinHostPort(fields[3], fields[4])
+		return
+	}
+}
+
+// Close close the sentinel conn, it is safe to interrupt the blocking SwitchMaster.
+func (s *Sentinel) Close() error {
+	if atomic.CompareAndSwapInt32(&s.state, opened, closed) && s.conn.Conn != nil {
+		// NOTE: close the socket directly, the closed flag of conn is not safe to be written concurrently.
+		return s.conn.Conn.Close()
+	}
+	return nil
+}
+
+func (s *Sentinel) write(cmd []byte) (err error) {
+	if atomic.LoadInt32(&s.state) == closed {
+		return errors.WithStack(ErrSentinelClosed)
+	}
+	_ = s.bw.Write(cmd)
+	if err = s.bw.Flush(); err != nil {
+		err = errors.WithStack(err)
+	}
+	return
+}
+
+// read decode the next reply, the buffered data is decoded first because of
+// the pushed events.
+func (s *Sentinel) read() (err error) {
+	for {
+		if atomic.LoadInt32(&s.state) == closed {
+			return errors.WithStack(ErrSentinelClosed)
+		}
+		mark := s.br.Mark()
+		if err = s.reply.decode(s.br); err == nil {
+			return
+		} else if err != bufio.ErrBufferFull {
+			return errors.WithStack(err)
+		}
+		s.br.AdvanceTo(mark)
+		if err = s.br.Read(); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ducesoft/overlord/pkg/mockconn"
+	libnet "github.com/ducesoft/overlord/pkg/net"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func _sentinel(data string) (*Sentinel, *mockconn.MockConn) {
+	conn := libnet.NewConn(mockconn.CreateConn([]byte(data), 1), time.Second, time.Second)
+	return NewSentinel(conn), conn.Conn.(*mockconn.MockConn)
+}
+
+func TestSentinelMasterAddr(t *testing.T) {
+	s, mconn := _sentinel("*2\r\n$8\r\n10.0.0.1\r\n$4\r\n6379\r\n")
+	addr, err := s.MasterAddr("mymaster")
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.1:6379", addr)
+	assert.Equal(t, "*3\r\n$8\r\nSENTINEL\r\n$23\r\nget-master-addr-by-name\r\n$8\r\nmymaster\r\n", mconn.Wbuf.String())
+
+	s, _ = _sentinel("*-1\r\n")
+	_, err = s.MasterAddr("unknown")
+	assert.Equal(t, ErrSentinelNoMaster, errors.Cause(err))
+
+	s, _ = _sentinel("-ERR unknown command\r\n")
+	_, err = s.MasterAddr("mymaster")
+	assert.Equal(t, ErrSentinelBadReply, errors.Cause(err))
+}
+
+func TestSentinelSwitchMaster(t *testing.T) {
+	s, mconn := _sentinel("*3\r\n$9\r\nsubscribe\r\n$14\r\n+switch-master\r\n:1\r\n" +
+		"*3\r\n$7\r\nmessage\r\n$5\r\nother\r\n$1\r\na\r\n" +
+		"*3\r\n$7\r\nmessage\r\n$14\r\n+switch-master\r\n$40\r\nmymaster 10.0.0.1 6379 10.0.0.2 6380 xxx\r\n" +
+		"*3\r\n$7\r\nmessage\r\n$14\r\n+switch-master\r\n$36\r\nmymaster 10.0.0.1 6379 10.0.0.2 6380\r\n")
+	assert.NoError(t, s.Subscribe())
+	assert.Equal(t, string(cmdSubSwitchMasterBytes), mconn.Wbuf.String())
+	_, _, err := s.SwitchMaster()
+	assert.Equal(t, ErrSentinelBadReply, errors.Cause(err))
+	name, addr, err := s.SwitchMaster()
+	assert.NoError(t, err)
+	assert.Equal(t, "mymaster", name)
+	assert.Equal(t, "10.0.0.2:6380", addr)
+
+	assert.NoError(t, s.Close())
+	_, _, err = s.SwitchMaster()
+	assert.Equal(t, ErrSentinelClosed, errors.Cause(err))
+}
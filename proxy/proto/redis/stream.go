@@ -0,0 +1,166 @@
+package redis
+
+import (
+	"bytes"
+	"strconv"
+	"time"
+
+	"github.com/ducesoft/overlord/pkg/conv"
+	"github.com/ducesoft/overlord/proxy/proto"
+)
+
+var (
+	cmdXReadBytes      = []byte("5\r\nXREAD")
+	cmdXReadGroupBytes = []byte("10\r\nXREADGROUP")
+	cmdXGroupBytes     = []byte("6\r\nXGROUP")
+	cmdXInfoBytes      = []byte("5\r\nXINFO")
+
+	argStreamsBytes = []byte("STREAMS")
+	argGroupBytes   = []byte("GROUP")
+	argCountBytes   = []byte("COUNT")
+	argBlockBytes   = []byte("BLOCK")
+)
+
+func isXRead(cmd []byte) bool {
+	return bytes.Equal(cmd, cmdXReadBytes) || bytes.Equal(cmd, cmdXReadGroupBytes)
+}
+
+// xread is the args of XREAD [COUNT count] [BLOCK milliseconds] STREAMS key [key ...] id [id ...]
+// and XREADGROUP GROUP group consumer [COUNT count] [BLOCK milliseconds] [NOACK] STREAMS key [key ...] id [id ...].
+type xread struct {
+	// streams is the index of STREAMS, 0 means the args are invalid.
+	streams int
+	// count is the number of streams.
+	count    int
+	blocking bool
+	block    time.Duration
+}
+
+func parseXRead(r *resp) (x xread) {
+	for i := 1; i < r.arraySize; i++ {
+		arg := bulkData(r.array[i])
+		switch {
+		case bytes.EqualFold(arg, argStreamsBytes):
+			n := r.arraySize - i - 1
+			if n == 0 || n%2 != 0 {
+				return
+			}
+			x.streams = i
+			x.count = n / 2
+			return
+		case bytes.EqualFold(arg, argGroupBytes):
+			i += 2
+		case bytes.EqualFold(arg, argCountBytes):
+			i++
+		case bytes.EqualFold(arg, argBlockBytes) && i+1 < r.arraySize:
+			i++
+			ms, err := conv.Btoi(bulkData(r.array[i]))
+			if err != nil || ms < 0 {
+				return
+			}
+			x.blocking = true
+			x.block = time.Duration(ms) * time.Millisecond
+		}
+	}
+	return
+}
+
+// xreadKeys returns the stream keys of XREAD and XREADGROUP.
+func xreadKeys(keys [][]byte, r *resp) [][]byte {
+	x := parseXRead(r)
+	if x.streams == 0 {
+		return append(keys, bulkData(r.array[1]))
+	}
+	for i := x.streams + 1; i <= x.streams+x.count; i++ {
+		keys = append(keys, bulkData(r.array[i]))
+	}
+	return keys
+}
+
+// decodeXRead split XREAD and XREADGROUP with multi streams into one request
+// per stream, the streams of the same node are merged again by forwarder and
+// the replies are joined by mergeTypeStreams.
+//
+// Blocking reads are not split because they return once any stream has data,
+// they are sent on a dedicated conn and all the streams must be in the same node.
+func (pc *proxyConn) decodeXRead(msg *proto.Message) {
+	x := parseXRead(pc.resp)
+	if x.streams == 0 || x.count == 1 || x.blocking {
+		r := nextReq(msg)
+		r.resp.copy(pc.resp)
+		if x.blocking {
+			pc.decodeBlocking(r, x.block)
+		}
+		return
+	}
+	for i := 0; i < x.count; i++ {
+		r := nextReq(msg)
+		r.mType = mergeTypeStreams
+		r.batchOpCount = 2
+		r.resp.setArray()
+		for j := 0; j <= x.streams; j++ {
+			r.resp.next().copy(pc.resp.array[j])
+		}
+		r.resp.next().copy(pc.resp.array[x.streams+1+i])         // NOTE: key
+		r.resp.next().copy(pc.resp.array[x.streams+1+x.count+i]) // NOTE: id
+		r.resp.setArraySize()
+	}
+}
+
+// mergeXRead merge the streams of reqs into r, the keys and ids are reordered
+// as STREAMS key [key ...] id [id ...].
+func (r *Request) mergeXRead(reqs []proto.Request) {
+	base := r.resp.arraySize - 2
+	for i := range reqs {
+		req := reqs[i].(*Request)
+		req.merged = true
+		n := req.resp.arraySize
+		r.resp.next().copy(req.resp.array[n-2])
+		r.resp.next().copy(req.resp.array[n-1])
+	}
+	tail := r.resp.array[base:r.resp.arraySize]
+	sorted := make([]*resp, 0, len(tail))
+	for i := 0; i < len(tail); i += 2 {
+		sorted = append(sorted, tail[i])
+	}
+	for i := 1; i < len(tail); i += 2 {
+		sorted = append(sorted, tail[i])
+	}
+	copy(tail, sorted)
+	r.resp.setArraySize()
+}
+
+// mergeStreams join the replies of streams, null array if no stream has data
+// and the first error is replied if any.
+func (pc *proxyConn) mergeStreams(m *proto.Message) (err error) {
+	var streams []*resp
+	for _, mreq := range m.Requests() {
+		req, ok := mreq.(*Request)
+		if !ok {
+			return ErrBadAssert
+		}
+		if req.merged {
+			continue
+		}
+		switch {
+		case req.reply.respType == respError:
+			return req.reply.encode(pc.bw)
+		case req.reply.respType == respArray:
+			streams = append(streams, req.reply.array[:req.reply.arraySize]...)
+		}
+	}
+	_ = pc.bw.Write(respArrayBytes)
+	if len(streams) == 0 {
+		return pc.bw.Write(nullBytes)
+	}
+	_ = pc.bw.Write([]byte(strconv.Itoa(len(streams))))
+	if err = pc.bw.Write(crlfBytes); err != nil {
+		return
+	}
+	for _, stream := range streams {
+		if err = stream.encode(pc.bw); err != nil {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,92 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ducesoft/overlord/pkg/mockconn"
+	libnet "github.com/ducesoft/overlord/pkg/net"
+	"github.com/ducesoft/overlord/proxy/proto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamKeys(t *testing.T) {
+	nmsgs := _decodeMessage(t, "XGROUP CREATE s1 g $\r\nXINFO STREAM s2\r\nXADD s3 * f v\r\nXREADGROUP GROUP streams c COUNT 1 STREAMS s4 >\r\nXREAD STREAMS\r\n")
+	assert.Len(t, nmsgs, 5)
+	for i, key := range []string{"s1", "s2", "s3", "s4", "STREAMS"} {
+		req := nmsgs[i].Request().(*Request)
+		assert.True(t, req.IsSupport())
+		assert.Equal(t, key, string(req.Key()))
+	}
+	r := newArrayResp("XREAD", "COUNT", "2", "STREAMS", "a", "b", "c", "0", "0", "0")
+	keys := appendKeys(nil, r)
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("b"), []byte("c")}, keys)
+}
+
+func TestDecodeXRead(t *testing.T) {
+	nmsgs := _decodeMessage(t, "XREAD COUNT 2 STREAMS a b 0 1\r\nXREAD BLOCK 100 STREAMS a b 0 1\r\n")
+	assert.Len(t, nmsgs, 2)
+
+	reqs := nmsgs[0].Requests()
+	assert.Len(t, reqs, 2)
+	assert.True(t, nmsgs[0].IsBatch())
+	for i, expect := range []string{"*6\r\n$5\r\nXREAD\r\n$5\r\nCOUNT\r\n$1\r\n2\r\n$7\r\nSTREAMS\r\n$1\r\na\r\n$1\r\n0\r\n",
+		"*6\r\n$5\r\nXREAD\r\n$5\r\nCOUNT\r\n$1\r\n2\r\n$7\r\nSTREAMS\r\n$1\r\nb\r\n$1\r\n1\r\n"} {
+		req := reqs[i].(*Request)
+		assert.Equal(t, mergeTypeStreams, req.mType)
+		assert.Equal(t, expect, _encodeResp(t, req.resp))
+	}
+	// NOTE: merged again when in the same node
+	main := reqs[0].(*Request)
+	assert.NoError(t, main.Merge(reqs[1:]))
+	assert.True(t, reqs[1].(*Request).merged)
+	assert.Equal(t, "*8\r\n$5\r\nXREAD\r\n$5\r\nCOUNT\r\n$1\r\n2\r\n$7\r\nSTREAMS\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\n0\r\n$1\r\n1\r\n", _encodeResp(t, main.resp))
+
+	block := nmsgs[1].Request().(*Request)
+	assert.False(t, nmsgs[1].IsBatch())
+	assert.True(t, block.blocking)
+	assert.Equal(t, 100*time.Millisecond, block.block)
+	assert.True(t, proto.IsSession(nmsgs[1]))
+	_, op := block.Session()
+	assert.Equal(t, proto.SessionRelease, op)
+	assert.Len(t, block.Keys(), 2)
+}
+
+func TestEncodeMergeStreams(t *testing.T) {
+	stream := newArrayResp("a", "entries")
+	ts := []struct {
+		Name    string
+		Replies []*resp
+		Expect  string
+	}{
+		{"null", []*resp{{respType: respArray, data: []byte("-1")}, {respType: respArray, data: []byte("-1")}}, "*-1\r\n"},
+		{"join", []*resp{{respType: respArray, data: []byte("-1")}, newrespArray([]*resp{stream})}, "*1\r\n*2\r\n$1\r\na\r\n$7\r\nentries\r\n"},
+		{"error", []*resp{newrespArray([]*resp{stream}), {respType: respError, data: []byte("WRONGTYPE")}}, "-WRONGTYPE\r\n"},
+	}
+	for _, tt := range ts {
+		t.Run(tt.Name, func(t *testing.T) {
+			msg := proto.NewMessage()
+			for _, rpl := range tt.Replies {
+				req := getReq()
+				req.mType = mergeTypeStreams
+				req.reply = rpl
+				msg.WithRequest(req)
+			}
+			msg.Batch()
+			conn, buf := mockconn.CreateDownStreamConn()
+			pc := NewProxyConn(libnet.NewConn(conn, time.Second, time.Second), true)
+			assert.NoError(t, pc.Encode(msg))
+			assert.NoError(t, pc.Flush())
+			assert.Equal(t, tt.Expect, buf.String())
+		})
+	}
+}
+
+func _encodeResp(t *testing.T, r *resp) string {
+	conn, buf := mockconn.CreateDownStreamConn()
+	pc := NewProxyConn(libnet.NewConn(conn, time.Second, time.Second), true).(*ProxyConn)
+	assert.NoError(t, r.encode(pc.bw))
+	assert.NoError(t, pc.Flush())
+	return buf.String()
+}
@@ -0,0 +1,176 @@
+package redis
+
+import (
+	"bytes"
+	errs "errors"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/ducesoft/overlord/pkg/bufio"
+	libnet "github.com/ducesoft/overlord/pkg/net"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	trackingBufferSize = 4096
+)
+
+// errors
+var (
+	ErrTrackingClosed   = errs.New("tracking conn has been closed")
+	ErrTrackingBadReply = errs.New("tracking reply is bad")
+)
+
+var (
+	invalidateChannel = "__redis__:invalidate"
+
+	cmdClientIDBytes       = []byte("*2\r\n$6\r\nCLIENT\r\n$2\r\nID\r\n")
+	cmdSubInvalidateBytes  = []byte("*2\r\n$9\r\nSUBSCRIBE\r\n$20\r\n__redis__:invalidate\r\n")
+	cmdClientTrackingBytes = []byte("$6\r\nCLIENT\r\n$8\r\nTRACKING\r\n$2\r\non\r\n$8\r\nREDIRECT\r\n")
+	argBcastBytes          = []byte("$5\r\nBCAST\r\n")
+	argPrefixBytes         = []byte("$6\r\nPREFIX\r\n")
+)
+
+// Tracking is the conn to redis 6 which receives the invalidation of keys
+// by CLIENT TRACKING in broadcast mode, the invalidations are redirected to
+// the conn itself.
+type Tracking struct {
+	conn *libnet.Conn
+
+	br    *bufio.Reader
+	bw    *bufio.Writer
+	reply *resp
+
+	state int32
+}
+
+// NewTracking new a tracking conn.
+func NewTracking(conn *libnet.Conn) *Tracking {
+	return &Tracking{
+		conn:  conn,
+		br:    bufio.NewReader(conn, bufio.NewBuffer(trackingBufferSize)),
+		bw:    bufio.NewWriter(conn),
+		reply: &resp{},
+		state: opened,
+	}
+}
+
+// Subscribe enable the tracking of keys with prefixes and subscribe the
+// invalidations, empty prefixes means all keys. The conn can only receive
+// the invalidations by Invalidated after that.
+func (t *Tracking) Subscribe(prefixes []string) (err error) {
+	if err = t.write(cmdClientIDBytes); err != nil {
+		return
+	}
+	if err = t.read(); err != nil {
+		return
+	}
+	if t.reply.respType != respInt {
+		err = errors.Wrapf(ErrTrackingBadReply, "client id:%s", t.reply.data)
+		return
+	}
+	id := t.reply.data
+	cmd := append(strconv.AppendInt([]byte("*"), int64(6+2*len(prefixes)), 10), crlfBytes...)
+	cmd = append(cmd, cmdClientTrackingBytes...)
+	cmd = appendBulk(cmd, id)
+	cmd = append(cmd, argBcastBytes...)
+	for _, prefix := range prefixes {
+		cmd = append(cmd, argPrefixBytes...)
+		cmd = appendBulk(cmd, []byte(prefix))
+	}
+	if err = t.write(cmd); err != nil {
+		return
+	}
+	if err = t.read(); err != nil {
+		return
+	}
+	if t.reply.respType != respString {
+		err = errors.Wrapf(ErrTrackingBadReply, "client tracking:%s", t.reply.data)
+		return
+	}
+	if err = t.write(cmdSubInvalidateBytes); err != nil {
+		return
+	}
+	if err = t.read(); err != nil {
+		return
+	}
+	if t.reply.respType != respArray || t.reply.arraySize != 3 {
+		err = errors.WithStack(ErrTrackingBadReply)
+	}
+	return
+}
+
+// Invalidated blocks until the next invalidation and returns the keys, which
+// are only valid until the next call. The flush means all the keys are
+// invalidated, eg: FLUSHALL.
+func (t *Tracking) Invalidated() (keys [][]byte, flush bool, err error) {
+	for {
+		if err = t.read(); err != nil {
+			return
+		}
+		r := t.reply
+		if r.respType != respArray || r.arraySize != 3 || !bytes.Equal(r.array[0].data, messageBytes) ||
+			string(bulkData(r.array[1])) != invalidateChannel {
+			continue
+		}
+		payload := r.array[2]
+		if payload.respType != respArray || payload.arraySize == 0 {
+			// NOTE: the null payload means flush
+			flush = true
+			return
+		}
+		for _, key := range payload.Array() {
+			if key.respType == respBulk && len(key.data) > 0 {
+				keys = append(keys, bulkData(key))
+			}
+		}
+		return
+	}
+}
+
+// Close close the tracking conn, it is safe to interrupt the blocking Invalidated.
+func (t *Tracking) Close() error {
+	if atomic.CompareAndSwapInt32(&t.state, opened, closed) && t.conn.Conn != nil {
+		// NOTE: close the socket directly, the closed flag of conn is not safe to be written concurrently.
+		return t.conn.Conn.Close()
+	}
+	return nil
+}
+
+func (t *Tracking) write(cmd []byte) (err error) {
+	if atomic.LoadInt32(&t.state) == closed {
+		return errors.WithStack(ErrTrackingClosed)
+	}
+	_ = t.bw.Write(cmd)
+	if err = t.bw.Flush(); err != nil {
+		err = errors.WithStack(err)
+	}
+	return
+}
+
+// read decode the next reply, the buffered data is decoded first because of
+// the pushed invalidations.
+func (t *Tracking) read() (err error) {
+	for {
+		if atomic.LoadInt32(&t.state) == closed {
+			return errors.WithStack(ErrTrackingClosed)
+		}
+		mark := t.br.Mark()
+		if err = t.reply.decode(t.br); err == nil {
+			return
+		} else if err != bufio.ErrBufferFull {
+			return errors.WithStack(err)
+		}
+		t.br.AdvanceTo(mark)
+		if err = t.br.Read(); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+}
+
+func appendBulk(cmd, data []byte) []byte {
+	cmd = append(cmd, respBulkBytes...)
+	cmd = append(strconv.AppendInt(cmd, int64(len(data)), 10), crlfBytes...)
+	return append(append(cmd, data...), crlfBytes...)
+}
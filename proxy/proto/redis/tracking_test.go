@@ -0,0 +1,48 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ducesoft/overlord/pkg/mockconn"
+	libnet "github.com/ducesoft/overlord/pkg/net"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func _tracking(data string) (*Tracking, *mockconn.MockConn) {
+	conn := libnet.NewConn(mockconn.CreateConn([]byte(data), 1), time.Second, time.Second)
+	return NewTracking(conn), conn.Conn.(*mockconn.MockConn)
+}
+
+func TestTrackingSubscribe(t *testing.T) {
+	tk, mconn := _tracking(":12\r\n+OK\r\n*3\r\n$9\r\nsubscribe\r\n$20\r\n__redis__:invalidate\r\n:1\r\n")
+	assert.NoError(t, tk.Subscribe([]string{"flag:", "conf"}))
+	assert.Equal(t, "*2\r\n$6\r\nCLIENT\r\n$2\r\nID\r\n"+
+		"*10\r\n$6\r\nCLIENT\r\n$8\r\nTRACKING\r\n$2\r\non\r\n$8\r\nREDIRECT\r\n$2\r\n12\r\n$5\r\nBCAST\r\n"+
+		"$6\r\nPREFIX\r\n$5\r\nflag:\r\n$6\r\nPREFIX\r\n$4\r\nconf\r\n"+
+		"*2\r\n$9\r\nSUBSCRIBE\r\n$20\r\n__redis__:invalidate\r\n", mconn.Wbuf.String())
+
+	tk, _ = _tracking(":12\r\n-ERR Prefix 'a' overlaps\r\n")
+	err := tk.Subscribe([]string{"a", "ab"})
+	assert.Equal(t, ErrTrackingBadReply, errors.Cause(err))
+}
+
+func TestTrackingInvalidated(t *testing.T) {
+	tk, _ := _tracking("*3\r\n$7\r\nmessage\r\n$5\r\nother\r\n$1\r\na\r\n" +
+		"*3\r\n$7\r\nmessage\r\n$20\r\n__redis__:invalidate\r\n*2\r\n$6\r\nflag:a\r\n$6\r\nflag:b\r\n" +
+		"*3\r\n$7\r\nmessage\r\n$20\r\n__redis__:invalidate\r\n$-1\r\n")
+	keys, flush, err := tk.Invalidated()
+	assert.NoError(t, err)
+	assert.False(t, flush)
+	assert.Equal(t, [][]byte{[]byte("flag:a"), []byte("flag:b")}, keys)
+	keys, flush, err = tk.Invalidated()
+	assert.NoError(t, err)
+	assert.True(t, flush)
+	assert.Nil(t, keys)
+
+	assert.NoError(t, tk.Close())
+	_, _, err = tk.Invalidated()
+	assert.Equal(t, ErrTrackingClosed, errors.Cause(err))
+}
@@ -0,0 +1,157 @@
+package redis
+
+import (
+	"bytes"
+
+	"github.com/ducesoft/overlord/proxy/proto"
+)
+
+var (
+	cmdMultiBytes   = []byte("5\r\nMULTI")
+	cmdExecBytes    = []byte("4\r\nEXEC")
+	cmdDiscardBytes = []byte("7\r\nDISCARD")
+	cmdUnwatchBytes = []byte("7\r\nUNWATCH")
+
+	multiBytes  = []byte("*1\r\n$5\r\nMULTI\r\n")
+	queuedBytes = []byte("QUEUED")
+	zeroBytes   = []byte("0")
+
+	errNestedMultiBytes    = []byte("ERR MULTI calls can not be nested")
+	errExecNoMultiBytes    = []byte("ERR EXEC without MULTI")
+	errDiscardNoMultiBytes = []byte("ERR DISCARD without MULTI")
+	errWatchInMultiBytes   = []byte("ERR WATCH inside MULTI is not allowed")
+	errWatchArgsBytes      = []byte("ERR wrong number of arguments for 'watch' command")
+	errExecAbortBytes      = []byte("EXECABORT Transaction discarded because of previous errors.")
+)
+
+// txnState is the MULTI/EXEC state of a client conn.
+//
+// Commands between MULTI and EXEC are queued by proxy and replied with QUEUED,
+// then the whole block is sent to the node owning all the keys over the conn
+// bound to client session. WATCH binds the session conn until EXEC, DISCARD
+// or UNWATCH, so the watched keys must live in the same node as the block.
+type txnState struct {
+	sess     *proto.Session
+	queued   *resp
+	multi    bool
+	aborted  bool
+	watching bool
+}
+
+func newTxnState() *txnState {
+	return &txnState{
+		sess:   &proto.Session{},
+		queued: &resp{},
+	}
+}
+
+// isTxn check whether the cmd must be handled by txn state.
+func (t *txnState) isTxn(cmd []byte) bool {
+	if t.multi {
+		return !bytes.Equal(cmd, cmdQuitBytes)
+	}
+	return bytes.Equal(cmd, cmdMultiBytes) || bytes.Equal(cmd, cmdExecBytes) ||
+		bytes.Equal(cmd, cmdDiscardBytes) || bytes.Equal(cmd, cmdWatchBytes) ||
+		bytes.Equal(cmd, cmdUnwatchBytes)
+}
+
+func (t *txnState) decode(r *Request) {
+	cmd := r.resp.array[0].data
+	switch {
+	case bytes.Equal(cmd, cmdMultiBytes):
+		if t.multi {
+			r.replyLocal(respError, errNestedMultiBytes)
+			return
+		}
+		t.multi = true
+		t.aborted = false
+		t.queued.reset()
+		r.replyLocal(respString, justOkBytes)
+	case bytes.Equal(cmd, cmdExecBytes):
+		if !t.multi {
+			r.replyLocal(respError, errExecNoMultiBytes)
+			return
+		}
+		t.multi = false
+		if t.aborted || t.queued.arraySize == 0 {
+			if t.aborted {
+				r.replyLocal(respError, errExecAbortBytes)
+			} else {
+				r.replyLocal(respArray, zeroBytes)
+			}
+			t.unwatch(r, proto.SessionReset)
+			return
+		}
+		r.txn.copy(t.queued)
+		t.queued.reset()
+		r.sess = t.sess
+		r.sessOp = proto.SessionRelease
+		t.watching = false
+	case bytes.Equal(cmd, cmdDiscardBytes):
+		if !t.multi {
+			r.replyLocal(respError, errDiscardNoMultiBytes)
+			return
+		}
+		t.multi = false
+		t.queued.reset()
+		r.replyLocal(respString, justOkBytes)
+		t.unwatch(r, proto.SessionReset)
+	case bytes.Equal(cmd, cmdWatchBytes):
+		if t.multi {
+			t.aborted = true
+			r.replyLocal(respError, errWatchInMultiBytes)
+			return
+		}
+		if r.resp.arraySize < 2 {
+			r.replyLocal(respError, errWatchArgsBytes)
+			return
+		}
+		r.sess = t.sess
+		r.sessOp = proto.SessionKeep
+		t.watching = true
+	case !t.multi && bytes.Equal(cmd, cmdUnwatchBytes):
+		if t.watching {
+			t.unwatch(r, proto.SessionRelease)
+			return
+		}
+		r.replyLocal(respString, justOkBytes)
+	default:
+		if !r.IsSupport() {
+			t.aborted = true
+			r.replyLocal(respError, notSupportDataBytes)
+			return
+		}
+		t.queued.next().copy(r.resp)
+		r.replyLocal(respString, queuedBytes)
+	}
+}
+
+// abort discard the queued commands at EXEC when in MULTI.
+func (t *txnState) abort() {
+	if t.multi {
+		t.aborted = true
+	}
+}
+
+// unwatch release the session conn bound by WATCH.
+func (t *txnState) unwatch(r *Request, op proto.SessionOp) {
+	if !t.watching {
+		return
+	}
+	t.watching = false
+	r.sess = t.sess
+	r.sessOp = op
+}
+
+// close release the session conn when client conn closed.
+func (t *txnState) close() error {
+	return t.sess.Close()
+}
+
+// replyLocal set the reply of request which is replied by proxy itself.
+func (r *Request) replyLocal(rtype respType, data []byte) {
+	r.local = true
+	r.reply.reset()
+	r.reply.respType = rtype
+	r.reply.data = append(r.reply.data, data...)
+}
@@ -0,0 +1,116 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ducesoft/overlord/pkg/mockconn"
+	libnet "github.com/ducesoft/overlord/pkg/net"
+	"github.com/ducesoft/overlord/proxy/proto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeTxnOk(t *testing.T) {
+	data := "*1\r\n$5\r\nMULTI\r\n*3\r\n$3\r\nSET\r\n$5\r\n{a}k1\r\n$1\r\n1\r\n*3\r\n$4\r\nMGET\r\n$5\r\n{a}k1\r\n$5\r\n{a}k2\r\n*1\r\n$4\r\nEXEC\r\n"
+	nmsgs := _decodeMessage(t, data)
+	assert.Len(t, nmsgs, 4)
+
+	req := nmsgs[0].Request().(*Request)
+	assert.True(t, req.IsCtl())
+	assert.Equal(t, respString, req.reply.respType)
+	assert.Equal(t, justOkBytes, req.reply.data)
+	sess, _ := req.Session()
+	assert.Nil(t, sess)
+
+	for _, msg := range nmsgs[1:3] {
+		assert.False(t, msg.IsBatch())
+		req = msg.Request().(*Request)
+		assert.True(t, req.IsCtl())
+		assert.Equal(t, queuedBytes, req.reply.data)
+	}
+
+	req = nmsgs[3].Request().(*Request)
+	assert.False(t, req.IsCtl())
+	assert.True(t, req.isTxn())
+	assert.Equal(t, 2, req.txn.arraySize)
+	sess, op := req.Session()
+	assert.NotNil(t, sess)
+	assert.Equal(t, proto.SessionRelease, op)
+	keys := req.Keys()
+	assert.Len(t, keys, 3)
+	assert.Equal(t, "{a}k1", string(keys[0]))
+	assert.Equal(t, "{a}k2", string(keys[2]))
+}
+
+func TestDecodeTxnErrors(t *testing.T) {
+	data := "EXEC\r\nDISCARD\r\nMULTI\r\nMULTI\r\nWATCH a\r\nKEYS *\r\nEXEC\r\n"
+	nmsgs := _decodeMessage(t, data)
+	assert.Len(t, nmsgs, 7)
+	expects := [][]byte{
+		errExecNoMultiBytes,
+		errDiscardNoMultiBytes,
+		justOkBytes,
+		errNestedMultiBytes,
+		errWatchInMultiBytes,
+		notSupportDataBytes,
+		errExecAbortBytes,
+	}
+	for i, msg := range nmsgs {
+		req := msg.Request().(*Request)
+		assert.True(t, req.local)
+		assert.Equal(t, expects[i], req.reply.data)
+	}
+}
+
+func TestDecodeTxnWatch(t *testing.T) {
+	data := "WATCH a b\r\nMULTI\r\nDISCARD\r\nWATCH a\r\nUNWATCH\r\nUNWATCH\r\n"
+	nmsgs := _decodeMessage(t, data)
+	assert.Len(t, nmsgs, 6)
+	ops := []struct {
+		sess bool
+		op   proto.SessionOp
+	}{
+		{true, proto.SessionKeep},
+		{false, proto.SessionKeep},
+		{true, proto.SessionReset},
+		{true, proto.SessionKeep},
+		{true, proto.SessionRelease},
+		{false, proto.SessionKeep},
+	}
+	for i, msg := range nmsgs {
+		sess, op := msg.Request().(*Request).Session()
+		assert.Equal(t, ops[i].sess, sess != nil, "index %d", i)
+		assert.Equal(t, ops[i].op, op, "index %d", i)
+	}
+	assert.Len(t, nmsgs[0].Request().(*Request).Keys(), 2)
+}
+
+func TestNodeConnTxnWriteRead(t *testing.T) {
+	reply := "+OK\r\n+QUEUED\r\n+QUEUED\r\n*2\r\n+OK\r\n$1\r\n1\r\n"
+	conn := libnet.NewConn(mockconn.CreateConn([]byte(reply), 1), time.Second, time.Second)
+	nc := newNodeConn("baka", "127.0.0.1:12345", conn)
+
+	req := getReq()
+	req.resp.copy(newArrayResp("EXEC"))
+	req.txn.next().copy(newArrayResp("SET", "a", "1"))
+	req.txn.next().copy(newArrayResp("GET", "a"))
+	msg := proto.NewMessage()
+	msg.WithRequest(req)
+
+	assert.NoError(t, nc.Write(msg))
+	assert.NoError(t, nc.Flush())
+	mconn := conn.Conn.(*mockconn.MockConn)
+	assert.Equal(t, "*1\r\n$5\r\nMULTI\r\n*3\r\n$3\r\nSET\r\n$1\r\na\r\n$1\r\n1\r\n*2\r\n$3\r\nGET\r\n$1\r\na\r\n*1\r\n$4\r\nEXEC\r\n", mconn.Wbuf.String())
+
+	assert.NoError(t, nc.Read(msg))
+	assert.Equal(t, respArray, req.reply.respType)
+	assert.Equal(t, 2, req.reply.arraySize)
+	assert.Equal(t, []byte("1\r\n1"), req.reply.array[1].data)
+}
+
+func newArrayResp(args ...string) *resp {
+	r := newRequest(args[0], args[1:]...).resp
+	r.respType = respArray
+	return r
+}
@@ -0,0 +1,113 @@
+package proto
+
+// SessionOp is the operation of a request on its session.
+type SessionOp = uint8
+
+// session operations
+const (
+	// SessionKeep run on the session conn and keep it bound, eg: WATCH.
+	SessionKeep SessionOp = iota
+	// SessionRelease run on the session conn then release it, eg: EXEC.
+	SessionRelease
+	// SessionReset release the session conn without any backend I/O, eg: DISCARD.
+	SessionReset
+)
+
+// Session is the backend conn bound to a single client, used by the commands
+// which depend on conn state like WATCH and MULTI/EXEC.
+type Session struct {
+	addr string
+	nc   NodeConn
+}
+
+// Addr returns the addr of bound node, empty if not bound.
+func (s *Session) Addr() string {
+	return s.addr
+}
+
+// Bound check whether the session is bound to a node conn.
+func (s *Session) Bound() bool {
+	return s.nc != nil
+}
+
+// Bind bind the session to node conn.
+func (s *Session) Bind(addr string, nc NodeConn) {
+	s.addr = addr
+	s.nc = nc
+}
+
+// Do write message into the bound conn and read the reply.
+func (s *Session) Do(m *Message) (err error) {
+	m.MarkWrite()
+	if err = s.nc.Write(m); err != nil {
+		return
+	}
+	if err = s.nc.Flush(); err != nil {
+		return
+	}
+	err = s.nc.Read(m)
+	m.MarkRead()
+	m.MarkAddr(s.addr)
+	return
+}
+
+// Close release the bound conn.
+func (s *Session) Close() (err error) {
+	if s.nc != nil {
+		err = s.nc.Close()
+	}
+	s.addr = ""
+	s.nc = nil
+	return
+}
+
+// ForwardSession run the session messages in order on their sessions.
+// route returns the node addr of message and dial creates the conn to addr.
+func ForwardSession(msgs []*Message, route func(*Message) (string, error), dial func(string) NodeConn) {
+	if len(msgs) == 0 {
+		return
+	}
+	for _, m := range msgs {
+		m.Add()
+	}
+	go func() {
+		for _, m := range msgs {
+			forwardSession(m, route, dial)
+			m.Done()
+		}
+	}()
+}
+
+func forwardSession(m *Message, route func(*Message) (string, error), dial func(string) NodeConn) {
+	sess, op := m.Request().(SessionRequest).Session()
+	if op == SessionReset {
+		_ = sess.Close()
+		return
+	}
+	addr, err := route(m)
+	if err != nil {
+		m.WithError(err)
+		return
+	}
+	if !sess.Bound() {
+		sess.Bind(addr, dial(addr))
+	} else if sess.Addr() != addr {
+		m.WithError(ErrCrossSlot)
+		return
+	}
+	m.MarkStartPipe()
+	if err = sess.Do(m); err != nil || op == SessionRelease {
+		_ = sess.Close()
+	}
+	m.WithError(err)
+}
+
+// IsSession check whether the message must be executed on session.
+func IsSession(m *Message) bool {
+	sr, ok := m.Request().(SessionRequest)
+	if !ok {
+		return false
+	}
+	sess, _ := sr.Session()
+	return sess != nil
+}
@@ -0,0 +1,79 @@
+package proto
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type mockSessionRequest struct {
+	mockRequest
+	sess *Session
+	op   SessionOp
+}
+
+func (r *mockSessionRequest) Session() (*Session, SessionOp) {
+	return r.sess, r.op
+}
+
+func newSessionMsg(wg *sync.WaitGroup, sess *Session, op SessionOp) *Message {
+	msg := NewMessage()
+	msg.WithRequest(&mockSessionRequest{sess: sess, op: op})
+	msg.WithWaitGroup(wg)
+	return msg
+}
+
+func TestForwardSession(t *testing.T) {
+	var (
+		wg     = &sync.WaitGroup{}
+		sess   = &Session{}
+		dialed []*mockNodeConn
+		addr   = "node1"
+	)
+	route := func(*Message) (string, error) {
+		return addr, nil
+	}
+	dial := func(string) NodeConn {
+		nc := &mockNodeConn{num: 10}
+		dialed = append(dialed, nc)
+		return nc
+	}
+
+	msgs := []*Message{
+		newSessionMsg(wg, sess, SessionKeep),
+		newSessionMsg(wg, sess, SessionKeep),
+	}
+	assert.True(t, IsSession(msgs[0]))
+	ForwardSession(msgs, route, dial)
+	wg.Wait()
+	assert.Len(t, dialed, 1)
+	assert.True(t, sess.Bound())
+	assert.Equal(t, "node1", sess.Addr())
+
+	// keys in other node must be rejected while bound
+	addr = "node2"
+	msgs = []*Message{
+		newSessionMsg(wg, sess, SessionRelease),
+		newSessionMsg(wg, sess, SessionReset),
+	}
+	ForwardSession(msgs, route, dial)
+	wg.Wait()
+	assert.Equal(t, ErrCrossSlot, msgs[0].Err())
+	assert.True(t, IsReplyError(msgs[0].Err()))
+	assert.False(t, sess.Bound())
+	assert.True(t, dialed[0].closed)
+
+	// release after run
+	msgs = []*Message{newSessionMsg(wg, sess, SessionRelease)}
+	ForwardSession(msgs, route, dial)
+	wg.Wait()
+	assert.NoError(t, msgs[0].Err())
+	assert.Len(t, dialed, 2)
+	assert.True(t, dialed[1].closed)
+	assert.False(t, sess.Bound())
+
+	msg := NewMessage()
+	msg.WithRequest(&mockRequest{})
+	assert.False(t, IsSession(msg))
+}
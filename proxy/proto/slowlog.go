@@ -28,6 +28,7 @@ func NewSlowlogEntry(ctype types.CacheType) *SlowlogEntry {
 
 // SlowlogEntry is each slowlog item
 type SlowlogEntry struct {
+	ID        int64  `json:"id"`
 	Cluster   string `json:"cluster,omitempty"`
 	CacheType types.CacheType
 	Cmd       []string
@@ -43,6 +44,14 @@ type SlowlogEntry struct {
 	Subs         []*SlowlogEntry `json:"Subs,omitempty"`
 }
 
+// SlowlogStore is the slowlog of cluster which is queried by client, eg: redis SLOWLOG.
+type SlowlogStore interface {
+	// Get returns the latest n entries and the newest is first, n < 0 means all.
+	Get(n int) []*SlowlogEntry
+	Len() int
+	Reset()
+}
+
 // collapseSymbol is the fill in strings.
 var collapseSymbol = []byte("...")
 
@@ -2,13 +2,40 @@ package proto
 
 import (
 	"errors"
+
+	pkgerrs "github.com/pkg/errors"
 )
 
 // defined common errors
 var (
-	ErrQuit = errors.New("close client conn")
+	ErrQuit      = errors.New("close client conn")
+	ErrCrossSlot = NewReplyError("CROSSSLOT Keys in request don't hash to the same slot")
+	// ErrMergeNotSupported is returned by Merge of the request which can't be
+	// merged, the requests of the same node are forwarded one by one.
+	ErrMergeNotSupported = errors.New("merge not supported")
 )
 
+// ReplyError is the error which only fails the message itself, proxy conn
+// encodes it back as an error reply and keeps the client conn alive.
+type ReplyError struct {
+	s string
+}
+
+// NewReplyError new a ReplyError with text.
+func NewReplyError(text string) error {
+	return &ReplyError{s: text}
+}
+
+func (e *ReplyError) Error() string {
+	return e.s
+}
+
+// IsReplyError check whether the cause of err is a ReplyError.
+func IsReplyError(err error) bool {
+	_, ok := pkgerrs.Cause(err).(*ReplyError)
+	return ok
+}
+
 // Slowlogger is the type which can convert self into slowlog entry
 type Slowlogger interface {
 	Slowlog() *SlowlogEntry
@@ -25,6 +52,83 @@ type Request interface {
 	Slowlogger
 }
 
+// MultiKeyer is the type of request which touches more than one key,
+// all the keys must be routed to the same node.
+type MultiKeyer interface {
+	Keys() [][]byte
+}
+
+// ReadOnlyer is the type of request which only reads data and can be
+// served by replicas, eg: redis GET.
+type ReadOnlyer interface {
+	IsReadOnly() bool
+}
+
+// NearCacheOp is the operation of request on the near cache of proxy.
+type NearCacheOp int
+
+// near cache operations
+const (
+	NearCacheNone NearCacheOp = iota
+	// NearCacheRead reads the value of single key which can be cached, eg: redis GET.
+	NearCacheRead
+	// NearCacheInvalidate writes the keys which must be invalidated, eg: redis SET.
+	NearCacheInvalidate
+)
+
+// NearCacher is the type of request which can be served by the near cache of proxy.
+type NearCacher interface {
+	NearCacheOp() NearCacheOp
+	// NearCacheReply returns the reply of read which can be cached, nil if
+	// not cacheable, eg: error or not found.
+	NearCacheReply() []byte
+	// ReplyNearCache fill the reply by the cached data, the request is replied
+	// by proxy itself and never forwarded.
+	ReplyNearCache(data []byte)
+}
+
+// ReadPolicy is the policy to route the read only requests between master and replicas.
+type ReadPolicy string
+
+// read policies
+const (
+	// ReadPolicyMaster sends all the requests to master.
+	ReadPolicyMaster ReadPolicy = "master"
+	// ReadPolicyPreferReplica sends the read only requests to the healthy replicas,
+	// and to master if no replica is healthy.
+	ReadPolicyPreferReplica ReadPolicy = "prefer_replica"
+	// ReadPolicyRoundRobin sends the read only requests to master and the healthy replicas in turn.
+	ReadPolicyRoundRobin ReadPolicy = "round_robin"
+)
+
+// ReadReplicas check whether the read only requests can be sent to replicas.
+func (p ReadPolicy) ReadReplicas() bool {
+	return p == ReadPolicyPreferReplica || p == ReadPolicyRoundRobin
+}
+
+// Pick returns the index of n healthy replicas to serve the read only request
+// in the turn, -1 means master.
+func (p ReadPolicy) Pick(turn uint32, n int) int {
+	if n <= 0 || !p.ReadReplicas() {
+		return -1
+	}
+	if p == ReadPolicyRoundRobin {
+		// NOTE: the last turn is master
+		if i := int(turn % uint32(n+1)); i < n {
+			return i
+		}
+		return -1
+	}
+	return int(turn % uint32(n))
+}
+
+// SessionRequest is the type of request which must be executed on the
+// backend conn bound to the client instead of the shared node pipe.
+type SessionRequest interface {
+	// Session returns nil if request use the shared node pipe.
+	Session() (*Session, SessionOp)
+}
+
 // ProxyConn decode bytes from client and encode write to conn.
 type ProxyConn interface {
 	Decode([]*Message) ([]*Message, error)
@@ -32,6 +136,13 @@ type ProxyConn interface {
 	Flush() error
 }
 
+// Followuper is the ProxyConn which has to forward followup messages after
+// the replies of msgs, eg: the STORE of redis set algebra computed by proxy.
+// The followup messages are forwarded before msgs are encoded.
+type Followuper interface {
+	Followup(msgs []*Message) []*Message
+}
+
 // NodeConn handle Msg to backend cache server and read response.
 type NodeConn interface {
 	Write(*Message) error
@@ -12,6 +12,10 @@ import (
 	"github.com/ducesoft/overlord/pkg/log"
 	libnet "github.com/ducesoft/overlord/pkg/net"
 	"github.com/ducesoft/overlord/pkg/types"
+	"github.com/ducesoft/overlord/proxy/capture"
+	"github.com/ducesoft/overlord/proxy/hotkey"
+	"github.com/ducesoft/overlord/proxy/mirror"
+	"github.com/ducesoft/overlord/proxy/nearcache"
 	"github.com/ducesoft/overlord/proxy/proto"
 	"github.com/ducesoft/overlord/proxy/proto/memcache"
 	mcbin "github.com/ducesoft/overlord/proxy/proto/memcache/binary"
@@ -27,6 +31,9 @@ var (
 	ErrProxyMoreMaxConns = errs.New("Proxy accept more than max connextions")
 	ErrProxyReloadIgnore = errs.New("Proxy reload cluster config is ignored")
 	ErrProxyReloadFail   = errs.New("Proxy reload cluster config is failed")
+	ErrProxyReloadNoFile = errs.New("Proxy cluster config file is not specified")
+	ErrProxyNotMigrating = errs.New("Proxy cluster is not migrating")
+	ErrProxyNoCapture    = errs.New("Proxy cluster does not support capture")
 )
 
 // Proxy is proxy.
@@ -36,9 +43,19 @@ type Proxy struct {
 	ccs []*ClusterConfig
 
 	forwarders map[string]proto.Forwarder
+	trackers   []*tracker
+	mirrors    []*mirror.Mirror
+	// shadows is the forwarders of mirror_servers owned by mirrors.
+	shadows    []proto.Forwarder
 	lock       sync.Mutex
+	reloadLock sync.Mutex
 
 	conns int32
+	// stats reported by INFO
+	start         time.Time
+	totalConns    int64
+	rejectedConns int64
+	commands      int64
 
 	closed bool
 }
@@ -51,6 +68,7 @@ func New(c *Config) (p *Proxy, err error) {
 	}
 	p = &Proxy{}
 	p.c = c
+	p.start = time.Now()
 	return
 }
 
@@ -63,6 +81,11 @@ func (p *Proxy) Serve(ccs []*ClusterConfig) {
 	p.lock.Lock()
 	p.forwarders = map[string]proto.Forwarder{}
 	p.lock.Unlock()
+	// NOTE: the forwarders are created before serving because the cluster may
+	// be mirrored to the cluster after it.
+	for _, cc := range ccs {
+		p.forwarders[cc.Name] = NewForwarder(cc)
+	}
 	for _, cc := range ccs {
 		log.Infof("start to serve cluster[%s] with configs %v", cc.Name, *cc)
 		p.serve(cc)
@@ -70,8 +93,42 @@ func (p *Proxy) Serve(ccs []*ClusterConfig) {
 }
 
 func (p *Proxy) serve(cc *ClusterConfig) {
-	forwarder := NewForwarder(cc)
-	p.forwarders[cc.Name] = forwarder
+	forwarder := p.forwarders[cc.Name]
+	if cc.HotKeyTopK > 0 {
+		store := hotkey.New(cc.Name, cc.HotKeyTopK, cc.HotKeySample, cc.HotKeyThreshold, time.Duration(cc.HotKeyWindow)*time.Second)
+		if router, ok := forwarder.(proto.KeyRouter); ok {
+			store.WithRouter(router)
+		}
+		hotkey.Register(store)
+	}
+	if cc.NearCacheMaxBytes > 0 {
+		cache := nearcache.New(cc.Name, cc.NearCacheMaxBytes, time.Duration(cc.NearCacheTTL)*time.Millisecond, cc.NearCacheKeys)
+		if ns, ok := forwarder.(proto.NodeStater); ok && cc.NearCacheTracking {
+			p.trackers = append(p.trackers, newTracker(cc, cache, ns))
+		}
+		nearcache.Register(cache)
+	}
+	if cc.Mirror != "" || len(cc.MirrorServers) > 0 {
+		shadow, ok := p.forwarders[cc.Mirror]
+		if len(cc.MirrorServers) > 0 {
+			scc := *cc
+			scc.Name = cc.Name + "-mirror"
+			scc.Servers = cc.MirrorServers
+			shadow, ok = NewForwarder(&scc), true
+			p.shadows = append(p.shadows, shadow)
+		}
+		if !ok {
+			panic(errors.Wrapf(ErrClusterConfInvalid, "mirror:%s", cc.Mirror))
+		}
+		m := mirror.New(cc.Name, shadow, cc.MirrorRate, cc.MirrorQueue, cc.MirrorCompare)
+		p.mirrors = append(p.mirrors, m)
+		mirror.Register(m)
+	}
+	if ns, ok := forwarder.(proto.NodeStater); ok {
+		registerNodeStater(cc.Name, ns)
+	}
+	cc.batch = registerBatchStats(cc.Name)
+	capture.Register(capture.New(cc.Name, string(cc.CacheType)))
 	// listen
 	l, err := Listen(cc.ListenProto, cc.ListenAddr)
 	if err != nil {
@@ -117,6 +174,7 @@ func (p *Proxy) accept(cc *ClusterConfig, l net.Listener, forwarder proto.Forwar
 					_ = encoder.Flush()
 				}
 				_ = conn.Close()
+				atomic.AddInt64(&p.rejectedConns, 1)
 				if log.V(4) {
 					log.Warnf("proxy reject connection count(%d) due to more than max(%d)", conns, p.c.Proxy.MaxConnections)
 				}
@@ -124,6 +182,7 @@ func (p *Proxy) accept(cc *ClusterConfig, l net.Listener, forwarder proto.Forwar
 			}
 		}
 		atomic.AddInt32(&p.conns, 1)
+		atomic.AddInt64(&p.totalConns, 1)
 		NewHandler(p, cc, conn, forwarder).Handle()
 	}
 }
@@ -136,6 +195,15 @@ func (p *Proxy) Close() error {
 	for _, forwarder := range p.forwarders {
 		forwarder.Close()
 	}
+	for _, t := range p.trackers {
+		t.close()
+	}
+	for _, m := range p.mirrors {
+		m.Close()
+	}
+	for _, shadow := range p.shadows {
+		shadow.Close()
+	}
 	p.closed = true
 	return nil
 }
@@ -169,19 +237,10 @@ func (p *Proxy) MonitorConfChange(ccf string) {
 		case ev := <-watch.Events:
 			if ev.Op&fsnotify.Create == fsnotify.Create || ev.Op&fsnotify.Write == fsnotify.Write || ev.Op&fsnotify.Rename == fsnotify.Rename {
 				time.Sleep(time.Second)
-				newConfs, err := LoadClusterConfWithPath(p.ccf)
-				if err != nil {
-					log.Errorf("failed to load conf file:%s and got error:%v", p.ccf, err)
+				if err := p.Reload(); err != nil {
+					log.Errorf("failed to reload conf file:%s and got error:%v", p.ccf, err)
 					continue
 				}
-				changed := ParseChanged(newConfs, p.ccs)
-				for _, conf := range changed {
-					if err = p.UpdateConfig(conf); err == nil {
-						log.Infof("reload successful cluster:%s config succeed", conf.Name)
-					} else {
-						log.Errorf("reload failed cluster:%s config and get error:%v", conf.Name, err)
-					}
-				}
 				log.Infof("watcher file:%s occurs event:%s and reload finish", ev.Name, ev.String())
 				continue
 			}
@@ -195,6 +254,38 @@ func (p *Proxy) MonitorConfChange(ccf string) {
 	}
 }
 
+// SetClusterConfFile set the cluster config file which is used by Reload.
+func (p *Proxy) SetClusterConfFile(ccf string) {
+	p.ccf = ccf
+}
+
+// Reload reloads the cluster config file and updates the servers of changed clusters.
+func (p *Proxy) Reload() (err error) {
+	if p.ccf == "" {
+		err = errors.WithStack(ErrProxyReloadNoFile)
+		return
+	}
+	p.reloadLock.Lock()
+	defer p.reloadLock.Unlock()
+	newConfs, err := LoadClusterConfWithPath(p.ccf)
+	if err != nil {
+		err = errors.Wrapf(err, "conf file:%s", p.ccf)
+		return
+	}
+	changed := ParseChanged(newConfs, p.ccs)
+	for _, conf := range changed {
+		if uerr := p.UpdateConfig(conf); uerr == nil {
+			log.Infof("reload successful cluster:%s config succeed", conf.Name)
+		} else {
+			log.Errorf("reload failed cluster:%s config and get error:%v", conf.Name, uerr)
+			if err == nil {
+				err = uerr
+			}
+		}
+	}
+	return
+}
+
 func (p *Proxy) UpdateConfig(conf *ClusterConfig) (err error) {
 	p.lock.Lock()
 	defer p.lock.Unlock()
@@ -203,21 +294,64 @@ func (p *Proxy) UpdateConfig(conf *ClusterConfig) (err error) {
 		err = errors.Wrapf(ErrProxyReloadIgnore, "cluster:%s", conf.Name)
 		return
 	}
-	if err = f.Update(conf.Servers); err != nil {
-		err = errors.Wrapf(ErrProxyReloadFail, "cluster:%s error:%v", conf.Name, err)
-		return
+	var oldConf *ClusterConfig
+	for _, cc := range p.ccs {
+		if cc.Name == conf.Name {
+			oldConf = cc
+		}
 	}
-	for _, oldConf := range p.ccs {
-		if oldConf.Name != conf.Name {
-			continue
+	if oldConf == nil || !deepEqualOrderedStringSlice(conf.Servers, oldConf.Servers) {
+		if err = f.Update(conf.Servers); err != nil {
+			err = errors.Wrapf(ErrProxyReloadFail, "cluster:%s error:%v", conf.Name, err)
+			return
+		}
+	}
+	if mg, ok := f.(migrator); ok && (oldConf == nil || migrateChanged(conf, oldConf)) {
+		if err = mg.Migrate(conf); err != nil {
+			err = errors.Wrapf(ErrProxyReloadFail, "cluster:%s error:%v", conf.Name, err)
+			return
 		}
-		oldConf.Servers = make([]string, len(conf.Servers), cap(conf.Servers))
-		copy(oldConf.Servers, conf.Servers)
+	}
+	if oldConf == nil {
 		return
 	}
+	oldConf.Servers = make([]string, len(conf.Servers), cap(conf.Servers))
+	copy(oldConf.Servers, conf.Servers)
+	oldConf.MigrateFrom = conf.MigrateFrom
+	oldConf.MigrateWrite = conf.MigrateWrite
+	oldConf.MigrateReadRepair = conf.MigrateReadRepair
+	oldConf.MigrateRepairTTL = conf.MigrateRepairTTL
 	return
 }
 
+// migrateChanged check whether the migration config of cluster is changed.
+func migrateChanged(newConf, oldConf *ClusterConfig) bool {
+	return !deepEqualOrderedStringSlice(newConf.MigrateFrom, oldConf.MigrateFrom) ||
+		newConf.MigrateWrite != oldConf.MigrateWrite || newConf.MigrateReadRepair != oldConf.MigrateReadRepair ||
+		newConf.MigrateRepairTTL != oldConf.MigrateRepairTTL
+}
+
+// FinishMigration finishes the migration of cluster, the old servers are not
+// used anymore.
+func (p *Proxy) FinishMigration(name string) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	mg, ok := p.forwarders[name].(migrator)
+	if !ok || !mg.Migrating() {
+		return errors.Wrapf(ErrProxyNotMigrating, "cluster:%s", name)
+	}
+	if err := mg.Migrate(&ClusterConfig{}); err != nil {
+		return err
+	}
+	for _, cc := range p.ccs {
+		if cc.Name == name {
+			cc.MigrateFrom = nil
+		}
+	}
+	log.Infof("cluster:%s finished the migration", name)
+	return nil
+}
+
 func ParseChanged(newConfs, oldConfs []*ClusterConfig) (changed []*ClusterConfig) {
 
 	changed = make([]*ClusterConfig, 0, len(oldConfs))
@@ -235,7 +369,7 @@ func ParseChanged(newConfs, oldConfs []*ClusterConfig) (changed []*ClusterConfig
 				continue
 			}
 
-			if !deepEqualOrderedStringSlice(newConf.Servers, oldConf.Servers) {
+			if !deepEqualOrderedStringSlice(newConf.Servers, oldConf.Servers) || migrateChanged(newConf, oldConf) {
 				changed = append(changed, newConf)
 			}
 			break
@@ -0,0 +1,212 @@
+package proxy
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ducesoft/overlord/pkg/log"
+	libnet "github.com/ducesoft/overlord/pkg/net"
+	"github.com/ducesoft/overlord/proxy/proto/redis"
+)
+
+const (
+	sentinelTimeout = time.Second
+)
+
+// sentinelRetryInterval for unit test override!!!
+var sentinelRetryInterval = time.Second
+
+// sentinel watches the master of shard by redis sentinels, the shard is
+// switched to the new master by the same path of reload.
+type sentinel struct {
+	f      *defaultForwarder
+	name   string
+	addrs  []string
+	master atomic.Value
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	// conn is the subscribed conn which is closed to stop watching.
+	conn *redis.Sentinel
+	lock sync.Mutex
+}
+
+func sentinelKey(opt *serverOpts) string {
+	return opt.master + "@" + strings.Join(opt.sentinels, ",")
+}
+
+// newSentinel new the sentinel of master which is resolved already, empty
+// master means the addr of servers is used until resolved by watch.
+func newSentinel(f *defaultForwarder, name string, addrs []string, master string) *sentinel {
+	s := &sentinel{f: f, name: name, addrs: addrs}
+	s.master.Store(master)
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	go s.watch()
+	return s
+}
+
+// getMaster returns the addr of master, empty if never resolved.
+func (s *sentinel) getMaster() string {
+	return s.master.Load().(string)
+}
+
+// resolve the master by SENTINEL get-master-addr-by-name of sentinels in order.
+func (s *sentinel) resolve() (addr string, err error) {
+	return resolveMaster(s.f.cc.Name, s.name, s.addrs)
+}
+
+func resolveMaster(cluster, name string, addrs []string) (addr string, err error) {
+	for _, saddr := range addrs {
+		conn := redis.NewSentinel(libnet.DialWithTimeout(saddr, sentinelTimeout, sentinelTimeout, sentinelTimeout))
+		addr, err = conn.MasterAddr(name)
+		_ = conn.Close()
+		if err == nil {
+			return
+		}
+		if log.V(3) {
+			log.Warnf("cluster:%s sentinel:%s get master:%s error:%v", cluster, saddr, name, err)
+		}
+	}
+	return
+}
+
+// watch subscribe +switch-master of sentinels in turn until closed.
+func (s *sentinel) watch() {
+	for i := 0; ; i++ {
+		saddr := s.addrs[i%len(s.addrs)]
+		nc := libnet.DialWithTimeout(saddr, sentinelTimeout, sentinelTimeout, sentinelTimeout)
+		conn := redis.NewSentinel(nc)
+		if !s.setConn(conn) {
+			_ = conn.Close()
+			return
+		}
+		err := conn.Subscribe()
+		if err == nil {
+			// NOTE: the switch may be missed before subscribed.
+			if addr, rerr := s.resolve(); rerr == nil {
+				s.switchTo(addr)
+			}
+			nc.SetReadTimeout(0)
+			for {
+				var name, addr string
+				if name, addr, err = conn.SwitchMaster(); err != nil {
+					break
+				}
+				if name == s.name {
+					s.switchTo(addr)
+				}
+			}
+		}
+		_ = conn.Close()
+		select {
+		case <-s.ctx.Done():
+			return
+		default:
+		}
+		if log.V(3) {
+			log.Warnf("cluster:%s sentinel:%s subscribe master:%s error:%v", s.f.cc.Name, saddr, s.name, err)
+		}
+		time.Sleep(sentinelRetryInterval)
+	}
+}
+
+func (s *sentinel) setConn(conn *redis.Sentinel) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	select {
+	case <-s.ctx.Done():
+		return false
+	default:
+	}
+	s.conn = conn
+	return true
+}
+
+func (s *sentinel) switchTo(addr string) {
+	if old := s.getMaster(); old == addr {
+		return
+	} else if log.V(2) {
+		log.Warnf("cluster:%s sentinel master:%s switch from %s to %s", s.f.cc.Name, s.name, old, addr)
+	}
+	s.master.Store(addr)
+	if err := s.f.switchMaster(); err != nil && log.V(2) {
+		log.Errorf("cluster:%s sentinel master:%s switch to %s error:%v", s.f.cc.Name, s.name, addr, err)
+	}
+}
+
+func (s *sentinel) close() {
+	s.cancel()
+	s.lock.Lock()
+	if s.conn != nil {
+		_ = s.conn.Close()
+	}
+	s.lock.Unlock()
+}
+
+// resolveMasters resolves the masters of sentinels which are not watched yet,
+// the sentinels are dialed without holding the lock of forwarder.
+func (f *defaultForwarder) resolveMasters(opts []*serverOpts) map[string]string {
+	f.lock.Lock()
+	watched := make(map[string]bool, len(f.sentinels))
+	for key := range f.sentinels {
+		watched[key] = true
+	}
+	f.lock.Unlock()
+	masters := make(map[string]string)
+	for _, opt := range opts {
+		if opt == nil || opt.master == "" {
+			continue
+		}
+		key := sentinelKey(opt)
+		if _, ok := masters[key]; ok || watched[key] {
+			continue
+		}
+		addr, err := resolveMaster(f.cc.Name, opt.master, opt.sentinels)
+		if err != nil && log.V(2) {
+			log.Errorf("cluster:%s sentinel master:%s resolve error:%v and use the addr of servers", f.cc.Name, opt.master, err)
+		}
+		masters[key] = addr
+	}
+	return masters
+}
+
+// watchMasters keep the sentinels of shards and replace the addr of shard by
+// the master resolved, masters is resolved by resolveMasters for the new sentinels.
+func (f *defaultForwarder) watchMasters(addrs []string, opts []*serverOpts, masters map[string]string) {
+	sentinels := make(map[string]*sentinel)
+	for idx, opt := range opts {
+		if opt == nil || opt.master == "" {
+			continue
+		}
+		key := sentinelKey(opt)
+		s, ok := sentinels[key]
+		if !ok {
+			if s, ok = f.sentinels[key]; !ok {
+				s = newSentinel(f, opt.master, opt.sentinels, masters[key])
+			}
+			sentinels[key] = s
+		}
+		if master := s.getMaster(); master != "" {
+			addrs[idx] = master
+		}
+	}
+	for key, s := range f.sentinels {
+		if _, ok := sentinels[key]; !ok {
+			s.close()
+		}
+	}
+	f.sentinels = sentinels
+}
+
+// switchMaster swap the pipes of shards to the current masters of sentinels.
+func (f *defaultForwarder) switchMaster() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if atomic.LoadInt32(&f.state) == forwarderStateClosed {
+		return ErrForwarderClosed
+	}
+	return f.update(f.servers, nil)
+}
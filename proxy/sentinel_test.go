@@ -0,0 +1,131 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ducesoft/overlord/pkg/types"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// _fakeSentinel speaks RESP on a local port, it replies the master and
+// publishes +switch-master to the subscribers.
+type _fakeSentinel struct {
+	ln     net.Listener
+	lock   sync.Mutex
+	master string
+	subs   []net.Conn
+}
+
+func _newFakeSentinel(t *testing.T, master string) *_fakeSentinel {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	s := &_fakeSentinel{ln: ln, master: master}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *_fakeSentinel) serve(conn net.Conn) {
+	br := bufio.NewReader(conn)
+	for {
+		args, err := _readArgs(br)
+		if err != nil {
+			_ = conn.Close()
+			return
+		}
+		s.lock.Lock()
+		switch strings.ToUpper(args[0]) {
+		case "SENTINEL":
+			host, port, _ := net.SplitHostPort(s.master)
+			fmt.Fprintf(conn, "*2\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(host), host, len(port), port)
+		case "SUBSCRIBE":
+			fmt.Fprintf(conn, "*3\r\n$9\r\nsubscribe\r\n$%d\r\n%s\r\n:1\r\n", len(args[1]), args[1])
+			s.subs = append(s.subs, conn)
+		default:
+			fmt.Fprintf(conn, "-ERR unknown command\r\n")
+		}
+		s.lock.Unlock()
+	}
+}
+
+func (s *_fakeSentinel) switchMaster(name, addr string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	oldHost, oldPort, _ := net.SplitHostPort(s.master)
+	host, port, _ := net.SplitHostPort(addr)
+	s.master = addr
+	msg := strings.Join([]string{name, oldHost, oldPort, host, port}, " ")
+	for _, conn := range s.subs {
+		fmt.Fprintf(conn, "*3\r\n$7\r\nmessage\r\n$14\r\n+switch-master\r\n$%d\r\n%s\r\n", len(msg), msg)
+	}
+}
+
+func _readArgs(br *bufio.Reader) (args []string, err error) {
+	line, err := br.ReadString('\n')
+	if err != nil {
+		return
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	for i := 0; i < n; i++ {
+		if _, err = br.ReadString('\n'); err != nil {
+			return
+		}
+		if line, err = br.ReadString('\n'); err != nil {
+			return
+		}
+		args = append(args, strings.TrimSpace(line))
+	}
+	return
+}
+
+func _shardAddr(f *defaultForwarder) string {
+	return f.conns.Load().(*connections).addrs[0]
+}
+
+func TestForwarderSentinel(t *testing.T) {
+	sentinelRetryInterval = 10 * time.Millisecond
+	fs := _newFakeSentinel(t, "127.0.0.1:16379")
+	defer fs.ln.Close()
+
+	cc := &ClusterConfig{Name: "sentinel", CacheType: types.CacheTypeRedis, HashMethod: "fnv1a_64", HashDistribution: "ketama",
+		Servers: []string{"127.0.0.1:6379:1 shard1 sentinel=mymaster@" + fs.ln.Addr().String()}}
+	cc.SetDefault()
+	assert.NoError(t, cc.Validate())
+	f := newDefaultForwarder(cc).(*defaultForwarder)
+	defer f.Close()
+	assert.Equal(t, "127.0.0.1:16379", _shardAddr(f))
+
+	// NOTE: wait the watcher subscribed.
+	assert.Eventually(t, func() bool {
+		fs.lock.Lock()
+		defer fs.lock.Unlock()
+		return len(fs.subs) > 0
+	}, time.Second, 10*time.Millisecond)
+	fs.switchMaster("other", "127.0.0.1:16381")
+	fs.switchMaster("mymaster", "127.0.0.1:16380")
+	assert.Eventually(t, func() bool { return _shardAddr(f) == "127.0.0.1:16380" }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "shard1", f.NodeStates()[0].Alias)
+
+	// NOTE: the reload keeps the master resolved by sentinel.
+	assert.NoError(t, f.Update(cc.Servers))
+	assert.Equal(t, "127.0.0.1:16380", _shardAddr(f))
+	assert.Len(t, f.sentinels, 1)
+	assert.NoError(t, f.Update([]string{"127.0.0.1:6379:1 shard1"}))
+	assert.Equal(t, "127.0.0.1:6379", _shardAddr(f))
+	assert.Len(t, f.sentinels, 0)
+}
@@ -22,7 +22,9 @@ func newStore(name string) *Store {
 type Store struct {
 	name   string
 	cursor uint32
-	msgs   []atomic.Value
+	// base is the cursor when reset, entries before it are dropped.
+	base uint32
+	msgs []atomic.Value
 }
 
 // Record impl the Handler
@@ -30,31 +32,21 @@ func (s *Store) Record(msg *proto.SlowlogEntry) {
 	if msg == nil {
 		return
 	}
-
-	for {
-		if atomic.CompareAndSwapUint32(&s.cursor, s.cursor, s.cursor+1) {
-			idx := (s.cursor - 1) % slowlogMaxCount
-			s.msgs[idx].Store(msg)
-			if fh != nil {
-				fh.save(s.name, msg)
-			}
-			break
-		}
+	id := atomic.AddUint32(&s.cursor, 1) - 1
+	msg.ID = int64(id)
+	s.msgs[id%slowlogMaxCount].Store(msg)
+	if fh != nil {
+		fh.save(s.name, msg)
 	}
 }
 
 // Reply impl the Replyer
 func (s *Store) Reply() *proto.SlowlogEntries {
-	entries := make([]*proto.SlowlogEntry, 0)
-	for _, msg := range s.msgs {
-		m := msg.Load()
-		if m == nil {
-			break
-		}
-		sentry := m.(*proto.SlowlogEntry)
-		entries = append(entries, sentry)
+	entries := s.Get(-1)
+	// NOTE: keep the oldest first order of HTTP
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
 	}
-
 	ses := &proto.SlowlogEntries{
 		Cluster: s.name,
 		Entries: entries,
@@ -62,6 +54,47 @@ func (s *Store) Reply() *proto.SlowlogEntries {
 	return ses
 }
 
+// Get impl the proto.SlowlogStore
+func (s *Store) Get(n int) []*proto.SlowlogEntry {
+	cursor := atomic.LoadUint32(&s.cursor)
+	if l := s.length(cursor); n < 0 || n > l {
+		n = l
+	}
+	entries := make([]*proto.SlowlogEntry, 0, n)
+	for i := 0; i < n; i++ {
+		id := cursor - 1 - uint32(i)
+		m := s.msgs[id%slowlogMaxCount].Load()
+		if m == nil {
+			break
+		}
+		entry := m.(*proto.SlowlogEntry)
+		if entry.ID != int64(id) {
+			// NOTE: overwritten by newer entry
+			break
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// Len impl the proto.SlowlogStore
+func (s *Store) Len() int {
+	return s.length(atomic.LoadUint32(&s.cursor))
+}
+
+func (s *Store) length(cursor uint32) int {
+	l := cursor - atomic.LoadUint32(&s.base)
+	if l > slowlogMaxCount {
+		l = slowlogMaxCount
+	}
+	return int(l)
+}
+
+// Reset impl the proto.SlowlogStore
+func (s *Store) Reset() {
+	atomic.StoreUint32(&s.base, atomic.LoadUint32(&s.cursor))
+}
+
 var (
 	storeMap  = map[string]*Store{}
 	storeLock sync.RWMutex
@@ -71,6 +104,7 @@ var (
 type Handler interface {
 	Record(msg *proto.SlowlogEntry)
 	Reply() *proto.SlowlogEntries
+	proto.SlowlogStore
 }
 
 // Get create the message Handler or get the exists one
@@ -84,6 +118,9 @@ func Get(name string) Handler {
 
 	storeLock.Lock()
 	defer storeLock.Unlock()
+	if s, ok := storeMap[name]; ok {
+		return s
+	}
 	s := newStore(name)
 	storeMap[name] = s
 	return s
@@ -6,6 +6,8 @@ import (
 	"os"
 	"sync/atomic"
 	"testing"
+
+	"github.com/ducesoft/overlord/proxy/proto"
 )
 
 //in slowlog cursorInt32 init val is -1
@@ -47,3 +49,30 @@ func TestRecordWithCursorInt32(t *testing.T) {
 	}
 	assert.False(t, idxOk)
 }
+
+func TestStoreGetLenReset(t *testing.T) {
+	s := newStore("test-get")
+	assert.Equal(t, 0, s.Len())
+	assert.Len(t, s.Get(10), 0)
+	for i := 0; i < slowlogMaxCount+10; i++ {
+		s.Record(&proto.SlowlogEntry{Cmd: []string{"GET", fmt.Sprint(i)}})
+	}
+	assert.Equal(t, slowlogMaxCount, s.Len())
+	entries := s.Get(3)
+	assert.Len(t, entries, 3)
+	assert.Equal(t, int64(slowlogMaxCount+9), entries[0].ID)
+	assert.Equal(t, fmt.Sprint(slowlogMaxCount+7), entries[2].Cmd[1])
+	assert.Len(t, s.Get(-1), slowlogMaxCount)
+	reply := s.Reply().Entries
+	assert.Len(t, reply, slowlogMaxCount)
+	assert.Equal(t, int64(10), reply[0].ID, "http reply is oldest first")
+	assert.Equal(t, int64(slowlogMaxCount+9), reply[slowlogMaxCount-1].ID)
+
+	s.Reset()
+	assert.Equal(t, 0, s.Len())
+	assert.Len(t, s.Get(-1), 0)
+	s.Record(&proto.SlowlogEntry{Cmd: []string{"SET", "a"}})
+	assert.Equal(t, 1, s.Len())
+	assert.Equal(t, "SET", s.Get(10)[0].Cmd[0])
+	assert.Len(t, s.Reply().Entries, 1)
+}
@@ -0,0 +1,171 @@
+package proxy
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ducesoft/overlord/pkg/log"
+	libnet "github.com/ducesoft/overlord/pkg/net"
+	"github.com/ducesoft/overlord/proxy/nearcache"
+	"github.com/ducesoft/overlord/proxy/proto"
+	"github.com/ducesoft/overlord/proxy/proto/redis"
+)
+
+const (
+	trackingTimeout = time.Second
+)
+
+// for unit test override!!!
+var (
+	trackingRetryInterval   = time.Second
+	trackingRefreshInterval = time.Second
+)
+
+// tracker invalidates the near cache of cluster by the CLIENT TRACKING
+// broadcasts of redis masters, the masters are refreshed from forwarder.
+type tracker struct {
+	cc    *ClusterConfig
+	cache *nearcache.Cache
+	ns    proto.NodeStater
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	// watchers by the addr of master, only accessed by run.
+	watchers map[string]*trackingWatcher
+}
+
+func newTracker(cc *ClusterConfig, cache *nearcache.Cache, ns proto.NodeStater) *tracker {
+	t := &tracker{cc: cc, cache: cache, ns: ns, watchers: make(map[string]*trackingWatcher)}
+	t.ctx, t.cancel = context.WithCancel(context.Background())
+	go t.run()
+	return t
+}
+
+func (t *tracker) run() {
+	ticker := time.NewTicker(trackingRefreshInterval)
+	defer ticker.Stop()
+	for {
+		t.refresh()
+		select {
+		case <-t.ctx.Done():
+			for _, w := range t.watchers {
+				w.close()
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// refresh watch the new masters and stop watching the removed ones.
+func (t *tracker) refresh() {
+	masters := make(map[string]struct{})
+	for _, state := range t.ns.NodeStates() {
+		if state.Role != roleSlave {
+			masters[state.Addr] = struct{}{}
+		}
+	}
+	for addr := range masters {
+		if _, ok := t.watchers[addr]; !ok {
+			t.watchers[addr] = newTrackingWatcher(t, addr)
+		}
+	}
+	for addr, w := range t.watchers {
+		if _, ok := masters[addr]; !ok {
+			w.close()
+			delete(t.watchers, addr)
+		}
+	}
+}
+
+func (t *tracker) close() {
+	t.cancel()
+}
+
+// trackingWatcher receives the invalidations of master until closed, the
+// near cache is flushed whenever the invalidations may be lost.
+type trackingWatcher struct {
+	t    *tracker
+	addr string
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	// conn is the tracking conn which is closed to stop watching.
+	conn *redis.Tracking
+	lock sync.Mutex
+}
+
+func newTrackingWatcher(t *tracker, addr string) *trackingWatcher {
+	w := &trackingWatcher{t: t, addr: addr}
+	w.ctx, w.cancel = context.WithCancel(context.Background())
+	go w.watch()
+	return w
+}
+
+func (w *trackingWatcher) watch() {
+	cache := w.t.cache
+	for {
+		nc := libnet.DialWithTimeout(w.addr, trackingTimeout, trackingTimeout, trackingTimeout)
+		conn := redis.NewTracking(nc)
+		if !w.setConn(conn) {
+			_ = conn.Close()
+			return
+		}
+		err := conn.Subscribe(cache.Prefixes())
+		if err == nil {
+			// NOTE: the invalidations before subscribed are lost.
+			cache.Flush()
+			nc.SetReadTimeout(0)
+			for {
+				var (
+					keys  [][]byte
+					flush bool
+				)
+				if keys, flush, err = conn.Invalidated(); err != nil {
+					break
+				}
+				if flush {
+					cache.Flush()
+					continue
+				}
+				for _, key := range keys {
+					cache.Invalidate(key)
+				}
+			}
+			// NOTE: the invalidations after disconnected are lost.
+			cache.Flush()
+		}
+		_ = conn.Close()
+		select {
+		case <-w.ctx.Done():
+			return
+		default:
+		}
+		if log.V(3) {
+			log.Warnf("cluster:%s near cache tracking node:%s error:%v", w.t.cc.Name, w.addr, err)
+		}
+		time.Sleep(trackingRetryInterval)
+	}
+}
+
+func (w *trackingWatcher) setConn(conn *redis.Tracking) bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	select {
+	case <-w.ctx.Done():
+		return false
+	default:
+	}
+	w.conn = conn
+	return true
+}
+
+func (w *trackingWatcher) close() {
+	w.cancel()
+	w.lock.Lock()
+	if w.conn != nil {
+		_ = w.conn.Close()
+	}
+	w.lock.Unlock()
+}
@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ducesoft/overlord/proxy/nearcache"
+	"github.com/ducesoft/overlord/proxy/proto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// _fakeTracking speaks RESP on a local port, it enables the tracking and
+// publishes the invalidations to the subscribers.
+type _fakeTracking struct {
+	ln       net.Listener
+	lock     sync.Mutex
+	prefixes []string
+	subs     []net.Conn
+}
+
+func _newFakeTracking(t *testing.T) *_fakeTracking {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	s := &_fakeTracking{ln: ln}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *_fakeTracking) serve(conn net.Conn) {
+	br := bufio.NewReader(conn)
+	for {
+		args, err := _readArgs(br)
+		if err != nil {
+			_ = conn.Close()
+			return
+		}
+		s.lock.Lock()
+		switch strings.ToUpper(args[0] + " " + args[1]) {
+		case "CLIENT ID":
+			fmt.Fprintf(conn, ":7\r\n")
+		case "CLIENT TRACKING":
+			for i, arg := range args {
+				if arg == "PREFIX" {
+					s.prefixes = append(s.prefixes, args[i+1])
+				}
+			}
+			fmt.Fprintf(conn, "+OK\r\n")
+		case "SUBSCRIBE __REDIS__:INVALIDATE":
+			fmt.Fprintf(conn, "*3\r\n$9\r\nsubscribe\r\n$20\r\n__redis__:invalidate\r\n:1\r\n")
+			s.subs = append(s.subs, conn)
+		default:
+			fmt.Fprintf(conn, "-ERR unknown command\r\n")
+		}
+		s.lock.Unlock()
+	}
+}
+
+func (s *_fakeTracking) invalidate(key string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	payload := "$-1\r\n"
+	if key != "" {
+		payload = fmt.Sprintf("*1\r\n$%d\r\n%s\r\n", len(key), key)
+	}
+	for _, conn := range s.subs {
+		fmt.Fprintf(conn, "*3\r\n$7\r\nmessage\r\n$20\r\n__redis__:invalidate\r\n%s", payload)
+	}
+}
+
+type _nodeStates []*proto.NodeState
+
+func (ns _nodeStates) NodeStates() []*proto.NodeState { return ns }
+
+func TestTrackerInvalidate(t *testing.T) {
+	trackingRetryInterval = 10 * time.Millisecond
+	ft := _newFakeTracking(t)
+	defer ft.ln.Close()
+
+	cc := &ClusterConfig{Name: "tracking"}
+	cache := nearcache.New(cc.Name, 1024, time.Minute, []string{"flag:*"})
+	ns := _nodeStates{{Addr: ft.ln.Addr().String()}, {Addr: "127.0.0.1:1", Role: roleSlave}}
+	tk := newTracker(cc, cache, ns)
+	defer tk.close()
+
+	// NOTE: wait the watcher subscribed and flushed the cache.
+	assert.Eventually(t, func() bool { return cache.Seq() > 0 }, time.Second, 10*time.Millisecond)
+	ft.lock.Lock()
+	assert.Equal(t, []string{"flag:"}, ft.prefixes)
+	ft.lock.Unlock()
+
+	cache.Set([]byte("flag:a"), []byte("1"), cache.Seq())
+	cache.Set([]byte("flag:b"), []byte("2"), cache.Seq())
+	ft.invalidate("flag:a")
+	assert.Eventually(t, func() bool { return cache.Stats().Keys == 1 }, time.Second, 10*time.Millisecond)
+	_, ok := cache.Get([]byte("flag:b"))
+	assert.True(t, ok)
+	ft.invalidate("")
+	assert.Eventually(t, func() bool { return cache.Stats().Keys == 0 }, time.Second, 10*time.Millisecond)
+}